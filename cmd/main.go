@@ -2,35 +2,159 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/terenzio/vfs/domain/models"
 	"github.com/terenzio/vfs/repository"
 	"github.com/terenzio/vfs/service"
+	"golang.org/x/term"
 )
 
+// defaultDataDir is the data directory used in persistent mode when none is given
+const defaultDataDir = "vfs-data"
+
+// defaultTrashRetention is how long deleted folders and files stay in the trash when no retention is given
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// purgeInterval is how often the expired entries are purged from the trash while the program runs
+const purgeInterval = time.Minute
+
+// defaultMaxTruncate is the largest size truncate-file pads a file to unless the -max-truncate flag sets another
+const defaultMaxTruncate = 1 << 30
+
+// Storage backends that can be selected with the -storage flag
+const (
+	fileStorage   = "file"
+	memoryStorage = "memory"
+	sqlStorage    = "sql"
+)
+
+// dataStore is a store whose repositories keep their data in files inside a data directory
+type dataStore interface {
+	Paths() []string
+	Validate() error
+	Fsck(repair bool) ([]string, error)
+	Close() error
+}
+
 func main() {
-	userService, folderService, fileService := initializeServices()
+	storage := flag.String("storage", fileStorage, "storage backend: \""+fileStorage+"\", \""+sqlStorage+"\" or \""+memoryStorage+"\"")
+	persistent := flag.Bool("persistent", false, "keep all data in the data directory across restarts")
+	dataDir := flag.String("data-dir", "", "directory holding the data (default \""+defaultDataDir+"\" in persistent mode, a new temporary directory otherwise)")
+	casePolicyName := flag.String("case", models.CasePreserving.String(), "how names are compared: \"preserving\", \"sensitive\" or \"insensitive\"")
+	namePolicyPath := flag.String("name-policy", "", "JSON file configuring which names are allowed (default: letters, digits, combining marks and ._- up to 30 characters)")
+	trashRetention := flag.Duration("trash-retention", defaultTrashRetention, "how long deleted folders and files stay in the trash before they are purged (0 keeps them until the trash is emptied)")
+	keepVersions := flag.Int("keep-versions", models.DefaultVersionPolicy().KeepLast, "how many versions of every file are kept (0 keeps them all)")
+	maxTruncate := flag.Int64("max-truncate", defaultMaxTruncate, "the largest size in bytes truncate-file pads a file to (0 doesn't limit it)")
+	versionRetention := flag.Duration("version-retention", models.DefaultVersionPolicy().KeepFor, "how long old versions of files are kept (0 keeps them however old they are)")
+	resetUser := flag.String("reset-password", "", "set the password of the user in the persistent store, read from the standard input, and exit")
+	flag.Parse()
+
+	casePolicy, err := models.ParseCasePolicy(*casePolicyName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	namePolicy, err := loadNamePolicy(*namePolicyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		os.Exit(1)
+	}
+	if *keepVersions < 0 || *versionRetention < 0 {
+		fmt.Fprintln(os.Stderr, "Error: The version policy can't be negative. Use 0 to keep every version.")
+		os.Exit(1)
+	}
+	versionPolicy := models.VersionPolicy{KeepLast: *keepVersions, KeepFor: *versionRetention}
+
+	if *resetUser != "" && !*persistent {
+		fmt.Fprintln(os.Stderr, "Error: Passwords can only be reset in a persistent store. Start with -persistent.")
+		os.Exit(1)
+	}
+
+	// The memory storage keeps nothing on disk, so there is no store to open
+	var store dataStore
+	switch *storage {
+	case fileStorage, sqlStorage:
+		var err error
+		if *dataDir, err = resolveDataDir(*dataDir, *persistent); err == nil {
+			store, err = openStore(*storage, *dataDir, *persistent, casePolicy)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	case memoryStorage:
+		if *resetUser != "" {
+			fmt.Fprintln(os.Stderr, "Error: The memory storage keeps no users to reset. Use -storage file or -storage sql with -persistent.")
+			os.Exit(1)
+		}
+		if *persistent || *dataDir != "" {
+			fmt.Fprintln(os.Stderr, "Error: The memory storage keeps no data on disk. Use -storage file or -storage sql with -persistent or -data-dir.")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Error: Unknown storage [%s]. Use \"%s\", \"%s\" or \"%s\".\n", *storage, fileStorage, sqlStorage, memoryStorage)
+		os.Exit(1)
+	}
+
+	userService, folderService, fileService, trashService := initializeServices(store, casePolicy, namePolicy, versionPolicy, *trashRetention, *maxTruncate)
+	if *resetUser != "" {
+		if !resetPassword(*resetUser, bufio.NewScanner(os.Stdin), userService) {
+			os.Exit(1)
+		}
+		return
+	}
+	var sess session
 	displayWelcomeMessage()
+	// Purge the entries and versions that expired while the program was not running, then keep purging in the
+	// background. The blobs no longer referenced by anything are removed first, so they are not reported as orphans.
+	if _, err := trashService.PurgeExpired(time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: purging the trash: %s\n", err.Error())
+	}
+	if _, err := fileService.PruneVersions(time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: pruning old versions: %s\n", err.Error())
+	}
+	if _, err := fileService.CollectGarbage(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: collecting garbage: %s\n", err.Error())
+	}
+	if *persistent {
+		fmt.Printf("Loaded the persistent store from %s.\n", *dataDir)
+		if orphans, err := store.Fsck(false); err == nil && len(orphans) > 0 {
+			fmt.Printf("Warning: Found %d orphans in the store. Type 'fsck --repair' to remove them.\n", len(orphans))
+		}
+	}
+	stopPurger := trashService.StartPurger(purgeInterval, func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: purging the trash or old versions: %s\n", err.Error())
+	}, fileService.PruneVersions)
+	defer stopPurger()
 
 	scanner := bufio.NewScanner(os.Stdin)
 	for {
 		fmt.Print("# ")
 
 		if !scanner.Scan() {
-			handleExit()
+			stopPurger()
+			handleExit(store, *dataDir, *persistent)
 			break // Exit the loop if an error occurs or EOF is reached
 		}
 
 		input := scanner.Text()
 		if input == "exit" {
-			handleExit()
+			stopPurger()
+			handleExit(store, *dataDir, *persistent)
 			return
 		}
 
-		processCommand(input, userService, folderService, fileService)
+		processCommand(input, scanner, store, &sess, userService, folderService, fileService, trashService)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -38,11 +162,117 @@ func main() {
 	}
 }
 
-// initializeServices creates new instances of the user, folder, and file services
-func initializeServices() (*service.UserService, *service.FolderService, *service.FileService) {
-	userRepo := repository.NewFileUserRepository("users.txt")
-	folderRepo := repository.NewFileFolderRepository("folders.txt")
-	fileRepo := repository.NewFileRepository("files.txt")
+// resolveDataDir returns the data directory to use.
+// In persistent mode it defaults to defaultDataDir. Otherwise the session starts from an empty store, which lives in
+// a new temporary directory unless a data directory is given.
+func resolveDataDir(dataDir string, persistent bool) (string, error) {
+	switch {
+	case persistent && dataDir == "":
+		return defaultDataDir, nil
+	case persistent:
+		return dataDir, nil
+	case dataDir == "":
+		return os.MkdirTemp("", "vfs-")
+	}
+
+	// Refuse to start over data that would be removed on exit
+	entries, err := os.ReadDir(dataDir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if len(entries) > 0 {
+		return "", fmt.Errorf("The data directory [%s] is not empty. Start with -persistent to load its store, or use an empty directory.", dataDir)
+	}
+	return dataDir, nil
+}
+
+// openStore opens the store of the selected storage kept in the data directory, matching names with the case policy.
+// In persistent mode the existing store is loaded and validated.
+func openStore(storage, dataDir string, persistent bool, casePolicy models.CasePolicy) (dataStore, error) {
+	var store dataStore
+	var err error
+	if storage == sqlStorage {
+		store, err = repository.OpenSQLStore(dataDir, casePolicy)
+	} else {
+		store, err = repository.OpenStore(dataDir, casePolicy)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if persistent {
+		if err := store.Validate(); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// loadNamePolicy returns the naming policy configured in the JSON file at path, or the default policy if path is empty.
+// Settings missing from the file keep their default value.
+func loadNamePolicy(path string) (models.NamePolicy, error) {
+	policy := models.DefaultNamePolicy()
+	if path == "" {
+		return policy, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return policy, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&policy); err != nil {
+		return policy, fmt.Errorf("invalid naming policy %s: %w", path, err)
+	}
+	if err := policy.Check(); err != nil {
+		return policy, fmt.Errorf("invalid naming policy %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// initializeServices creates new instances of the user, folder, file and trash services backed by the selected
+// storage. The file and SQL storages use the repositories of the given store, while the memory storage, which has no
+// store, keeps everything in indexed maps that match names with the case policy. Every service checks new names with
+// the naming policy, the history of files keeps the versions the version policy allows, and the trash keeps deleted
+// items for the retention period. Files are padded up to maxTruncate bytes at most.
+func initializeServices(store dataStore, casePolicy models.CasePolicy, namePolicy models.NamePolicy, versionPolicy models.VersionPolicy, trashRetention time.Duration, maxTruncate int64) (*service.UserService, *service.FolderService, *service.FileService, *service.TrashService) {
+	var (
+		userRepo    models.UserRepository
+		folderRepo  models.FolderRepository
+		fileRepo    models.FileRepository
+		blobRepo    models.BlobRepository
+		trashRepo   models.TrashRepository
+		versionRepo models.VersionRepository
+	)
+	switch s := store.(type) {
+	case *repository.Store:
+		userRepo = s.Users
+		folderRepo = s.Folders
+		fileRepo = s.Files
+		blobRepo = s.Blobs
+		trashRepo = s.Trash
+		versionRepo = s.Versions
+	case *repository.SQLStore:
+		userRepo = s.Users
+		folderRepo = s.Folders
+		fileRepo = s.Files
+		blobRepo = s.Blobs
+		trashRepo = s.Trash
+		versionRepo = s.Versions
+	default:
+		users := repository.NewMemoryUserRepository(casePolicy)
+		files := repository.NewMemoryFileRepository()
+		userRepo = users
+		folderRepo = repository.NewMemoryFolderRepository(users, files)
+		fileRepo = files
+		blobRepo = repository.NewMemoryBlobRepository()
+		trashRepo = repository.NewMemoryTrashRepository()
+		versionRepo = repository.NewMemoryVersionRepository()
+	}
 
 	// Dependency Injection for Flexibility
 	// Can use NewUserService with a text file implementation, a database implementation, etc.
@@ -59,11 +289,14 @@ func initializeServices() (*service.UserService, *service.FolderService, *servic
 	// The service layer remains the same, as it only interacts with the repository interface.
 	// This makes the code more adaptable to future changes and requirements.
 
-	userService := service.NewUserService(userRepo)
-	folderService := service.NewFolderService(folderRepo, userRepo)
-	fileService := service.NewFileService(fileRepo, folderRepo, userRepo)
+	// The trash lock is shared, so deleting a user never races restoring or purging the entries in its trash.
+	trashMu := &sync.Mutex{}
+	userService := service.NewUserService(userRepo, blobRepo, trashRepo, versionRepo, namePolicy, trashMu)
+	folderService := service.NewFolderService(folderRepo, userRepo, blobRepo, trashRepo, versionRepo, namePolicy)
+	fileService := service.NewFileService(fileRepo, folderRepo, userRepo, blobRepo, trashRepo, versionRepo, namePolicy, versionPolicy, maxTruncate)
+	trashService := service.NewTrashService(trashRepo, folderRepo, fileRepo, userRepo, blobRepo, versionRepo, namePolicy, trashRetention, trashMu)
 
-	return userService, folderService, fileService
+	return userService, folderService, fileService, trashService
 }
 
 // displayWelcomeMessage prints a welcome message to the console
@@ -73,32 +306,95 @@ func displayWelcomeMessage() {
 	fmt.Println("Type 'help' to see available commands.")
 }
 
-// handleExit performs cleanup and exits the program
-func handleExit() {
-	filesToCleanup := []string{"users.txt", "folders.txt", "files.txt"}
-	cleanup(filesToCleanup)
-	fmt.Println("Removed all temp files.")
+// handleExit performs cleanup and exits the program.
+// In persistent mode all data is kept in the data directory for the next run.
+func handleExit(store dataStore, dataDir string, persistent bool) {
+	if store == nil {
+		fmt.Println("Discarded all in-memory data.")
+	} else if persistent {
+		store.Close()
+		fmt.Printf("Kept all data in %s.\n", dataDir)
+	} else {
+		store.Close()
+		cleanup(store.Paths())
+		os.Remove(dataDir) // only succeeds if nothing else is left in the data directory
+		fmt.Println("Removed all temp files.")
+	}
 	fmt.Println("Exiting program.\nSee you next time!")
 }
 
-// cleanup removes the files specified in the input slice
+// cleanup removes the files and directories specified in the input slice
 func cleanup(files []string) {
 	for _, file := range files {
-		if err := os.Remove(file); err == nil {
-			fmt.Printf("Removing file %s ...\n", file)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		if err := os.RemoveAll(file); err == nil {
+			fmt.Printf("Removing file %s ...\n", filepath.Base(file))
 		}
 	}
 }
 
+// session is the login session of the REPL. Commands act as the logged-in user, so nobody can act as another user by
+// typing their username.
+type session struct {
+	username string // the logged-in user, or empty if nobody is logged in
+}
+
+// userCommands are the commands that act as the logged-in user. The username of the session is passed to them as
+// their first argument.
+var userCommands = map[string]bool{
+	"rename-user": true, "delete-user": true, "set-display-name": true, "set-email": true, "passwd": true,
+	"create-folder": true, "delete-folder": true, "rename-folder": true, "list-folders": true,
+	"create-file": true, "delete-file": true, "rename-file": true, "set-description": true, "list-files": true,
+	"write-file": true, "append-file": true, "truncate-file": true, "cat": true, "mv": true, "cp": true,
+	"import-file": true, "export-file": true,
+	"history": true, "show-version": true, "diff": true, "revert": true,
+	"chmod": true, "chown": true, "chgrp": true, "add-to-group": true, "remove-from-group": true,
+	"share-folder": true, "unshare-folder": true, "list-shared": true,
+	"trash": true, "restore": true, "empty-trash": true,
+}
+
 // processCommand handles the user input and calls the appropriate service method
-func processCommand(input string, userService *service.UserService, folderService *service.FolderService, fileService *service.FileService) {
+// The scanner is used by commands that read file content or passwords from the standard input, and the store by fsck.
+func processCommand(input string, scanner *bufio.Scanner, store dataStore, sess *session, userService *service.UserService, folderService *service.FolderService, fileService *service.FileService, trashService *service.TrashService) {
 	args := strings.Fields(input)
+	if len(args) == 0 {
+		return
+	}
+	if userCommands[args[0]] {
+		if sess.username == "" {
+			fmt.Println("Error: You are not logged in. Type 'login [username]' first.")
+			return
+		}
+		args = append([]string{args[0], sess.username}, args[1:]...)
+	}
 
 	switch args[0] {
 	case "help":
 		displayHelp()
 	case "register":
-		registerUser(args, userService)
+		registerUser(args, scanner, userService)
+	case "login":
+		login(args, scanner, sess, userService)
+	case "logout":
+		logout(args, sess)
+	case "whoami":
+		whoami(args, sess)
+	case "passwd":
+		changePassword(args, scanner, userService)
+	case "list-users":
+		listUsers(args, userService)
+	case "show-user":
+		showUser(args, sess, userService)
+	case "rename-user":
+		renameUser(args, sess, userService)
+	case "delete-user":
+		deleteUser(args, sess, userService)
+	case "set-display-name":
+		setDisplayName(args, userService)
+	case "set-email":
+		setEmail(args, userService)
 	case "create-folder":
 		createFolder(args, folderService)
 	case "delete-folder":
@@ -106,13 +402,63 @@ func processCommand(input string, userService *service.UserService, folderServic
 	case "rename-folder":
 		renameFolder(args, folderService)
 	case "list-folders":
-		listFolders(args, folderService)
+		listFolders(args, userService, folderService)
 	case "create-file":
 		createFile(args, fileService)
 	case "delete-file":
 		deleteFile(args, fileService)
+	case "rename-file":
+		renameFile(args, fileService)
+	case "set-description":
+		setDescription(args, folderService, fileService)
 	case "list-files":
-		listFiles(args, fileService)
+		listFiles(args, userService, fileService)
+	case "write-file":
+		writeFile(args, scanner, fileService, false)
+	case "append-file":
+		writeFile(args, scanner, fileService, true)
+	case "truncate-file":
+		truncateFile(args, fileService)
+	case "cat":
+		catFile(args, fileService)
+	case "import-file":
+		importFile(args, fileService)
+	case "export-file":
+		exportFile(args, fileService)
+	case "history":
+		showHistory(args, userService, fileService)
+	case "show-version":
+		showVersion(args, fileService)
+	case "diff":
+		diffVersions(args, fileService)
+	case "revert":
+		revertFile(args, fileService)
+	case "fsck":
+		checkStore(args, store)
+	case "gc":
+		collectGarbage(args, fileService)
+	case "mv":
+		relocateFile(args, fileService, false)
+	case "cp":
+		relocateFile(args, fileService, true)
+	case "chmod", "chown", "chgrp":
+		changePermissions(args, folderService, fileService)
+	case "add-to-group":
+		changeGroupMembers(args, userService, true)
+	case "remove-from-group":
+		changeGroupMembers(args, userService, false)
+	case "share-folder":
+		shareFolder(args, folderService)
+	case "unshare-folder":
+		unshareFolder(args, folderService)
+	case "list-shared":
+		listShared(args, userService, folderService)
+	case "trash":
+		listTrash(args, userService, trashService)
+	case "restore":
+		restoreTrash(args, trashService)
+	case "empty-trash":
+		emptyTrash(args, trashService)
 	default:
 		fmt.Println("Error: Unrecognized command. Type 'help' to see available commands.")
 	}
@@ -122,24 +468,65 @@ func processCommand(input string, userService *service.UserService, folderServic
 func displayHelp() {
 	fmt.Println("Available commands:")
 	fmt.Println("> register [username]")
-	fmt.Println("> create-folder [username] [foldername] [description]?")
-	fmt.Println("> delete-folder [username] [foldername]")
-	fmt.Println("> list-folders [username] [--sort-name|--sort-created] [asc|desc]")
-	fmt.Println("> rename-folder [username] [foldername] [new-folder-name]")
-	fmt.Println("> create-file [username] [foldername] [filename] [description]?")
-	fmt.Println("> delete-file [username] [foldername] [filename]")
-	fmt.Println("> list-files [username] [foldername] [--sort-name|--sort-created] [asc|desc]")
+	fmt.Println("> login [username]")
+	fmt.Println("> logout")
+	fmt.Println("> whoami")
+	fmt.Println("> passwd")
+	fmt.Println("> list-users [--sort-name|--sort-created] [asc|desc]")
+	fmt.Println("> show-user [username]?")
+	fmt.Println("> rename-user [new-username]")
+	fmt.Println("> delete-user")
+	fmt.Println("> set-display-name [display-name]?")
+	fmt.Println("> set-email [email]?")
+	fmt.Println("> create-folder [folderpath] [description]?")
+	fmt.Println("> delete-folder [folderpath] [--recursive]?")
+	fmt.Println("> list-folders [folderpath]? [--sort-name|--sort-created] [asc|desc]")
+	fmt.Println("> rename-folder [folderpath] [new-folder-name]")
+	fmt.Println("> create-file [folderpath] [filename] [description]?")
+	fmt.Println("> delete-file [folderpath] [filename]")
+	fmt.Println("> rename-file [folderpath] [filename] [new-filename]")
+	fmt.Println("> set-description [folderpath] [--file [filename]]? [description]?")
+	fmt.Println("> list-files [folderpath] [--sort-name|--sort-created] [asc|desc]")
+	fmt.Println("> write-file [folderpath] [filename] [hostfile]?")
+	fmt.Println("> append-file [folderpath] [filename] [hostfile]?")
+	fmt.Println("> truncate-file [folderpath] [filename] [size]")
+	fmt.Println("> cat [folderpath] [filename] [offset length]?")
+	fmt.Println("> import-file [folderpath] [filename] [hostfile]")
+	fmt.Println("> export-file [folderpath] [filename] [hostfile]")
+	fmt.Println("> history [folderpath] [filename]")
+	fmt.Println("> show-version [folderpath] [filename] [version]")
+	fmt.Println("> diff [folderpath] [filename] [version] [version]?")
+	fmt.Println("> revert [folderpath] [filename] [version]")
+	fmt.Println("> mv [folderpath] [filename] [dest-folderpath] [new-filename]? [--overwrite|--skip|--rename]?")
+	fmt.Println("> cp [folderpath] [filename] [dest-folderpath] [new-filename]? [--overwrite|--skip|--rename]?")
+	fmt.Println("> chmod [mode] [folderpath] [filename]?")
+	fmt.Println("> chown [username] [folderpath] [filename]?")
+	fmt.Println("> chgrp [group|--none] [folderpath] [filename]?")
+	fmt.Println("> add-to-group [group] [username]")
+	fmt.Println("> remove-from-group [group] [username]")
+	fmt.Println("> share-folder [folderpath] [username] [read|read-write]")
+	fmt.Println("> unshare-folder [folderpath] [username]")
+	fmt.Println("> list-shared")
+	fmt.Println("> trash list")
+	fmt.Println("> restore [id] [--overwrite|--skip|--rename]?")
+	fmt.Println("> empty-trash")
+	fmt.Println("> fsck [--repair]?")
+	fmt.Println("> gc")
 	fmt.Println("> exit")
 }
 
-// registerUser registers a new user
-func registerUser(args []string, userService *service.UserService) {
+// registerUser registers a new user with the password read from the standard input
+func registerUser(args []string, scanner *bufio.Scanner, userService *service.UserService) {
 	if len(args) != 2 {
 		fmt.Println("Usage: register [username]")
 		return
 	}
 	username := args[1]
-	err := userService.Register(username)
+	password, ok := readPassword(scanner, "Enter the password:")
+	if !ok {
+		return
+	}
+	err := userService.Register(username, password)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	} else {
@@ -147,93 +534,347 @@ func registerUser(args []string, userService *service.UserService) {
 	}
 }
 
+// readPassword prints the prompt and reads a password from the next line of the standard input, which is false at the
+// end of the input. On a terminal the password is read without echoing it; the scanner never reads ahead of the line
+// typed last, so nothing it buffered is skipped.
+func readPassword(scanner *bufio.Scanner, prompt string) (string, bool) {
+	fmt.Println(prompt)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		password, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", false
+		}
+		return string(password), true
+	}
+	if !scanner.Scan() {
+		return "", false
+	}
+	return scanner.Text(), true
+}
+
+// login starts a session as the user after checking the password read from the standard input.
+// Logging in as another user ends the current session.
+func login(args []string, scanner *bufio.Scanner, sess *session, userService *service.UserService) {
+	if len(args) != 2 {
+		fmt.Println("Usage: login [username]")
+		return
+	}
+	password, ok := readPassword(scanner, "Enter the password:")
+	if !ok {
+		return
+	}
+	user, err := userService.Login(args[1], password)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	sess.username = user.Username
+	fmt.Printf("Log in as '%s' successfully.\n", user.Username)
+}
+
+// resetPassword sets the password of the user to the one read from the standard input and reports whether it was set.
+// It is run by the owner of the data directory outside of any session, so users without a password, who can't log in,
+// get one without letting whoever logs in first choose it.
+func resetPassword(username string, scanner *bufio.Scanner, userService *service.UserService) bool {
+	password, ok := readPassword(scanner, fmt.Sprintf("Enter a new password for '%s':", username))
+	if !ok {
+		return false
+	}
+	if err := userService.ResetPassword(username, password); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		return false
+	}
+	fmt.Printf("Set the password of '%s' successfully.\n", username)
+	return true
+}
+
+// logout ends the session
+func logout(args []string, sess *session) {
+	if len(args) != 1 {
+		fmt.Println("Usage: logout")
+		return
+	}
+	if sess.username == "" {
+		fmt.Println("Error: You are not logged in.")
+		return
+	}
+	fmt.Printf("Log out '%s' successfully.\n", sess.username)
+	sess.username = ""
+}
+
+// whoami prints the logged-in user
+func whoami(args []string, sess *session) {
+	if len(args) != 1 {
+		fmt.Println("Usage: whoami")
+		return
+	}
+	if sess.username == "" {
+		fmt.Println("You are not logged in.")
+		return
+	}
+	fmt.Println(sess.username)
+}
+
+// changePassword replaces the password of a user after checking the current one, both read from the standard input
+func changePassword(args []string, scanner *bufio.Scanner, userService *service.UserService) {
+	if len(args) != 2 {
+		fmt.Println("Usage: passwd")
+		return
+	}
+	oldPassword, ok := readPassword(scanner, "Enter the current password:")
+	if !ok {
+		return
+	}
+	newPassword, ok := readPassword(scanner, "Enter the new password:")
+	if !ok {
+		return
+	}
+	err := userService.ChangePassword(args[1], oldPassword, newPassword)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Change the password of '%s' successfully.\n", args[1])
+	}
+}
+
+// listUsers lists all the registered users
+func listUsers(args []string, userService *service.UserService) {
+	usage := "Usage: list-users [--sort-name|--sort-created] [asc|desc]"
+	sortField := ""
+	sortOrder := "asc"
+	if len(args) > 3 {
+		fmt.Fprintln(os.Stderr, usage)
+		return
+	}
+	if len(args) > 1 {
+		sortField = args[1]
+		if sortField != "--sort-name" && sortField != "--sort-created" {
+			fmt.Fprintln(os.Stderr, usage)
+			return
+		}
+		if len(args) == 3 {
+			sortOrder = args[2]
+			if sortOrder != "asc" && sortOrder != "desc" {
+				fmt.Fprintln(os.Stderr, usage)
+				return
+			}
+		}
+	}
+	users, err := userService.ListUsers(sortField, sortOrder)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else if len(users) == 0 {
+		fmt.Println("Warning: No users are registered.")
+	} else {
+
+		// Determine the maximum length of each field across all users
+		maxUserLen, maxNameLen, maxEmailLen, maxDateLen := len("User Name"), len("Display Name"), len("Email"), len("Created At")
+		for _, u := range users {
+			maxUserLen = max(maxUserLen, len(u.Username))
+			maxNameLen = max(maxNameLen, len(u.DisplayName))
+			maxEmailLen = max(maxEmailLen, len(u.Email))
+			maxDateLen = max(maxDateLen, len(formatCreatedAt(u.CreatedAt)))
+		}
+
+		// Print header
+		headerFmt := fmt.Sprintf("%%-%ds | %%-%ds | %%-%ds | %%-%ds\n", maxUserLen, maxNameLen, maxEmailLen, maxDateLen)
+		fmt.Printf(headerFmt, "User Name", "Display Name", "Email", "Created At")
+		fmt.Println(strings.Repeat("-", maxUserLen+maxNameLen+maxEmailLen+maxDateLen+9))
+
+		for _, user := range users {
+			fmt.Printf(headerFmt, user.Username, user.DisplayName, user.Email, formatCreatedAt(user.CreatedAt))
+		}
+	}
+}
+
+// showUser prints the profile of a user, which defaults to the logged-in user
+func showUser(args []string, sess *session, userService *service.UserService) {
+	if len(args) > 2 {
+		fmt.Println("Usage: show-user [username]?")
+		return
+	}
+	username := sess.username
+	if len(args) == 2 {
+		username = args[1]
+	} else if username == "" {
+		fmt.Println("Error: You are not logged in. Type 'login [username]' first.")
+		return
+	}
+	user, err := userService.GetUser(username)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	fmt.Printf("User Name:    %s\n", user.Username)
+	fmt.Printf("Display Name: %s\n", user.DisplayName)
+	fmt.Printf("Email:        %s\n", user.Email)
+	fmt.Printf("Groups:       %s\n", strings.Join(user.Groups, ", "))
+	fmt.Printf("Owned Groups: %s\n", strings.Join(user.OwnedGroups, ", "))
+	fmt.Printf("Created At:   %s\n", formatCreatedAt(user.CreatedAt))
+}
+
+// formatCreatedAt formats the creation time of a user. Users registered before creation times were kept have none.
+func formatCreatedAt(createdAt time.Time) string {
+	if createdAt.IsZero() {
+		return "-"
+	}
+	return createdAt.Format(time.DateTime)
+}
+
+// renameUser changes the username of a user. The session goes on under the new username.
+func renameUser(args []string, sess *session, userService *service.UserService) {
+	if len(args) != 3 {
+		fmt.Println("Usage: rename-user [new-username]")
+		return
+	}
+	newUsername, err := userService.RenameUser(args[1], args[2])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		sess.username = newUsername
+		fmt.Printf("Rename '%s' to '%s' successfully.\n", args[1], newUsername)
+	}
+}
+
+// deleteUser deletes a user together with all its folders and files, and ends its session
+func deleteUser(args []string, sess *session, userService *service.UserService) {
+	if len(args) != 2 {
+		fmt.Println("Usage: delete-user")
+		return
+	}
+	err := userService.DeleteUser(args[1])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		sess.username = ""
+		fmt.Printf("Delete '%s' successfully.\n", args[1])
+	}
+}
+
+// setDisplayName replaces the display name of a user. Leaving out the display name clears it.
+func setDisplayName(args []string, userService *service.UserService) {
+	if len(args) < 2 {
+		fmt.Println("Usage: set-display-name [display-name]?")
+		return
+	}
+	err := userService.SetDisplayName(args[1], strings.Join(args[2:], " "))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Set the display name of '%s' successfully.\n", args[1])
+	}
+}
+
+// setEmail replaces the email address of a user. Leaving out the address clears it.
+func setEmail(args []string, userService *service.UserService) {
+	if len(args) != 2 && len(args) != 3 {
+		fmt.Println("Usage: set-email [email]?")
+		return
+	}
+	err := userService.SetEmail(args[1], strings.Join(args[2:], " "))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Set the email of '%s' successfully.\n", args[1])
+	}
+}
+
 // createFolder creates a new folder
 func createFolder(args []string, folderService *service.FolderService) {
 	if len(args) < 3 {
-		fmt.Println("Usage: create-folder [username] [foldername] [description]?")
+		fmt.Println("Usage: create-folder [folderpath] [description]?")
 		return
 	}
-	username, folderName := args[1], args[2]
+	username, folderPath := args[1], args[2]
 	description := ""
 	if len(args) > 3 {
 		description = strings.Join(args[3:], " ")
 	}
-	err := folderService.CreateFolder(username, folderName, description)
+	err := folderService.CreateFolder(username, folderPath, description)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	} else {
-		fmt.Printf("Create '%s' successfully.\n", folderName)
+		fmt.Printf("Create '%s' successfully.\n", fullPath(username, folderPath))
 	}
 }
 
-// deleteFolder deletes an existing folder
+// deleteFolder moves an existing folder to the trash, together with everything inside it if --recursive is given
 func deleteFolder(args []string, folderService *service.FolderService) {
-	if len(args) != 3 {
-		fmt.Println("Usage: delete-folder [username] [foldername]")
+	if len(args) != 3 && (len(args) != 4 || args[3] != "--recursive") {
+		fmt.Println("Usage: delete-folder [folderpath] [--recursive]?")
 		return
 	}
-	err := folderService.DeleteFolder(args[1], args[2])
+	err := folderService.DeleteFolder(args[1], args[2], len(args) == 4)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	} else {
-		fmt.Printf("Delete '%s' successfully.\n", args[2])
+		fmt.Printf("Move '%s' to the trash successfully.\n", fullPath(args[1], args[2]))
 	}
 }
 
 // renameFolder renames an existing folder
 func renameFolder(args []string, folderService *service.FolderService) {
 	if len(args) != 4 {
-		fmt.Println("Usage: rename-folder [username] [foldername] [new-folder-name]")
+		fmt.Println("Usage: rename-folder [folderpath] [new-folder-name]")
 		return
 	}
 	err := folderService.RenameFolder(args[1], args[2], args[3])
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	} else {
-		fmt.Printf("Rename '%s' to '%s' successfully.\n", args[2], args[3])
+		fmt.Printf("Rename '%s' to '%s' successfully.\n", fullPath(args[1], args[2]), args[3])
 	}
 }
 
-// listFolders lists all folders for a given user
-func listFolders(args []string, folderService *service.FolderService) {
+// listFolders lists the folders of a given user inside a folder, which defaults to the user's root folder
+func listFolders(args []string, userService *service.UserService, folderService *service.FolderService) {
 	if len(args) < 2 {
-		fmt.Println("Usage: list-folders [username] [--sort-name|--sort-created] [asc|desc]")
+		fmt.Println("Usage: list-folders [folderpath]? [--sort-name|--sort-created] [asc|desc]")
 		return
 	}
+	parentPath := models.RootPath
+	if len(args) > 2 && !strings.HasPrefix(args[2], "--") {
+		parentPath = args[2]
+		args = append(args[:2], args[3:]...)
+	}
 	sortField := ""
 	sortOrder := "asc"
 	if len(args) > 2 {
 		sortField = args[2]
 		if sortField != "--sort-name" && sortField != "--sort-created" {
-			fmt.Fprintln(os.Stderr, "Usage: list-files [username] [foldername] [--sort-name|--sort-created] [asc|desc]")
+			fmt.Fprintln(os.Stderr, "Usage: list-folders [folderpath]? [--sort-name|--sort-created] [asc|desc]")
 			return
 		}
 		if len(args) == 4 {
 			sortOrder = args[3]
 			if sortOrder != "asc" && sortOrder != "desc" {
-				fmt.Fprintln(os.Stderr, "Usage: list-files [username] [foldername] [--sort-name|--sort-created] [asc|desc]")
+				fmt.Fprintln(os.Stderr, "Usage: list-folders [folderpath]? [--sort-name|--sort-created] [asc|desc]")
 				return
 			}
 		}
 	}
 	// List the folders
-	folders, err := folderService.ListFolders(args[1], sortField, sortOrder)
+	folders, err := folderService.ListFolders(args[1], parentPath, sortField, sortOrder)
 	if err != nil {
-
+		fmt.Printf("Error: %s\n", err.Error())
+	} else if _, treePath := models.SplitTree(parentPath); len(folders) == 0 && treePath == models.RootPath {
 		// If no folders are found, print a warning
-		if err.Error() == fmt.Sprintf("no folders found for user %s", args[1]) {
-			fmt.Printf("Warning: The %s doesn't have any folders.\n", args[1])
-		} else {
-			fmt.Printf("Error: %s\n", err.Error())
-		}
-
+		fmt.Printf("Warning: The %s doesn't have any folders.\n", treeUser(args[1], parentPath))
+	} else if len(folders) == 0 {
+		fmt.Printf("Warning: The folder %s doesn't have any subfolders.\n", fullPath(args[1], parentPath))
 	} else {
 
 		// Determine the maximum length of each field across all files
+		owners := ownerNames(userService)
 		maxFolderLen, maxDescLen, maxDateLen, maxUserLen := 0, 0, 0, 0
+		maxOwnerLen, maxGroupLen := len("Owner"), len("Group")
+		tree := treeUser(args[1], parentPath)
 		for _, f := range folders {
-			if len(f.Name) > maxFolderLen {
-				maxFolderLen = len(f.Name)
+			maxOwnerLen = max(maxOwnerLen, len(owners[f.OwnerID]))
+			maxGroupLen = max(maxGroupLen, len(f.Group))
+			if name := folderName(tree, f); len(name) > maxFolderLen {
+				maxFolderLen = len(name)
 			}
 			if len(f.Description) > maxDescLen {
 				maxDescLen = len(f.Description)
@@ -248,12 +889,12 @@ func listFolders(args []string, folderService *service.FolderService) {
 		}
 
 		// Print header
-		headerFmt := fmt.Sprintf("%%-%ds | %%-%ds | %%-%ds | %%-%ds\n", maxFolderLen, maxDescLen, maxDateLen, maxUserLen)
-		fmt.Printf(headerFmt, "Name", "Description", "Created At", "User Name")
-		fmt.Println(strings.Repeat("-", maxFolderLen+maxDescLen+maxDateLen+maxUserLen+20))
+		headerFmt := fmt.Sprintf("%%-%ds | %%-%ds | %%-%ds | %%-%ds | %%-11s | %%-%ds | %%-%ds\n", maxFolderLen, maxDescLen, maxDateLen, maxUserLen, maxOwnerLen, maxGroupLen)
+		fmt.Printf(headerFmt, "Name", "Description", "Created At", "User Name", "Permissions", "Owner", "Group")
+		fmt.Println(strings.Repeat("-", maxFolderLen+maxDescLen+maxDateLen+maxUserLen+maxOwnerLen+maxGroupLen+40))
 
 		for _, folder := range folders {
-			fmt.Printf(headerFmt, folder.Name, folder.Description, folder.CreatedAt.Format(time.DateTime), folder.Username)
+			fmt.Printf(headerFmt, folderName(tree, folder), folder.Description, folder.CreatedAt.Format(time.DateTime), folder.Username, "d"+folder.Mode.String(), owners[folder.OwnerID], folder.Group)
 		}
 	}
 }
@@ -261,61 +902,103 @@ func listFolders(args []string, folderService *service.FolderService) {
 // createFile creates a new file
 func createFile(args []string, fileService *service.FileService) {
 	if len(args) < 4 {
-		fmt.Println("Usage: create-file [username] [foldername] [filename] [description]?")
+		fmt.Println("Usage: create-file [folderpath] [filename] [description]?")
 		return
 	}
-	username, folderName, fileName := args[1], args[2], args[3]
+	username, folderPath, fileName := args[1], args[2], args[3]
 	description := ""
 	if len(args) > 4 {
 		description = strings.Join(args[4:], " ")
 	}
-	err := fileService.CreateFile(username, folderName, fileName, description)
+	err := fileService.CreateFile(username, folderPath, fileName, description)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	} else {
-		fmt.Printf("Create '%s' in %s/%s successfully.\n", args[3], args[1], args[2])
+		fmt.Printf("Create '%s' in %s successfully.\n", fileName, fullPath(username, folderPath))
 	}
 }
 
-// deleteFile deletes an existing file
+// deleteFile moves an existing file to the trash
 func deleteFile(args []string, fileService *service.FileService) {
 	if len(args) != 4 {
-		fmt.Println("Usage: delete-file [username] [foldername] [filename]")
+		fmt.Println("Usage: delete-file [folderpath] [filename]")
 		return
 	}
 	err := fileService.DeleteFile(args[1], args[2], args[3])
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	} else {
-		fmt.Printf("Delete '%s' in %s/%s successfully.\n", args[3], args[1], args[2])
+		fmt.Printf("Move '%s' in %s to the trash successfully.\n", args[3], fullPath(args[1], args[2]))
+	}
+}
+
+// renameFile renames an existing file
+func renameFile(args []string, fileService *service.FileService) {
+	if len(args) != 5 {
+		fmt.Println("Usage: rename-file [folderpath] [filename] [new-filename]")
+		return
+	}
+	err := fileService.RenameFile(args[1], args[2], args[3], args[4])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Rename '%s' in %s to '%s' successfully.\n", args[3], fullPath(args[1], args[2]), args[4])
+	}
+}
+
+// setDescription replaces the description of a folder, or of a file inside it if --file is given.
+// Leaving out the description clears it.
+func setDescription(args []string, folderService *service.FolderService, fileService *service.FileService) {
+	if len(args) < 3 || (len(args) > 3 && args[3] == "--file" && len(args) < 5) {
+		fmt.Println("Usage: set-description [folderpath] [--file [filename]]? [description]?")
+		return
+	}
+	username, folderPath := args[1], args[2]
+
+	if len(args) > 3 && args[3] == "--file" {
+		fileName := args[4]
+		err := fileService.UpdateFileDescription(username, folderPath, fileName, strings.Join(args[5:], " "))
+		if err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+		} else {
+			fmt.Printf("Set the description of '%s' in %s successfully.\n", fileName, fullPath(username, folderPath))
+		}
+		return
+	}
+
+	err := folderService.UpdateFolderDescription(username, folderPath, strings.Join(args[3:], " "))
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Set the description of '%s' successfully.\n", fullPath(username, folderPath))
 	}
 }
 
 // listFiles lists all files for a given user and folder
-func listFiles(args []string, fileService *service.FileService) {
+func listFiles(args []string, userService *service.UserService, fileService *service.FileService) {
 	if len(args) < 3 {
-		fmt.Fprintln(os.Stderr, "Usage: list-files [username] [foldername] [--sort-name|--sort-created] [asc|desc]")
+		fmt.Fprintln(os.Stderr, "Usage: list-files [folderpath] [--sort-name|--sort-created] [asc|desc]")
 		return
 	}
-	username, folderName := args[1], args[2]
+	username, folderPath := args[1], args[2]
 	sortField := ""
 	sortOrder := "asc" // Default sorting order
 	if len(args) > 3 {
 		sortField = args[3]
 		if sortField != "--sort-name" && sortField != "--sort-created" {
-			fmt.Fprintln(os.Stderr, "Usage: list-files [username] [foldername] [--sort-name|--sort-created] [asc|desc]")
+			fmt.Fprintln(os.Stderr, "Usage: list-files [folderpath] [--sort-name|--sort-created] [asc|desc]")
 			return
 		}
 		if len(args) == 5 {
 			sortOrder = args[4]
 			if sortOrder != "asc" && sortOrder != "desc" {
-				fmt.Fprintln(os.Stderr, "Usage: list-files [username] [foldername] [--sort-name|--sort-created] [asc|desc]")
+				fmt.Fprintln(os.Stderr, "Usage: list-files [folderpath] [--sort-name|--sort-created] [asc|desc]")
 				return
 			}
 		}
 	}
 	// List the files
-	files, err := fileService.ListFiles(username, folderName, sortField, sortOrder)
+	files, err := fileService.ListFiles(username, folderPath, sortField, sortOrder)
 	if err != nil {
 		fmt.Printf("Error: %s\n", err.Error())
 	} else if len(files) == 0 {
@@ -323,11 +1006,18 @@ func listFiles(args []string, fileService *service.FileService) {
 	} else {
 
 		// Determine the maximum length of each field across all files
-		maxFileLen, maxFolderLen, maxDescLen, maxDateLen, maxUserLen := 0, 0, 0, 0, 0
+		owners := ownerNames(userService)
+		maxFileLen, maxSizeLen, maxFolderLen, maxDescLen, maxDateLen, maxUserLen := 0, 0, 0, 0, 0, 0
+		maxOwnerLen, maxGroupLen := len("Owner"), len("Group")
 		for _, f := range files {
+			maxOwnerLen = max(maxOwnerLen, len(owners[f.OwnerID]))
+			maxGroupLen = max(maxGroupLen, len(f.Group))
 			if len(f.Name) > maxFileLen {
 				maxFileLen = len(f.Name)
 			}
+			if size := strconv.FormatInt(f.Size, 10); len(size) > maxSizeLen {
+				maxSizeLen = len(size)
+			}
 			if len(f.Description) > maxDescLen {
 				maxDescLen = len(f.Description)
 			}
@@ -335,8 +1025,8 @@ func listFiles(args []string, fileService *service.FileService) {
 			if len(folderCreatedAt) > maxDateLen {
 				maxDateLen = len(folderCreatedAt)
 			}
-			if len(f.FolderName) > maxFolderLen {
-				maxFolderLen = len(f.FolderName)
+			if len(f.FolderPath) > maxFolderLen {
+				maxFolderLen = len(f.FolderPath)
 			}
 			if len(f.Username) > maxUserLen {
 				maxUserLen = len(f.Username)
@@ -344,13 +1034,691 @@ func listFiles(args []string, fileService *service.FileService) {
 		}
 
 		// Print header
-		headerFmt := fmt.Sprintf("%%-%ds | %%-%ds | %%-%ds | %%-%ds | %%-%ds\n", maxFileLen, maxDescLen, maxDateLen, maxFolderLen, maxUserLen)
-		fmt.Printf(headerFmt, "Name", "Description", "Created At", "Folder", "User Name")
-		fmt.Println(strings.Repeat("-", maxFolderLen+maxDescLen+maxDateLen+maxUserLen+20))
+		headerFmt := fmt.Sprintf("%%-%ds | %%-%ds | %%-%ds | %%-%ds | %%-%ds | %%-%ds | %%-11s | %%-%ds | %%-%ds\n", maxFileLen, maxSizeLen, maxDescLen, maxDateLen, maxFolderLen, maxUserLen, maxOwnerLen, maxGroupLen)
+		fmt.Printf(headerFmt, "Name", "Size", "Description", "Created At", "Folder", "User Name", "Permissions", "Owner", "Group")
+		fmt.Println(strings.Repeat("-", maxFileLen+maxSizeLen+maxFolderLen+maxDescLen+maxDateLen+maxUserLen+maxOwnerLen+maxGroupLen+40))
 
 		for _, file := range files {
-			fmt.Printf(headerFmt, file.Name, file.Description, file.CreatedAt.Format(time.DateTime), file.FolderName, file.Username)
+			fmt.Printf(headerFmt, file.Name, strconv.FormatInt(file.Size, 10), file.Description, file.CreatedAt.Format(time.DateTime), file.FolderPath, file.Username, "-"+file.Mode.String(), owners[file.OwnerID], file.Group)
+		}
+
+	}
+}
+
+// writeFile replaces or appends to the content of a file.
+// The content is read from a host file if one is given, or else from the standard input until a line containing only EOF.
+func writeFile(args []string, scanner *bufio.Scanner, fileService *service.FileService, appendContent bool) {
+	command := "write-file"
+	if appendContent {
+		command = "append-file"
+	}
+	if len(args) != 4 && len(args) != 5 {
+		fmt.Printf("Usage: %s [folderpath] [filename] [hostfile]?\n", command)
+		return
+	}
+	username, folderPath, fileName := args[1], args[2], args[3]
+
+	var data []byte
+	if len(args) == 5 {
+		var err error
+		if data, err = os.ReadFile(args[4]); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+			return
+		}
+	} else {
+		data = readContent(scanner)
+	}
+
+	var err error
+	if appendContent {
+		err = fileService.AppendFile(username, folderPath, fileName, data)
+	} else {
+		err = fileService.WriteFile(username, folderPath, fileName, data)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Write %d bytes to '%s' in %s successfully.\n", len(data), fileName, fullPath(username, folderPath))
+	}
+}
+
+// readContent reads lines from the standard input until a line containing only EOF or the end of the input
+func readContent(scanner *bufio.Scanner) []byte {
+	fmt.Println("Enter the content, then type EOF on a line of its own to finish:")
+	var content strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "EOF" {
+			break
 		}
+		content.WriteString(line)
+		content.WriteString("\n")
+	}
+	return []byte(content.String())
+}
 
+// truncateFile changes the size of the content of a file
+func truncateFile(args []string, fileService *service.FileService) {
+	if len(args) != 5 {
+		fmt.Println("Usage: truncate-file [folderpath] [filename] [size]")
+		return
+	}
+	size, err := strconv.ParseInt(args[4], 10, 64)
+	if err != nil {
+		fmt.Println("Usage: truncate-file [folderpath] [filename] [size]")
+		return
+	}
+	err = fileService.Truncate(args[1], args[2], args[3], size)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Truncate '%s' in %s to %d bytes successfully.\n", args[3], fullPath(args[1], args[2]), size)
+	}
+}
+
+// catFile prints the content of a file, or the part of it starting at offset and holding at most length bytes
+func catFile(args []string, fileService *service.FileService) {
+	if len(args) != 4 && len(args) != 6 {
+		fmt.Println("Usage: cat [folderpath] [filename] [offset length]?")
+		return
+	}
+	var data []byte
+	var err error
+	if len(args) == 4 {
+		data, err = fileService.ReadFile(args[1], args[2], args[3])
+	} else {
+		offset, offsetErr := strconv.ParseInt(args[4], 10, 64)
+		length, lengthErr := strconv.ParseInt(args[5], 10, 64)
+		if offsetErr != nil || lengthErr != nil {
+			fmt.Println("Usage: cat [folderpath] [filename] [offset length]?")
+			return
+		}
+		data, err = fileService.ReadFileRange(args[1], args[2], args[3], offset, length)
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	os.Stdout.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		fmt.Println()
+	}
+}
+
+// importFile streams the content of a host file into a file, creating the file if it doesn't exist yet. The host
+// file is never held in memory as a whole, so it can be larger than the memory.
+func importFile(args []string, fileService *service.FileService) {
+	if len(args) != 5 {
+		fmt.Println("Usage: import-file [folderpath] [filename] [hostfile]")
+		return
+	}
+	src, err := os.Open(args[4])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	defer src.Close()
+	dst, err := fileService.Create(args[1], args[2], args[3])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	n, err := io.Copy(dst, src)
+	if err != nil {
+		// Keep the old content of the file rather than a part of the host file
+		err = dst.CloseWithError(err)
+	} else {
+		err = dst.Close()
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Import %d bytes from '%s' to '%s' in %s successfully.\n", n, args[4], args[3], fullPath(args[1], args[2]))
+	}
+}
+
+// exportFile streams the content of a file into a host file, replacing the host file if it exists
+func exportFile(args []string, fileService *service.FileService) {
+	if len(args) != 5 {
+		fmt.Println("Usage: export-file [folderpath] [filename] [hostfile]")
+		return
+	}
+	src, err := fileService.Open(args[1], args[2], args[3])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	defer src.Close()
+	dst, err := os.Create(args[4])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	n, err := io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Export %d bytes from '%s' in %s to '%s' successfully.\n", n, args[3], fullPath(args[1], args[2]), args[4])
+	}
+}
+
+// showHistory lists the versions of a file, oldest first
+func showHistory(args []string, userService *service.UserService, fileService *service.FileService) {
+	if len(args) != 4 {
+		fmt.Println("Usage: history [folderpath] [filename]")
+		return
+	}
+	versions, err := fileService.History(args[1], args[2], args[3])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	if len(versions) == 0 {
+		fmt.Println("Warning: The file has no history yet.")
+		return
+	}
+
+	type versionRow struct{ number, size, hash, author, createdAt string }
+	names := ownerNames(userService)
+	rows := make([]versionRow, 0, len(versions))
+	for _, version := range versions {
+		author, ok := names[version.AuthorID]
+		if !ok {
+			author = "-" // the user has been deleted since
+		}
+		rows = append(rows, versionRow{
+			number:    strconv.Itoa(version.Number),
+			size:      strconv.FormatInt(version.Size, 10),
+			hash:      version.Hash[:min(shortHashLen, len(version.Hash))],
+			author:    author,
+			createdAt: version.CreatedAt.Format(time.DateTime),
+		})
+	}
+
+	maxNumberLen, maxSizeLen, maxAuthorLen := len("Version"), len("Size"), len("Author")
+	for _, row := range rows {
+		maxNumberLen = max(maxNumberLen, len(row.number))
+		maxSizeLen = max(maxSizeLen, len(row.size))
+		maxAuthorLen = max(maxAuthorLen, len(row.author))
+	}
+	headerFmt := fmt.Sprintf("%%-%ds | %%-%ds | %%-%ds | %%-%ds | %%s\n", maxNumberLen, maxSizeLen, shortHashLen, maxAuthorLen)
+	fmt.Printf(headerFmt, "Version", "Size", "Hash", "Author", "Created At")
+	fmt.Println(strings.Repeat("-", maxNumberLen+maxSizeLen+shortHashLen+maxAuthorLen+len(time.DateTime)+12))
+	for _, row := range rows {
+		fmt.Printf(headerFmt, row.number, row.size, row.hash, row.author, row.createdAt)
+	}
+}
+
+// shortHashLen is how many characters of the content hash of a version are shown
+const shortHashLen = 12
+
+// parseVersion returns the version number given as an argument, or false if it isn't a positive number
+func parseVersion(arg string) (int, bool) {
+	number, err := strconv.Atoi(arg)
+	return number, err == nil && number > 0
+}
+
+// showVersion prints the content of a file as it was in a version
+func showVersion(args []string, fileService *service.FileService) {
+	usage := "Usage: show-version [folderpath] [filename] [version]"
+	if len(args) != 5 {
+		fmt.Println(usage)
+		return
+	}
+	number, ok := parseVersion(args[4])
+	if !ok {
+		fmt.Println(usage)
+		return
+	}
+	data, err := fileService.ReadVersion(args[1], args[2], args[3], number)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	os.Stdout.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		fmt.Println()
+	}
+}
+
+// diffVersions prints the line diff between two versions of a file, or between a version and the current content
+func diffVersions(args []string, fileService *service.FileService) {
+	usage := "Usage: diff [folderpath] [filename] [version] [version]?"
+	if len(args) != 5 && len(args) != 6 {
+		fmt.Println(usage)
+		return
+	}
+	from, ok := parseVersion(args[4])
+	if !ok {
+		fmt.Println(usage)
+		return
+	}
+	to := 0 // the current content
+	if len(args) == 6 {
+		if to, ok = parseVersion(args[5]); !ok {
+			fmt.Println(usage)
+			return
+		}
+	}
+	diff, err := fileService.DiffVersions(args[1], args[2], args[3], from, to)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	for _, line := range diff {
+		fmt.Println(line.String())
+	}
+}
+
+// revertFile replaces the content of a file with its content in a version
+func revertFile(args []string, fileService *service.FileService) {
+	usage := "Usage: revert [folderpath] [filename] [version]"
+	if len(args) != 5 {
+		fmt.Println(usage)
+		return
+	}
+	number, ok := parseVersion(args[4])
+	if !ok {
+		fmt.Println(usage)
+		return
+	}
+	if err := fileService.RevertFile(args[1], args[2], args[3], number); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Revert '%s' in %s to version %d successfully.\n", args[3], fullPath(args[1], args[2]), number)
+	}
+}
+
+// conflictPolicies maps the flags of mv, cp and restore to the policy applied when the destination already exists
+var conflictPolicies = map[string]service.ConflictPolicy{
+	"--overwrite": service.ConflictOverwrite,
+	"--skip":      service.ConflictSkip,
+	"--rename":    service.ConflictRename,
+}
+
+// relocateFile moves or copies a file to another folder of the same user
+func relocateFile(args []string, fileService *service.FileService, copyFile bool) {
+	usage := "Usage: mv [folderpath] [filename] [dest-folderpath] [new-filename]? [--overwrite|--skip|--rename]?"
+	verb := "Move"
+	if copyFile {
+		usage = "Usage: cp" + strings.TrimPrefix(usage, "Usage: mv")
+		verb = "Copy"
+	}
+
+	policy := service.ConflictFail
+	if len(args) > 5 && strings.HasPrefix(args[len(args)-1], "--") {
+		var ok bool
+		if policy, ok = conflictPolicies[args[len(args)-1]]; !ok {
+			fmt.Println(usage)
+			return
+		}
+		args = args[:len(args)-1]
+	}
+	if len(args) != 5 && len(args) != 6 {
+		fmt.Println(usage)
+		return
+	}
+	username, folderPath, fileName, destPath := args[1], args[2], args[3], args[4]
+	destName := ""
+	if len(args) == 6 {
+		destName = args[5]
+	}
+
+	var file models.File
+	var done bool
+	var err error
+	if copyFile {
+		file, done, err = fileService.CopyFile(username, folderPath, fileName, destPath, destName, policy)
+	} else {
+		file, done, err = fileService.MoveFile(username, folderPath, fileName, destPath, destName, policy)
+	}
+	source := models.JoinPath(fullPath(username, folderPath), fileName)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else if !done {
+		fmt.Printf("Skip '%s': '%s' already exists.\n", source, fullPath(file.Username, file.Path()))
+	} else {
+		fmt.Printf("%s '%s' to '%s' successfully.\n", verb, source, fullPath(file.Username, file.Path()))
+	}
+}
+
+// checkStore finds the data the store keeps for entities that no longer exist, and removes it if --repair is given
+func checkStore(args []string, store dataStore) {
+	if len(args) > 2 || (len(args) == 2 && args[1] != "--repair") {
+		fmt.Println("Usage: fsck [--repair]?")
+		return
+	}
+	if store == nil {
+		fmt.Println("The memory storage has no orphans to check.")
+		return
+	}
+
+	repair := len(args) == 2
+	orphans, err := store.Fsck(repair)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	for _, orphan := range orphans {
+		fmt.Printf("Orphan: %s\n", orphan)
+	}
+	switch {
+	case len(orphans) == 0:
+		fmt.Println("The store is consistent.")
+	case repair:
+		fmt.Printf("Removed %d orphans successfully.\n", len(orphans))
+	default:
+		fmt.Printf("Found %d orphans. Type 'fsck --repair' to remove them.\n", len(orphans))
+	}
+}
+
+// collectGarbage removes the blobs of file contents that nothing references any longer
+func collectGarbage(args []string, fileService *service.FileService) {
+	if len(args) != 1 {
+		fmt.Println("Usage: gc")
+		return
+	}
+	removed, err := fileService.CollectGarbage()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Removed %d unreferenced blobs successfully.\n", removed)
+	}
+}
+
+// changePermissions changes the mode, owner or group of a folder, or of a file inside it if a file name is given
+func changePermissions(args []string, folderService *service.FolderService, fileService *service.FileService) {
+	usages := map[string]string{
+		"chmod": "Usage: chmod [mode] [folderpath] [filename]?",
+		"chown": "Usage: chown [username] [folderpath] [filename]?",
+		"chgrp": "Usage: chgrp [group|--none] [folderpath] [filename]?",
+	}
+	if len(args) != 4 && len(args) != 5 {
+		fmt.Println(usages[args[0]])
+		return
+	}
+	username, value, folderPath := args[1], args[2], args[3]
+	if args[0] == "chgrp" && value == "--none" {
+		value = ""
+	}
+
+	var err error
+	target := fullPath(username, folderPath)
+	if len(args) == 5 {
+		fileName := args[4]
+		target = models.JoinPath(target, fileName)
+		switch args[0] {
+		case "chmod":
+			err = fileService.ChangeFileMode(username, folderPath, fileName, value)
+		case "chown":
+			err = fileService.ChangeFileOwner(username, folderPath, fileName, value)
+		case "chgrp":
+			err = fileService.ChangeFileGroup(username, folderPath, fileName, value)
+		}
+	} else {
+		switch args[0] {
+		case "chmod":
+			err = folderService.ChangeFolderMode(username, folderPath, value)
+		case "chown":
+			err = folderService.ChangeFolderOwner(username, folderPath, value)
+		case "chgrp":
+			err = folderService.ChangeFolderGroup(username, folderPath, value)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	switch args[0] {
+	case "chmod":
+		fmt.Printf("Change the mode of '%s' successfully.\n", target)
+	case "chown":
+		fmt.Printf("Change the owner of '%s' to '%s' successfully.\n", target, value)
+	case "chgrp":
+		fmt.Printf("Change the group of '%s' successfully.\n", target)
+	}
+}
+
+// changeGroupMembers adds a user to a group or removes a user from it on behalf of the logged-in user
+func changeGroupMembers(args []string, userService *service.UserService, add bool) {
+	if len(args) != 4 {
+		fmt.Printf("Usage: %s [group] [username]\n", args[0])
+		return
+	}
+	actor, group, member := args[1], args[2], args[3]
+	if add {
+		if err := userService.AddToGroup(actor, group, member); err != nil {
+			fmt.Printf("Error: %s\n", err.Error())
+		} else {
+			fmt.Printf("Add '%s' to the group '%s' successfully.\n", member, group)
+		}
+		return
+	}
+	if err := userService.RemoveFromGroup(actor, group, member); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Remove '%s' from the group '%s' successfully.\n", member, group)
+	}
+}
+
+// shareFolder shares a folder of the logged-in user with another user
+func shareFolder(args []string, folderService *service.FolderService) {
+	if len(args) != 5 {
+		fmt.Println("Usage: share-folder [folderpath] [username] [read|read-write]")
+		return
+	}
+	username, folderPath, sharee, access := args[1], args[2], args[3], args[4]
+	if err := folderService.ShareFolder(username, folderPath, sharee, access); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Share '%s' with '%s' for %s access successfully.\n", fullPath(username, folderPath), sharee, access)
+	}
+}
+
+// unshareFolder stops sharing a folder of the logged-in user with another user
+func unshareFolder(args []string, folderService *service.FolderService) {
+	if len(args) != 4 {
+		fmt.Println("Usage: unshare-folder [folderpath] [username]")
+		return
+	}
+	username, folderPath, sharee := args[1], args[2], args[3]
+	if err := folderService.UnshareFolder(username, folderPath, sharee); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Unshare '%s' with '%s' successfully.\n", fullPath(username, folderPath), sharee)
+	}
+}
+
+// listShared lists the folders the logged-in user shares with others and the folders others share with them, one
+// share per row
+func listShared(args []string, userService *service.UserService, folderService *service.FolderService) {
+	if len(args) != 2 {
+		fmt.Println("Usage: list-shared")
+		return
+	}
+	username := args[1]
+	folders, err := folderService.ListShared(username)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+
+	// Keep the shares of the user's own folders and the shares with the user
+	type sharedRow struct{ path, user, access string }
+	names := ownerNames(userService)
+	var rows []sharedRow
+	for _, folder := range folders {
+		folderPath := folder.Path()
+		if folder.Username != username {
+			folderPath = models.TreePrefix + folder.Username + folderPath
+		}
+		for _, share := range folder.Shares {
+			if folder.Username == username || names[share.UserID] == username {
+				rows = append(rows, sharedRow{folderPath, names[share.UserID], string(share.Access)})
+			}
+		}
+	}
+	if len(rows) == 0 {
+		fmt.Printf("Warning: The %s doesn't share any folders and no folders are shared with them.\n", username)
+		return
+	}
+
+	maxPathLen, maxUserLen, maxAccessLen := len("Folder"), len("Shared With"), len("Access")
+	for _, row := range rows {
+		maxPathLen = max(maxPathLen, len(row.path))
+		maxUserLen = max(maxUserLen, len(row.user))
+		maxAccessLen = max(maxAccessLen, len(row.access))
+	}
+	headerFmt := fmt.Sprintf("%%-%ds | %%-%ds | %%-%ds\n", maxPathLen, maxUserLen, maxAccessLen)
+	fmt.Printf(headerFmt, "Folder", "Shared With", "Access")
+	fmt.Println(strings.Repeat("-", maxPathLen+maxUserLen+maxAccessLen+6))
+	for _, row := range rows {
+		fmt.Printf(headerFmt, row.path, row.user, row.access)
+	}
+}
+
+// folderName returns the name a folder is listed under in the tree of the given user: its name, or its path with the
+// tree prefix if the folder is in the tree of another user, e.g. "~alice/projects/2024"
+func folderName(username string, folder models.Folder) string {
+	if folder.Username == username {
+		return folder.Name
+	}
+	return models.TreePrefix + folder.Username + folder.Path()
+}
+
+// ownerNames returns the usernames of all the users by ID, to name the owners of folders and files
+func ownerNames(userService *service.UserService) map[models.ID]string {
+	names := make(map[models.ID]string)
+	users, err := userService.ListUsers("", "")
+	if err != nil {
+		return names
+	}
+	for _, user := range users {
+		names[user.ID] = user.Username
+	}
+	return names
+}
+
+// treeUser returns the user whose tree the path is in: the given user, or the user named by the tree prefix of the path
+func treeUser(username, folderPath string) string {
+	if tree, _ := models.SplitTree(folderPath); tree != "" {
+		return tree
+	}
+	return username
+}
+
+// fullPath returns the absolute path of a folder of the given user, e.g. "/user1/projects/2024/q3".
+// A path in the tree of another user, e.g. "~alice/projects", becomes the path of that user's folder.
+func fullPath(username, folderPath string) string {
+	_, treePath := models.SplitTree(folderPath)
+	return models.JoinPath(models.RootPath+treeUser(username, folderPath), treePath)
+}
+
+// listTrash lists the folders and files in the trash of the logged-in user, in the order they were deleted
+func listTrash(args []string, userService *service.UserService, trashService *service.TrashService) {
+	if len(args) != 3 || args[2] != "list" {
+		fmt.Println("Usage: trash list")
+		return
+	}
+	username := args[1]
+	entries, err := trashService.ListTrash(username)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("Warning: The trash is empty.")
+		return
+	}
+
+	type trashRow struct{ id, path, kind, size, deletedAt, deletedBy, expiresAt string }
+	names := ownerNames(userService)
+	rows := make([]trashRow, 0, len(entries))
+	for _, entry := range entries {
+		kind := "file"
+		if entry.IsFolder() {
+			kind = "folder"
+		}
+		expiresAt := "never"
+		if retention := trashService.Retention(); retention > 0 {
+			expiresAt = entry.DeletedAt.Add(retention).Format(time.DateTime)
+		}
+		deletedBy, ok := names[entry.DeletedBy]
+		if !ok {
+			deletedBy = "-" // the user has been deleted since
+		}
+		rows = append(rows, trashRow{
+			id:        entry.ID.String(),
+			path:      fullPath(username, entry.Path),
+			kind:      kind,
+			size:      strconv.FormatInt(entry.Size(), 10),
+			deletedAt: entry.DeletedAt.Format(time.DateTime),
+			deletedBy: deletedBy,
+			expiresAt: expiresAt,
+		})
+	}
+
+	maxIDLen, maxPathLen, maxSizeLen, maxUserLen, maxExpiresLen := len("ID"), len("Path"), len("Size"), len("Deleted By"), len("Expires At")
+	for _, row := range rows {
+		maxIDLen = max(maxIDLen, len(row.id))
+		maxPathLen = max(maxPathLen, len(row.path))
+		maxSizeLen = max(maxSizeLen, len(row.size))
+		maxUserLen = max(maxUserLen, len(row.deletedBy))
+		maxExpiresLen = max(maxExpiresLen, len(row.expiresAt))
+	}
+	dateLen := len(time.DateTime)
+	headerFmt := fmt.Sprintf("%%-%ds | %%-%ds | %%-6s | %%-%ds | %%-%ds | %%-%ds | %%-%ds\n", maxIDLen, maxPathLen, maxSizeLen, dateLen, maxUserLen, maxExpiresLen)
+	fmt.Printf(headerFmt, "ID", "Path", "Type", "Size", "Deleted At", "Deleted By", "Expires At")
+	fmt.Println(strings.Repeat("-", maxIDLen+maxPathLen+6+maxSizeLen+dateLen+maxUserLen+maxExpiresLen+18))
+	for _, row := range rows {
+		fmt.Printf(headerFmt, row.id, row.path, row.kind, row.size, row.deletedAt, row.deletedBy, row.expiresAt)
+	}
+}
+
+// restoreTrash restores an entry from the trash of the logged-in user to the path it was deleted from
+func restoreTrash(args []string, trashService *service.TrashService) {
+	usage := "Usage: restore [id] [--overwrite|--skip|--rename]?"
+	policy := service.ConflictFail
+	if len(args) == 4 {
+		var ok bool
+		if policy, ok = conflictPolicies[args[3]]; !ok {
+			fmt.Println(usage)
+			return
+		}
+	} else if len(args) != 3 {
+		fmt.Println(usage)
+		return
+	}
+	id, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || id <= 0 {
+		fmt.Println(usage)
+		return
+	}
+
+	username := args[1]
+	restoredPath, done, err := trashService.RestoreTrash(username, models.ID(id), policy)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else if !done {
+		fmt.Printf("Skip restoring %s: '%s' already exists.\n", args[2], fullPath(username, restoredPath))
+	} else {
+		fmt.Printf("Restore '%s' successfully.\n", fullPath(username, restoredPath))
+	}
+}
+
+// emptyTrash permanently removes everything in the trash of the logged-in user
+func emptyTrash(args []string, trashService *service.TrashService) {
+	if len(args) != 2 {
+		fmt.Println("Usage: empty-trash")
+		return
+	}
+	removed, err := trashService.EmptyTrash(args[1])
+	if err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+	} else {
+		fmt.Printf("Remove %d entries from the trash permanently.\n", removed)
 	}
 }
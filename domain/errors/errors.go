@@ -1,53 +1,447 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 )
 
+// Kind identifies the kind of entity or value an error is about
+type Kind string
+
+const (
+	KindUser        Kind = "user"
+	KindFolder      Kind = "folder"
+	KindFile        Kind = "file"
+	KindTrash       Kind = "trash entry"
+	KindVersion     Kind = "version"
+	KindBlob        Kind = "blob"
+	KindName        Kind = "name"
+	KindPath        Kind = "path"
+	KindEmail       Kind = "email"
+	KindDisplayName Kind = "display name"
+	KindPassword    Kind = "password"
+	KindGroup       Kind = "group"
+	KindMode        Kind = "mode"
+	KindAccess      Kind = "access"
+	KindSize        Kind = "size"
+	KindRange       Kind = "range"
+)
+
+// Code is a stable, machine-readable identifier of an error.
+// Codes never change once released, so they can be relied on by scripts and other programs.
+type Code string
+
+const (
+	CodeUserNotFound       Code = "USER_NOT_FOUND"
+	CodeUserExists         Code = "USER_EXISTS"
+	CodeFolderNotFound     Code = "FOLDER_NOT_FOUND"
+	CodeFolderExists       Code = "FOLDER_EXISTS"
+	CodeFolderNotEmpty     Code = "FOLDER_NOT_EMPTY"
+	CodeFileNotFound       Code = "FILE_NOT_FOUND"
+	CodeFileExists         Code = "FILE_EXISTS"
+	CodeTrashNotFound      Code = "TRASH_NOT_FOUND"
+	CodeVersionNotFound    Code = "VERSION_NOT_FOUND"
+	CodeBlobNotFound       Code = "BLOB_NOT_FOUND"
+	CodeCorruptBlob        Code = "CORRUPT_BLOB"
+	CodeInvalidName        Code = "INVALID_NAME"
+	CodeNameTooLong        Code = "NAME_TOO_LONG"
+	CodeReservedName       Code = "RESERVED_NAME"
+	CodeInvalidExtension   Code = "INVALID_EXTENSION"
+	CodeInvalidPath        Code = "INVALID_PATH"
+	CodeInvalidEmail       Code = "INVALID_EMAIL"
+	CodeInvalidDisplayName Code = "INVALID_DISPLAY_NAME"
+	CodeInvalidPassword    Code = "INVALID_PASSWORD"
+	CodeInvalidCredentials Code = "INVALID_CREDENTIALS"
+	CodeInvalidSize        Code = "INVALID_SIZE"
+	CodeInvalidRange       Code = "INVALID_RANGE"
+	CodeDiffTooLarge       Code = "DIFF_TOO_LARGE"
+	CodeInvalidMode        Code = "INVALID_MODE"
+	CodeInvalidAccess      Code = "INVALID_ACCESS"
+	CodePermissionDenied   Code = "PERMISSION_DENIED"
+	CodeInvalidStore       Code = "INVALID_STORE"
+	CodeInternal           Code = "INTERNAL"
+)
+
+// Sentinel errors matching every error of a category with errors.Is
+var (
+	// ErrNotFound matches every error returned when an entity does not exist
+	ErrNotFound = stderrors.New("not found")
+	// ErrConflict matches every error returned when an entity already exists
+	ErrConflict = stderrors.New("conflict")
+	// ErrInvalid matches every error returned when a value is rejected by validation
+	ErrInvalid = stderrors.New("invalid")
+	// ErrCorrupt matches every error returned when the data kept in a store is malformed or inconsistent
+	ErrCorrupt = stderrors.New("corrupt")
+	// ErrUnauthenticated matches every error returned when a user can't prove who they are
+	ErrUnauthenticated = stderrors.New("unauthenticated")
+	// ErrForbidden matches every error returned when a user isn't allowed to do something
+	ErrForbidden = stderrors.New("forbidden")
+)
+
+// NotFoundError is returned when an entity does not exist
+type NotFoundError struct {
+	Kind Kind
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("The %s [%s] doesn't exist.", e.Kind, e.Name)
+}
+
+// Is reports whether target is ErrNotFound
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// Code returns the code of the error, which depends on the kind of entity
+func (e *NotFoundError) Code() Code {
+	switch e.Kind {
+	case KindUser:
+		return CodeUserNotFound
+	case KindFolder:
+		return CodeFolderNotFound
+	case KindFile:
+		return CodeFileNotFound
+	case KindTrash:
+		return CodeTrashNotFound
+	case KindVersion:
+		return CodeVersionNotFound
+	case KindBlob:
+		return CodeBlobNotFound
+	}
+	return CodeInternal
+}
+
+// ConflictError is returned when an entity already exists
+type ConflictError struct {
+	Kind Kind
+	Name string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("The %s [%s] already exists.", e.Kind, e.Name)
+}
+
+// Is reports whether target is ErrConflict
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Code returns the code of the error, which depends on the kind of entity
+func (e *ConflictError) Code() Code {
+	switch e.Kind {
+	case KindUser:
+		return CodeUserExists
+	case KindFolder:
+		return CodeFolderExists
+	case KindFile:
+		return CodeFileExists
+	}
+	return CodeInternal
+}
+
+// NotEmptyError is returned when an entity can't be removed because it still contains other entities
+type NotEmptyError struct {
+	Kind Kind
+	Name string
+}
+
+func (e *NotEmptyError) Error() string {
+	return fmt.Sprintf("The %s [%s] is not empty.", e.Kind, e.Name)
+}
+
+// Is reports whether target is ErrConflict
+func (e *NotEmptyError) Is(target error) bool {
+	return target == ErrConflict
+}
+
+// Code returns the code of the error
+func (e *NotEmptyError) Code() Code {
+	if e.Kind == KindFolder {
+		return CodeFolderNotEmpty
+	}
+	return CodeInternal
+}
+
+// ValidationError is returned when a value is rejected by validation
+type ValidationError struct {
+	ErrCode Code
+	Kind    Kind
+	Value   string
+	Reason  string // explains why the value was rejected, e.g. "is too long"
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("The %s [%s] %s", e.Kind, e.Value, e.Reason)
+}
+
+// Is reports whether target is ErrInvalid
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// Code returns the code of the error
+func (e *ValidationError) Code() Code {
+	return e.ErrCode
+}
+
+// AuthError is returned when a user can't prove who they are. It never tells which part of the credentials was wrong.
+type AuthError struct {
+	ErrCode Code
+	Reason  string
+}
+
+func (e *AuthError) Error() string {
+	return e.Reason
+}
+
+// Is reports whether target is ErrUnauthenticated
+func (e *AuthError) Is(target error) bool {
+	return target == ErrUnauthenticated
+}
+
+// Code returns the code of the error
+func (e *AuthError) Code() Code {
+	return e.ErrCode
+}
+
+// PermissionError is returned when a user isn't allowed to do something to an entity
+type PermissionError struct {
+	Kind   Kind
+	Name   string
+	Action string // what the user isn't allowed to do, e.g. "write"
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("Permission denied to %s the %s [%s].", e.Action, e.Kind, e.Name)
+}
+
+// Is reports whether target is ErrForbidden
+func (e *PermissionError) Is(target error) bool {
+	return target == ErrForbidden
+}
+
+// Code returns the code of the error
+func (e *PermissionError) Code() Code {
+	return CodePermissionDenied
+}
+
+// StoreError is returned when the data kept in a store is malformed or inconsistent
+type StoreError struct {
+	Path string
+	Err  error // the inconsistency found in the store
+}
+
+func (e *StoreError) Error() string {
+	return fmt.Sprintf("The store [%s] is invalid: %s.", e.Path, e.Err)
+}
+
+// Is reports whether target is ErrCorrupt
+func (e *StoreError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// Unwrap returns the inconsistency found in the store
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// Code returns the code of the error
+func (e *StoreError) Code() Code {
+	return CodeInvalidStore
+}
+
+// IntegrityError is returned when the data read from a blob doesn't match the hash it is addressed by
+type IntegrityError struct {
+	Hash string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("The blob [%s] is corrupt: its data doesn't match its hash.", e.Hash)
+}
+
+// Is reports whether target is ErrCorrupt
+func (e *IntegrityError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// Code returns the code of the error
+func (e *IntegrityError) Code() Code {
+	return CodeCorruptBlob
+}
+
+// CodeOf returns the code of err, or CodeInternal if err carries no code
+func CodeOf(err error) Code {
+	var coded interface{ Code() Code }
+	if stderrors.As(err, &coded) {
+		return coded.Code()
+	}
+	return CodeInternal
+}
+
 // NAMING ERRORS ========================================
 
-// ErrInvalidName ErrUserNotFound is an error that is returned when a user does not exist
-func ErrInvalidName(name string) error {
-	return fmt.Errorf("The name [%s] contains invalid chars. Only alphabets and numbers are allowed.", name)
+// ErrInvalidName is an error that is returned when a name contains invalid chars.
+// allowed describes the characters names may contain, e.g. "letters, digits and ._-", if there is a choice.
+func ErrInvalidName(name, allowed string) error {
+	reason := "contains invalid chars."
+	if allowed != "" {
+		reason += fmt.Sprintf(" Only %s are allowed.", allowed)
+	}
+	return &ValidationError{ErrCode: CodeInvalidName, Kind: KindName, Value: name, Reason: reason}
 }
 
-// ErrNameTooLong is an error that is returned when a username is too long
-func ErrNameTooLong(name string) error {
-	return fmt.Errorf("The name [%s] is too long. The maximum length is 30 characters.", name)
+// ErrNameTooLong is an error that is returned when a name is longer than maxLength characters
+func ErrNameTooLong(name string, maxLength int) error {
+	return &ValidationError{ErrCode: CodeNameTooLong, Kind: KindName, Value: name, Reason: fmt.Sprintf("is too long. The maximum length is %d characters.", maxLength)}
+}
+
+// ErrReservedName is an error that is returned when a name is reserved by the naming policy
+func ErrReservedName(name string) error {
+	return &ValidationError{ErrCode: CodeReservedName, Kind: KindName, Value: name, Reason: "is reserved."}
+}
+
+// ErrInvalidExtension is an error that is returned when a file name doesn't end with one of the allowed extensions
+func ErrInvalidExtension(name, allowed string) error {
+	return &ValidationError{ErrCode: CodeInvalidExtension, Kind: KindName, Value: name, Reason: fmt.Sprintf("has an invalid extension. Only %s are allowed.", allowed)}
+}
+
+// ErrOtherTree is an error that is returned when a file is moved or copied to a path in the tree of another user
+func ErrOtherTree(path string) error {
+	return &ValidationError{ErrCode: CodeInvalidPath, Kind: KindPath, Value: path, Reason: "is in the tree of another user. Files can only be moved or copied inside the tree that holds them."}
 }
 
 // USER ERRORS ========================================
 
 // ErrUserExists is an error that is returned when a user already exists
 func ErrUserExists(username string) error {
-	return fmt.Errorf("The user [%s] already exists.", username)
+	return &ConflictError{Kind: KindUser, Name: username}
 }
 
 // ErrUserNotExists ErrUserNotFound is an error that is returned when a user does not exist
 func ErrUserNotExists(username string) error {
-	return fmt.Errorf("The user [%s] doesn't exist.", username)
+	return &NotFoundError{Kind: KindUser, Name: username}
+}
+
+// ErrInvalidEmail is an error that is returned when the email address of a user is malformed
+func ErrInvalidEmail(email string) error {
+	return &ValidationError{ErrCode: CodeInvalidEmail, Kind: KindEmail, Value: email, Reason: "is invalid. It must be a plain address such as alice@example.com."}
+}
+
+// ErrInvalidDisplayName is an error that is returned when the display name of a user is too long or contains control
+// characters
+func ErrInvalidDisplayName(displayName string, maxLength int) error {
+	return &ValidationError{ErrCode: CodeInvalidDisplayName, Kind: KindDisplayName, Value: displayName, Reason: fmt.Sprintf("is invalid. It must be at most %d characters long without control characters.", maxLength)}
+}
+
+// ErrInvalidPassword is an error that is returned when a new password is too short, too long or contains control
+// characters. The password itself is never part of the error.
+func ErrInvalidPassword(minLength, maxLength int) error {
+	return &ValidationError{ErrCode: CodeInvalidPassword, Kind: KindPassword, Value: "********", Reason: fmt.Sprintf("is invalid. It must be %d to %d characters long without control characters.", minLength, maxLength)}
+}
+
+// ErrInvalidCredentials is an error that is returned when a user logs in with an unknown username or a wrong password
+func ErrInvalidCredentials() error {
+	return &AuthError{ErrCode: CodeInvalidCredentials, Reason: "The username or password is incorrect."}
 }
 
 // FOLDER ERRORS ========================================
 
 // ErrFolderExists is an error that is returned when a folder already exists
 func ErrFolderExists(folderName string) error {
-	return fmt.Errorf("The folder [%s] already exists.", folderName)
+	return &ConflictError{Kind: KindFolder, Name: folderName}
 }
 
 // ErrFolderNotFound is an error that is returned when a folder does not exist
 func ErrFolderNotFound(folderName string) error {
-	return fmt.Errorf("The folder [%s] doesn't exist.", folderName)
+	return &NotFoundError{Kind: KindFolder, Name: folderName}
+}
+
+// ErrFolderNotEmpty is an error that is returned when a folder that still holds folders or files is deleted
+func ErrFolderNotEmpty(folderName string) error {
+	return &NotEmptyError{Kind: KindFolder, Name: folderName}
 }
 
 // FILE ERRORS ========================================
 
 // ErrFileExists is an error that is returned when a file already exists
 func ErrFileExists(fileName string) error {
-	return fmt.Errorf("The file [%s] already exists.", fileName)
+	return &ConflictError{Kind: KindFile, Name: fileName}
 }
 
 // ErrFileNotFound is an error that is returned when a file does not exist
 func ErrFileNotFound(fileName string) error {
-	return fmt.Errorf("The file [%s] doesn't exist.", fileName)
+	return &NotFoundError{Kind: KindFile, Name: fileName}
+}
+
+// TRASH ERRORS ========================================
+
+// ErrTrashNotFound is an error that is returned when the trash of a user holds no entry with the ID
+func ErrTrashNotFound(id string) error {
+	return &NotFoundError{Kind: KindTrash, Name: id}
+}
+
+// VERSION ERRORS ========================================
+
+// ErrVersionNotFound is an error that is returned when a file has no version with the number, e.g. "/docs/notes.txt@3"
+func ErrVersionNotFound(filePath string, number int) error {
+	return &NotFoundError{Kind: KindVersion, Name: fmt.Sprintf("%s@%d", filePath, number)}
+}
+
+// CONTENT ERRORS ========================================
+
+// ErrInvalidSize is an error that is returned when a file size is negative
+func ErrInvalidSize(size int64) error {
+	return &ValidationError{ErrCode: CodeInvalidSize, Kind: KindSize, Value: fmt.Sprint(size), Reason: "is invalid. The size must not be negative."}
+}
+
+// ErrSizeTooLarge is an error that is returned when a file would be padded beyond the largest size allowed
+func ErrSizeTooLarge(size, limit int64) error {
+	return &ValidationError{ErrCode: CodeInvalidSize, Kind: KindSize, Value: fmt.Sprint(size), Reason: fmt.Sprintf("is too large. Files can't be padded beyond %d bytes.", limit)}
+}
+
+// ErrDiffTooLarge is an error that is returned when a version has more bytes or lines, as counted in unit, than can be
+// diffed
+func ErrDiffTooLarge(size, limit int64, unit string) error {
+	return &ValidationError{ErrCode: CodeDiffTooLarge, Kind: KindSize, Value: fmt.Sprintf("%d %s", size, unit), Reason: fmt.Sprintf("is too large to diff. Only versions of up to %d %s can be diffed.", limit, unit)}
+}
+
+// ErrInvalidRange is an error that is returned when the offset or the length of a range of a file is negative
+func ErrInvalidRange(offset, length int64) error {
+	return &ValidationError{ErrCode: CodeInvalidRange, Kind: KindRange, Value: fmt.Sprintf("%d:%d", offset, length), Reason: "is invalid. The offset and the length must not be negative."}
+}
+
+// ErrBlobNotFound is an error that is returned when no blob is stored under the hash
+func ErrBlobNotFound(hash string) error {
+	return &NotFoundError{Kind: KindBlob, Name: hash}
+}
+
+// ErrCorruptBlob is an error that is returned when the data of the blob stored under the hash doesn't match the hash
+func ErrCorruptBlob(hash string) error {
+	return &IntegrityError{Hash: hash}
+}
+
+// PERMISSION ERRORS ========================================
+
+// ErrInvalidMode is an error that is returned when a mode is neither octal nor a list of symbolic changes
+func ErrInvalidMode(mode string) error {
+	return &ValidationError{ErrCode: CodeInvalidMode, Kind: KindMode, Value: mode, Reason: "is invalid. Use octal bits such as 750 or symbolic changes such as u+x,go-w."}
+}
+
+// ErrInvalidShareAccess is an error that is returned when the access a folder is shared with is neither read nor read-write
+func ErrInvalidShareAccess(access string) error {
+	return &ValidationError{ErrCode: CodeInvalidAccess, Kind: KindAccess, Value: access, Reason: `is invalid. Use "read" or "read-write".`}
+}
+
+// ErrPermissionDenied is an error that is returned when a user isn't allowed to do the action to an entity, e.g. to
+// "write" the folder "/projects"
+func ErrPermissionDenied(kind Kind, name, action string) error {
+	return &PermissionError{Kind: kind, Name: name, Action: action}
+}
+
+// STORAGE ERRORS ========================================
+
+// ErrInvalidStore is an error that is returned when the data kept in a store is malformed or inconsistent
+func ErrInvalidStore(storePath string, err error) error {
+	return &StoreError{Path: storePath, Err: err}
 }
@@ -0,0 +1,40 @@
+// domain/blob.go
+
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// HashContent returns the SHA-256 hash of the content in hexadecimal, which addresses the blob holding it
+func HashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// BlobRepository is an interface that abstracts the methods for the persistence of file contents as blobs.
+// A blob is addressed by the hash of its data, see HashContent, so equal contents are stored once however many files,
+// versions and trash entries reference them. Every blob counts its references: PutBlob and RetainBlob add one and
+// ReleaseBlob removes one, while CollectGarbage removes the blobs that are no longer referenced and returns how many
+// it removed. GetBlob verifies that the data still matches its hash.
+// OpenBlob and CreateBlob stream the data of a blob, so that contents larger than the memory can be read and written.
+// The empty hash stands for content that was never written, which is empty and never stored.
+type BlobRepository interface {
+	PutBlob(data []byte) (string, error)
+	GetBlob(hash string) ([]byte, error)
+	OpenBlob(hash string) (io.ReadSeekCloser, error)
+	CreateBlob() BlobWriter
+	RetainBlob(hash string) error
+	ReleaseBlob(hash string) error
+	CollectGarbage() (int, error)
+}
+
+// BlobWriter streams data into a new blob. Commit stores the blob like PutBlob, adding a reference to it, and returns
+// its hash, while Abort discards the data written so far. Once either is called the writer can't be used any longer.
+type BlobWriter interface {
+	io.Writer
+	Commit() (string, error)
+	Abort() error
+}
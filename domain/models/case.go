@@ -0,0 +1,73 @@
+// domain/case.go
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+// CasePolicy decides how the names of users, folders and files are compared.
+// A single policy is configured for the whole VFS and every repository matches names through it, so two names that
+// the policy considers equal can never name two users, two sibling folders or two files in the same folder.
+// Names are compared with full Unicode case folding, so "STRASSE" and "straße" match unless names are case sensitive.
+type CasePolicy int
+
+const (
+	// CasePreserving matches names regardless of case and keeps every name as it was given. It is the default.
+	CasePreserving CasePolicy = iota
+	// CaseSensitive treats names that differ only in case as different names
+	CaseSensitive
+	// CaseInsensitive matches names regardless of case and stores new names in lower case
+	CaseInsensitive
+)
+
+// casePolicyNames maps every case policy to the name it is configured with
+var casePolicyNames = map[CasePolicy]string{
+	CasePreserving:  "preserving",
+	CaseSensitive:   "sensitive",
+	CaseInsensitive: "insensitive",
+}
+
+// ParseCasePolicy returns the case policy with the given name: "preserving", "sensitive" or "insensitive"
+func ParseCasePolicy(name string) (CasePolicy, error) {
+	for policy, policyName := range casePolicyNames {
+		if strings.EqualFold(name, policyName) {
+			return policy, nil
+		}
+	}
+	return CasePreserving, fmt.Errorf("unknown case policy %q: expected \"preserving\", \"sensitive\" or \"insensitive\"", name)
+}
+
+// String returns the name of the case policy
+func (p CasePolicy) String() string {
+	if name, ok := casePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("CasePolicy(%d)", int(p))
+}
+
+// Key returns the form of a name or path under which it is indexed: two names are equal under the policy exactly
+// when their keys are equal. Folding never touches "/", so the key of a path is the path of the keys of its elements.
+func (p CasePolicy) Key(name string) string {
+	if p == CaseSensitive {
+		return name
+	}
+	return cases.Fold().String(name)
+}
+
+// Equal reports whether two names are equal under the policy
+func (p CasePolicy) Equal(a, b string) bool {
+	return a == b || p.Key(a) == p.Key(b)
+}
+
+// Normalize returns the form in which a new name is stored
+func (p CasePolicy) Normalize(name string) string {
+	if p == CaseInsensitive {
+		return cases.Lower(language.Und).String(name)
+	}
+	return name
+}
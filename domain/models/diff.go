@@ -0,0 +1,230 @@
+// domain/diff.go
+
+package models
+
+import (
+	"strings"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+)
+
+// Texts are diffed in memory, so only texts within these limits can be diffed. Diffing takes space linear in the
+// number of lines, and time proportional to the number of lines times the number of changed lines.
+const (
+	// MaxDiffSize is the largest text in bytes that can be diffed
+	MaxDiffSize = 8 << 20
+	// MaxDiffLines is the largest number of lines of a text that can be diffed
+	MaxDiffLines = 10_000
+)
+
+// DiffOp tells what happened to a line between two versions of a text
+type DiffOp byte
+
+const (
+	DiffEqual  DiffOp = ' ' // the line is in both versions
+	DiffDelete DiffOp = '-' // the line is only in the old version
+	DiffInsert DiffOp = '+' // the line is only in the new version
+)
+
+// DiffLine is a line of a diff between two versions of a text
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+// String returns the line prefixed with its operation, e.g. "+ hello"
+func (l DiffLine) String() string {
+	return string(l.Op) + " " + l.Text
+}
+
+// CheckDiffSize returns an error if a text of the size in bytes is too large to be diffed
+func CheckDiffSize(size int64) error {
+	if size > MaxDiffSize {
+		return customErrors.ErrDiffTooLarge(size, MaxDiffSize, "bytes")
+	}
+	return nil
+}
+
+// DiffLines returns the shortest diff that turns the old text into the new text, line by line. Where the texts differ,
+// the deleted lines come before the inserted lines.
+// It returns an error if either text has more than MaxDiffSize bytes or MaxDiffLines lines.
+func DiffLines(oldText, newText string) ([]DiffLine, error) {
+	for _, text := range []string{oldText, newText} {
+		if err := CheckDiffSize(int64(len(text))); err != nil {
+			return nil, err
+		}
+	}
+	a, b := splitLines(oldText), splitLines(newText)
+	for _, lines := range [][]string{a, b} {
+		if len(lines) > MaxDiffLines {
+			return nil, customErrors.ErrDiffTooLarge(int64(len(lines)), MaxDiffLines, "lines")
+		}
+	}
+
+	// The lines are compared by number, which is cheaper than comparing them by text every time
+	numbers := make(map[string]int)
+	number := func(lines []string) []int {
+		numbered := make([]int, len(lines))
+		for i, line := range lines {
+			n, ok := numbers[line]
+			if !ok {
+				n = len(numbers)
+				numbers[line] = n
+			}
+			numbered[i] = n
+		}
+		return numbered
+	}
+	size := 2*((len(a)+len(b)+1)/2) + 3
+	d := differ{a: a, b: b, x: number(a), y: number(b), forward: make([]int, size), backward: make([]int, size)}
+	d.diff(0, len(a), 0, len(b))
+	deletionsFirst(d.lines)
+	return d.lines, nil
+}
+
+// differ finds the shortest diff between the lines a and b, numbered x and y, with the linear space refinement of the
+// O(ND) algorithm by Eugene W. Myers. The furthest reaching paths of the current search are kept in forward and
+// backward, which are shared by every step.
+type differ struct {
+	a, b              []string
+	x, y              []int
+	forward, backward []int
+	lines             []DiffLine
+}
+
+// diff appends the diff between a[aLo:aHi] and b[bLo:bHi] to the lines. It splits them where the searches from both
+// ends of their shortest edit script meet and diffs the parts before and after, until one of them is empty.
+func (d *differ) diff(aLo, aHi, bLo, bHi int) {
+	// Lines both parts start or end with are equal in any shortest diff
+	for aLo < aHi && bLo < bHi && d.x[aLo] == d.y[bLo] {
+		d.lines = append(d.lines, DiffLine{Op: DiffEqual, Text: d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.x[aHi-suffix-1] == d.y[bHi-suffix-1] {
+		suffix++
+	}
+	aHi, bHi = aHi-suffix, bHi-suffix
+
+	switch {
+	case aLo == aHi:
+		for _, text := range d.b[bLo:bHi] {
+			d.lines = append(d.lines, DiffLine{Op: DiffInsert, Text: text})
+		}
+	case bLo == bHi:
+		for _, text := range d.a[aLo:aHi] {
+			d.lines = append(d.lines, DiffLine{Op: DiffDelete, Text: text})
+		}
+	default:
+		i, j := d.split(aLo, aHi, bLo, bHi)
+		d.diff(aLo, aLo+i, bLo, bLo+j)
+		d.diff(aLo+i, aHi, bLo+j, bHi)
+	}
+
+	for _, text := range d.a[aHi : aHi+suffix] {
+		d.lines = append(d.lines, DiffLine{Op: DiffEqual, Text: text})
+	}
+}
+
+// split returns a point on a shortest edit script between a[aLo:aHi] and b[bLo:bHi], as the number of lines of each
+// before it. The parts must neither be empty nor start or end with the same line, so the point is at neither end.
+// The script is searched from both ends at once, one more edit from each end in every step, until the paths meet.
+func (d *differ) split(aLo, aHi, bLo, bHi int) (int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	// The path reaching the furthest on diagonal k, where i-j is k, is kept at offset+k, or -1 before there is one.
+	// The backward search counts its lines and diagonals from the ends of the parts. Diagonals whose paths ran off
+	// the edges are trimmed from the search.
+	steps := (n + m + 1) / 2
+	offset := steps
+	forward, backward := d.forward[:2*steps+2], d.backward[:2*steps+2]
+	for k := range forward {
+		forward[k], backward[k] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+	var forwardStart, forwardEnd, backwardStart, backwardEnd int
+
+	for step := 0; step < steps; step++ {
+		for k := -step + forwardStart; k <= step-forwardEnd; k += 2 {
+			var i int
+			if k == -step || (k != step && forward[offset+k-1] < forward[offset+k+1]) {
+				i = forward[offset+k+1] // an insertion
+			} else {
+				i = forward[offset+k-1] + 1 // a deletion
+			}
+			j := i - k
+			for i < n && j < m && d.x[aLo+i] == d.y[bLo+j] {
+				i++
+				j++
+			}
+			forward[offset+k] = i
+			switch c := offset + delta - k; {
+			case i > n:
+				forwardEnd += 2
+			case j > m:
+				forwardStart += 2
+			case odd && c >= 0 && c < len(backward) && backward[c] != -1 && i >= n-backward[c]:
+				return i, j
+			}
+		}
+
+		for k := -step + backwardStart; k <= step-backwardEnd; k += 2 {
+			var i int
+			if k == -step || (k != step && backward[offset+k-1] < backward[offset+k+1]) {
+				i = backward[offset+k+1]
+			} else {
+				i = backward[offset+k-1] + 1
+			}
+			j := i - k
+			for i < n && j < m && d.x[aHi-1-i] == d.y[bHi-1-j] {
+				i++
+				j++
+			}
+			backward[offset+k] = i
+			switch c := offset + delta - k; {
+			case i > n:
+				backwardEnd += 2
+			case j > m:
+				backwardStart += 2
+			case !odd && c >= 0 && c < len(forward) && forward[c] != -1 && forward[c] >= n-i:
+				return forward[c], forward[c] - (c - offset)
+			}
+		}
+	}
+	// The parts have no line in common
+	return n, 0
+}
+
+// deletionsFirst moves the deleted lines of every run of changed lines before the inserted lines, keeping their order
+func deletionsFirst(lines []DiffLine) {
+	for start := 0; start < len(lines); {
+		if lines[start].Op == DiffEqual {
+			start++
+			continue
+		}
+		end := start
+		for end < len(lines) && lines[end].Op != DiffEqual {
+			end++
+		}
+		changed := make([]DiffLine, 0, end-start)
+		for _, op := range []DiffOp{DiffDelete, DiffInsert} {
+			for _, line := range lines[start:end] {
+				if line.Op == op {
+					changed = append(changed, line)
+				}
+			}
+		}
+		copy(lines[start:end], changed)
+		start = end
+	}
+}
+
+// splitLines splits a text into its lines. A final line break doesn't start another line.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -10,21 +10,40 @@ import (
 //Interfaces can be used to define the expected behaviors (services) of your domain entities,
 //making the core logic agnostic to specific implementations.
 
-// File represents a file in the VFS
+// File represents a file in the VFS.
+// Repositories reference the owner and the folder of a file by ID, and resolve Username and FolderPath from them
+// whenever a file is read. The file lives in the tree of the user with UserID, but its Permissions may give it to
+// another owner.
+// The content is kept in a blob referenced by its hash, so files with the same content share a single blob.
 type File struct {
+	ID          ID
+	UserID      ID
+	FolderID    ID // zero for a file in the root folder
 	Username    string
-	FolderName  string
+	FolderPath  string
 	Name        string
 	Description string
+	Size        int64
+	ContentHash string // the hash of the blob holding the content, empty while the content was never written
 	CreatedAt   time.Time
+	ModifiedAt  time.Time
+	Permissions
+}
+
+// Path returns the full path of the file, e.g. "/projects/2024/q3/report"
+func (f File) Path() string {
+	return JoinPath(f.FolderPath, f.Name)
 }
 
 // FileRepository is an interface that abstracts the methods for file persistence
 type FileRepository interface {
 	CreateFile(file File) error
-	DeleteFile(username, folderName, fileName string) error
-	ListFiles(username, folderName, sortField, sortOrder string) ([]File, error)
-	ValidateFileName(folderName string) error
+	GetFile(username, folderPath, fileName string) (File, error)
+	UpdateFile(file File) error
+	DeleteFile(username, folderPath, fileName string) error
+	MoveFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) error
+	CopyFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) (File, error)
+	ListFiles(username, folderPath, sortField, sortOrder string) ([]File, error)
 }
 
 // Interface Advantages:
@@ -8,22 +8,40 @@ import (
 //Interfaces can be used to define the expected behaviors (services) of your domain entities,
 //making the core logic agnostic to specific implementations.
 
-// Folder represents the folder entity in the domain layer
+// Folder represents the folder entity in the domain layer.
+// Folders are nested: each folder lives inside the folder at ParentPath, and the root path "/" is the parent of
+// every top-level folder of a user. Repositories keep the hierarchy by ID: UserID and ParentID reference the owner and
+// the parent folder, and Username and ParentPath are resolved from them whenever a folder is read.
+// The folder lives in the tree of the user with UserID, but its Permissions may give it to another owner.
+// Shares is the access control list of the folder, which grants other users access to it and to everything inside it.
 type Folder struct {
+	ID          ID
+	UserID      ID
+	ParentID    ID // zero for a top-level folder
 	Username    string
+	ParentPath  string
 	Name        string
 	Description string
 	CreatedAt   time.Time
+	Permissions
+	Shares []Share
+}
+
+// Path returns the full path of the folder, e.g. "/projects/2024/q3"
+func (f Folder) Path() string {
+	return JoinPath(f.ParentPath, f.Name)
 }
 
 // FolderRepository is an interface that abstracts the methods for folder persistence
 type FolderRepository interface {
-	Exists(userName, folderName string) (bool, error)
+	Exists(userName, folderPath string) (bool, error)
+	GetFolder(username, folderPath string) (Folder, error)
 	CreateFolder(folder Folder) error
-	DeleteFolder(username, folderName string) error
-	RenameFolder(username, folderName, newFolderName string) error
-	ListFolders(username, sortField, sortOrder string) ([]Folder, error)
-	ValidateFolderName(folderName string) error
+	DeleteFolder(username, folderPath string, recursive bool) ([]File, error)
+	RenameFolder(username, folderPath, newFolderName string) error
+	UpdateFolder(folder Folder) error
+	ListFolders(username, parentPath, sortField, sortOrder string) ([]Folder, error)
+	ListShared(username string) ([]Folder, error)
 }
 
 // Interface Advantages:
@@ -0,0 +1,16 @@
+// domain/id.go
+
+package models
+
+import "strconv"
+
+// ID identifies a user, folder or file.
+// IDs are assigned by the repository when an entity is created and never change afterwards, like the inode numbers of
+// a real file system: renaming or moving an entity keeps its ID, so references by ID never break.
+// The zero ID identifies no entity; a folder or file whose parent folder ID is zero lives in the root folder.
+type ID int64
+
+// String returns the decimal form of the ID
+func (id ID) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
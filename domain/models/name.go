@@ -0,0 +1,223 @@
+// domain/name.go
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// CharClass is a class of characters that names may be made of
+type CharClass string
+
+const (
+	// Letters are the letters of every script, e.g. "a", "É", "ß" or "語"
+	Letters CharClass = "letters"
+	// Digits are the decimal digits of every script, e.g. "7" or "٧"
+	Digits CharClass = "digits"
+	// Marks are the combining marks, e.g. the accents written after a letter in many scripts
+	Marks CharClass = "marks"
+	// ASCIILetters are the letters "a" to "z" and "A" to "Z"
+	ASCIILetters CharClass = "ascii-letters"
+	// ASCIIDigits are the digits "0" to "9"
+	ASCIIDigits CharClass = "ascii-digits"
+)
+
+// charClasses maps every character class to the test of its characters and its description
+var charClasses = map[CharClass]struct {
+	contains    func(r rune) bool
+	description string
+}{
+	Letters:      {unicode.IsLetter, "letters"},
+	Digits:       {unicode.IsDigit, "digits"},
+	Marks:        {unicode.IsMark, "combining marks"},
+	ASCIILetters: {func(r rune) bool { return r < utf8.RuneSelf && unicode.IsLetter(r) }, "ASCII letters"},
+	ASCIIDigits:  {func(r rune) bool { return '0' <= r && r <= '9' }, "ASCII digits"},
+}
+
+// NamePolicy decides which names users, folders and files may be given.
+// A single policy is configured for the whole VFS and injected into the services, which check every new name with it.
+// The zero value allows no characters at all; DefaultNamePolicy returns the policy used unless another is configured.
+type NamePolicy struct {
+	// Classes lists the classes of characters names may be made of
+	Classes []CharClass `json:"classes"`
+	// Symbols lists the other characters names may contain, e.g. "._-"
+	Symbols string `json:"symbols"`
+	// Extensions lists the extensions file names must end with, e.g. ".pdf", compared regardless of case.
+	// If it is empty, file names may have any extension or none.
+	Extensions []string `json:"extensions"`
+	// Reserved lists the names that can't be given, compared regardless of case and of the extension, e.g. "CON"
+	Reserved []string `json:"reserved"`
+	// MaxLength is the maximum length of a name in characters (runes); 0 means no limit
+	MaxLength int `json:"maxLength"`
+	// NFC normalizes new names to Unicode Normalization Form C, so that a name typed with precomposed or with
+	// combining characters is stored the same way
+	NFC bool `json:"nfc"`
+}
+
+// DefaultNamePolicy returns the default naming policy: up to 30 letters, digits and combining marks of any script,
+// dots, underscores and dashes, normalized to NFC
+func DefaultNamePolicy() NamePolicy {
+	return NamePolicy{
+		Classes:   []CharClass{Letters, Digits, Marks},
+		Symbols:   "._-",
+		MaxLength: 30,
+		NFC:       true,
+	}
+}
+
+// Check returns an error if the policy itself is invalid
+func (p NamePolicy) Check() error {
+	for _, class := range p.Classes {
+		if _, ok := charClasses[class]; !ok {
+			return fmt.Errorf("unknown character class %q", class)
+		}
+	}
+	for _, r := range p.Symbols {
+		if forbidden(r) {
+			return fmt.Errorf("the symbol %q can't be allowed in names", r)
+		}
+	}
+	for _, ext := range p.Extensions {
+		if !strings.HasPrefix(ext, ".") || len(ext) == 1 {
+			return fmt.Errorf("the extension %q must be a dot followed by at least one character", ext)
+		}
+	}
+	if p.MaxLength < 0 {
+		return fmt.Errorf("the maximum length %d is negative", p.MaxLength)
+	}
+	return nil
+}
+
+// Normalize returns the form in which a new name is validated and stored
+func (p NamePolicy) Normalize(name string) string {
+	if p.NFC {
+		return norm.NFC.String(name)
+	}
+	return name
+}
+
+// ValidateUsername checks if the username is allowed by the policy
+func (p NamePolicy) ValidateUsername(username string) error {
+	return p.validate(username)
+}
+
+// ValidateFolderName checks if the folder name is allowed by the policy
+func (p NamePolicy) ValidateFolderName(folderName string) error {
+	return p.validate(folderName)
+}
+
+// ValidateGroupName checks if the group name is allowed by the policy
+func (p NamePolicy) ValidateGroupName(groupName string) error {
+	return p.validate(groupName)
+}
+
+// ValidateFileName checks if the file name is allowed by the policy, including its extension
+func (p NamePolicy) ValidateFileName(fileName string) error {
+	if err := p.validate(fileName); err != nil {
+		return err
+	}
+	if len(p.Extensions) == 0 {
+		return nil
+	}
+	_, ext := SplitExtension(fileName)
+	for _, allowed := range p.Extensions {
+		if strings.EqualFold(ext, allowed) {
+			return nil
+		}
+	}
+	return customErrors.ErrInvalidExtension(fileName, strings.Join(p.Extensions, ", "))
+}
+
+// validate checks the rules shared by the names of users, groups, folders and files
+func (p NamePolicy) validate(name string) error {
+	// Check the length of the name first
+	if p.MaxLength > 0 && utf8.RuneCountInString(name) > p.MaxLength {
+		return customErrors.ErrNameTooLong(name, p.MaxLength)
+	}
+
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	if strings.HasPrefix(name, TreePrefix) {
+		return customErrors.ErrInvalidName(name, "") // the name would be taken for the tree of a user in paths
+	}
+	for _, r := range name {
+		if !p.allows(r) {
+			return customErrors.ErrInvalidName(name, p.describe())
+		}
+	}
+
+	base, _ := SplitExtension(name)
+	for _, reserved := range p.Reserved {
+		if strings.EqualFold(name, reserved) || strings.EqualFold(base, reserved) {
+			return customErrors.ErrReservedName(name)
+		}
+	}
+	return nil
+}
+
+// allows reports whether names may contain the character
+func (p NamePolicy) allows(r rune) bool {
+	if strings.ContainsRune(p.Symbols, r) {
+		return true
+	}
+	for _, class := range p.Classes {
+		if charClasses[class].contains(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns a description of the characters names may contain, e.g. "letters, digits and ._-"
+func (p NamePolicy) describe() string {
+	allowed := make([]string, 0, len(p.Classes)+1)
+	for _, class := range p.Classes {
+		allowed = append(allowed, charClasses[class].description)
+	}
+	if p.Symbols != "" {
+		allowed = append(allowed, p.Symbols)
+	}
+	switch len(allowed) {
+	case 0:
+		return "no characters"
+	case 1:
+		return allowed[0]
+	}
+	return strings.Join(allowed[:len(allowed)-1], ", ") + " and " + allowed[len(allowed)-1]
+}
+
+// CheckName checks the rules every name follows whatever the naming policy: a name is valid UTF-8, is not empty,
+// "." or "..", and contains no "/", white space or control characters, which would break paths and commands
+func CheckName(name string) error {
+	if name == "" || name == "." || name == ".." || !utf8.ValidString(name) {
+		return customErrors.ErrInvalidName(name, "")
+	}
+	for _, r := range name {
+		if forbidden(r) {
+			return customErrors.ErrInvalidName(name, "")
+		}
+	}
+	return nil
+}
+
+// forbidden reports whether no name may contain the character
+func forbidden(r rune) bool {
+	return r == '/' || unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+// SplitExtension splits a file name into its base and its extension, which starts at the last dot.
+// A name starting with its only dot, like ".profile", has no extension.
+func SplitExtension(fileName string) (base, ext string) {
+	i := strings.LastIndex(fileName, ".")
+	if i <= 0 {
+		return fileName, ""
+	}
+	return fileName[:i], fileName[i:]
+}
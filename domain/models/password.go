@@ -0,0 +1,58 @@
+// domain/password.go
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Password hashes are derived with PBKDF2-HMAC-SHA256 from the password and a random salt, and encoded together with
+// their parameters as "pbkdf2-sha256$<iterations>$<salt>$<key>", so the cost can be raised without invalidating the
+// hashes stored so far.
+const (
+	passwordScheme = "pbkdf2-sha256"
+	// PasswordIterations is the number of iterations new password hashes are derived with. It makes every guess slow.
+	PasswordIterations = 600_000
+	passwordSaltLength = 16
+	passwordKeyLength  = sha256.Size
+)
+
+// HashPassword returns the salted hash of the password in its encoded form
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2.Key([]byte(password), salt, PasswordIterations, passwordKeyLength, sha256.New)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, PasswordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword reports whether the password matches the encoded hash. A malformed hash matches no password.
+func VerifyPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) != passwordKeyLength {
+		return false
+	}
+	return subtle.ConstantTimeCompare(pbkdf2.Key([]byte(password), salt, iterations, passwordKeyLength, sha256.New), key) == 1
+}
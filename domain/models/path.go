@@ -0,0 +1,58 @@
+// domain/path.go
+
+package models
+
+import (
+	"path"
+	"strings"
+)
+
+// RootPath is the path of a user's root folder. Every folder path in the VFS is
+// relative to the root of the user who owns it, e.g. "/projects/2024/q3".
+const RootPath = "/"
+
+// TreePrefix starts the first element of a path that names a folder in the tree of another user, e.g.
+// "~alice/projects" names the folder "/projects" of alice. No new name may start with it.
+const TreePrefix = "~"
+
+// CleanPath returns the canonical form of a folder path.
+// The result is always rooted at "/", has no trailing slash and contains no "." or ".." elements,
+// so "projects//2024/../2024/" becomes "/projects/2024".
+func CleanPath(p string) string {
+	return path.Clean(RootPath + p)
+}
+
+// JoinPath appends a name to a parent folder path and returns the cleaned result.
+func JoinPath(parentPath, name string) string {
+	return path.Join(CleanPath(parentPath), name)
+}
+
+// SplitPath splits a folder path into the path of its parent folder and its own name.
+// Splitting the root path returns the root path and an empty name.
+func SplitPath(p string) (parentPath, name string) {
+	p = CleanPath(p)
+	if p == RootPath {
+		return RootPath, ""
+	}
+	return path.Dir(p), path.Base(p)
+}
+
+// PathElements returns the names of the folders along the path, outermost first.
+// The root path has no elements.
+func PathElements(p string) []string {
+	p = CleanPath(p)
+	if p == RootPath {
+		return nil
+	}
+	return strings.Split(strings.TrimPrefix(p, RootPath), "/")
+}
+
+// SplitTree splits a path starting with the tree prefix into the username of the tree and the cleaned path inside it,
+// so "~alice/projects" becomes "alice" and "/projects". Any other path returns an empty username and the cleaned path.
+func SplitTree(p string) (username, folderPath string) {
+	elements := PathElements(p)
+	if len(elements) == 0 || !strings.HasPrefix(elements[0], TreePrefix) {
+		return "", CleanPath(p)
+	}
+	return strings.TrimPrefix(elements[0], TreePrefix), RootPath + strings.Join(elements[1:], "/")
+}
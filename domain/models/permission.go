@@ -0,0 +1,156 @@
+// domain/permission.go
+
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+)
+
+// Access is a kind of access to a folder or file, like a bit of a Unix permission triplet. Accesses combine with |.
+// Reading a folder lists it, writing a folder creates, deletes and renames the entries inside it, and executing a
+// folder searches it, which is needed to reach anything inside it.
+type Access uint8
+
+const (
+	Execute Access = 1 << iota
+	Write
+	Read
+)
+
+// Mode holds the permission bits of a folder or file: the read, write and execute bits of the owner, of the members
+// of the group and of everyone else, like the permission bits of a Unix file, e.g. 0750
+type Mode uint32
+
+const (
+	// ModePerm masks the permission bits of a mode
+	ModePerm Mode = 0o777
+	// DefaultFolderMode is the mode of a new folder: only its owner has access to it
+	DefaultFolderMode Mode = 0o700
+	// DefaultFileMode is the mode of a new file: only its owner can read and write it
+	DefaultFileMode Mode = 0o600
+	// RootMode is the mode of the root folder of every user, which the user owns. Everyone else may search it, so the
+	// folders the user opens up to others can be reached, but not list it.
+	RootMode Mode = 0o711
+)
+
+// String returns the permission bits in the form listed by ls, e.g. "rwxr-x---"
+func (m Mode) String() string {
+	const letters = "rwx"
+	var b strings.Builder
+	for bit := 8; bit >= 0; bit-- {
+		if m&(1<<bit) != 0 {
+			b.WriteByte(letters[2-bit%3])
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	return b.String()
+}
+
+// Octal returns the permission bits as four octal digits, e.g. "0750"
+func (m Mode) Octal() string {
+	return fmt.Sprintf("%04o", uint32(m&ModePerm))
+}
+
+// ParseMode returns the mode given by spec, which is either octal, e.g. "750", or a comma-separated list of symbolic
+// changes applied to mode like chmod does, e.g. "u+x,go-w" or "a=r". A symbolic change names who it applies to with
+// "u" (the owner), "g" (the group), "o" (the others) or "a" (everyone, the default), adds, removes or sets bits with
+// "+", "-" or "=", and names the bits with "r", "w" and "x".
+func ParseMode(spec string, mode Mode) (Mode, error) {
+	if spec == "" {
+		return mode, customErrors.ErrInvalidMode(spec)
+	}
+	if octal, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		if Mode(octal) > ModePerm {
+			return mode, customErrors.ErrInvalidMode(spec)
+		}
+		return Mode(octal), nil
+	}
+
+	for _, change := range strings.Split(spec, ",") {
+		op := strings.IndexAny(change, "+-=")
+		if op < 0 {
+			return mode, customErrors.ErrInvalidMode(spec)
+		}
+
+		// Find the bits of the classes the change applies to
+		var who Mode
+		for _, c := range change[:op] {
+			switch c {
+			case 'u':
+				who |= 0o700
+			case 'g':
+				who |= 0o070
+			case 'o':
+				who |= 0o007
+			case 'a':
+				who |= 0o777
+			default:
+				return mode, customErrors.ErrInvalidMode(spec)
+			}
+		}
+		if who == 0 {
+			who = 0o777
+		}
+
+		// Find the bits the change names, repeated for every class
+		var bits Mode
+		for _, c := range change[op+1:] {
+			switch c {
+			case 'r':
+				bits |= 0o444
+			case 'w':
+				bits |= 0o222
+			case 'x':
+				bits |= 0o111
+			default:
+				return mode, customErrors.ErrInvalidMode(spec)
+			}
+		}
+
+		switch change[op] {
+		case '+':
+			mode |= who & bits
+		case '-':
+			mode &^= who & bits
+		case '=':
+			mode = mode&^who | who&bits
+		}
+	}
+	return mode, nil
+}
+
+// Permissions decide who has which access to a folder or file
+type Permissions struct {
+	OwnerID ID     // the user who owns the folder or file, who isn't necessarily the user whose tree holds it
+	Group   string // the group the folder or file belongs to, or empty if it belongs to none
+	Mode    Mode
+}
+
+// RootPermissions returns the permissions of the root folder of the user
+func RootPermissions(user User) Permissions {
+	return Permissions{OwnerID: user.ID, Mode: RootMode}
+}
+
+// Access returns the accesses the permissions grant the user.
+// Like on Unix, only one class of bits applies: the owner bits to the owner, the group bits to the members of the
+// group and the other bits to everyone else, so an owner is never granted more than the owner bits.
+func (p Permissions) Access(user User) Access {
+	bits := p.Mode & ModePerm
+	switch {
+	case user.ID == p.OwnerID:
+		bits >>= 6
+	case p.Group != "" && user.InGroup(p.Group):
+		bits >>= 3
+	}
+	return Access(bits & 7)
+}
+
+// Allows reports whether the permissions grant the user every access in access
+func (p Permissions) Allows(user User, access Access) bool {
+	return p.Access(user)&access == access
+}
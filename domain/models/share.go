@@ -0,0 +1,74 @@
+// domain/share.go
+
+package models
+
+import customErrors "github.com/terenzio/vfs/domain/errors"
+
+// ShareAccess is the access a folder shared with a user grants them to the folder and to everything inside it
+type ShareAccess string
+
+const (
+	// ShareRead lets the user list and search the folders and read the files
+	ShareRead ShareAccess = "read"
+	// ShareReadWrite also lets the user create, change, rename and delete folders and files
+	ShareReadWrite ShareAccess = "read-write"
+)
+
+// ParseShareAccess returns the share access named by access: "read" or "read-write"
+func ParseShareAccess(access string) (ShareAccess, error) {
+	switch a := ShareAccess(access); a {
+	case ShareRead, ShareReadWrite:
+		return a, nil
+	}
+	return "", customErrors.ErrInvalidShareAccess(access)
+}
+
+// Access returns the access the share grants to folders. Files get the same access without Execute.
+func (a ShareAccess) Access() Access {
+	switch a {
+	case ShareRead:
+		return Read | Execute
+	case ShareReadWrite:
+		return Read | Write | Execute
+	}
+	return 0
+}
+
+// Share is an entry of the access control list of a folder, which grants a user access to the folder and to everything
+// inside it on top of what its permissions allow
+type Share struct {
+	UserID ID
+	Access ShareAccess
+}
+
+// SharedWith returns the access the folder itself is shared with the user, or the empty access if it isn't
+func (f Folder) SharedWith(userID ID) ShareAccess {
+	for _, share := range f.Shares {
+		if share.UserID == userID {
+			return share.Access
+		}
+	}
+	return ""
+}
+
+// Share returns the shares of the folder with the user's share set to access, replacing an existing one
+func (f Folder) Share(userID ID, access ShareAccess) []Share {
+	shares := make([]Share, 0, len(f.Shares)+1)
+	for _, share := range f.Shares {
+		if share.UserID != userID {
+			shares = append(shares, share)
+		}
+	}
+	return append(shares, Share{UserID: userID, Access: access})
+}
+
+// Unshare returns the shares of the folder without the share of the user
+func (f Folder) Unshare(userID ID) []Share {
+	shares := make([]Share, 0, len(f.Shares))
+	for _, share := range f.Shares {
+		if share.UserID != userID {
+			shares = append(shares, share)
+		}
+	}
+	return shares
+}
@@ -0,0 +1,57 @@
+// domain/trash.go
+
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// TrashEntry is a folder or file moved to the trash of a user, from which it can be restored until the trash is
+// emptied or the entry outlives the retention period.
+// The trash of a user holds the items deleted from their tree, whoever deleted them. Path is the location the item was
+// deleted from. A deleted folder keeps its nested folders and the files inside them: Folders holds the folder itself
+// followed by the folders nested inside it, outermost first, and Files the files inside them, all with the paths they
+// had when they were deleted. A deleted file only has Files. The files keep referencing their content by hash while they
+// are in the trash.
+type TrashEntry struct {
+	ID        ID
+	UserID    ID
+	DeletedBy ID // the user who deleted the item
+	DeletedAt time.Time
+	Path      string
+	Folders   []Folder
+	Files     []File
+}
+
+// IsFolder reports whether the entry holds a deleted folder rather than a deleted file
+func (e TrashEntry) IsFolder() bool {
+	return len(e.Folders) > 0
+}
+
+// Size returns the total size of the files held by the entry
+func (e TrashEntry) Size() int64 {
+	var size int64
+	for _, file := range e.Files {
+		size += file.Size
+	}
+	return size
+}
+
+// Rebase returns a path inside the deleted item as it is named once the item is restored to newPath
+func (e TrashEntry) Rebase(itemPath, newPath string) string {
+	if itemPath == e.Path {
+		return newPath
+	}
+	return newPath + strings.TrimPrefix(itemPath, e.Path)
+}
+
+// TrashRepository is an interface that abstracts the methods for trash persistence.
+// The trash of a user is addressed by the ID of the user, and entries are listed in the order they were deleted.
+type TrashRepository interface {
+	AddTrash(entry TrashEntry) (TrashEntry, error)
+	GetTrash(userID, id ID) (TrashEntry, error)
+	ListTrash(userID ID) ([]TrashEntry, error)
+	ListExpiredTrash(deletedBefore time.Time) ([]TrashEntry, error)
+	DeleteTrash(userID, id ID) error
+}
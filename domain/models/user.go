@@ -2,9 +2,42 @@
 
 package models
 
+import (
+	"slices"
+	"time"
+)
+
 // User represents the user entity in the domain layer.
+// DisplayName and Email make up the profile of the user and may be empty.
+// PasswordHash holds the salted hash of the password, encoded by HashPassword, and is empty for users registered
+// before passwords existed.
+// Groups lists the names of the groups the user is a member of, which grant access to the folders and files that
+// belong to them. OwnedGroups lists the names of the groups the user started, whose members it may change even once
+// it is no member, and which nobody else can start again.
 type User struct {
-	Username string
+	ID           ID
+	Username     string
+	DisplayName  string
+	Email        string
+	PasswordHash string
+	Groups       []string
+	OwnedGroups  []string
+	CreatedAt    time.Time
+}
+
+// InGroup reports whether the user is a member of the group. Group names are compared exactly.
+func (u User) InGroup(group string) bool {
+	for _, g := range u.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnsGroup reports whether the user started the group. Group names are compared exactly.
+func (u User) OwnsGroup(group string) bool {
+	return slices.Contains(u.OwnedGroups, group)
 }
 
 // In DDD, the domain layer contains the core business logic and models.
@@ -14,8 +47,12 @@ type User struct {
 // UserRepository is the interface that wraps the basic user repository operations.
 type UserRepository interface {
 	Register(user User) error
+	GetUser(username string) (User, error)
 	Exists(username string) (bool, error)
-	ValidateUsername(username string) error
+	ListUsers(sortField, sortOrder string) ([]User, error)
+	UpdateUser(user User) error
+	RenameUser(username, newUsername string) error
+	DeleteUser(username string) ([]File, error)
 }
 
 // Interface Advantages:
@@ -0,0 +1,132 @@
+// domain/validator.go
+
+package models
+
+import (
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+)
+
+// Validator checks and normalizes every name and path given to the services.
+// New names, given to a user, folder or file when it is created, renamed, moved or copied, must be allowed by the
+// naming policy. The names of existing users, folders and files only need to be well formed, since the policy may have
+// been tightened after they were given and they must still be reachable. Both are normalized the same way, so that a
+// name typed in another Unicode form still matches the stored name.
+type Validator struct {
+	policy NamePolicy
+}
+
+// NewValidator creates a new Validator checking new names with the naming policy
+func NewValidator(policy NamePolicy) Validator {
+	return Validator{policy: policy}
+}
+
+// NewUsername checks the username of a new user and returns it normalized
+func (v Validator) NewUsername(username string) (string, error) {
+	username = v.policy.Normalize(username)
+	return username, v.policy.ValidateUsername(username)
+}
+
+// NewFolderName checks the name of a new or renamed folder and returns it normalized
+func (v Validator) NewFolderName(folderName string) (string, error) {
+	folderName = v.policy.Normalize(folderName)
+	return folderName, v.policy.ValidateFolderName(folderName)
+}
+
+// NewFileName checks the name of a new, renamed, moved or copied file and returns it normalized
+func (v Validator) NewFileName(fileName string) (string, error) {
+	fileName = v.policy.Normalize(fileName)
+	return fileName, v.policy.ValidateFileName(fileName)
+}
+
+// NewGroupName checks the name of a group a user is added to and returns it normalized
+func (v Validator) NewGroupName(groupName string) (string, error) {
+	groupName = v.policy.Normalize(groupName)
+	return groupName, v.policy.ValidateGroupName(groupName)
+}
+
+// GroupName checks the name of an existing group and returns it normalized
+func (v Validator) GroupName(groupName string) (string, error) {
+	groupName = v.policy.Normalize(groupName)
+	return groupName, CheckName(groupName)
+}
+
+// Username checks the username of an existing user and returns it normalized
+func (v Validator) Username(username string) (string, error) {
+	username = v.policy.Normalize(username)
+	return username, CheckName(username)
+}
+
+// FolderPath checks the path of an existing folder, which may be the root path, and returns it cleaned and normalized
+func (v Validator) FolderPath(folderPath string) (string, error) {
+	folderPath = CleanPath(v.policy.Normalize(folderPath))
+	for _, name := range PathElements(folderPath) {
+		if err := CheckName(name); err != nil {
+			return folderPath, err
+		}
+	}
+	return folderPath, nil
+}
+
+// FileName checks the name of an existing file and returns it normalized
+func (v Validator) FileName(fileName string) (string, error) {
+	fileName = v.policy.Normalize(fileName)
+	return fileName, CheckName(fileName)
+}
+
+// MaxDisplayNameLength is the maximum length of the display name of a user in characters (runes)
+const MaxDisplayNameLength = 64
+
+// DisplayName checks the display name of a user and returns it normalized, without surrounding white space.
+// An empty display name is valid and clears it.
+func (v Validator) DisplayName(displayName string) (string, error) {
+	displayName = strings.TrimSpace(v.policy.Normalize(displayName))
+	if utf8.RuneCountInString(displayName) > MaxDisplayNameLength || !utf8.ValidString(displayName) {
+		return displayName, customErrors.ErrInvalidDisplayName(displayName, MaxDisplayNameLength)
+	}
+	for _, r := range displayName {
+		if unicode.IsControl(r) {
+			return displayName, customErrors.ErrInvalidDisplayName(displayName, MaxDisplayNameLength)
+		}
+	}
+	return displayName, nil
+}
+
+// Email checks the email address of a user and returns it without surrounding white space. The address must be a
+// plain address such as "alice@example.com", without a name or angle brackets. An empty address is valid and clears it.
+func (v Validator) Email(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return email, nil
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return email, customErrors.ErrInvalidEmail(email)
+	}
+	return email, nil
+}
+
+// Limits of the length of a password in characters (runes)
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+)
+
+// Password checks the password given to a user. Passwords are never normalized or trimmed, since they are hashed as
+// they were typed.
+func (v Validator) Password(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < MinPasswordLength || length > MaxPasswordLength || !utf8.ValidString(password) {
+		return customErrors.ErrInvalidPassword(MinPasswordLength, MaxPasswordLength)
+	}
+	for _, r := range password {
+		if unicode.IsControl(r) {
+			return customErrors.ErrInvalidPassword(MinPasswordLength, MaxPasswordLength)
+		}
+	}
+	return nil
+}
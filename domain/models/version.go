@@ -0,0 +1,59 @@
+// domain/version.go
+
+package models
+
+import (
+	"time"
+)
+
+// Version is a state of the content of a file, recorded every time the content changes.
+// Versions are numbered from 1 per file in the order they were recorded, and the last version holds the current
+// content. Numbers are never reused, so the numbers of a file have gaps once old versions are pruned.
+type Version struct {
+	FileID    ID
+	Number    int
+	AuthorID  ID // the user who wrote the content
+	CreatedAt time.Time
+	Size      int64
+	Hash      string // the hash of the blob holding the content, see HashContent
+}
+
+// VersionPolicy bounds the history of every file: only the last KeepLast versions are kept, and only the versions
+// recorded within KeepFor. A zero limit doesn't apply. The last version, which holds the current content, is always
+// kept.
+type VersionPolicy struct {
+	KeepLast int
+	KeepFor  time.Duration
+}
+
+// DefaultVersionPolicy returns the policy that keeps the last 10 versions of every file, however old they are
+func DefaultVersionPolicy() VersionPolicy {
+	return VersionPolicy{KeepLast: 10}
+}
+
+// Prune splits the versions of a file, ordered by number, into the versions the policy keeps at the time now and the
+// versions it no longer keeps
+func (p VersionPolicy) Prune(versions []Version, now time.Time) (kept, pruned []Version) {
+	for i, version := range versions {
+		newer := len(versions) - 1 - i
+		if newer > 0 && ((p.KeepLast > 0 && newer >= p.KeepLast) || (p.KeepFor > 0 && version.CreatedAt.Before(now.Add(-p.KeepFor)))) {
+			pruned = append(pruned, version)
+		} else {
+			kept = append(kept, version)
+		}
+	}
+	return kept, pruned
+}
+
+// VersionRepository is an interface that abstracts the methods for the persistence of file versions.
+// AddVersion assigns the next number to the version and enforces the policy on the history of the file, returning
+// the versions it pruned so that their content can be removed. PruneVersions enforces the policy on the history of every
+// file at the time now, so versions expire even if their file is never written again, and returns the versions it
+// pruned. Versions are listed ordered by number.
+type VersionRepository interface {
+	AddVersion(version Version, policy VersionPolicy) (Version, []Version, error)
+	PruneVersions(policy VersionPolicy, now time.Time) ([]Version, error)
+	GetVersion(fileID ID, number int) (Version, error)
+	ListVersions(fileID ID) ([]Version, error)
+	DeleteVersions(fileID ID) ([]Version, error)
+}
@@ -0,0 +1,136 @@
+// repository/atomic.go
+
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempFileMarker is part of the name of every temporary file created by writeFileAtomic
+const tempFileMarker = ".tmp-"
+
+// writeFileAtomic replaces the file at filePath with data so that a crash never leaves a partially written file behind.
+// The data is written and synced to a temporary file in the same directory first, which is then renamed over the
+// original file. Readers see either the complete old content or the complete new content.
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filePath)
+	tmp, err := os.CreateTemp(dir, filepath.Base(filePath)+tempFileMarker+"*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// Remove the temporary file unless it has been renamed over the original file
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+	committed = true
+
+	return syncDir(dir)
+}
+
+// JournalFileName is the name of the journal that writeFilesAtomic keeps in a directory while it replaces several files
+const JournalFileName = "journal.json"
+
+// writeFilesAtomic replaces several files so that a crash replaces either all of them or none of them.
+// The files are given by their paths relative to dir. Their new contents are first recorded in a journal inside dir;
+// once the journal is durable every file is replaced with writeFileAtomic and the journal is removed.
+// If the program crashes halfway, recoverJournal finishes the writes on the next start.
+func writeFilesAtomic(dir string, files map[string][]byte, perm os.FileMode) error {
+	journal, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, JournalFileName), journal, perm); err != nil {
+		return err
+	}
+	return applyJournal(dir, files, perm)
+}
+
+// recoverJournal finishes the writes recorded in a journal that a crash left behind in dir
+func recoverJournal(dir string) error {
+	journal, err := os.ReadFile(filepath.Join(dir, JournalFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// The journal itself is written atomically, so it is always complete
+	var files map[string][]byte
+	if err := json.Unmarshal(journal, &files); err != nil {
+		return err
+	}
+	return applyJournal(dir, files, 0644)
+}
+
+// applyJournal replaces every file recorded in the journal inside dir and then removes the journal
+func applyJournal(dir string, files map[string][]byte, perm os.FileMode) error {
+	for name, data := range files {
+		if err := writeFileAtomic(filepath.Join(dir, name), data, perm); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, JournalFileName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir flushes the directory entry of a renamed file to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// Some platforms don't support syncing directories, so a failure is ignored; the rename itself is still atomic
+	d.Sync()
+	return nil
+}
+
+// removeTempFiles deletes the temporary files that writeFileAtomic left behind in dir when the program crashed
+// before renaming them. The files they were meant to replace are still intact.
+func removeTempFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.Contains(entry.Name(), tempFileMarker) {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,410 @@
+// repository/blob_repository.go
+
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// storedBlob is a blob as it is stored in the index: the chunks its data is split into, its size and the number of
+// its references
+type storedBlob struct {
+	Chunks []string `json:"chunks"`
+	Size   int64    `json:"size"`
+	Refs   int      `json:"refs"`
+}
+
+// blobRecord is a line of the log of the index. The first record only names the index the log applies to by the hash
+// of its data. Every other record holds the state of a blob after a change: the whole blob if the change added it to
+// the index, or else the number of its references. Replaying a record twice changes nothing.
+type blobRecord struct {
+	Base string      `json:"base,omitempty"`
+	Hash string      `json:"hash,omitempty"`
+	Blob *storedBlob `json:"blob,omitempty"`
+	Refs int         `json:"refs"`
+}
+
+// blobIndex is the index as it was loaded from its file and its log
+type blobIndex struct {
+	blobs   map[string]storedBlob
+	base    string // the hash of the data of the index file, named by the log that applies to it
+	size    int64  // the size of the index file
+	logSize int64  // the size of the complete records of the log that applies to the index, or zero without one
+}
+
+// FileBlobRepository handles the repository logic for file contents, keyed by their hash.
+// The data of every blob is split into chunks, see ChunkSize, and every chunk is kept in its own host file inside a
+// directory, named after its hash. The chunks, size and number of references of every blob are kept in an index file
+// holding a JSON object keyed by the hashes of the blobs. The chunks of a blob are written before the blob is added to
+// the index, so a crash leaves at most chunks that no blob lists behind, which CollectGarbage removes.
+// Adding a blob and changing its references only appends a record to the log of the index. The log is compacted into
+// the index once it grows larger than the index, and whenever garbage is collected, so a change costs as much as
+// writing a record on average, whatever the number of blobs.
+type FileBlobRepository struct {
+	dirPath   string
+	indexPath string
+	logPath   string
+	held      heldChunks
+	mu        sync.RWMutex // ensures thread-safe access to the directory, the index, its log and held
+}
+
+// NewFileBlobRepository creates a new instance of FileBlobRepository keeping the chunks in dirPath, the index in
+// indexPath and its log in logPath
+func NewFileBlobRepository(dirPath, indexPath, logPath string) *FileBlobRepository {
+	return &FileBlobRepository{
+		dirPath:   dirPath,
+		indexPath: indexPath,
+		logPath:   logPath,
+		held:      make(heldChunks),
+	}
+}
+
+// chunkPath returns the path of the host file holding the chunk with the hash
+func (r *FileBlobRepository) chunkPath(hash string) string {
+	return filepath.Join(r.dirPath, hash)
+}
+
+// loadBlobs reads the blobs from the index and its log. The caller must hold r.mu.
+func (r *FileBlobRepository) loadBlobs() (map[string]storedBlob, error) {
+	index, err := r.loadIndex()
+	return index.blobs, err
+}
+
+// loadIndex reads the index and replays the records of its log. A log naming another index was left behind by a crash
+// while the index was compacted, which already holds its changes, so it is ignored. So is a last record cut short by
+// a crash while it was appended. The caller must hold r.mu.
+func (r *FileBlobRepository) loadIndex() (blobIndex, error) {
+	// There is no index file before the first blob is stored
+	data, err := os.ReadFile(r.indexPath)
+	if err != nil && !os.IsNotExist(err) {
+		return blobIndex{}, err
+	}
+	index := blobIndex{blobs: map[string]storedBlob{}, base: models.HashContent(data), size: int64(len(data))}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &index.blobs); err != nil {
+			return blobIndex{}, err
+		}
+	}
+
+	log, err := os.ReadFile(r.logPath)
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return blobIndex{}, err
+	}
+	log = log[:bytes.LastIndexByte(log, '\n')+1]
+	for i, line := range bytes.SplitAfter(log, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var record blobRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return blobIndex{}, fmt.Errorf("the record %d of [%s] is malformed: %w", i+1, r.logPath, err)
+		}
+		if i == 0 {
+			if record.Base != index.base {
+				return index, nil
+			}
+			continue
+		}
+		if record.Blob != nil {
+			index.blobs[record.Hash] = *record.Blob
+		} else if blob, ok := index.blobs[record.Hash]; ok {
+			blob.Refs = record.Refs
+			index.blobs[record.Hash] = blob
+		}
+	}
+	index.logSize = int64(len(log))
+	return index, nil
+}
+
+// saveBlobs atomically replaces the index, then removes its log, whose changes the index now holds. The caller must
+// hold r.mu.
+func (r *FileBlobRepository) saveBlobs(blobs map[string]storedBlob) error {
+	data, err := json.Marshal(blobs)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.indexPath, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Remove(r.logPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncDir(filepath.Dir(r.logPath))
+}
+
+// logBlob records the change of the blob with the hash, which is already made to the loaded index, by appending a
+// record to the log, started anew if there is none that applies to the index. Once the log is larger than the index
+// it is compacted into the index instead. The caller must hold r.mu.
+func (r *FileBlobRepository) logBlob(index blobIndex, hash string, added bool) error {
+	record := blobRecord{Hash: hash, Refs: index.blobs[hash].Refs}
+	if added {
+		blob := index.blobs[hash]
+		record.Blob = &blob
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if index.logSize == 0 {
+		base, err := json.Marshal(blobRecord{Base: index.base})
+		if err != nil {
+			return err
+		}
+		line = append(append(base, '\n'), line...)
+	}
+	if index.logSize+int64(len(line)) > index.size {
+		return r.saveBlobs(index.blobs)
+	}
+
+	// A record cut short by a crash, or a log left behind for another index, is overwritten
+	f, err := os.OpenFile(r.logPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(index.logSize); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.WriteAt(line, index.logSize); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if index.logSize == 0 {
+		return syncDir(filepath.Dir(r.logPath))
+	}
+	return nil
+}
+
+// PutBlob stores the data unless an equal blob is already stored, adds a reference to the blob and returns its hash
+func (r *FileBlobRepository) PutBlob(data []byte) (string, error) {
+	return putBlob(r, data)
+}
+
+// GetBlob returns the data of the blob, once it is verified against the hash
+func (r *FileBlobRepository) GetBlob(hash string) ([]byte, error) {
+	reader, err := r.openBlob(hash)
+	if err != nil {
+		return nil, err
+	}
+	return readBlob(reader)
+}
+
+// OpenBlob returns a reader of the data of the blob, which verifies every chunk as it is read
+func (r *FileBlobRepository) OpenBlob(hash string) (io.ReadSeekCloser, error) {
+	return r.openBlob(hash)
+}
+
+// openBlob returns a reader of the blob holding its chunks
+func (r *FileBlobRepository) openBlob(hash string) (*blobReader, error) {
+	if hash == "" {
+		return emptyBlob(r), nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	blobs, err := r.loadBlobs()
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := blobs[hash]
+	if !ok {
+		return nil, customErrors.ErrBlobNotFound(hash)
+	}
+	r.held.hold(blob.Chunks...)
+	return newBlobReader(r, hash, blob.Size, blob.Chunks), nil
+}
+
+// CreateBlob returns a writer that stores a new blob once it is committed
+func (r *FileBlobRepository) CreateBlob() models.BlobWriter {
+	return newBlobWriter(r)
+}
+
+// putChunk writes the data to the host file of the chunk unless it exists already, and holds the chunk
+func (r *FileBlobRepository) putChunk(data []byte) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash := models.HashContent(data)
+	if _, err := os.Stat(r.chunkPath(hash)); os.IsNotExist(err) {
+		if err := os.MkdirAll(r.dirPath, 0755); err != nil {
+			return "", err
+		}
+		if err := writeFileAtomic(r.chunkPath(hash), data, 0644); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	}
+	r.held.hold(hash)
+	return hash, nil
+}
+
+// getChunk reads the data of the chunk from its host file
+func (r *FileBlobRepository) getChunk(hash string) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	data, err := os.ReadFile(r.chunkPath(hash))
+	if os.IsNotExist(err) {
+		return nil, customErrors.ErrBlobNotFound(hash)
+	}
+	return data, err
+}
+
+// commitBlob adds the blob made of the chunks to the index unless it is there already, and adds a reference to it
+func (r *FileBlobRepository) commitBlob(hash string, size int64, chunks []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index, err := r.loadIndex()
+	if err != nil {
+		return err
+	}
+	blob, ok := index.blobs[hash]
+	if !ok {
+		blob = storedBlob{Chunks: chunks, Size: size}
+	}
+	blob.Refs++
+	index.blobs[hash] = blob
+	return r.logBlob(index, hash, !ok)
+}
+
+// releaseChunks stops holding the chunks
+func (r *FileBlobRepository) releaseChunks(chunks []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.held.release(chunks)
+}
+
+// RetainBlob adds a reference to the blob
+func (r *FileBlobRepository) RetainBlob(hash string) error {
+	if hash == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index, err := r.loadIndex()
+	if err != nil {
+		return err
+	}
+	blob, ok := index.blobs[hash]
+	if !ok {
+		return customErrors.ErrBlobNotFound(hash)
+	}
+	blob.Refs++
+	index.blobs[hash] = blob
+	return r.logBlob(index, hash, false)
+}
+
+// ReleaseBlob removes a reference to the blob. Releasing a blob that is gone changes nothing.
+func (r *FileBlobRepository) ReleaseBlob(hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index, err := r.loadIndex()
+	if err != nil {
+		return err
+	}
+	blob, ok := index.blobs[hash]
+	if !ok || blob.Refs <= 0 {
+		return nil
+	}
+	blob.Refs--
+	index.blobs[hash] = blob
+	return r.logBlob(index, hash, false)
+}
+
+// CollectGarbage removes the blobs without references, then the chunks that no blob lists and nobody holds, including
+// chunks a crash left behind before their blob was added to the index, and returns how many blobs were removed.
+// The log of the index is compacted into the index.
+func (r *FileBlobRepository) CollectGarbage() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index, err := r.loadIndex()
+	if err != nil {
+		return 0, err
+	}
+	blobs := index.blobs
+	removed := 0
+	for hash, blob := range blobs {
+		if blob.Refs <= 0 {
+			delete(blobs, hash)
+			removed++
+		}
+	}
+	if removed > 0 || index.logSize > 0 {
+		if err := r.saveBlobs(blobs); err != nil {
+			return 0, err
+		}
+	}
+	garbage, err := r.garbage(blobs)
+	if err != nil {
+		return 0, err
+	}
+	return removed, r.removeChunks(garbage)
+}
+
+// garbage returns the hashes of the stored chunks that none of the blobs lists and nobody holds. The caller must hold
+// r.mu.
+func (r *FileBlobRepository) garbage(blobs map[string]storedBlob) ([]string, error) {
+	entries, err := os.ReadDir(r.dirPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listed := listedChunks(blobs)
+	var garbage []string
+	for _, entry := range entries {
+		hash := entry.Name()
+		if entry.IsDir() || strings.Contains(hash, tempFileMarker) || listed[hash] || r.held[hash] > 0 {
+			continue
+		}
+		garbage = append(garbage, hash)
+	}
+	return garbage, nil
+}
+
+// removeChunks removes the host files of the chunks with the hashes. The caller must hold r.mu.
+func (r *FileBlobRepository) removeChunks(hashes []string) error {
+	for _, hash := range hashes {
+		if err := os.Remove(r.chunkPath(hash)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// listedChunks returns the hashes of the chunks the blobs are made of
+func listedChunks(blobs map[string]storedBlob) map[string]bool {
+	listed := make(map[string]bool)
+	for _, blob := range blobs {
+		for _, chunk := range blob.Chunks {
+			listed[chunk] = true
+		}
+	}
+	return listed
+}
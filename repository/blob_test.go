@@ -0,0 +1,240 @@
+package repository_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/repository"
+)
+
+// blobRepositories creates the blob repository of every implementation
+var blobRepositories = map[string]func(t *testing.T) models.BlobRepository{
+	"File": func(t *testing.T) models.BlobRepository {
+		store, err := repository.OpenStore(t.TempDir(), models.CasePreserving)
+		assert.NoError(t, err)
+		return store.Blobs
+	},
+	"Memory": func(t *testing.T) models.BlobRepository {
+		return repository.NewMemoryBlobRepository()
+	},
+	"SQL": func(t *testing.T) models.BlobRepository {
+		store, err := repository.OpenSQLStore(t.TempDir(), models.CasePreserving)
+		assert.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store.Blobs
+	},
+}
+
+// TestBlobs tests that every repository stores equal contents once and removes a blob only once nothing references it
+func TestBlobs(t *testing.T) {
+	for implementation, newRepository := range blobRepositories {
+		t.Run(implementation, func(t *testing.T) {
+			blobs := newRepository(t)
+
+			// Equal contents share a blob
+			hash, err := blobs.PutBlob([]byte("hello"))
+			assert.NoError(t, err)
+			assert.Equal(t, models.HashContent([]byte("hello")), hash)
+			again, err := blobs.PutBlob([]byte("hello"))
+			assert.NoError(t, err)
+			assert.Equal(t, hash, again)
+			assert.NoError(t, blobs.RetainBlob(hash))
+			empty, err := blobs.PutBlob(nil)
+			assert.NoError(t, err)
+			data, err := blobs.GetBlob(empty)
+			assert.NoError(t, err)
+			assert.Empty(t, data)
+
+			// Content that was never written has no blob
+			data, err = blobs.GetBlob("")
+			assert.NoError(t, err)
+			assert.Empty(t, data)
+			assert.NoError(t, blobs.RetainBlob(""))
+			assert.EqualError(t, blobs.RetainBlob("missing"), customErrors.ErrBlobNotFound("missing").Error())
+
+			// A blob outlives all but its last reference
+			assert.NoError(t, blobs.ReleaseBlob(empty))
+			for i := 0; i < 2; i++ {
+				assert.NoError(t, blobs.ReleaseBlob(hash))
+				removed, err := blobs.CollectGarbage()
+				assert.NoError(t, err)
+				assert.Equal(t, 1-i, removed) // the empty blob goes first
+			}
+			data, err = blobs.GetBlob(hash)
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+
+			assert.NoError(t, blobs.ReleaseBlob(hash))
+			assert.NoError(t, blobs.ReleaseBlob(hash)) // releasing a blob without references changes nothing
+			removed, err := blobs.CollectGarbage()
+			assert.NoError(t, err)
+			assert.Equal(t, 1, removed)
+			_, err = blobs.GetBlob(hash)
+			assert.ErrorIs(t, err, customErrors.ErrNotFound)
+		})
+	}
+}
+
+// TestBlobStreams tests that every repository streams blobs larger than a chunk and reads them from any offset
+func TestBlobStreams(t *testing.T) {
+	data := make([]byte, 2*repository.ChunkSize+10)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	for implementation, newRepository := range blobRepositories {
+		t.Run(implementation, func(t *testing.T) {
+			blobs := newRepository(t)
+
+			// The data is written in pieces that don't line up with the chunks
+			w := blobs.CreateBlob()
+			for rest := data; len(rest) > 0; {
+				n := min(len(rest), 300_000)
+				written, err := w.Write(rest[:n])
+				assert.NoError(t, err)
+				assert.Equal(t, n, written)
+				rest = rest[n:]
+			}
+			hash, err := w.Commit()
+			assert.NoError(t, err)
+			assert.Equal(t, models.HashContent(data), hash)
+			_, err = w.Write([]byte("late"))
+			assert.Error(t, err)
+			stored, err := blobs.GetBlob(hash)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data, stored))
+
+			// A reader seeks across the chunks
+			r, err := blobs.OpenBlob(hash)
+			assert.NoError(t, err)
+			offset, err := r.Seek(repository.ChunkSize-3, io.SeekStart)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(repository.ChunkSize-3), offset)
+			part := make([]byte, 6)
+			_, err = io.ReadFull(r, part)
+			assert.NoError(t, err)
+			assert.Equal(t, data[repository.ChunkSize-3:repository.ChunkSize+3], part)
+			_, err = r.Seek(-4, io.SeekEnd)
+			assert.NoError(t, err)
+			tail, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, data[len(data)-4:], tail)
+			_, err = r.Seek(-1, io.SeekStart)
+			assert.ErrorIs(t, err, customErrors.ErrInvalid)
+
+			// The chunks of an open blob survive the garbage collection of the blob
+			assert.NoError(t, blobs.ReleaseBlob(hash))
+			removed, err := blobs.CollectGarbage()
+			assert.NoError(t, err)
+			assert.Equal(t, 1, removed)
+			_, err = r.Seek(0, io.SeekStart)
+			assert.NoError(t, err)
+			read, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data, read))
+			assert.NoError(t, r.Close())
+
+			// An aborted blob is never stored
+			w = blobs.CreateBlob()
+			_, err = w.Write(data)
+			assert.NoError(t, err)
+			assert.NoError(t, w.Abort())
+			_, err = w.Commit()
+			assert.Error(t, err)
+			removed, err = blobs.CollectGarbage()
+			assert.NoError(t, err)
+			assert.Zero(t, removed)
+			_, err = blobs.GetBlob(hash)
+			assert.ErrorIs(t, err, customErrors.ErrNotFound)
+		})
+	}
+}
+
+// TestBlobGarbageCollection tests that the stores remove every chunk of a blob once the blob is garbage collected,
+// including its last chunk, which is only part full
+func TestBlobGarbageCollection(t *testing.T) {
+	stores := map[string]func(t *testing.T) (models.BlobRepository, func() int){
+		"File": func(t *testing.T) (models.BlobRepository, func() int) {
+			dir := t.TempDir()
+			store, err := repository.OpenStore(dir, models.CasePreserving)
+			assert.NoError(t, err)
+			return store.Blobs, func() int {
+				entries, err := os.ReadDir(filepath.Join(dir, repository.ChunksDirName))
+				assert.NoError(t, err)
+				return len(entries)
+			}
+		},
+		"SQL": func(t *testing.T) (models.BlobRepository, func() int) {
+			store, err := repository.OpenSQLStore(t.TempDir(), models.CasePreserving)
+			assert.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store.Blobs, func() int {
+				var count int
+				assert.NoError(t, store.DB.QueryRow(`SELECT COUNT(*) FROM chunks`).Scan(&count))
+				return count
+			}
+		},
+	}
+
+	for implementation, open := range stores {
+		t.Run(implementation, func(t *testing.T) {
+			blobs, countChunks := open(t)
+			data := bytes.Repeat([]byte("x"), repository.ChunkSize+10)
+			data[0] = 'y' // the chunks differ
+			hash, err := blobs.PutBlob(data)
+			assert.NoError(t, err)
+			small, err := blobs.PutBlob([]byte("hello"))
+			assert.NoError(t, err)
+			assert.Equal(t, 3, countChunks())
+
+			assert.NoError(t, blobs.ReleaseBlob(hash))
+			assert.NoError(t, blobs.ReleaseBlob(small))
+			removed, err := blobs.CollectGarbage()
+			assert.NoError(t, err)
+			assert.Equal(t, 2, removed)
+			assert.Zero(t, countChunks())
+		})
+	}
+}
+
+// TestBlobIntegrity tests that the stores detect a blob whose data no longer matches its hash
+func TestBlobIntegrity(t *testing.T) {
+	stores := map[string]func(t *testing.T) (models.BlobRepository, func(hash string)){
+		"File": func(t *testing.T) (models.BlobRepository, func(hash string)) {
+			dir := t.TempDir()
+			store, err := repository.OpenStore(dir, models.CasePreserving)
+			assert.NoError(t, err)
+			return store.Blobs, func(hash string) {
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, repository.ChunksDirName, hash), []byte("tampered"), 0644))
+			}
+		},
+		"SQL": func(t *testing.T) (models.BlobRepository, func(hash string)) {
+			store, err := repository.OpenSQLStore(t.TempDir(), models.CasePreserving)
+			assert.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store.Blobs, func(hash string) {
+				_, err := store.DB.Exec(`UPDATE chunks SET data = ? WHERE hash = ?`, []byte("tampered"), hash)
+				assert.NoError(t, err)
+			}
+		},
+	}
+
+	for implementation, open := range stores {
+		t.Run(implementation, func(t *testing.T) {
+			blobs, corrupt := open(t)
+			hash, err := blobs.PutBlob([]byte("hello"))
+			assert.NoError(t, err)
+			corrupt(hash)
+
+			_, err = blobs.GetBlob(hash)
+			assert.ErrorIs(t, err, customErrors.ErrCorrupt)
+			assert.EqualError(t, err, customErrors.ErrCorruptBlob(hash).Error())
+		})
+	}
+}
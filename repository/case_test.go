@@ -0,0 +1,186 @@
+package repository_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/repository"
+)
+
+// caseRepositories creates the empty user, folder and file repositories of every implementation, matching names with
+// the case policy
+var caseRepositories = map[string]func(t *testing.T, policy models.CasePolicy) (models.UserRepository, models.FolderRepository, models.FileRepository){
+	"File": func(t *testing.T, policy models.CasePolicy) (models.UserRepository, models.FolderRepository, models.FileRepository) {
+		store, err := repository.OpenStore(t.TempDir(), policy)
+		assert.NoError(t, err)
+		return store.Users, store.Folders, store.Files
+	},
+	"Memory": func(t *testing.T, policy models.CasePolicy) (models.UserRepository, models.FolderRepository, models.FileRepository) {
+		users := repository.NewMemoryUserRepository(policy)
+		files := repository.NewMemoryFileRepository()
+		return users, repository.NewMemoryFolderRepository(users, files), files
+	},
+	"SQL": func(t *testing.T, policy models.CasePolicy) (models.UserRepository, models.FolderRepository, models.FileRepository) {
+		store, err := repository.OpenSQLStore(t.TempDir(), policy)
+		assert.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store.Users, store.Folders, store.Files
+	},
+}
+
+// caseExpectations describes how a case policy treats names
+type caseExpectations struct {
+	distinct bool // whether names that only differ in case name different entities
+	lower    bool // whether new names are stored in lower case
+}
+
+// stored returns the form in which a new name is stored
+func (e caseExpectations) stored(name string) string {
+	if e.lower {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// TestCasePolicy tests that every repository matches the names of users, folders and files with its case policy
+func TestCasePolicy(t *testing.T) {
+	policies := map[models.CasePolicy]caseExpectations{
+		models.CaseSensitive:   {distinct: true},
+		models.CasePreserving:  {},
+		models.CaseInsensitive: {lower: true},
+	}
+	tests := []struct {
+		name     string
+		testFunc func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository, expect caseExpectations)
+	}{
+		{
+			name: "Users",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository, expect caseExpectations) {
+				err := users.Register(models.User{Username: "USER1"})
+				if expect.distinct {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorIs(t, err, customErrors.ErrConflict)
+				}
+
+				exists, err := users.Exists("User1")
+				assert.NoError(t, err)
+				assert.Equal(t, !expect.distinct, exists)
+			},
+		},
+		{
+			name: "Folders",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository, expect caseExpectations) {
+				assert.NoError(t, folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "Report", CreatedAt: time.Now()}))
+				err := folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "REPORT", CreatedAt: time.Now()})
+				if expect.distinct {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorIs(t, err, customErrors.ErrConflict)
+				}
+
+				folder, err := folders.GetFolder("user1", "/report")
+				if expect.distinct {
+					assert.ErrorIs(t, err, customErrors.ErrNotFound)
+				} else if assert.NoError(t, err) {
+					assert.Equal(t, expect.stored("Report"), folder.Name)
+				}
+			},
+		},
+		{
+			name: "Files",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository, expect caseExpectations) {
+				assert.NoError(t, files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "Report"}))
+				err := files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "REPORT"})
+				if expect.distinct {
+					assert.NoError(t, err)
+				} else {
+					assert.ErrorIs(t, err, customErrors.ErrConflict)
+				}
+
+				file, err := files.GetFile("USER1", "/", "report")
+				if expect.distinct {
+					assert.ErrorIs(t, err, customErrors.ErrNotFound)
+				} else if assert.NoError(t, err) {
+					assert.Equal(t, expect.stored("Report"), file.Name)
+				}
+			},
+		},
+		{
+			name: "NestedPaths",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository, expect caseExpectations) {
+				assert.NoError(t, folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "Projects", CreatedAt: time.Now()}))
+				err := folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/PROJECTS", Name: "Report", CreatedAt: time.Now()})
+				if expect.distinct {
+					assert.ErrorIs(t, err, customErrors.ErrNotFound)
+					return
+				}
+				assert.NoError(t, err)
+				assert.NoError(t, files.CreateFile(models.File{Username: "user1", FolderPath: "/projects/REPORT", Name: "notes"}))
+
+				// The stored spelling of the parent is kept, whatever spelling the child was created with
+				folder, err := folders.GetFolder("user1", "/PROJECTS/report")
+				assert.NoError(t, err)
+				assert.Equal(t, "/"+expect.stored("Projects"), folder.ParentPath)
+				assert.Equal(t, expect.stored("Report"), folder.Name)
+				listed, err := files.ListFiles("user1", "/Projects/Report", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, listed, 1)
+			},
+		},
+		{
+			name: "UnicodeFolding",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository, expect caseExpectations) {
+				assert.NoError(t, files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "Straße"}))
+				assert.NoError(t, files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "ΣΊΣΥΦΟΣ"}))
+
+				for _, name := range []string{"STRASSE", "σίσυφος"} {
+					_, err := files.GetFile("user1", "/", name)
+					if expect.distinct {
+						assert.ErrorIs(t, err, customErrors.ErrNotFound, name)
+					} else {
+						assert.NoError(t, err, name)
+					}
+				}
+			},
+		},
+		{
+			name: "CaseOnlyRename",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository, expect caseExpectations) {
+				assert.NoError(t, folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "docs", CreatedAt: time.Now()}))
+				assert.NoError(t, files.CreateFile(models.File{Username: "user1", FolderPath: "/docs", Name: "report"}))
+				original, err := files.GetFile("user1", "/docs", "report")
+				assert.NoError(t, err)
+
+				assert.NoError(t, folders.RenameFolder("user1", "/docs", "Docs"))
+				assert.NoError(t, files.MoveFile("user1", "/Docs", "report", "/Docs", "Report", false))
+
+				moved, err := files.GetFile("user1", "/Docs", "Report")
+				assert.NoError(t, err)
+				assert.Equal(t, original.ID, moved.ID)
+				listed, err := files.ListFiles("user1", "/Docs", "", "")
+				assert.NoError(t, err)
+				if assert.Len(t, listed, 1) {
+					assert.Equal(t, expect.stored("Report"), listed[0].Name)
+					assert.Equal(t, "/"+expect.stored("Docs"), listed[0].FolderPath)
+				}
+			},
+		},
+	}
+
+	for implementation, newRepositories := range caseRepositories {
+		for policy, expect := range policies {
+			for _, tt := range tests {
+				t.Run(implementation+"/"+policy.String()+"/"+tt.name, func(t *testing.T) {
+					users, folders, files := newRepositories(t, policy)
+					assert.NoError(t, users.Register(models.User{Username: "user1"}))
+					tt.testFunc(t, users, folders, files, expect)
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,276 @@
+// repository/chunk.go
+
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// ChunkSize is the size of the chunks the data of a blob is split into. Every chunk but the last one of a blob holds
+// exactly ChunkSize bytes, so the chunk holding an offset is found without reading the chunks before it.
+// Chunks are addressed by their hash like blobs, so blobs sharing a chunk store it once.
+const ChunkSize = 1 << 20
+
+// chunkStore is implemented by the blob repositories to keep the chunks of blobs. A chunk that is being written into
+// a blob or read from one is held, and CollectGarbage never removes a held chunk even if no blob lists it yet.
+type chunkStore interface {
+	// putChunk stores the data as a chunk unless an equal chunk is already stored, holds it and returns its hash. The
+	// data is reused by the caller, so it must be copied if it is kept.
+	putChunk(data []byte) (string, error)
+	// getChunk returns the data of the chunk with the hash, or ErrBlobNotFound if it is missing
+	getChunk(hash string) ([]byte, error)
+	// commitBlob stores the blob made of the chunks, or adds a reference to the blob if it is already stored
+	commitBlob(hash string, size int64, chunks []string) error
+	// releaseChunks stops holding the chunks
+	releaseChunks(chunks []string)
+}
+
+// heldChunks counts how many writers and readers hold every chunk. The blob repository guards it with its lock.
+type heldChunks map[string]int
+
+// hold holds the chunks
+func (h heldChunks) hold(chunks ...string) {
+	for _, chunk := range chunks {
+		h[chunk]++
+	}
+}
+
+// release stops holding the chunks
+func (h heldChunks) release(chunks []string) {
+	for _, chunk := range chunks {
+		if h[chunk]--; h[chunk] <= 0 {
+			delete(h, chunk)
+		}
+	}
+}
+
+// blobWriter splits the data written to it into chunks and stores them as they fill up, so only one chunk is kept in
+// memory however large the blob is
+type blobWriter struct {
+	store  chunkStore
+	hasher hash.Hash
+	buf    []byte
+	chunks []string
+	size   int64
+	err    error // the error that made storing a chunk fail, returned by every later call
+	done   bool
+}
+
+// newBlobWriter creates a writer storing a new blob in the chunk store
+func newBlobWriter(store chunkStore) *blobWriter {
+	return &blobWriter{store: store, hasher: sha256.New()}
+}
+
+// Write adds data to the blob, storing every chunk that fills up
+func (w *blobWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, io.ErrClosedPipe
+	} else if w.err != nil {
+		return 0, w.err
+	}
+	if w.buf == nil {
+		w.buf = make([]byte, 0, ChunkSize)
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+		if len(w.buf) == ChunkSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// flush stores the buffered data as a chunk. If storing fails, the buffered data is kept but the writer fails from
+// then on, as the blob can no longer be completed.
+func (w *blobWriter) flush() error {
+	chunk, err := w.store.putChunk(w.buf)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.hasher.Write(w.buf)
+	w.chunks = append(w.chunks, chunk)
+	w.size += int64(len(w.buf))
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Commit stores the last chunk and the blob made of all the chunks, and returns the hash of the blob
+func (w *blobWriter) Commit() (string, error) {
+	if w.done {
+		return "", io.ErrClosedPipe
+	}
+	w.done = true
+	// The last chunk is only added to the chunks by flush, so they are released once it has been stored
+	defer func() { w.store.releaseChunks(w.chunks) }()
+
+	if w.err != nil {
+		return "", w.err
+	}
+	if len(w.buf) > 0 {
+		if err := w.flush(); err != nil {
+			return "", err
+		}
+	}
+	hash := hex.EncodeToString(w.hasher.Sum(nil))
+	if err := w.store.commitBlob(hash, w.size, w.chunks); err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// Abort stops holding the chunks stored so far, which CollectGarbage removes unless another blob lists them
+func (w *blobWriter) Abort() error {
+	if !w.done {
+		w.done = true
+		w.store.releaseChunks(w.chunks)
+	}
+	return nil
+}
+
+// blobReader reads the data of a blob one chunk at a time, verifying every chunk against its hash
+type blobReader struct {
+	store  chunkStore
+	hash   string
+	size   int64
+	chunks []string
+	offset int64
+	index  int // the index of the chunk in data, or -1 if no chunk is loaded
+	data   []byte
+	closed bool
+}
+
+// newBlobReader creates a reader of the blob with the hash, which is made of the chunks. The caller must hold the
+// chunks, and Close releases them.
+func newBlobReader(store chunkStore, hash string, size int64, chunks []string) *blobReader {
+	return &blobReader{store: store, hash: hash, size: size, chunks: chunks, index: -1}
+}
+
+// Read reads the data at the current offset, loading the chunk holding it
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if err := r.load(int(r.offset / ChunkSize)); err != nil {
+		return 0, err
+	}
+	n := copy(p, r.data[r.offset%ChunkSize:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// load loads the chunk with the index unless it is loaded already
+func (r *blobReader) load(index int) error {
+	if index == r.index {
+		return nil
+	}
+	if index >= len(r.chunks) {
+		return customErrors.ErrCorruptBlob(r.hash)
+	}
+	data, err := r.store.getChunk(r.chunks[index])
+	if customErrors.CodeOf(err) == customErrors.CodeBlobNotFound {
+		return customErrors.ErrCorruptBlob(r.hash) // the blob lists a chunk that is gone
+	} else if err != nil {
+		return err
+	}
+
+	// Every chunk but the last one is full, and the chunks add up to the size of the blob
+	expected := int64(ChunkSize)
+	if index == len(r.chunks)-1 {
+		expected = r.size - int64(index)*ChunkSize
+	}
+	if models.HashContent(data) != r.chunks[index] || int64(len(data)) != expected {
+		return customErrors.ErrCorruptBlob(r.hash)
+	}
+	r.index, r.data = index, data
+	return nil
+}
+
+// Seek sets the offset of the next Read
+func (r *blobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, customErrors.ErrInvalidRange(offset, 0)
+	}
+	if offset < 0 {
+		return 0, customErrors.ErrInvalidRange(offset, 0)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Close releases the chunks of the blob
+func (r *blobReader) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.store.releaseChunks(r.chunks)
+	}
+	return nil
+}
+
+// putBlob stores the data as a blob in the chunk store and returns its hash
+func putBlob(store chunkStore, data []byte) (string, error) {
+	w := newBlobWriter(store)
+	if _, err := w.Write(data); err != nil {
+		w.Abort()
+		return "", err
+	}
+	return w.Commit()
+}
+
+// readBlob reads all the data of the blob from the reader and verifies it against the hash of the blob
+func readBlob(r *blobReader) ([]byte, error) {
+	defer r.Close()
+	data := make([]byte, 0, r.size)
+	for {
+		n, err := r.Read(data[len(data):cap(data)])
+		data = data[:len(data)+n]
+		if err == io.EOF || int64(len(data)) == r.size {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if models.HashContent(data) != r.hash {
+		return nil, customErrors.ErrCorruptBlob(r.hash)
+	}
+	return data, nil
+}
+
+// emptyBlob returns a reader of the content that was never written, which is empty
+func emptyBlob(store chunkStore) *blobReader {
+	return newBlobReader(store, models.HashContent(nil), 0, nil)
+}
+
+// splitChunks splits data into the chunks a blob is made of
+func splitChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > ChunkSize {
+		chunks = append(chunks, data[:ChunkSize])
+		data = data[ChunkSize:]
+	}
+	if len(data) > 0 {
+		chunks = append(chunks, data)
+	}
+	return chunks
+}
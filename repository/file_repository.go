@@ -7,8 +7,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"regexp"
-	"sort"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -16,19 +16,106 @@ import (
 	"github.com/terenzio/vfs/domain/models"
 )
 
-// FileRepository handles the repository logic for files
+// FileRepository handles the repository logic for files.
+// Files are stored with the IDs of their owner and folder, and folder paths are resolved through the folder
+// repository the file repository is attached to by NewFileFolderRepository.
 type FileRepository struct {
 	filePath string
-	mu       sync.Mutex // Ensures thread-safe access to the file
+	folders  *FileFolderRepository
+	mu       sync.RWMutex // Ensures thread-safe access to the file
 }
 
 // storedFile represents the file structure stored in the file
 type storedFile struct {
-	Username    string `json:"username"`
-	FolderName  string `json:"folderName"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	CreatedAt   string `json:"createdAt"`
+	ID          models.ID `json:"id"`
+	UserID      models.ID `json:"userId"`
+	FolderID    models.ID `json:"folderId"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Size        int64     `json:"size"`
+	ContentHash string    `json:"contentHash,omitempty"`
+	CreatedAt   string    `json:"createdAt"`
+	ModifiedAt  string    `json:"modifiedAt"`
+	OwnerID     models.ID `json:"ownerId,omitempty"`
+	Group       string    `json:"group,omitempty"`
+	Mode        string    `json:"mode,omitempty"` // octal, e.g. "0640"
+}
+
+// storedTimeLayout is the layout used to store the timestamps of a file
+const storedTimeLayout = "2006-01-02T15:04:05"
+
+// storedPermissions converts the stored owner, group and mode of a folder or file in the tree of the user with userID.
+// Folders and files stored before permissions existed are owned by that user and have the default mode.
+func storedPermissions(userID, ownerID models.ID, group, mode string, defaultMode models.Mode) (models.Permissions, error) {
+	permissions := models.Permissions{OwnerID: ownerID, Group: group, Mode: defaultMode}
+	if ownerID == 0 {
+		permissions.OwnerID = userID
+	}
+	if mode != "" {
+		bits, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || models.Mode(bits) > models.ModePerm {
+			return permissions, fmt.Errorf("the mode [%s] is invalid", mode)
+		}
+		permissions.Mode = models.Mode(bits)
+	}
+	return permissions, nil
+}
+
+// toDomain converts the stored file of the user named username inside folderPath into a domain file.
+// Files stored before modification times were tracked report their creation time as modification time.
+func (f storedFile) toDomain(username, folderPath string) (models.File, error) {
+	createdAt, err := time.Parse(storedTimeLayout, f.CreatedAt)
+	if err != nil {
+		return models.File{}, err
+	}
+	permissions, err := storedPermissions(f.UserID, f.OwnerID, f.Group, f.Mode, models.DefaultFileMode)
+	if err != nil {
+		return models.File{}, err
+	}
+
+	modifiedAt := createdAt
+	if f.ModifiedAt != "" {
+		if modifiedAt, err = time.Parse(storedTimeLayout, f.ModifiedAt); err != nil {
+			return models.File{}, err
+		}
+	}
+
+	return models.File{
+		ID:          f.ID,
+		UserID:      f.UserID,
+		FolderID:    f.FolderID,
+		Username:    username,
+		FolderPath:  folderPath,
+		Name:        f.Name,
+		Description: f.Description,
+		Size:        f.Size,
+		ContentHash: f.ContentHash,
+		CreatedAt:   createdAt,
+		ModifiedAt:  modifiedAt,
+		Permissions: permissions,
+	}, nil
+}
+
+// newStoredFile returns the stored form of a domain file, without its username and folder path
+func newStoredFile(file models.File) storedFile {
+	f := storedFile{
+		ID:          file.ID,
+		UserID:      file.UserID,
+		FolderID:    file.FolderID,
+		Name:        file.Name,
+		Description: file.Description,
+		Size:        file.Size,
+		ContentHash: file.ContentHash,
+		CreatedAt:   file.CreatedAt.Format(storedTimeLayout),
+		ModifiedAt:  file.ModifiedAt.Format(storedTimeLayout),
+	}
+	f.setPermissions(file.Permissions)
+	return f
+}
+
+// setPermissions stores the owner, group and mode of the permissions
+func (f *storedFile) setPermissions(permissions models.Permissions) {
+	f.OwnerID, f.Group, f.Mode = permissions.OwnerID, permissions.Group, permissions.Mode.Octal()
 }
 
 // NewFileRepository creates a new instance of FileRepository
@@ -38,10 +125,8 @@ func NewFileRepository(filePath string) *FileRepository {
 	}
 }
 
+// loadFiles loads the files from the file. The caller must hold r.mu.
 func (r *FileRepository) loadFiles() ([]storedFile, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	// If the file does not exist, return an empty list
 	if _, err := os.Stat(r.filePath); os.IsNotExist(err) {
 		return []storedFile{}, nil
@@ -60,136 +145,305 @@ func (r *FileRepository) loadFiles() ([]storedFile, error) {
 	return files, nil
 }
 
+// saveFiles atomically replaces the stored files, so a crash never leaves a partially written file behind
 func (r *FileRepository) saveFiles(files []storedFile) error {
 	data, err := json.Marshal(files)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(r.filePath, data, 0644)
+	return writeFileAtomic(r.filePath, data, 0644)
+}
+
+// resolveFolder resolves the folder of the user at folderPath to its ID and returns the loaded folder tree, or false
+// if there is no such folder. The caller must hold r.folders.mu.
+func (r *FileRepository) resolveFolder(user models.User, folderPath string) (*folderTree, models.ID, bool, error) {
+	tree, err := r.folders.loadTree()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	id, ok := tree.resolve(user.ID, folderPath)
+	return tree, id, ok, nil
+}
+
+// indexOf returns the index of the file of the user named fileName under the case policy inside the folder with the
+// given ID, or -1
+func indexOf(files []storedFile, policy models.CasePolicy, userID, folderID models.ID, fileName string) int {
+	for i, f := range files {
+		if f.UserID == userID && f.FolderID == folderID && policy.Equal(f.Name, fileName) {
+			return i
+		}
+	}
+	return -1
 }
 
-// CreateFile adds a new file to the repository
+// CreateFile adds a new file to the repository and assigns it the next ID, which is never reused
 func (r *FileRepository) CreateFile(file models.File) error {
+	user, err := r.folders.users.GetUser(file.Username)
+	if err != nil {
+		return err
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, folderID, ok, err := r.resolveFolder(user, file.FolderPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return customErrors.ErrFolderNotFound(models.CleanPath(file.FolderPath))
+	}
 	files, err := r.loadFiles()
 	if err != nil {
 		return err
 	}
 
 	// Check if the file already exists within the same folder
-	for _, f := range files {
-		if f.Username == file.Username && f.FolderName == file.FolderName && f.Name == file.Name {
-			return customErrors.ErrFileExists(file.Name)
-		}
+	if indexOf(files, r.folders.users.policy, user.ID, folderID, file.Name) >= 0 {
+		return customErrors.ErrFileExists(file.Name)
 	}
 
-	newFile := storedFile{
-		Username:    file.Username,
-		FolderName:  file.FolderName,
-		Name:        file.Name,
-		Description: file.Description,
-		CreatedAt:   file.CreatedAt.Format("2006-01-02T15:04:05"),
+	if file.ID, err = r.nextID(files); err != nil {
+		return err
 	}
+	file.UserID, file.FolderID = user.ID, folderID
+	file.Name = r.folders.users.policy.Normalize(file.Name)
+	if file.OwnerID == 0 {
+		file.OwnerID = user.ID
+	}
+	newFile := newStoredFile(file)
 
 	files = append(files, newFile)
 
 	return r.saveFiles(files)
 }
 
+// nextID hands out the ID of the next file added to the stored files. The caller must hold r.mu.
+func (r *FileRepository) nextID(files []storedFile) (models.ID, error) {
+	var highest models.ID
+	for _, f := range files {
+		if f.ID > highest {
+			highest = f.ID
+		}
+	}
+	return nextID(filepath.Dir(r.filePath), fileIDs, highest)
+}
+
+// GetFile returns a single file of the repository
+func (r *FileRepository) GetFile(username, folderPath, fileName string) (models.File, error) {
+	user, ok, err := r.folders.users.find(username)
+	if err != nil {
+		return models.File{}, err
+	}
+	if !ok {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tree, folderID, ok, err := r.resolveFolder(user, folderPath)
+	if err != nil {
+		return models.File{}, err
+	}
+	if !ok {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+	files, err := r.loadFiles()
+	if err != nil {
+		return models.File{}, err
+	}
+
+	i := indexOf(files, r.folders.users.policy, user.ID, folderID, fileName)
+	if i < 0 {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+	return files[i].toDomain(user.Username, tree.path(folderID))
+}
+
+// UpdateFile replaces the description, size, content hash, modification time and permissions of an existing file
+func (r *FileRepository) UpdateFile(file models.File) error {
+	return r.modifyFile(file.Username, file.FolderPath, file.Name, func(files []storedFile, i int) []storedFile {
+		files[i].Description = file.Description
+		files[i].Size = file.Size
+		files[i].ContentHash = file.ContentHash
+		files[i].ModifiedAt = file.ModifiedAt.Format(storedTimeLayout)
+		files[i].setPermissions(file.Permissions)
+		return files
+	})
+}
+
 // DeleteFile removes a file from the repository
-func (r *FileRepository) DeleteFile(username, folderName, fileName string) error {
+func (r *FileRepository) DeleteFile(username, folderPath, fileName string) error {
+	return r.modifyFile(username, folderPath, fileName, func(files []storedFile, i int) []storedFile {
+		// Remove the file from the list
+		return append(files[:i], files[i+1:]...)
+	})
+}
+
+// modifyFile looks up an existing file and replaces the stored files with the result of modify, which is given the
+// stored files and the index of the file
+func (r *FileRepository) modifyFile(username, folderPath, fileName string, modify func(files []storedFile, i int) []storedFile) error {
+	user, ok, err := r.folders.users.find(username)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return customErrors.ErrFileNotFound(fileName)
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, folderID, ok, err := r.resolveFolder(user, folderPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return customErrors.ErrFileNotFound(fileName)
+	}
 	files, err := r.loadFiles()
 	if err != nil {
 		return err
 	}
 
-	for i, f := range files {
-		if f.Username == username && f.FolderName == folderName && f.Name == fileName {
-			// Remove the file from the list
-			files = append(files[:i], files[i+1:]...)
-			return r.saveFiles(files)
+	i := indexOf(files, r.folders.users.policy, user.ID, folderID, fileName)
+	if i < 0 {
+		return customErrors.ErrFileNotFound(fileName)
+	}
+	return r.saveFiles(modify(files, i))
+}
+
+// MoveFile moves a file to newFolderPath under newFileName, keeping its ID, description, size and times.
+// An existing file at the destination is replaced if overwrite is set, otherwise ErrFileExists is returned.
+func (r *FileRepository) MoveFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) error {
+	_, err := r.relocateFile(username, folderPath, fileName, newFolderPath, newFileName, overwrite, false)
+	return err
+}
+
+// CopyFile copies a file to newFolderPath under newFileName, keeping its description, size and times, and returns
+// the copy, which gets an ID of its own.
+// An existing file at the destination is replaced if overwrite is set, otherwise ErrFileExists is returned.
+func (r *FileRepository) CopyFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) (models.File, error) {
+	return r.relocateFile(username, folderPath, fileName, newFolderPath, newFileName, overwrite, true)
+}
+
+// relocateFile moves or copies a file within a single load and save, so the destination is never left half replaced,
+// and returns the relocated file
+func (r *FileRepository) relocateFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite, keepSource bool) (models.File, error) {
+	user, ok, err := r.folders.users.find(username)
+	if err != nil {
+		return models.File{}, err
+	}
+	if !ok {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tree, folderID, ok, err := r.resolveFolder(user, folderPath)
+	if err != nil {
+		return models.File{}, err
+	}
+	if !ok {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+	newFolderID, ok := tree.resolve(user.ID, newFolderPath)
+	if !ok {
+		return models.File{}, customErrors.ErrFolderNotFound(models.CleanPath(newFolderPath))
+	}
+	files, err := r.loadFiles()
+	if err != nil {
+		return models.File{}, err
+	}
+
+	source := indexOf(files, r.folders.users.policy, user.ID, folderID, fileName)
+	target := indexOf(files, r.folders.users.policy, user.ID, newFolderID, newFileName)
+	if source < 0 {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+	if target == source {
+		// Moving a file onto itself at most changes the case of its name, but a file can't be copied onto itself
+		if keepSource {
+			return models.File{}, customErrors.ErrFileExists(newFileName)
+		}
+		target = -1
+	}
+	if target >= 0 && !overwrite {
+		return models.File{}, customErrors.ErrFileExists(newFileName)
+	}
+
+	relocated := files[source]
+	relocated.FolderID = newFolderID
+	relocated.Name = r.folders.users.policy.Normalize(newFileName)
+	if keepSource {
+		if relocated.ID, err = r.nextID(files); err != nil {
+			return models.File{}, err
 		}
+		files = append(files, relocated)
+	} else {
+		files[source] = relocated
+	}
+	if target >= 0 {
+		files = append(files[:target], files[target+1:]...)
 	}
 
-	return customErrors.ErrFileNotFound(fileName)
+	if err := r.saveFiles(files); err != nil {
+		return models.File{}, err
+	}
+	return relocated.toDomain(user.Username, tree.path(newFolderID))
 }
 
 // ListFiles returns a slice of files sorted based on the specified field and order.
-func (r *FileRepository) ListFiles(username, folderName, sortField, sortOrder string) ([]models.File, error) {
+func (r *FileRepository) ListFiles(username, folderPath, sortField, sortOrder string) ([]models.File, error) {
+	user, ok, err := r.folders.users.find(username)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tree, folderID, ok, err := r.resolveFolder(user, folderPath)
+	if err != nil || !ok {
+		return nil, err
+	}
 	files, err := r.loadFiles()
 	if err != nil {
 		return nil, err
 	}
 
-	// Filter files by username and folderName
-	var filteredFiles []storedFile
+	// Filter files by owner and folder, converting them to domain files
+	folderPath = tree.path(folderID)
+	var domainFiles []models.File
 	for _, f := range files {
-		if f.Username == username && f.FolderName == folderName {
-			filteredFiles = append(filteredFiles, f)
+		if f.UserID != user.ID || f.FolderID != folderID {
+			continue
 		}
-	}
-
-	// Sorting
-	switch sortField {
-	case "--sort-name":
-		sort.Slice(filteredFiles, func(i, j int) bool {
-			if sortOrder == "desc" {
-				return filteredFiles[i].Name > filteredFiles[j].Name
-			}
-			return filteredFiles[i].Name < filteredFiles[j].Name
-		})
-	case "--sort-created":
-		sort.Slice(filteredFiles, func(i, j int) bool {
-			if sortOrder == "desc" {
-				return filteredFiles[i].CreatedAt > filteredFiles[j].CreatedAt
-			}
-			return filteredFiles[i].CreatedAt < filteredFiles[j].CreatedAt
-		})
-	default:
-		// Default sorting by name in ascending order
-		sort.Slice(filteredFiles, func(i, j int) bool {
-			return filteredFiles[i].Name < filteredFiles[j].Name
-		})
-	}
-
-	// Convert to domain.File slice
-	var domainFiles []models.File
-	for _, f := range filteredFiles {
-		createdAt, err := time.Parse("2006-01-02T15:04:05", f.CreatedAt)
+		domainFile, err := f.toDomain(user.Username, folderPath)
 		if err != nil {
 			// Handle the error, e.g., log it, skip this file, or use a zero time.
 			// For this example, we'll log the error and continue with the next file.
 			fmt.Printf("Error parsing date for file '%s': %v\n", f.Name, err)
 			continue
 		}
-
-		domainFile := models.File{
-			Username:    f.Username,
-			FolderName:  f.FolderName,
-			Name:        f.Name,
-			Description: f.Description,
-			CreatedAt:   createdAt,
-		}
 		domainFiles = append(domainFiles, domainFile)
 	}
 
-	return domainFiles, nil
-}
-
-// ValidateFileName checks if the folder name is valid.
-// It must contain only alphabets (uppercase and lowercase) and numbers, no spaces.
-// The length of the file name must be less than or equal to 30 characters.
-func (r *FileRepository) ValidateFileName(fileName string) error {
-	// Check the length of the folder name first
-	if len(fileName) > 30 {
-		return customErrors.ErrNameTooLong(fileName)
-	}
-
-	// Regular expression to match usernames containing only alphabets and numbers.
-	validFolderNameRegex := regexp.MustCompile(`^[A-Za-z0-9]+$`)
-	if !validFolderNameRegex.MatchString(fileName) {
-		return customErrors.ErrInvalidName(fileName)
-	}
+	// Sorting
+	sortFiles(domainFiles, sortField, sortOrder)
 
-	return nil
+	return domainFiles, nil
 }
@@ -0,0 +1,250 @@
+package repository_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/repository"
+)
+
+// stressWorkers is the number of goroutines that hammer a repository at the same time
+const stressWorkers = 50
+
+// fileRepositories creates an empty instance of every file repository implementation in which user1 owns the
+// folders /folder1 and /folder2
+var fileRepositories = map[string]func(t *testing.T) models.FileRepository{
+	"File": func(t *testing.T) models.FileRepository {
+		return withFolders(t)(newFileStoreRepositories(t))
+	},
+	"Memory": func(t *testing.T) models.FileRepository {
+		return withFolders(t)(newMemoryRepositories(t))
+	},
+	"SQL": func(t *testing.T) models.FileRepository {
+		db := openSQLDatabase(t)
+		return withFolders(t)(repository.NewSQLFolderRepository(db, models.CasePreserving), repository.NewSQLFileRepository(db, models.CasePreserving))
+	},
+}
+
+// withFolders returns a function that creates /folder1 and /folder2 in a folder repository and returns the file
+// repository holding their files
+func withFolders(t *testing.T) func(folders models.FolderRepository, files models.FileRepository) models.FileRepository {
+	return func(folders models.FolderRepository, files models.FileRepository) models.FileRepository {
+		assert.NoError(t, folders.CreateFolder(newFolder("/", "folder1")))
+		assert.NoError(t, folders.CreateFolder(newFolder("/", "folder2")))
+		return files
+	}
+}
+
+// TestFileRepositoryConcurrency tests that concurrent mutations of every file repository never overwrite each other
+func TestFileRepositoryConcurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		testFunc func(t *testing.T, repo models.FileRepository)
+	}{
+		{
+			name: "ConcurrentCreatesOfDistinctFiles",
+			testFunc: func(t *testing.T, repo models.FileRepository) {
+				var wg sync.WaitGroup
+				for i := 0; i < stressWorkers; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						assert.NoError(t, repo.CreateFile(newFile(fmt.Sprintf("file%d", i))))
+					}(i)
+				}
+				wg.Wait()
+
+				files, err := repo.ListFiles("user1", "/folder1", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, files, stressWorkers)
+			},
+		},
+		{
+			name: "ConcurrentCreatesOfTheSameFile",
+			testFunc: func(t *testing.T, repo models.FileRepository) {
+				var wg sync.WaitGroup
+				var created int32
+				for i := 0; i < stressWorkers; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if repo.CreateFile(newFile("file1")) == nil {
+							atomic.AddInt32(&created, 1)
+						}
+					}()
+				}
+				wg.Wait()
+
+				assert.Equal(t, int32(1), created)
+				files, err := repo.ListFiles("user1", "/folder1", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, files, 1)
+			},
+		},
+		{
+			name: "ConcurrentMixedMutations",
+			testFunc: func(t *testing.T, repo models.FileRepository) {
+				for i := 0; i < stressWorkers; i++ {
+					assert.NoError(t, repo.CreateFile(newFile(fmt.Sprintf("old%d", i))))
+				}
+
+				var wg sync.WaitGroup
+				for i := 0; i < stressWorkers; i++ {
+					wg.Add(3)
+					go func(i int) {
+						defer wg.Done()
+						assert.NoError(t, repo.DeleteFile("user1", "/folder1", fmt.Sprintf("old%d", i)))
+					}(i)
+					go func(i int) {
+						defer wg.Done()
+						assert.NoError(t, repo.CreateFile(newFile(fmt.Sprintf("new%d", i))))
+					}(i)
+					go func() {
+						defer wg.Done()
+						_, err := repo.ListFiles("user1", "/folder1", "--sort-name", "asc")
+						assert.NoError(t, err)
+					}()
+				}
+				wg.Wait()
+
+				files, err := repo.ListFiles("user1", "/folder1", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, files, stressWorkers)
+				for _, f := range files {
+					assert.Regexp(t, "^new", f.Name)
+				}
+			},
+		},
+		{
+			name: "ConcurrentUpdatesOfTheSameFile",
+			testFunc: func(t *testing.T, repo models.FileRepository) {
+				assert.NoError(t, repo.CreateFile(newFile("file1")))
+
+				var wg sync.WaitGroup
+				for i := 0; i < stressWorkers; i++ {
+					wg.Add(2)
+					go func(i int) {
+						defer wg.Done()
+						file := newFile("file1")
+						file.Size = int64(i)
+						assert.NoError(t, repo.UpdateFile(file))
+					}(i)
+					go func(i int) {
+						defer wg.Done()
+						assert.NoError(t, repo.CreateFile(newFile(fmt.Sprintf("other%d", i))))
+					}(i)
+				}
+				wg.Wait()
+
+				files, err := repo.ListFiles("user1", "/folder1", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, files, stressWorkers+1)
+			},
+		},
+	}
+
+	for implementation, newRepository := range fileRepositories {
+		for _, tt := range tests {
+			t.Run(implementation+"/"+tt.name, func(t *testing.T) {
+				tt.testFunc(t, newRepository(t))
+			})
+		}
+	}
+}
+
+// TestFileRepositoryRelocation tests that every file repository moves and copies files with their metadata
+func TestFileRepositoryRelocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		testFunc func(t *testing.T, repo models.FileRepository, original models.File)
+	}{
+		{
+			name: "MoveKeepsMetadata",
+			testFunc: func(t *testing.T, repo models.FileRepository, original models.File) {
+				source, err := repo.GetFile("user1", "/folder1", "file1")
+				assert.NoError(t, err)
+				assert.NoError(t, repo.MoveFile("user1", "/folder1", "file1", "/folder2", "file2", false))
+
+				_, err = repo.GetFile("user1", "/folder1", "file1")
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+				moved, err := repo.GetFile("user1", "/folder2", "file2")
+				assert.NoError(t, err)
+				assert.Equal(t, source.ID, moved.ID)
+				assert.Equal(t, original.Description, moved.Description)
+				assert.Equal(t, original.Size, moved.Size)
+				assert.Equal(t, original.ContentHash, moved.ContentHash)
+				assert.WithinDuration(t, original.CreatedAt, moved.CreatedAt, time.Second) // the file store keeps whole seconds
+			},
+		},
+		{
+			name: "CopyKeepsSource",
+			testFunc: func(t *testing.T, repo models.FileRepository, original models.File) {
+				copied, err := repo.CopyFile("user1", "/folder1", "file1", "/folder1", "file2", false)
+				assert.NoError(t, err)
+				source, err := repo.GetFile("user1", "/folder1", "file1")
+				assert.NoError(t, err)
+				assert.NotEqual(t, source.ID, copied.ID)
+				assert.Equal(t, "/folder1/file2", copied.Path())
+				assert.Equal(t, original.ContentHash, copied.ContentHash)
+
+				files, err := repo.ListFiles("user1", "/folder1", "--sort-name", "asc")
+				assert.NoError(t, err)
+				if assert.Len(t, files, 2) {
+					assert.Equal(t, "file2", files[1].Name)
+					assert.Equal(t, original.Description, files[1].Description)
+				}
+			},
+		},
+		{
+			name: "ConflictWithoutOverwrite",
+			testFunc: func(t *testing.T, repo models.FileRepository, original models.File) {
+				assert.NoError(t, repo.CreateFile(newFile("file2")))
+
+				err := repo.MoveFile("user1", "/folder1", "file1", "/folder1", "file2", false)
+				assert.ErrorIs(t, err, customErrors.ErrConflict)
+				_, err = repo.CopyFile("user1", "/folder1", "file1", "/folder1", "file1", true)
+				assert.ErrorIs(t, err, customErrors.ErrConflict)
+			},
+		},
+		{
+			name: "OverwriteReplacesTarget",
+			testFunc: func(t *testing.T, repo models.FileRepository, original models.File) {
+				assert.NoError(t, repo.CreateFile(newFile("file2")))
+				assert.NoError(t, repo.MoveFile("user1", "/folder1", "file1", "/folder1", "file2", true))
+
+				files, err := repo.ListFiles("user1", "/folder1", "", "")
+				assert.NoError(t, err)
+				if assert.Len(t, files, 1) {
+					assert.Equal(t, "file2", files[0].Name)
+					assert.Equal(t, original.Description, files[0].Description)
+				}
+			},
+		},
+	}
+
+	for implementation, newRepository := range fileRepositories {
+		for _, tt := range tests {
+			t.Run(implementation+"/"+tt.name, func(t *testing.T) {
+				repo := newRepository(t)
+				original := newFile("file1")
+				original.Description = "report"
+				original.Size = 42
+				original.ContentHash = models.HashContent([]byte("content"))
+				assert.NoError(t, repo.CreateFile(original))
+				tt.testFunc(t, repo, original)
+			})
+		}
+	}
+}
+
+// newFile returns a file of user1 inside /folder1
+func newFile(name string) models.File {
+	now := time.Now()
+	return models.File{Username: "user1", FolderPath: "/folder1", Name: name, CreatedAt: now, ModifiedAt: now}
+}
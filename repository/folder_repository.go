@@ -4,12 +4,10 @@ package repository
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"os"
-	"regexp"
-	"sort"
-	"strings"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
@@ -17,32 +15,80 @@ import (
 	"github.com/terenzio/vfs/domain/models"
 )
 
-// FileFolderRepository handles the repository logic for folders
+// FileFolderRepository handles the repository logic for folders.
+// Folders are stored with the IDs of their owner and parent folder, and are loaded into a folder tree that resolves
+// paths to IDs. Deleting a folder also deletes the files inside it, so the repository works together with the user
+// and file repositories of the same data directory.
 type FileFolderRepository struct {
 	filePath string
-	mu       sync.Mutex // ensures thread-safe access to the file
+	users    *FileUserRepository
+	files    *FileRepository
+	mu       sync.RWMutex // ensures thread-safe access to the file
 }
 
-// storedFolder represents the folder structure stored in the file
+// storedFolder represents the folder structure stored in the file.
+// ParentID holds the ID of the parent folder, which is how the parent/child relationships are persisted.
 type storedFolder struct {
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Username    string    `json:"username"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          models.ID     `json:"id"`
+	UserID      models.ID     `json:"user_id"`
+	ParentID    models.ID     `json:"parent_id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	CreatedAt   time.Time     `json:"created_at"`
+	OwnerID     models.ID     `json:"owner_id,omitempty"`
+	Group       string        `json:"group,omitempty"`
+	Mode        string        `json:"mode,omitempty"` // octal, e.g. "0750"
+	Shares      []storedShare `json:"shares,omitempty"`
 }
 
-// NewFileFolderRepository creates a new instance of FileFolderRepository
-func NewFileFolderRepository(filePath string) *FileFolderRepository {
-	return &FileFolderRepository{
+// storedShare represents an entry of the access control list of a stored folder
+type storedShare struct {
+	UserID models.ID `json:"user_id"`
+	Access string    `json:"access"`
+}
+
+// toDomain converts the stored folder into a domain folder, without its username and parent path
+func (f storedFolder) toDomain() (models.Folder, error) {
+	permissions, err := storedPermissions(f.UserID, f.OwnerID, f.Group, f.Mode, models.DefaultFolderMode)
+	if err != nil {
+		return models.Folder{}, err
+	}
+	var shares []models.Share
+	for _, s := range f.Shares {
+		access, err := models.ParseShareAccess(s.Access)
+		if err != nil {
+			return models.Folder{}, err
+		}
+		shares = append(shares, models.Share{UserID: s.UserID, Access: access})
+	}
+	return models.Folder{
+		ID:          f.ID,
+		UserID:      f.UserID,
+		ParentID:    f.ParentID,
+		Name:        f.Name,
+		Description: f.Description,
+		CreatedAt:   f.CreatedAt,
+		Permissions: permissions,
+		Shares:      shares,
+	}, nil
+}
+
+// NewFileFolderRepository creates a new instance of FileFolderRepository that resolves usernames through users and
+// keeps the files of its folders in files. The file repository resolves folder paths through the new repository, and
+// the user repository deletes the folders of deleted users through it.
+func NewFileFolderRepository(filePath string, users *FileUserRepository, files *FileRepository) *FileFolderRepository {
+	r := &FileFolderRepository{
 		filePath: filePath,
+		users:    users,
+		files:    files,
 	}
+	files.folders = r
+	users.folders = r
+	return r
 }
 
-// loadFolders loads the folders from the file
+// loadFolders loads the stored folders from the file. The caller must hold r.mu.
 func (r *FileFolderRepository) loadFolders() ([]storedFolder, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	// Check if file exists
 	if _, err := os.Stat(r.filePath); os.IsNotExist(err) {
 		return []storedFolder{}, nil // Return an empty slice if the file doesn't exist
@@ -61,168 +107,337 @@ func (r *FileFolderRepository) loadFolders() ([]storedFolder, error) {
 	return folders, nil
 }
 
-// saveFolders saves the folders to the file
-func (r *FileFolderRepository) saveFolders(folders []storedFolder) error {
-	data, err := json.Marshal(folders)
+// loadTree loads the folders from the file into a folder tree. The caller must hold r.mu.
+func (r *FileFolderRepository) loadTree() (*folderTree, error) {
+	folders, err := r.loadFolders()
+	if err != nil {
+		return nil, err
+	}
+
+	tree := newFolderTree(r.users.policy)
+	for _, f := range folders {
+		folder, err := f.toDomain()
+		if err != nil {
+			return nil, err
+		}
+		tree.insert(folder)
+	}
+	return tree, nil
+}
+
+// marshalTree returns the stored form of the folders of the tree
+func marshalTree(tree *folderTree) ([]byte, error) {
+	folders := []storedFolder{}
+	for _, f := range tree.all() {
+		folders = append(folders, newStoredFolder(f))
+	}
+	return json.Marshal(folders)
+}
+
+// newStoredFolder returns the stored form of a domain folder, without its username and parent path
+func newStoredFolder(f models.Folder) storedFolder {
+	var shares []storedShare
+	for _, s := range f.Shares {
+		shares = append(shares, storedShare{UserID: s.UserID, Access: string(s.Access)})
+	}
+	return storedFolder{
+		ID:          f.ID,
+		UserID:      f.UserID,
+		ParentID:    f.ParentID,
+		Name:        f.Name,
+		Description: f.Description,
+		CreatedAt:   f.CreatedAt,
+		OwnerID:     f.OwnerID,
+		Group:       f.Group,
+		Mode:        f.Mode.Octal(),
+		Shares:      shares,
+	}
+}
+
+// saveTree atomically replaces the stored folders, so a crash never leaves a partially written file behind
+func (r *FileFolderRepository) saveTree(tree *folderTree) error {
+	data, err := marshalTree(tree)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(r.filePath, data, 0644)
+	return writeFileAtomic(r.filePath, data, 0644)
+}
+
+// saveTreeAndFiles atomically replaces both the stored folders and the stored files, so a crash never leaves a
+// change that spans folders and files half applied. The caller must hold r.mu and r.files.mu.
+func (r *FileFolderRepository) saveTreeAndFiles(tree *folderTree, files []storedFile) error {
+	folderData, err := marshalTree(tree)
+	if err != nil {
+		return err
+	}
+	fileData, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+
+	// The journal is kept next to the folders and names both files relative to it
+	dir := filepath.Dir(r.filePath)
+	filesPath, err := filepath.Rel(dir, r.files.filePath)
+	if err != nil {
+		return err
+	}
+	return writeFilesAtomic(dir, map[string][]byte{
+		filepath.Base(r.filePath): folderData,
+		filesPath:                 fileData,
+	}, 0644)
 }
 
 // Exists checks if a folder already exists for a user
-func (r *FileFolderRepository) Exists(userName, folderName string) (bool, error) {
-	folders, err := r.loadFolders()
+func (r *FileFolderRepository) Exists(userName, folderPath string) (bool, error) {
+	user, ok, err := r.users.find(userName)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tree, err := r.loadTree()
 	if err != nil {
 		return false, err
 	}
 
-	// Check for existing folder with same name under the same username
-	for _, f := range folders {
-		if strings.EqualFold(f.Name, folderName) && f.Username == userName {
-			return true, nil
-		}
+	id, ok := tree.resolve(user.ID, folderPath)
+	return ok && id != 0, nil
+}
+
+// GetFolder returns the folder of the user at folderPath
+func (r *FileFolderRepository) GetFolder(username, folderPath string) (models.Folder, error) {
+	user, ok, err := r.users.find(username)
+	if err != nil {
+		return models.Folder{}, err
+	}
+	if !ok {
+		return models.Folder{}, customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
 	}
-	return false, nil
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tree, err := r.loadTree()
+	if err != nil {
+		return models.Folder{}, err
+	}
+
+	id, ok := tree.resolve(user.ID, folderPath)
+	if !ok || id == 0 {
+		return models.Folder{}, customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
+	return tree.get(id, user), nil
 }
 
-// CreateFolder adds a new folder to the repository
+// CreateFolder adds a new folder to the repository and assigns it the next ID, which is never reused
 func (r *FileFolderRepository) CreateFolder(folder models.Folder) error {
-	folders, err := r.loadFolders()
+	user, err := r.users.GetUser(folder.Username)
 	if err != nil {
 		return err
 	}
 
-	// Check for existing folder with same name under the same username
-	for _, f := range folders {
-		if strings.EqualFold(f.Name, folder.Name) && f.Username == folder.Username {
-			return customErrors.ErrFolderExists(folder.Name)
-		}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tree, err := r.loadTree()
+	if err != nil {
+		return err
+	}
+
+	// Check for existing folder with same name inside the same parent folder
+	parentID, ok := tree.resolve(user.ID, folder.ParentPath)
+	if !ok {
+		return customErrors.ErrFolderNotFound(models.CleanPath(folder.ParentPath))
+	}
+	if _, ok := tree.child(user.ID, parentID, folder.Name); ok {
+		return customErrors.ErrFolderExists(folder.Path())
+	}
+
+	if folder.ID, err = nextID(filepath.Dir(r.filePath), folderIDs, tree.lastID); err != nil {
+		return err
+	}
+	folder.UserID, folder.ParentID = user.ID, parentID
+	folder.Name = tree.policy.Normalize(folder.Name)
+	if folder.OwnerID == 0 {
+		folder.OwnerID = user.ID
+	}
+	tree.insert(folder)
+	return r.saveTree(tree)
+}
+
+// DeleteFolder deletes a folder together with all the folders nested inside it and the files inside them, and returns
+// the deleted files. Unless recursive is set, a folder that still holds folders or files is not deleted.
+func (r *FileFolderRepository) DeleteFolder(username, folderPath string, recursive bool) ([]models.File, error) {
+	user, ok, err := r.users.find(username)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files.mu.Lock()
+	defer r.files.mu.Unlock()
+
+	tree, err := r.loadTree()
+	if err != nil {
+		return nil, err
+	}
+	id, ok := tree.resolve(user.ID, folderPath)
+	if !ok || id == 0 {
+		return nil, customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
+	subtree := tree.subtree(id)
+	inSubtree := make(map[models.ID]bool, len(subtree))
+	for _, folderID := range subtree {
+		inSubtree[folderID] = true
 	}
 
-	folders = append(folders, storedFolder{
-		Name:        folder.Name,
-		Description: folder.Description,
-		Username:    folder.Username,
-		CreatedAt:   folder.CreatedAt,
-	})
+	files, err := r.files.loadFiles()
+	if err != nil {
+		return nil, err
+	}
+	var deleted []models.File
+	remainingFiles := files[:0]
+	for _, f := range files {
+		if f.UserID == user.ID && inSubtree[f.FolderID] {
+			file, err := f.toDomain(user.Username, tree.path(f.FolderID))
+			if err != nil {
+				return nil, err
+			}
+			deleted = append(deleted, file)
+			continue
+		}
+		remainingFiles = append(remainingFiles, f)
+	}
 
-	return r.saveFolders(folders)
+	if !recursive && (len(subtree) > 1 || len(deleted) > 0) {
+		return nil, customErrors.ErrFolderNotEmpty(tree.path(id))
+	}
+	for _, folderID := range subtree {
+		tree.remove(folderID)
+	}
+	if len(deleted) == 0 {
+		return nil, r.saveTree(tree)
+	}
+	return deleted, r.saveTreeAndFiles(tree, remainingFiles)
 }
 
-// DeleteFolder deletes a folder
-func (r *FileFolderRepository) DeleteFolder(username, folderName string) error {
-	folders, err := r.loadFolders()
+// RenameFolder renames a folder. The folders nested inside it and the files inside them reference it by ID, so they
+// move along without being updated.
+func (r *FileFolderRepository) RenameFolder(username, folderPath, newFolderName string) error {
+	user, ok, err := r.users.find(username)
 	if err != nil {
 		return err
 	}
+	if !ok {
+		return customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
 
-	found := false
-	for i, f := range folders {
-		if f.Username == username && strings.EqualFold(f.Name, folderName) {
-			// Remove folder from slice
-			folders = append(folders[:i], folders[i+1:]...)
-			found = true
-			break
-		}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tree, err := r.loadTree()
+	if err != nil {
+		return err
+	}
+	id, ok := tree.resolve(user.ID, folderPath)
+	if !ok || id == 0 {
+		return customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
 	}
 
-	if !found {
-		return customErrors.ErrFolderNotFound(folderName)
+	// Check if new name already exists
+	folder := tree.folders[id]
+	if existing, ok := tree.child(user.ID, folder.ParentID, newFolderName); ok && existing != id {
+		return customErrors.ErrFolderExists(models.JoinPath(tree.path(folder.ParentID), newFolderName))
 	}
 
-	return r.saveFolders(folders)
+	tree.remove(id)
+	folder.Name = tree.policy.Normalize(newFolderName)
+	tree.insert(folder)
+	return r.saveTree(tree)
 }
 
-// RenameFolder renames a folder
-func (r *FileFolderRepository) RenameFolder(username, folderName, newFolderName string) error {
-	folders, err := r.loadFolders()
+// UpdateFolder replaces the description, permissions and access control list of an existing folder
+func (r *FileFolderRepository) UpdateFolder(folder models.Folder) error {
+	user, ok, err := r.users.find(folder.Username)
 	if err != nil {
 		return err
 	}
+	if !ok {
+		return customErrors.ErrFolderNotFound(folder.Path())
+	}
 
-	for i, f := range folders {
-		if f.Username == username && strings.EqualFold(f.Name, folderName) {
-			// Check if new name already exists
-			for _, f2 := range folders {
-				if f2.Username == username && strings.EqualFold(f2.Name, newFolderName) {
-					return customErrors.ErrFolderExists(newFolderName)
-				}
-			}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-			folders[i].Name = newFolderName
-			return r.saveFolders(folders)
-		}
+	tree, err := r.loadTree()
+	if err != nil {
+		return err
+	}
+	id, ok := tree.resolve(user.ID, folder.Path())
+	if !ok || id == 0 {
+		return customErrors.ErrFolderNotFound(folder.Path())
 	}
 
-	return customErrors.ErrFolderNotFound(folderName)
+	stored := tree.folders[id]
+	stored.Description = folder.Description
+	stored.Permissions = folder.Permissions
+	stored.Shares = slices.Clone(folder.Shares)
+	tree.folders[id] = stored
+	return r.saveTree(tree)
 }
 
-// ListFolders returns a slice of folders sorted based on the specified field and order.
-func (r *FileFolderRepository) ListFolders(username, sortField, sortOrder string) ([]models.Folder, error) {
-	folders, err := r.loadFolders()
-	if err != nil {
+// ListFolders returns a slice of the folders directly inside parentPath, sorted based on the specified field and order.
+// The slice is empty if parentPath has no subfolders.
+func (r *FileFolderRepository) ListFolders(username, parentPath, sortField, sortOrder string) ([]models.Folder, error) {
+	user, ok, err := r.users.find(username)
+	if err != nil || !ok {
 		return nil, err
 	}
 
-	// Filter folders by username
-	var userFolders []models.Folder
-	for _, f := range folders {
-		if f.Username == username {
-			userFolders = append(userFolders, models.Folder{
-				Username:    f.Username,
-				Name:        f.Name,
-				Description: f.Description,
-				CreatedAt:   f.CreatedAt,
-			})
-		}
-	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	if len(userFolders) == 0 {
-		return nil, fmt.Errorf("no folders found for user %s", username)
+	tree, err := r.loadTree()
+	if err != nil {
+		return nil, err
+	}
+	parentID, ok := tree.resolve(user.ID, parentPath)
+	if !ok {
+		return nil, nil
 	}
 
 	// Sorting the folders
-	switch sortField {
-	case "--sort-name":
-		sort.Slice(userFolders, func(i, j int) bool {
-			if sortOrder == "desc" {
-				return userFolders[i].Name > userFolders[j].Name
-			}
-			return userFolders[i].Name < userFolders[j].Name
-		})
-	case "--sort-created":
-		sort.Slice(userFolders, func(i, j int) bool {
-			if sortOrder == "desc" {
-				return userFolders[i].CreatedAt.After(userFolders[j].CreatedAt)
-			}
-			return userFolders[i].CreatedAt.Before(userFolders[j].CreatedAt)
-		})
-	default:
-		// Default to sort by name in ascending order if no sort flag is provided
-		sort.Slice(userFolders, func(i, j int) bool {
-			return userFolders[i].Name < userFolders[j].Name
-		})
-	}
+	userFolders := tree.list(user, parentID)
+	sortFolders(userFolders, sortField, sortOrder)
 
 	return userFolders, nil
 }
 
-// ValidateFolderName checks if the folder name is valid.
-// It must contain only alphabets (uppercase and lowercase) and numbers, no spaces.
-// The length of the folder name must be less than or equal to 30 characters.
-func (r *FileFolderRepository) ValidateFolderName(folderName string) error {
-	// Check the length of the folder name first
-	if len(folderName) > 30 {
-		return customErrors.ErrNameTooLong(folderName)
+// ListShared returns the folders other users share with the user and the folders the user shares with others, ordered
+// by ID
+func (r *FileFolderRepository) ListShared(username string) ([]models.Folder, error) {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return nil, err
 	}
-
-	// Regular expression to match usernames containing only alphabets and numbers.
-	validFolderNameRegex := regexp.MustCompile(`^[A-Za-z0-9]+$`)
-	if !validFolderNameRegex.MatchString(folderName) {
-		return customErrors.ErrInvalidName(folderName)
+	users, err := r.users.ListUsers("", "")
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tree, err := r.loadTree()
+	if err != nil {
+		return nil, err
+	}
+	return tree.shared(user, users), nil
 }
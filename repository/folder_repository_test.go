@@ -0,0 +1,395 @@
+package repository_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/repository"
+)
+
+// folderRepositories creates an empty instance of every folder repository implementation
+var folderRepositories = map[string]func(t *testing.T) models.FolderRepository{
+	"File": func(t *testing.T) models.FolderRepository {
+		folders, _ := newFileStoreRepositories(t)
+		return folders
+	},
+	"Memory": func(t *testing.T) models.FolderRepository {
+		folders, _ := newMemoryRepositories(t)
+		return folders
+	},
+	"SQL": func(t *testing.T) models.FolderRepository {
+		return repository.NewSQLFolderRepository(openSQLDatabase(t), models.CasePreserving)
+	},
+}
+
+// folderAndFileRepositories creates an empty folder repository of every implementation together with the file
+// repository that holds the files of its folders
+var folderAndFileRepositories = map[string]func(t *testing.T) (models.FolderRepository, models.FileRepository){
+	"File": func(t *testing.T) (models.FolderRepository, models.FileRepository) {
+		return newFileStoreRepositories(t)
+	},
+	"Memory": func(t *testing.T) (models.FolderRepository, models.FileRepository) {
+		return newMemoryRepositories(t)
+	},
+	"SQL": func(t *testing.T) (models.FolderRepository, models.FileRepository) {
+		db := openSQLDatabase(t)
+		return repository.NewSQLFolderRepository(db, models.CasePreserving), repository.NewSQLFileRepository(db, models.CasePreserving)
+	},
+}
+
+// newFileStoreRepositories creates the folder and file repositories of a data directory in which only user1 is registered
+func newFileStoreRepositories(t *testing.T) (*repository.FileFolderRepository, *repository.FileRepository) {
+	dir := t.TempDir()
+	users := repository.NewFileUserRepository(filepath.Join(dir, repository.UsersFileName), models.CasePreserving)
+	assert.NoError(t, users.Register(models.User{Username: "user1"}))
+	files := repository.NewFileRepository(filepath.Join(dir, repository.FilesFileName))
+	return repository.NewFileFolderRepository(filepath.Join(dir, repository.FoldersFileName), users, files), files
+}
+
+// newMemoryRepositories creates the in-memory folder and file repositories in which only user1 is registered
+func newMemoryRepositories(t *testing.T) (*repository.MemoryFolderRepository, *repository.MemoryFileRepository) {
+	users := repository.NewMemoryUserRepository(models.CasePreserving)
+	assert.NoError(t, users.Register(models.User{Username: "user1"}))
+	files := repository.NewMemoryFileRepository()
+	return repository.NewMemoryFolderRepository(users, files), files
+}
+
+// TestFolderRepositoryConcurrency tests that concurrent mutations of every folder repository never overwrite each other
+func TestFolderRepositoryConcurrency(t *testing.T) {
+	tests := []struct {
+		name     string
+		testFunc func(t *testing.T, repo models.FolderRepository)
+	}{
+		{
+			name: "ConcurrentCreatesOfDistinctFolders",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				var wg sync.WaitGroup
+				for i := 0; i < stressWorkers; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						assert.NoError(t, repo.CreateFolder(newFolder("/", fmt.Sprintf("folder%d", i))))
+					}(i)
+				}
+				wg.Wait()
+
+				folders, err := repo.ListFolders("user1", "/", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, folders, stressWorkers)
+			},
+		},
+		{
+			name: "ConcurrentCreatesOfTheSameFolder",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				var wg sync.WaitGroup
+				var created int32
+				for i := 0; i < stressWorkers; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if repo.CreateFolder(newFolder("/", "folder1")) == nil {
+							atomic.AddInt32(&created, 1)
+						}
+					}()
+				}
+				wg.Wait()
+
+				assert.Equal(t, int32(1), created)
+				folders, err := repo.ListFolders("user1", "/", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, folders, 1)
+			},
+		},
+		{
+			name: "ConcurrentRenamesToTheSameName",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				for i := 0; i < stressWorkers; i++ {
+					assert.NoError(t, repo.CreateFolder(newFolder("/", fmt.Sprintf("folder%d", i))))
+				}
+
+				var wg sync.WaitGroup
+				var renamed int32
+				for i := 0; i < stressWorkers; i++ {
+					wg.Add(1)
+					go func(i int) {
+						defer wg.Done()
+						if err := repo.RenameFolder("user1", fmt.Sprintf("/folder%d", i), "target"); err == nil {
+							atomic.AddInt32(&renamed, 1)
+						}
+					}(i)
+				}
+				wg.Wait()
+
+				assert.Equal(t, int32(1), renamed)
+				folders, err := repo.ListFolders("user1", "/", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, folders, stressWorkers)
+			},
+		},
+		{
+			name: "ConcurrentMixedMutations",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				for i := 0; i < stressWorkers; i++ {
+					assert.NoError(t, repo.CreateFolder(newFolder("/", fmt.Sprintf("old%d", i))))
+				}
+
+				var wg sync.WaitGroup
+				for i := 0; i < stressWorkers; i++ {
+					wg.Add(4)
+					go func(i int) {
+						defer wg.Done()
+						_, err := repo.DeleteFolder("user1", fmt.Sprintf("/old%d", i), false)
+						assert.NoError(t, err)
+					}(i)
+					go func(i int) {
+						defer wg.Done()
+						assert.NoError(t, repo.CreateFolder(newFolder("/", fmt.Sprintf("new%d", i))))
+					}(i)
+					go func(i int) {
+						defer wg.Done()
+						_, err := repo.Exists("user1", fmt.Sprintf("/old%d", i))
+						assert.NoError(t, err)
+					}(i)
+					go func() {
+						defer wg.Done()
+						_, err := repo.ListFolders("user1", "/", "--sort-created", "desc")
+						assert.NoError(t, err)
+					}()
+				}
+				wg.Wait()
+
+				folders, err := repo.ListFolders("user1", "/", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, folders, stressWorkers)
+				for _, f := range folders {
+					assert.Regexp(t, "^new", f.Name)
+				}
+			},
+		},
+	}
+
+	for implementation, newRepository := range folderRepositories {
+		for _, tt := range tests {
+			t.Run(implementation+"/"+tt.name, func(t *testing.T) {
+				tt.testFunc(t, newRepository(t))
+			})
+		}
+	}
+}
+
+// newFolder returns a folder of user1 inside parentPath
+func newFolder(parentPath, name string) models.Folder {
+	return models.Folder{Username: "user1", ParentPath: parentPath, Name: name, CreatedAt: time.Now()}
+}
+
+// TestFolderRepositoryHierarchy tests that every folder repository keeps nested folders consistent
+func TestFolderRepositoryHierarchy(t *testing.T) {
+	tests := []struct {
+		name     string
+		testFunc func(t *testing.T, repo models.FolderRepository)
+	}{
+		{
+			name: "RenameMovesNestedFolders",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				assert.NoError(t, repo.RenameFolder("user1", "/projects", "archive"))
+
+				exists, err := repo.Exists("user1", "/archive/2024/q3")
+				assert.NoError(t, err)
+				assert.True(t, exists)
+				exists, err = repo.Exists("user1", "/projects/2024")
+				assert.NoError(t, err)
+				assert.False(t, exists)
+
+				folders, err := repo.ListFolders("user1", "/archive/2024", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, folders, 1)
+				assert.Equal(t, "/archive/2024/q3", folders[0].Path())
+			},
+		},
+		{
+			name: "DeleteRemovesNestedFolders",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				_, err := repo.DeleteFolder("user1", "/projects/2024", true)
+				assert.NoError(t, err)
+
+				exists, err := repo.Exists("user1", "/projects/2024/q3")
+				assert.NoError(t, err)
+				assert.False(t, exists)
+				exists, err = repo.Exists("user1", "/projects")
+				assert.NoError(t, err)
+				assert.True(t, exists)
+			},
+		},
+		{
+			name: "PathsMatchCaseInsensitively",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				exists, err := repo.Exists("user1", "/Projects/2024/Q3")
+				assert.NoError(t, err)
+				assert.True(t, exists)
+				assert.Error(t, repo.CreateFolder(newFolder("/PROJECTS", "2024")))
+			},
+		},
+		{
+			name: "ErrorsAreMatchable",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				err := repo.CreateFolder(newFolder("/projects", "2024"))
+				assert.ErrorIs(t, err, customErrors.ErrConflict)
+				assert.Equal(t, customErrors.CodeFolderExists, customErrors.CodeOf(err))
+
+				_, err = repo.DeleteFolder("user1", "/missing", true)
+				var notFound *customErrors.NotFoundError
+				if assert.ErrorAs(t, err, &notFound) {
+					assert.Equal(t, customErrors.KindFolder, notFound.Kind)
+					assert.Equal(t, "/missing", notFound.Name)
+				}
+			},
+		},
+		{
+			name: "UpdateReplacesDescription",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				folder := newFolder("/projects", "2024")
+				folder.Description = "quarterly reports"
+				assert.NoError(t, repo.UpdateFolder(folder))
+
+				folders, err := repo.ListFolders("user1", "/projects", "", "")
+				assert.NoError(t, err)
+				if assert.Len(t, folders, 1) {
+					assert.Equal(t, "quarterly reports", folders[0].Description)
+				}
+				assert.ErrorIs(t, repo.UpdateFolder(newFolder("/", "missing")), customErrors.ErrNotFound)
+			},
+		},
+		{
+			name: "ListEmptyFolder",
+			testFunc: func(t *testing.T, repo models.FolderRepository) {
+				folders, err := repo.ListFolders("user1", "/projects/2024/q3", "", "")
+				assert.NoError(t, err)
+				assert.Empty(t, folders)
+			},
+		},
+	}
+
+	for implementation, newRepository := range folderRepositories {
+		for _, tt := range tests {
+			t.Run(implementation+"/"+tt.name, func(t *testing.T) {
+				repo := newRepository(t)
+				assert.NoError(t, repo.CreateFolder(newFolder("/", "projects")))
+				assert.NoError(t, repo.CreateFolder(newFolder("/projects", "2024")))
+				assert.NoError(t, repo.CreateFolder(newFolder("/projects/2024", "q3")))
+				tt.testFunc(t, repo)
+			})
+		}
+	}
+}
+
+// TestFolderRepositoryDeletion tests that every folder repository deletes or moves the files of a deleted or renamed
+// folder with it
+func TestFolderRepositoryDeletion(t *testing.T) {
+	tests := []struct {
+		name     string
+		testFunc func(t *testing.T, folderRepo models.FolderRepository, fileRepo models.FileRepository)
+	}{
+		{
+			name: "RefuseNonEmptyFolder",
+			testFunc: func(t *testing.T, folderRepo models.FolderRepository, fileRepo models.FileRepository) {
+				_, err := folderRepo.DeleteFolder("user1", "/projects", false)
+				assert.ErrorIs(t, err, customErrors.ErrConflict)
+				assert.Equal(t, customErrors.CodeFolderNotEmpty, customErrors.CodeOf(err))
+
+				exists, err := folderRepo.Exists("user1", "/projects/2024")
+				assert.NoError(t, err)
+				assert.True(t, exists)
+			},
+		},
+		{
+			name: "RecursiveDeleteRemovesFiles",
+			testFunc: func(t *testing.T, folderRepo models.FolderRepository, fileRepo models.FileRepository) {
+				deleted, err := folderRepo.DeleteFolder("user1", "/projects", true)
+				assert.NoError(t, err)
+				assert.Len(t, deleted, 2)
+
+				_, err = fileRepo.GetFile("user1", "/projects/2024", "report")
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+				files, err := fileRepo.ListFiles("user1", "/", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, files, 1)
+			},
+		},
+		{
+			name: "RecreatedFolderIsEmpty",
+			testFunc: func(t *testing.T, folderRepo models.FolderRepository, fileRepo models.FileRepository) {
+				_, err := folderRepo.DeleteFolder("user1", "/projects", true)
+				assert.NoError(t, err)
+				assert.NoError(t, folderRepo.CreateFolder(newFolder("/", "projects")))
+
+				files, err := fileRepo.ListFiles("user1", "/projects", "", "")
+				assert.NoError(t, err)
+				assert.Empty(t, files)
+			},
+		},
+		{
+			name: "RenameKeepsIDs",
+			testFunc: func(t *testing.T, folderRepo models.FolderRepository, fileRepo models.FileRepository) {
+				folder, err := folderRepo.GetFolder("user1", "/projects/2024")
+				assert.NoError(t, err)
+				report, err := fileRepo.GetFile("user1", "/projects/2024", "report")
+				assert.NoError(t, err)
+				assert.NoError(t, folderRepo.RenameFolder("user1", "/projects", "archive"))
+
+				renamed, err := folderRepo.GetFolder("user1", "/archive/2024")
+				assert.NoError(t, err)
+				assert.Equal(t, folder.ID, renamed.ID)
+				moved, err := fileRepo.GetFile("user1", "/archive/2024", "report")
+				assert.NoError(t, err)
+				assert.Equal(t, report.ID, moved.ID)
+				assert.Equal(t, "/archive/2024/report", moved.Path())
+				files, err := fileRepo.ListFiles("user1", "/projects", "", "")
+				assert.NoError(t, err)
+				assert.Empty(t, files)
+			},
+		},
+		{
+			name: "IDsAreUniqueAndNeverReused",
+			testFunc: func(t *testing.T, folderRepo models.FolderRepository, fileRepo models.FileRepository) {
+				plan, err := fileRepo.GetFile("user1", "/projects", "plan")
+				assert.NoError(t, err)
+				notes, err := fileRepo.GetFile("user1", "/", "notes")
+				assert.NoError(t, err)
+				assert.NotZero(t, plan.ID)
+				assert.NotEqual(t, plan.ID, notes.ID)
+
+				_, err = folderRepo.DeleteFolder("user1", "/projects", true)
+				assert.NoError(t, err)
+				assert.NoError(t, fileRepo.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "plan"}))
+				recreated, err := fileRepo.GetFile("user1", "/", "plan")
+				assert.NoError(t, err)
+				assert.Greater(t, recreated.ID, notes.ID)
+			},
+		},
+	}
+
+	for implementation, newRepositories := range folderAndFileRepositories {
+		for _, tt := range tests {
+			t.Run(implementation+"/"+tt.name, func(t *testing.T) {
+				folderRepo, fileRepo := newRepositories(t)
+				assert.NoError(t, folderRepo.CreateFolder(newFolder("/", "projects")))
+				assert.NoError(t, folderRepo.CreateFolder(newFolder("/projects", "2024")))
+				for _, file := range []models.File{
+					{Username: "user1", FolderPath: "/projects", Name: "plan"},
+					{Username: "user1", FolderPath: "/projects/2024", Name: "report"},
+					{Username: "user1", FolderPath: "/", Name: "notes"},
+				} {
+					assert.NoError(t, fileRepo.CreateFile(file))
+				}
+				tt.testFunc(t, folderRepo, fileRepo)
+			})
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// repository/folder_tree.go
+
+package repository
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// folderTree indexes folders by their ID, and every folder keeps an index of the names of its children keyed by the
+// case policy, so paths resolve to IDs one element at a time, like directories in a real file system. Folders
+// reference their parent by ID, so renaming a folder only changes its own entry.
+type folderTree struct {
+	folders  map[models.ID]models.Folder
+	children map[folderKey]map[string]models.ID
+	lastID   models.ID
+	policy   models.CasePolicy
+}
+
+// folderKey identifies a folder by its owner and ID. The zero folder ID identifies the root folder of the owner.
+type folderKey struct {
+	userID   models.ID
+	folderID models.ID
+}
+
+// newFolderTree returns a tree holding the given folders, matching their names with the case policy
+func newFolderTree(policy models.CasePolicy, folders ...models.Folder) *folderTree {
+	t := &folderTree{
+		folders:  make(map[models.ID]models.Folder, len(folders)),
+		children: make(map[folderKey]map[string]models.ID),
+		policy:   policy,
+	}
+	for _, folder := range folders {
+		t.insert(folder)
+	}
+	return t
+}
+
+// nextID returns the ID of the next folder added to the tree
+func (t *folderTree) nextID() models.ID {
+	return t.lastID + 1
+}
+
+// insert adds a folder to the indexes. The username and parent path are resolved on every read, so they are not kept.
+func (t *folderTree) insert(folder models.Folder) {
+	folder.Username, folder.ParentPath = "", ""
+	t.folders[folder.ID] = folder
+	if folder.ID > t.lastID {
+		t.lastID = folder.ID
+	}
+
+	parent := folderKey{folder.UserID, folder.ParentID}
+	if t.children[parent] == nil {
+		t.children[parent] = make(map[string]models.ID)
+	}
+	t.children[parent][t.policy.Key(folder.Name)] = folder.ID
+}
+
+// remove deletes a single folder from the indexes
+func (t *folderTree) remove(id models.ID) {
+	folder := t.folders[id]
+	delete(t.folders, id)
+
+	parent := folderKey{folder.UserID, folder.ParentID}
+	delete(t.children[parent], t.policy.Key(folder.Name))
+	if len(t.children[parent]) == 0 {
+		delete(t.children, parent)
+	}
+}
+
+// resolve returns the ID of the folder of the user at folderPath, walking the path from the root one folder at a time.
+// The root path resolves to the zero ID. Folder names are matched with the case policy.
+func (t *folderTree) resolve(userID models.ID, folderPath string) (models.ID, bool) {
+	var id models.ID
+	for _, name := range models.PathElements(folderPath) {
+		child, ok := t.children[folderKey{userID, id}][t.policy.Key(name)]
+		if !ok {
+			return 0, false
+		}
+		id = child
+	}
+	return id, true
+}
+
+// child returns the ID of the folder named name inside the folder with the given ID
+func (t *folderTree) child(userID, parentID models.ID, name string) (models.ID, bool) {
+	id, ok := t.children[folderKey{userID, parentID}][t.policy.Key(name)]
+	return id, ok
+}
+
+// path returns the path of the folder with the given ID, walking up to the root
+func (t *folderTree) path(id models.ID) string {
+	var names []string
+	for id != 0 {
+		folder := t.folders[id]
+		names = append(names, folder.Name)
+		id = folder.ParentID
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return models.RootPath + strings.Join(names, "/")
+}
+
+// get returns the folder with the given ID, with the username of its owner and the path of its parent filled in
+func (t *folderTree) get(id models.ID, user models.User) models.Folder {
+	folder := t.folders[id]
+	folder.Username = user.Username
+	folder.ParentPath = t.path(folder.ParentID)
+	folder.Shares = slices.Clone(folder.Shares)
+	return folder
+}
+
+// list returns the folders directly inside the folder with the given ID
+func (t *folderTree) list(user models.User, parentID models.ID) []models.Folder {
+	var folders []models.Folder
+	for _, id := range t.children[folderKey{user.ID, parentID}] {
+		folders = append(folders, t.get(id, user))
+	}
+	return folders
+}
+
+// subtree returns the IDs of a folder and of all the folders nested inside it
+func (t *folderTree) subtree(id models.ID) []models.ID {
+	return t.walk(t.folders[id].UserID, id)
+}
+
+// owned returns the IDs of all the folders of the user, starting with the zero ID of the root folder
+func (t *folderTree) owned(userID models.ID) []models.ID {
+	return t.walk(userID, 0)
+}
+
+// walk returns the ID of a folder of the user and the IDs of all the folders nested inside it, outermost first
+func (t *folderTree) walk(userID, id models.ID) []models.ID {
+	ids := []models.ID{id}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range t.children[folderKey{userID, ids[i]}] {
+			ids = append(ids, child)
+		}
+	}
+	return ids
+}
+
+// handOver gives the folders the user owns in the trees of other users to the users whose trees hold them
+func (t *folderTree) handOver(ownerID models.ID) {
+	for id, folder := range t.folders {
+		if folder.OwnerID == ownerID && folder.UserID != ownerID {
+			folder.OwnerID = folder.UserID
+			t.folders[id] = folder
+		}
+	}
+}
+
+// revoke removes the user from the access control lists of all the folders
+func (t *folderTree) revoke(userID models.ID) {
+	for id, folder := range t.folders {
+		if folder.SharedWith(userID) != "" {
+			folder.Shares = folder.Unshare(userID)
+			t.folders[id] = folder
+		}
+	}
+}
+
+// shared returns the folders shared with the user and the folders of the user shared with others, ordered by ID.
+// users resolves the usernames of the trees that hold the folders.
+func (t *folderTree) shared(user models.User, users []models.User) []models.Folder {
+	usersByID := make(map[models.ID]models.User, len(users))
+	for _, u := range users {
+		usersByID[u.ID] = u
+	}
+
+	var folders []models.Folder
+	for _, folder := range t.all() {
+		if folder.SharedWith(user.ID) != "" || (folder.UserID == user.ID && len(folder.Shares) > 0) {
+			folders = append(folders, t.get(folder.ID, usersByID[folder.UserID]))
+		}
+	}
+	return folders
+}
+
+// all returns every folder of the tree ordered by ID
+func (t *folderTree) all() []models.Folder {
+	folders := make([]models.Folder, 0, len(t.folders))
+	for _, folder := range t.folders {
+		folders = append(folders, folder)
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].ID < folders[j].ID })
+	return folders
+}
+
+// resolveFile returns the file with the username of its owner and the path of its folder filled in
+func (t *folderTree) resolveFile(file models.File, user models.User) models.File {
+	file.Username = user.Username
+	file.FolderPath = t.path(file.FolderID)
+	return file
+}
@@ -0,0 +1,63 @@
+// repository/ids.go
+
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// Kinds of entities the file-based repositories hand out IDs for
+const (
+	userIDs   = "users"
+	folderIDs = "folders"
+	fileIDs   = "files"
+	trashIDs  = "trash"
+)
+
+// idsMu guards the ID files of every data directory. The repositories of a data directory share its ID file, so
+// their own locks aren't enough.
+var idsMu sync.Mutex
+
+// nextID hands out the next ID of the kind from the ID file inside dir, which holds a JSON object with the last ID
+// handed out of every kind. The ID of a deleted entity is thereby never handed out again, even once it is no longer the
+// highest stored ID. highest is the highest ID of the kind that is stored, which the next ID exceeds even if the ID file
+// is missing, e.g. in a store written before the file was kept.
+// The ID file is written before the entity, so a crash in between skips an ID but never hands it out twice.
+func nextID(dir, kind string, highest models.ID) (models.ID, error) {
+	idsMu.Lock()
+	defer idsMu.Unlock()
+
+	filePath := filepath.Join(dir, IDsFileName)
+	ids, err := loadIDs(filePath)
+	if err != nil {
+		return 0, err
+	}
+	id := max(ids[kind], highest) + 1
+	ids[kind] = id
+
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return 0, err
+	}
+	return id, writeFileAtomic(filePath, data, 0644)
+}
+
+// loadIDs reads the last ID handed out of every kind from the ID file
+func loadIDs(filePath string) (map[string]models.ID, error) {
+	ids := make(map[string]models.ID)
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return ids, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,180 @@
+// repository/memory_blob_repository.go
+
+package repository
+
+import (
+	"io"
+	"sync"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// memoryBlob is a blob kept in memory as the list of its chunks, together with its size and the number of its
+// references
+type memoryBlob struct {
+	chunks []string
+	size   int64
+	refs   int
+}
+
+// MemoryBlobRepository handles the repository logic for file contents in memory, keyed by their hash. The data of
+// every blob is split into chunks, see ChunkSize, which are kept once however many blobs share them.
+type MemoryBlobRepository struct {
+	blobs  map[string]*memoryBlob
+	chunks map[string][]byte
+	held   heldChunks
+	mu     sync.RWMutex // ensures thread-safe access to the maps
+}
+
+// NewMemoryBlobRepository creates a new instance of MemoryBlobRepository
+func NewMemoryBlobRepository() *MemoryBlobRepository {
+	return &MemoryBlobRepository{
+		blobs:  make(map[string]*memoryBlob),
+		chunks: make(map[string][]byte),
+		held:   make(heldChunks),
+	}
+}
+
+// PutBlob stores the data unless an equal blob is already stored, adds a reference to the blob and returns its hash
+func (r *MemoryBlobRepository) PutBlob(data []byte) (string, error) {
+	return putBlob(r, data)
+}
+
+// GetBlob returns a copy of the data of the blob, once it is verified against the hash
+func (r *MemoryBlobRepository) GetBlob(hash string) ([]byte, error) {
+	reader, err := r.openBlob(hash)
+	if err != nil {
+		return nil, err
+	}
+	return readBlob(reader)
+}
+
+// OpenBlob returns a reader of the data of the blob, which verifies every chunk as it is read
+func (r *MemoryBlobRepository) OpenBlob(hash string) (io.ReadSeekCloser, error) {
+	return r.openBlob(hash)
+}
+
+// openBlob returns a reader of the blob holding its chunks
+func (r *MemoryBlobRepository) openBlob(hash string) (*blobReader, error) {
+	if hash == "" {
+		return emptyBlob(r), nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	blob, ok := r.blobs[hash]
+	if !ok {
+		return nil, customErrors.ErrBlobNotFound(hash)
+	}
+	r.held.hold(blob.chunks...)
+	return newBlobReader(r, hash, blob.size, blob.chunks), nil
+}
+
+// CreateBlob returns a writer that stores a new blob once it is committed
+func (r *MemoryBlobRepository) CreateBlob() models.BlobWriter {
+	return newBlobWriter(r)
+}
+
+// putChunk stores a copy of the data as a chunk unless an equal chunk is already stored and holds it
+func (r *MemoryBlobRepository) putChunk(data []byte) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash := models.HashContent(data)
+	if _, ok := r.chunks[hash]; !ok {
+		r.chunks[hash] = append([]byte{}, data...)
+	}
+	r.held.hold(hash)
+	return hash, nil
+}
+
+// getChunk returns a copy of the data of the chunk
+func (r *MemoryBlobRepository) getChunk(hash string) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	data, ok := r.chunks[hash]
+	if !ok {
+		return nil, customErrors.ErrBlobNotFound(hash)
+	}
+	return append([]byte{}, data...), nil
+}
+
+// commitBlob stores the blob made of the chunks unless it is already stored, and adds a reference to it
+func (r *MemoryBlobRepository) commitBlob(hash string, size int64, chunks []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	blob, ok := r.blobs[hash]
+	if !ok {
+		blob = &memoryBlob{chunks: chunks, size: size}
+		r.blobs[hash] = blob
+	}
+	blob.refs++
+	return nil
+}
+
+// releaseChunks stops holding the chunks
+func (r *MemoryBlobRepository) releaseChunks(chunks []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.held.release(chunks)
+}
+
+// RetainBlob adds a reference to the blob
+func (r *MemoryBlobRepository) RetainBlob(hash string) error {
+	if hash == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	blob, ok := r.blobs[hash]
+	if !ok {
+		return customErrors.ErrBlobNotFound(hash)
+	}
+	blob.refs++
+	return nil
+}
+
+// ReleaseBlob removes a reference to the blob. Releasing a blob that is gone changes nothing.
+func (r *MemoryBlobRepository) ReleaseBlob(hash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if blob, ok := r.blobs[hash]; ok && blob.refs > 0 {
+		blob.refs--
+	}
+	return nil
+}
+
+// CollectGarbage removes the blobs without references, then the chunks that no blob lists and nobody holds, and
+// returns how many blobs were removed
+func (r *MemoryBlobRepository) CollectGarbage() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removed := 0
+	for hash, blob := range r.blobs {
+		if blob.refs <= 0 {
+			delete(r.blobs, hash)
+			removed++
+		}
+	}
+	listed := make(map[string]bool, len(r.chunks))
+	for _, blob := range r.blobs {
+		for _, chunk := range blob.chunks {
+			listed[chunk] = true
+		}
+	}
+	for hash := range r.chunks {
+		if !listed[hash] && r.held[hash] == 0 {
+			delete(r.chunks, hash)
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,266 @@
+// repository/memory_file_repository.go
+
+package repository
+
+import (
+	"sync"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// MemoryFileRepository handles the repository logic for files in memory.
+// Files are indexed by their ID, and every folder keeps an index of the names of its files keyed by the case policy of
+// the user repository, so lookups take constant time and listings only visit the files inside the listed folder. Files reference their folder by ID, and folder paths
+// are resolved through the folder repository the file repository is attached to by NewMemoryFolderRepository.
+type MemoryFileRepository struct {
+	files    map[models.ID]models.File
+	byFolder map[folderKey]map[string]models.ID
+	lastID   models.ID
+	folders  *MemoryFolderRepository
+	mu       sync.RWMutex // ensures thread-safe access to the maps
+}
+
+// NewMemoryFileRepository creates a new instance of MemoryFileRepository
+func NewMemoryFileRepository() *MemoryFileRepository {
+	return &MemoryFileRepository{
+		files:    make(map[models.ID]models.File),
+		byFolder: make(map[folderKey]map[string]models.ID),
+	}
+}
+
+// CreateFile adds a new file to the repository and assigns it the next ID
+func (r *MemoryFileRepository) CreateFile(file models.File) error {
+	user, err := r.folders.users.GetUser(file.Username)
+	if err != nil {
+		return err
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	folderID, ok := r.folders.tree.resolve(user.ID, file.FolderPath)
+	if !ok {
+		return customErrors.ErrFolderNotFound(models.CleanPath(file.FolderPath))
+	}
+	if _, ok := r.byFolder[folderKey{user.ID, folderID}][r.key(file.Name)]; ok {
+		return customErrors.ErrFileExists(file.Name)
+	}
+
+	r.lastID++
+	file.ID, file.UserID, file.FolderID = r.lastID, user.ID, folderID
+	file.Name = r.folders.users.policy.Normalize(file.Name)
+	if file.OwnerID == 0 {
+		file.OwnerID = user.ID
+	}
+	r.insert(file)
+	return nil
+}
+
+// insert adds the file to the maps. The username and folder path are resolved on every read, so they are not kept.
+// The caller must hold r.mu.
+func (r *MemoryFileRepository) insert(file models.File) {
+	file.Username, file.FolderPath = "", ""
+	r.files[file.ID] = file
+
+	folder := folderKey{file.UserID, file.FolderID}
+	if r.byFolder[folder] == nil {
+		r.byFolder[folder] = make(map[string]models.ID)
+	}
+	r.byFolder[folder][r.key(file.Name)] = file.ID
+}
+
+// key returns the key under which a file name is indexed
+func (r *MemoryFileRepository) key(name string) string {
+	return r.folders.users.policy.Key(name)
+}
+
+// remove deletes the file with the given ID from the maps. The caller must hold r.mu.
+func (r *MemoryFileRepository) remove(id models.ID) {
+	file := r.files[id]
+	delete(r.files, id)
+
+	folder := folderKey{file.UserID, file.FolderID}
+	delete(r.byFolder[folder], r.key(file.Name))
+	if len(r.byFolder[folder]) == 0 {
+		delete(r.byFolder, folder)
+	}
+}
+
+// lookup resolves the folder path and the name of a file of the user to the ID of the file, and returns false if there
+// is no such file. The caller must hold r.folders.mu and r.mu.
+func (r *MemoryFileRepository) lookup(user models.User, folderPath, fileName string) (models.ID, bool) {
+	folderID, ok := r.folders.tree.resolve(user.ID, folderPath)
+	if !ok {
+		return 0, false
+	}
+	id, ok := r.byFolder[folderKey{user.ID, folderID}][r.key(fileName)]
+	return id, ok
+}
+
+// GetFile returns a single file of the repository
+func (r *MemoryFileRepository) GetFile(username, folderPath, fileName string) (models.File, error) {
+	user, err := r.folders.users.GetUser(username)
+	if err != nil {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.lookup(user, folderPath, fileName)
+	if !ok {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+	return r.folders.tree.resolveFile(r.files[id], user), nil
+}
+
+// UpdateFile replaces the description, size, content hash and modification time of an existing file
+func (r *MemoryFileRepository) UpdateFile(file models.File) error {
+	user, err := r.folders.users.GetUser(file.Username)
+	if err != nil {
+		return customErrors.ErrFileNotFound(file.Name)
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.lookup(user, file.FolderPath, file.Name)
+	if !ok {
+		return customErrors.ErrFileNotFound(file.Name)
+	}
+
+	stored := r.files[id]
+	stored.Description = file.Description
+	stored.Size = file.Size
+	stored.ContentHash = file.ContentHash
+	stored.ModifiedAt = file.ModifiedAt
+	stored.Permissions = file.Permissions
+	r.files[id] = stored
+	return nil
+}
+
+// DeleteFile removes a file from the repository
+func (r *MemoryFileRepository) DeleteFile(username, folderPath, fileName string) error {
+	user, err := r.folders.users.GetUser(username)
+	if err != nil {
+		return customErrors.ErrFileNotFound(fileName)
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.lookup(user, folderPath, fileName)
+	if !ok {
+		return customErrors.ErrFileNotFound(fileName)
+	}
+
+	r.remove(id)
+	return nil
+}
+
+// within returns the IDs of the files of the user inside the folders with the given IDs. The caller must hold r.mu.
+func (r *MemoryFileRepository) within(userID models.ID, folderIDs []models.ID) []models.ID {
+	var ids []models.ID
+	for _, folderID := range folderIDs {
+		for _, id := range r.byFolder[folderKey{userID, folderID}] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// MoveFile moves a file to newFolderPath under newFileName, keeping its ID, description, size and times.
+// An existing file at the destination is replaced if overwrite is set, otherwise ErrFileExists is returned.
+func (r *MemoryFileRepository) MoveFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) error {
+	_, err := r.relocateFile(username, folderPath, fileName, newFolderPath, newFileName, overwrite, false)
+	return err
+}
+
+// CopyFile copies a file to newFolderPath under newFileName, keeping its description, size and times, and returns
+// the copy, which gets an ID of its own.
+// An existing file at the destination is replaced if overwrite is set, otherwise ErrFileExists is returned.
+func (r *MemoryFileRepository) CopyFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) (models.File, error) {
+	return r.relocateFile(username, folderPath, fileName, newFolderPath, newFileName, overwrite, true)
+}
+
+// relocateFile moves or copies a file in a single critical section and returns the relocated file
+func (r *MemoryFileRepository) relocateFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite, keepSource bool) (models.File, error) {
+	user, err := r.folders.users.GetUser(username)
+	if err != nil {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	source, ok := r.lookup(user, folderPath, fileName)
+	if !ok {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+	folderID, ok := r.folders.tree.resolve(user.ID, newFolderPath)
+	if !ok {
+		return models.File{}, customErrors.ErrFolderNotFound(models.CleanPath(newFolderPath))
+	}
+
+	file := r.files[source]
+	if target, ok := r.byFolder[folderKey{user.ID, folderID}][r.key(newFileName)]; ok {
+		switch {
+		case target == source && keepSource:
+			return models.File{}, customErrors.ErrFileExists(newFileName) // a file can't be copied onto itself
+		case target == source:
+			// Moving a file onto itself at most changes the case of its name
+		case !overwrite:
+			return models.File{}, customErrors.ErrFileExists(newFileName)
+		default:
+			r.remove(target)
+		}
+	}
+
+	if keepSource {
+		r.lastID++
+		file.ID = r.lastID
+	} else {
+		r.remove(source)
+	}
+	file.FolderID = folderID
+	file.Name = r.folders.users.policy.Normalize(newFileName)
+	r.insert(file)
+	return r.folders.tree.resolveFile(file, user), nil
+}
+
+// ListFiles returns a slice of files sorted based on the specified field and order.
+func (r *MemoryFileRepository) ListFiles(username, folderPath, sortField, sortOrder string) ([]models.File, error) {
+	user, err := r.folders.users.GetUser(username)
+	if err != nil {
+		return nil, nil
+	}
+
+	r.folders.mu.RLock()
+	defer r.folders.mu.RUnlock()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	folderID, ok := r.folders.tree.resolve(user.ID, folderPath)
+	if !ok {
+		return nil, nil
+	}
+
+	var files []models.File
+	for _, id := range r.byFolder[folderKey{user.ID, folderID}] {
+		files = append(files, r.folders.tree.resolveFile(r.files[id], user))
+	}
+
+	sortFiles(files, sortField, sortOrder)
+	return files, nil
+}
@@ -0,0 +1,247 @@
+// repository/memory_folder_repository.go
+
+package repository
+
+import (
+	"slices"
+	"sync"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// MemoryFolderRepository handles the repository logic for folders in memory.
+// Folders are kept in a folder tree indexed by ID, so paths resolve to IDs in a constant time per path element and
+// listings only visit the folders inside the listed parent.
+// Deleting a folder also deletes the files inside it, so the repository works together with a file repository.
+type MemoryFolderRepository struct {
+	tree  *folderTree
+	users *MemoryUserRepository
+	files *MemoryFileRepository
+	mu    sync.RWMutex // ensures thread-safe access to the tree
+}
+
+// NewMemoryFolderRepository creates a new instance of MemoryFolderRepository that resolves usernames through users and
+// keeps the files of its folders in files. The file repository resolves folder paths through the new repository, and
+// the user repository deletes the folders of deleted users through it.
+func NewMemoryFolderRepository(users *MemoryUserRepository, files *MemoryFileRepository) *MemoryFolderRepository {
+	r := &MemoryFolderRepository{
+		tree:  newFolderTree(users.policy),
+		users: users,
+		files: files,
+	}
+	files.folders = r
+	users.folders = r
+	return r
+}
+
+// Exists checks if a folder already exists for a user
+func (r *MemoryFolderRepository) Exists(userName, folderPath string) (bool, error) {
+	user, err := r.users.GetUser(userName)
+	if err != nil {
+		return false, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.tree.resolve(user.ID, folderPath)
+	return ok && id != 0, nil
+}
+
+// GetFolder returns the folder of the user at folderPath
+func (r *MemoryFolderRepository) GetFolder(username, folderPath string) (models.Folder, error) {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return models.Folder{}, customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.tree.resolve(user.ID, folderPath)
+	if !ok || id == 0 {
+		return models.Folder{}, customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
+	return r.tree.get(id, user), nil
+}
+
+// CreateFolder adds a new folder to the repository and assigns it the next ID
+func (r *MemoryFolderRepository) CreateFolder(folder models.Folder) error {
+	user, err := r.users.GetUser(folder.Username)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	parentID, ok := r.tree.resolve(user.ID, folder.ParentPath)
+	if !ok {
+		return customErrors.ErrFolderNotFound(models.CleanPath(folder.ParentPath))
+	}
+	if _, ok := r.tree.child(user.ID, parentID, folder.Name); ok {
+		return customErrors.ErrFolderExists(folder.Path())
+	}
+
+	folder.ID, folder.UserID, folder.ParentID = r.tree.nextID(), user.ID, parentID
+	folder.Name = r.tree.policy.Normalize(folder.Name)
+	if folder.OwnerID == 0 {
+		folder.OwnerID = user.ID
+	}
+	r.tree.insert(folder)
+	return nil
+}
+
+// DeleteFolder deletes a folder together with all the folders nested inside it and the files inside them, and returns
+// the deleted files. Unless recursive is set, a folder that still holds folders or files is not deleted.
+func (r *MemoryFolderRepository) DeleteFolder(username, folderPath string, recursive bool) ([]models.File, error) {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return nil, customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files.mu.Lock()
+	defer r.files.mu.Unlock()
+
+	id, ok := r.tree.resolve(user.ID, folderPath)
+	if !ok || id == 0 {
+		return nil, customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
+
+	subtree := r.tree.subtree(id)
+	files := r.files.within(user.ID, subtree)
+	if !recursive && (len(subtree) > 1 || len(files) > 0) {
+		return nil, customErrors.ErrFolderNotEmpty(r.tree.path(id))
+	}
+
+	var deleted []models.File
+	for _, fileID := range files {
+		deleted = append(deleted, r.tree.resolveFile(r.files.files[fileID], user))
+		r.files.remove(fileID)
+	}
+	for _, folderID := range subtree {
+		r.tree.remove(folderID)
+	}
+	return deleted, nil
+}
+
+// deleteOwned deletes all the folders and files of the user and returns the deleted files. The folders and files the
+// user owns in other trees are handed over. The caller must hold the lock of the user repository.
+func (r *MemoryFolderRepository) deleteOwned(user models.User) []models.File {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files.mu.Lock()
+	defer r.files.mu.Unlock()
+
+	owned := r.tree.owned(user.ID)
+	var deleted []models.File
+	for _, fileID := range r.files.within(user.ID, owned) {
+		deleted = append(deleted, r.tree.resolveFile(r.files.files[fileID], user))
+		r.files.remove(fileID)
+	}
+	for _, folderID := range owned[1:] {
+		r.tree.remove(folderID)
+	}
+	r.tree.handOver(user.ID)
+	r.tree.revoke(user.ID)
+	for id, file := range r.files.files {
+		if file.OwnerID == user.ID {
+			file.OwnerID = file.UserID
+			r.files.files[id] = file
+		}
+	}
+	return deleted
+}
+
+// RenameFolder renames a folder. The folders nested inside it and the files inside them reference it by ID, so they
+// move along without being updated.
+func (r *MemoryFolderRepository) RenameFolder(username, folderPath, newFolderName string) error {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.tree.resolve(user.ID, folderPath)
+	if !ok || id == 0 {
+		return customErrors.ErrFolderNotFound(models.CleanPath(folderPath))
+	}
+
+	// Check if new name already exists
+	folder := r.tree.folders[id]
+	if existing, ok := r.tree.child(user.ID, folder.ParentID, newFolderName); ok && existing != id {
+		return customErrors.ErrFolderExists(models.JoinPath(r.tree.path(folder.ParentID), newFolderName))
+	}
+
+	r.tree.remove(id)
+	folder.Name = r.tree.policy.Normalize(newFolderName)
+	r.tree.insert(folder)
+	return nil
+}
+
+// UpdateFolder replaces the description, permissions and access control list of an existing folder
+func (r *MemoryFolderRepository) UpdateFolder(folder models.Folder) error {
+	user, err := r.users.GetUser(folder.Username)
+	if err != nil {
+		return customErrors.ErrFolderNotFound(folder.Path())
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.tree.resolve(user.ID, folder.Path())
+	if !ok || id == 0 {
+		return customErrors.ErrFolderNotFound(folder.Path())
+	}
+
+	stored := r.tree.folders[id]
+	stored.Description = folder.Description
+	stored.Permissions = folder.Permissions
+	stored.Shares = slices.Clone(folder.Shares)
+	r.tree.folders[id] = stored
+	return nil
+}
+
+// ListFolders returns a slice of the folders directly inside parentPath, sorted based on the specified field and order.
+// The slice is empty if parentPath has no subfolders.
+func (r *MemoryFolderRepository) ListFolders(username, parentPath, sortField, sortOrder string) ([]models.Folder, error) {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return nil, nil
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	parentID, ok := r.tree.resolve(user.ID, parentPath)
+	if !ok {
+		return nil, nil
+	}
+
+	userFolders := r.tree.list(user, parentID)
+	sortFolders(userFolders, sortField, sortOrder)
+	return userFolders, nil
+}
+
+// ListShared returns the folders other users share with the user and the folders the user shares with others, ordered
+// by ID
+func (r *MemoryFolderRepository) ListShared(username string) ([]models.Folder, error) {
+	user, err := r.users.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	users, err := r.users.ListUsers("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.tree.shared(user, users), nil
+}
@@ -0,0 +1,94 @@
+// repository/memory_trash_repository.go
+
+package repository
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// MemoryTrashRepository handles the repository logic for the trash of every user in memory
+type MemoryTrashRepository struct {
+	entries map[models.ID]models.TrashEntry
+	lastID  models.ID
+	mu      sync.RWMutex // ensures thread-safe access to the map
+}
+
+// NewMemoryTrashRepository creates a new instance of MemoryTrashRepository
+func NewMemoryTrashRepository() *MemoryTrashRepository {
+	return &MemoryTrashRepository{
+		entries: make(map[models.ID]models.TrashEntry),
+	}
+}
+
+// AddTrash adds an entry to the trash of its user, assigns it the next ID and returns it
+func (r *MemoryTrashRepository) AddTrash(entry models.TrashEntry) (models.TrashEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	entry.ID = r.lastID
+	r.entries[entry.ID] = cloneTrashEntry(entry)
+	return entry, nil
+}
+
+// GetTrash returns the entry with the ID from the trash of the user
+func (r *MemoryTrashRepository) GetTrash(userID, id models.ID) (models.TrashEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.entries[id]
+	if !ok || entry.UserID != userID {
+		return models.TrashEntry{}, customErrors.ErrTrashNotFound(id.String())
+	}
+	return cloneTrashEntry(entry), nil
+}
+
+// ListTrash returns the entries in the trash of the user, in the order they were deleted
+func (r *MemoryTrashRepository) ListTrash(userID models.ID) ([]models.TrashEntry, error) {
+	return r.list(func(entry models.TrashEntry) bool { return entry.UserID == userID }), nil
+}
+
+// ListExpiredTrash returns the entries of every user deleted before deletedBefore, in the order they were deleted
+func (r *MemoryTrashRepository) ListExpiredTrash(deletedBefore time.Time) ([]models.TrashEntry, error) {
+	return r.list(func(entry models.TrashEntry) bool { return entry.DeletedAt.Before(deletedBefore) }), nil
+}
+
+// list returns the entries matching keep ordered by ID
+func (r *MemoryTrashRepository) list(keep func(models.TrashEntry) bool) []models.TrashEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var entries []models.TrashEntry
+	for _, entry := range r.entries {
+		if keep(entry) {
+			entries = append(entries, cloneTrashEntry(entry))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries
+}
+
+// DeleteTrash permanently removes the entry with the ID from the trash of the user
+func (r *MemoryTrashRepository) DeleteTrash(userID, id models.ID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.entries[id]; !ok || entry.UserID != userID {
+		return customErrors.ErrTrashNotFound(id.String())
+	}
+	delete(r.entries, id)
+	return nil
+}
+
+// cloneTrashEntry returns a copy of the entry that shares no slices with it
+func cloneTrashEntry(entry models.TrashEntry) models.TrashEntry {
+	entry.Folders = slices.Clone(entry.Folders)
+	entry.Files = slices.Clone(entry.Files)
+	return entry
+}
@@ -0,0 +1,154 @@
+// repository/memory_user_repository.go
+
+package repository
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// MemoryUserRepository handles the repository logic for users in memory.
+// Users are indexed by their ID, and the keys of their usernames under the case policy resolve to IDs, so lookups take
+// constant time. The folder and file repositories linked to it match names with the same policy.
+// Deleting a user also deletes its folders and files, so the repository works together with the folder repository
+// attached to it by NewMemoryFolderRepository.
+type MemoryUserRepository struct {
+	users   map[models.ID]models.User
+	byName  map[string]models.ID
+	lastID  models.ID
+	policy  models.CasePolicy
+	folders *MemoryFolderRepository
+	mu      sync.RWMutex // ensures thread-safe access to the maps
+}
+
+// NewMemoryUserRepository creates a new instance of an in-memory user repository that matches names with the policy
+func NewMemoryUserRepository(policy models.CasePolicy) *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users:  make(map[models.ID]models.User),
+		byName: make(map[string]models.ID),
+		policy: policy,
+	}
+}
+
+// Register adds a new user to the repository and assigns it the next ID
+func (r *MemoryUserRepository) Register(user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := r.policy.Key(user.Username)
+	if _, ok := r.byName[key]; ok {
+		return errors.ErrUserExists(user.Username)
+	}
+
+	r.lastID++
+	user.ID = r.lastID
+	user.Username = r.policy.Normalize(user.Username)
+	user.Groups = slices.Clone(user.Groups)
+	user.OwnedGroups = slices.Clone(user.OwnedGroups)
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	r.users[user.ID] = user
+	r.byName[key] = user.ID
+	return nil
+}
+
+// GetUser returns the user registered under the username
+func (r *MemoryUserRepository) GetUser(username string) (models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.byName[r.policy.Key(username)]
+	if !ok {
+		return models.User{}, errors.ErrUserNotExists(username)
+	}
+	return r.users[id], nil
+}
+
+// Exists checks if a username already exists in the repository
+func (r *MemoryUserRepository) Exists(username string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.byName[r.policy.Key(username)]
+	return ok, nil
+}
+
+// ListUsers returns all the users, sorted based on the specified field and order
+func (r *MemoryUserRepository) ListUsers(sortField, sortOrder string) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	sortUsers(users, sortField, sortOrder)
+	return users, nil
+}
+
+// UpdateUser replaces the display name, email, password hash, groups and owned groups of an existing user
+func (r *MemoryUserRepository) UpdateUser(user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.byName[r.policy.Key(user.Username)]
+	if !ok {
+		return errors.ErrUserNotExists(user.Username)
+	}
+
+	stored := r.users[id]
+	stored.DisplayName, stored.Email, stored.PasswordHash = user.DisplayName, user.Email, user.PasswordHash
+	stored.Groups = slices.Clone(user.Groups)
+	stored.OwnedGroups = slices.Clone(user.OwnedGroups)
+	r.users[id] = stored
+	return nil
+}
+
+// RenameUser changes the username of a user. Folders and files reference their user by ID, so they move along without
+// being updated.
+func (r *MemoryUserRepository) RenameUser(username, newUsername string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.byName[r.policy.Key(username)]
+	if !ok {
+		return errors.ErrUserNotExists(username)
+	}
+	newKey := r.policy.Key(newUsername)
+	if existing, ok := r.byName[newKey]; ok && existing != id {
+		return errors.ErrUserExists(newUsername)
+	}
+
+	user := r.users[id]
+	delete(r.byName, r.policy.Key(user.Username))
+	user.Username = r.policy.Normalize(newUsername)
+	r.users[id] = user
+	r.byName[newKey] = id
+	return nil
+}
+
+// DeleteUser deletes a user together with all its folders and files, and returns the deleted files.
+// The folders and files the user owns in the trees of other users are handed over to the users whose trees hold them.
+func (r *MemoryUserRepository) DeleteUser(username string) ([]models.File, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id, ok := r.byName[r.policy.Key(username)]
+	if !ok {
+		return nil, errors.ErrUserNotExists(username)
+	}
+
+	user := r.users[id]
+	var deleted []models.File
+	if r.folders != nil {
+		deleted = r.folders.deleteOwned(user)
+	}
+	delete(r.users, id)
+	delete(r.byName, r.policy.Key(user.Username))
+	return deleted, nil
+}
@@ -0,0 +1,91 @@
+// repository/memory_version_repository.go
+
+package repository
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// MemoryVersionRepository handles the repository logic for the versions of files in memory.
+// The versions of every file are kept ordered by number.
+type MemoryVersionRepository struct {
+	versions map[models.ID][]models.Version
+	mu       sync.RWMutex // ensures thread-safe access to the map
+}
+
+// NewMemoryVersionRepository creates a new instance of MemoryVersionRepository
+func NewMemoryVersionRepository() *MemoryVersionRepository {
+	return &MemoryVersionRepository{
+		versions: make(map[models.ID][]models.Version),
+	}
+}
+
+// AddVersion adds the next version to the history of its file and prunes the versions the policy no longer keeps.
+// It returns the added version and the pruned versions.
+func (r *MemoryVersionRepository) AddVersion(version models.Version, policy models.VersionPolicy) (models.Version, []models.Version, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := r.versions[version.FileID]
+	version.Number = 1
+	if len(history) > 0 {
+		version.Number = history[len(history)-1].Number + 1
+	}
+	history = append(history, version)
+
+	kept, pruned := policy.Prune(history, version.CreatedAt)
+	r.versions[version.FileID] = kept
+	return version, pruned, nil
+}
+
+// PruneVersions prunes the versions of every file the policy no longer keeps at the time now and returns them
+func (r *MemoryVersionRepository) PruneVersions(policy models.VersionPolicy, now time.Time) ([]models.Version, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var pruned []models.Version
+	for fileID, history := range r.versions {
+		kept, prunedVersions := policy.Prune(history, now)
+		if len(prunedVersions) > 0 {
+			r.versions[fileID] = kept
+			pruned = append(pruned, prunedVersions...)
+		}
+	}
+	return pruned, nil
+}
+
+// GetVersion returns the version of the file with the number
+func (r *MemoryVersionRepository) GetVersion(fileID models.ID, number int) (models.Version, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, version := range r.versions[fileID] {
+		if version.Number == number {
+			return version, nil
+		}
+	}
+	return models.Version{}, customErrors.ErrVersionNotFound(fileID.String(), number)
+}
+
+// ListVersions returns the versions of the file ordered by number
+func (r *MemoryVersionRepository) ListVersions(fileID models.ID) ([]models.Version, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.versions[fileID]), nil
+}
+
+// DeleteVersions removes the whole history of the file and returns the removed versions
+func (r *MemoryVersionRepository) DeleteVersions(fileID models.ID) ([]models.Version, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := r.versions[fileID]
+	delete(r.versions, fileID)
+	return deleted, nil
+}
@@ -0,0 +1,68 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// TestPermissions tests that every repository stores the owner, group and mode of folders and files
+func TestPermissions(t *testing.T) {
+	for implementation, newRepositories := range caseRepositories {
+		t.Run(implementation, func(t *testing.T) {
+			users, folders, files := newRepositories(t, models.CasePreserving)
+			assert.NoError(t, users.Register(models.User{Username: "alice"}))
+			assert.NoError(t, users.Register(models.User{Username: "bob"}))
+			alice, err := users.GetUser("alice")
+			assert.NoError(t, err)
+			bob, err := users.GetUser("bob")
+			assert.NoError(t, err)
+
+			// New folders and files keep the permissions they are created with, and belong to the tree user by default
+			shared := models.Permissions{OwnerID: bob.ID, Group: "dev", Mode: 0o750}
+			assert.NoError(t, folders.CreateFolder(models.Folder{Username: "alice", ParentPath: "/", Name: "shared", CreatedAt: time.Now(), Permissions: shared}))
+			assert.NoError(t, folders.CreateFolder(models.Folder{Username: "alice", ParentPath: "/", Name: "private", CreatedAt: time.Now(), Permissions: models.Permissions{Mode: models.DefaultFolderMode}}))
+			assert.NoError(t, files.CreateFile(models.File{Username: "alice", FolderPath: "/shared", Name: "notes", CreatedAt: time.Now(), Permissions: models.Permissions{Group: "dev", Mode: 0o640}}))
+
+			folder, err := folders.GetFolder("alice", "/shared")
+			assert.NoError(t, err)
+			assert.Equal(t, shared, folder.Permissions)
+			folder, err = folders.GetFolder("alice", "/private")
+			assert.NoError(t, err)
+			assert.Equal(t, models.Permissions{OwnerID: alice.ID, Mode: models.DefaultFolderMode}, folder.Permissions)
+			file, err := files.GetFile("alice", "/shared", "notes")
+			assert.NoError(t, err)
+			assert.Equal(t, models.Permissions{OwnerID: alice.ID, Group: "dev", Mode: 0o640}, file.Permissions)
+
+			// Updates replace the permissions
+			folder.Permissions = models.Permissions{OwnerID: bob.ID, Mode: 0o711}
+			assert.NoError(t, folders.UpdateFolder(folder))
+			folder, err = folders.GetFolder("alice", "/private")
+			assert.NoError(t, err)
+			assert.Equal(t, models.Permissions{OwnerID: bob.ID, Mode: 0o711}, folder.Permissions)
+			file.Permissions.Mode = 0o604
+			assert.NoError(t, files.UpdateFile(file))
+
+			// Copies and moved files keep the permissions of the file
+			copied, err := files.CopyFile("alice", "/shared", "notes", "/private", "copy", false)
+			assert.NoError(t, err)
+			assert.Equal(t, file.Permissions, copied.Permissions)
+			assert.NoError(t, files.MoveFile("alice", "/shared", "notes", "/", "moved", false))
+			moved, err := files.GetFile("alice", "/", "moved")
+			assert.NoError(t, err)
+			assert.Equal(t, file.Permissions, moved.Permissions)
+
+			// Folders keep their permissions when they are listed and renamed
+			assert.NoError(t, folders.RenameFolder("alice", "/shared", "team"))
+			listed, err := folders.ListFolders("alice", "/", "", "")
+			assert.NoError(t, err)
+			for _, f := range listed {
+				if f.Name == "team" {
+					assert.Equal(t, shared, f.Permissions)
+				}
+			}
+		})
+	}
+}
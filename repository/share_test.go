@@ -0,0 +1,75 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// TestShares tests that every repository stores the access control lists of folders, lists the shared folders and
+// revokes the shares of deleted folders and users
+func TestShares(t *testing.T) {
+	for implementation, newRepositories := range caseRepositories {
+		t.Run(implementation, func(t *testing.T) {
+			users, folders, _ := newRepositories(t, models.CasePreserving)
+			for _, username := range []string{"alice", "bob", "carol"} {
+				assert.NoError(t, users.Register(models.User{Username: username}))
+			}
+			bob, err := users.GetUser("bob")
+			assert.NoError(t, err)
+			carol, err := users.GetUser("carol")
+			assert.NoError(t, err)
+			for _, name := range []string{"docs", "team", "private"} {
+				assert.NoError(t, folders.CreateFolder(models.Folder{Username: "alice", ParentPath: "/", Name: name, CreatedAt: time.Now()}))
+			}
+
+			// Updates replace the shares
+			shares := []models.Share{{UserID: bob.ID, Access: models.ShareRead}, {UserID: carol.ID, Access: models.ShareReadWrite}}
+			for _, name := range []string{"docs", "team"} {
+				folder, err := folders.GetFolder("alice", "/"+name)
+				assert.NoError(t, err)
+				assert.Empty(t, folder.Shares)
+				folder.Shares = shares
+				assert.NoError(t, folders.UpdateFolder(folder))
+			}
+			folder, err := folders.GetFolder("alice", "/docs")
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, shares, folder.Shares)
+
+			// Shares are listed for the users they are shared with and for the user whose tree holds the folders
+			assertShared := func(username string, expected ...string) {
+				shared, err := folders.ListShared(username)
+				assert.NoError(t, err)
+				var paths []string
+				for _, f := range shared {
+					assert.Equal(t, "alice", f.Username)
+					paths = append(paths, f.Path())
+				}
+				assert.Equal(t, expected, paths, username)
+			}
+			assertShared("alice", "/docs", "/team")
+			assertShared("bob", "/docs", "/team")
+			assertShared("carol", "/docs", "/team")
+
+			// Renamed folders keep their shares, while deleted folders and users take theirs along
+			assert.NoError(t, folders.RenameFolder("alice", "/docs", "papers"))
+			_, err = folders.DeleteFolder("alice", "/team", false)
+			assert.NoError(t, err)
+			assertShared("bob", "/papers")
+			_, err = users.DeleteUser("bob")
+			assert.NoError(t, err)
+			folder, err = folders.GetFolder("alice", "/papers")
+			assert.NoError(t, err)
+			assert.Equal(t, []models.Share{{UserID: carol.ID, Access: models.ShareReadWrite}}, folder.Shares)
+			assertShared("alice", "/papers")
+			assertShared("carol", "/papers")
+
+			folder.Shares = nil
+			assert.NoError(t, folders.UpdateFolder(folder))
+			assertShared("alice")
+			assertShared("carol")
+		})
+	}
+}
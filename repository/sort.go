@@ -0,0 +1,87 @@
+// repository/sort.go
+
+package repository
+
+import (
+	"sort"
+
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// sortFolders sorts folders in place based on the specified field and order.
+// It is shared by all the folder repositories so that every storage backend lists folders the same way.
+func sortFolders(folders []models.Folder, sortField, sortOrder string) {
+	switch sortField {
+	case "--sort-name":
+		sort.Slice(folders, func(i, j int) bool {
+			if sortOrder == "desc" {
+				return folders[i].Name > folders[j].Name
+			}
+			return folders[i].Name < folders[j].Name
+		})
+	case "--sort-created":
+		sort.Slice(folders, func(i, j int) bool {
+			if sortOrder == "desc" {
+				return folders[i].CreatedAt.After(folders[j].CreatedAt)
+			}
+			return folders[i].CreatedAt.Before(folders[j].CreatedAt)
+		})
+	default:
+		// Default to sort by name in ascending order if no sort flag is provided
+		sort.Slice(folders, func(i, j int) bool {
+			return folders[i].Name < folders[j].Name
+		})
+	}
+}
+
+// sortFiles sorts files in place based on the specified field and order.
+// It is shared by all the file repositories so that every storage backend lists files the same way.
+func sortFiles(files []models.File, sortField, sortOrder string) {
+	switch sortField {
+	case "--sort-name":
+		sort.Slice(files, func(i, j int) bool {
+			if sortOrder == "desc" {
+				return files[i].Name > files[j].Name
+			}
+			return files[i].Name < files[j].Name
+		})
+	case "--sort-created":
+		sort.Slice(files, func(i, j int) bool {
+			if sortOrder == "desc" {
+				return files[i].CreatedAt.After(files[j].CreatedAt)
+			}
+			return files[i].CreatedAt.Before(files[j].CreatedAt)
+		})
+	default:
+		// Default sorting by name in ascending order
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Name < files[j].Name
+		})
+	}
+}
+
+// sortUsers sorts users in place based on the specified field and order.
+// It is shared by all the user repositories so that every storage backend lists users the same way.
+func sortUsers(users []models.User, sortField, sortOrder string) {
+	switch sortField {
+	case "--sort-name":
+		sort.Slice(users, func(i, j int) bool {
+			if sortOrder == "desc" {
+				return users[i].Username > users[j].Username
+			}
+			return users[i].Username < users[j].Username
+		})
+	case "--sort-created":
+		sort.Slice(users, func(i, j int) bool {
+			if sortOrder == "desc" {
+				return users[i].CreatedAt.After(users[j].CreatedAt)
+			}
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		})
+	default:
+		// Default sorting by name in ascending order
+		sort.Slice(users, func(i, j int) bool {
+			return users[i].Username < users[j].Username
+		})
+	}
+}
@@ -0,0 +1,228 @@
+// repository/sql_blob_repository.go
+
+package repository
+
+import (
+	"database/sql"
+	"io"
+	"sync"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// SQLBlobRepository handles the repository logic for file contents in a SQL database, keyed by their hash. The data of
+// every blob is split into chunks, see ChunkSize, which are stored once however many blobs share them.
+type SQLBlobRepository struct {
+	db   *sql.DB
+	held heldChunks
+	mu   sync.Mutex // ensures thread-safe access to held, and keeps CollectGarbage from removing chunks being held
+}
+
+// NewSQLBlobRepository creates a new instance of SQLBlobRepository
+func NewSQLBlobRepository(db *sql.DB) *SQLBlobRepository {
+	return &SQLBlobRepository{
+		db:   db,
+		held: make(heldChunks),
+	}
+}
+
+// PutBlob stores the data unless an equal blob is already stored, adds a reference to the blob and returns its hash
+func (r *SQLBlobRepository) PutBlob(data []byte) (string, error) {
+	return putBlob(r, data)
+}
+
+// GetBlob returns the data of the blob, once it is verified against the hash
+func (r *SQLBlobRepository) GetBlob(hash string) ([]byte, error) {
+	reader, err := r.openBlob(hash)
+	if err != nil {
+		return nil, err
+	}
+	return readBlob(reader)
+}
+
+// OpenBlob returns a reader of the data of the blob, which verifies every chunk as it is read
+func (r *SQLBlobRepository) OpenBlob(hash string) (io.ReadSeekCloser, error) {
+	return r.openBlob(hash)
+}
+
+// openBlob returns a reader of the blob holding its chunks
+func (r *SQLBlobRepository) openBlob(hash string) (*blobReader, error) {
+	if hash == "" {
+		return emptyBlob(r), nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var size int64
+	err := r.db.QueryRow(`SELECT size FROM blobs WHERE hash = ?`, hash).Scan(&size)
+	if err == sql.ErrNoRows {
+		return nil, customErrors.ErrBlobNotFound(hash)
+	} else if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.Query(`SELECT chunk_hash FROM blob_chunks WHERE blob_hash = ? ORDER BY position`, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var chunks []string
+	for rows.Next() {
+		var chunk string
+		if err := rows.Scan(&chunk); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	r.held.hold(chunks...)
+	return newBlobReader(r, hash, size, chunks), nil
+}
+
+// CreateBlob returns a writer that stores a new blob once it is committed
+func (r *SQLBlobRepository) CreateBlob() models.BlobWriter {
+	return newBlobWriter(r)
+}
+
+// putChunk stores the data as a chunk unless an equal chunk is already stored and holds it
+func (r *SQLBlobRepository) putChunk(data []byte) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hash := models.HashContent(data)
+	if _, err := r.db.Exec(`INSERT INTO chunks (hash, data) VALUES (?, ?) ON CONFLICT (hash) DO NOTHING`, hash, data); err != nil {
+		return "", err
+	}
+	r.held.hold(hash)
+	return hash, nil
+}
+
+// getChunk returns the data of the chunk
+func (r *SQLBlobRepository) getChunk(hash string) ([]byte, error) {
+	var data []byte
+	err := r.db.QueryRow(`SELECT data FROM chunks WHERE hash = ?`, hash).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, customErrors.ErrBlobNotFound(hash)
+	}
+	return data, err
+}
+
+// commitBlob stores the blob made of the chunks unless it is already stored, and adds a reference to it, in a single
+// transaction
+func (r *SQLBlobRepository) commitBlob(hash string, size int64, chunks []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE blobs SET refs = refs + 1 WHERE hash = ?`, hash)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		if _, err := tx.Exec(`INSERT INTO blobs (hash, size, refs) VALUES (?, ?, 1)`, hash, size); err != nil {
+			return err
+		}
+		for position, chunk := range chunks {
+			if _, err := tx.Exec(`INSERT INTO blob_chunks (blob_hash, position, chunk_hash) VALUES (?, ?, ?)`, hash, position, chunk); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// releaseChunks stops holding the chunks
+func (r *SQLBlobRepository) releaseChunks(chunks []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.held.release(chunks)
+}
+
+// RetainBlob adds a reference to the blob
+func (r *SQLBlobRepository) RetainBlob(hash string) error {
+	if hash == "" {
+		return nil
+	}
+
+	result, err := r.db.Exec(`UPDATE blobs SET refs = refs + 1 WHERE hash = ?`, hash)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return customErrors.ErrBlobNotFound(hash)
+	}
+	return nil
+}
+
+// ReleaseBlob removes a reference to the blob. Releasing a blob that is gone changes nothing.
+func (r *SQLBlobRepository) ReleaseBlob(hash string) error {
+	_, err := r.db.Exec(`UPDATE blobs SET refs = refs - 1 WHERE hash = ? AND refs > 0`, hash)
+	return err
+}
+
+// CollectGarbage removes the blobs without references, then the chunks that no blob lists and nobody holds, and
+// returns how many blobs were removed
+func (r *SQLBlobRepository) CollectGarbage() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result, err := r.db.Exec(`DELETE FROM blobs WHERE refs <= 0`)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if err := r.removeChunks(); err != nil {
+		return 0, err
+	}
+	return int(removed), nil
+}
+
+// removeChunks removes the chunks that no blob lists and nobody holds. The caller must hold r.mu.
+func (r *SQLBlobRepository) removeChunks() error {
+	rows, err := r.db.Query(`SELECT hash FROM chunks WHERE hash NOT IN (SELECT chunk_hash FROM blob_chunks)`)
+	if err != nil {
+		return err
+	}
+	var garbage []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return err
+		}
+		if r.held[hash] == 0 {
+			garbage = append(garbage, hash)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, hash := range garbage {
+		if _, err := r.db.Exec(`DELETE FROM chunks WHERE hash = ?`, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nonNil returns data, or an empty slice if data is nil, so that it is stored as an empty BLOB instead of NULL
+func nonNil(data []byte) []byte {
+	if data == nil {
+		return []byte{}
+	}
+	return data
+}
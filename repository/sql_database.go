@@ -0,0 +1,648 @@
+// repository/sql_database.go
+
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terenzio/vfs/domain/models"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// migration is a single versioned step of the SQL schema.
+// Migrations are applied in order and exactly once; every applied version is recorded in the schema_migrations table.
+// A step that can't be expressed in SQL alone has an upgrade function, run after its statements in the same transaction.
+// A step that rebuilds tables referenced by foreign keys runs with the foreign keys off, since dropping the old table
+// would otherwise cascade to the rows referencing it; the foreign keys are checked before the step is committed.
+type migration struct {
+	version        int
+	description    string
+	statements     []string
+	upgrade        func(tx *sql.Tx) error
+	foreignKeysOff bool
+}
+
+// migrations lists every version of the SQL schema. New versions must be appended, never edited once released.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create users, folders, files and contents",
+		statements: []string{
+			`CREATE TABLE users (
+				id       INTEGER PRIMARY KEY,
+				username TEXT NOT NULL COLLATE NOCASE
+			)`,
+			`CREATE UNIQUE INDEX users_username ON users (username)`,
+			`CREATE TABLE folders (
+				id          INTEGER PRIMARY KEY,
+				user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				parent_id   INTEGER REFERENCES folders (id) ON DELETE CASCADE,
+				name        TEXT NOT NULL COLLATE NOCASE,
+				path        TEXT NOT NULL COLLATE NOCASE,
+				description TEXT NOT NULL DEFAULT '',
+				created_at  INTEGER NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX folders_path ON folders (user_id, path)`,
+			`CREATE INDEX folders_parent ON folders (user_id, parent_id)`,
+			`CREATE TABLE files (
+				id          INTEGER PRIMARY KEY,
+				user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				folder_id   INTEGER REFERENCES folders (id) ON DELETE CASCADE,
+				name        TEXT NOT NULL,
+				description TEXT NOT NULL DEFAULT '',
+				size        INTEGER NOT NULL DEFAULT 0,
+				created_at  INTEGER NOT NULL,
+				modified_at INTEGER NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX files_name ON files (user_id, IFNULL(folder_id, 0), name)`,
+			`CREATE TABLE contents (
+				key  TEXT PRIMARY KEY,
+				data BLOB NOT NULL
+			)`,
+		},
+	},
+	{
+		version:     2,
+		description: "key contents by the IDs of their files",
+		statements: []string{
+			`UPDATE contents SET key = (
+				SELECT CAST(f.id AS TEXT) FROM files f JOIN users u ON u.id = f.user_id LEFT JOIN folders d ON d.id = f.folder_id
+				WHERE '/' || u.username || IFNULL(d.path, '') || '/' || f.name = contents.key
+			) WHERE key IN (
+				SELECT '/' || u.username || IFNULL(d.path, '') || '/' || f.name
+				FROM files f JOIN users u ON u.id = f.user_id LEFT JOIN folders d ON d.id = f.folder_id
+			)`,
+		},
+	},
+	{
+		version:     3,
+		description: "match names by the keys of the case policy",
+		statements: []string{
+			// The keys start out as the names were matched so far and are recomputed by rekey on every start
+			`ALTER TABLE users ADD COLUMN username_key TEXT NOT NULL DEFAULT ''`,
+			`UPDATE users SET username_key = lower(username)`,
+			`DROP INDEX users_username`,
+			`CREATE UNIQUE INDEX users_username ON users (username_key)`,
+			`ALTER TABLE folders ADD COLUMN path_key TEXT NOT NULL DEFAULT ''`,
+			`UPDATE folders SET path_key = lower(path)`,
+			`DROP INDEX folders_path`,
+			`CREATE UNIQUE INDEX folders_path ON folders (user_id, path_key)`,
+			`ALTER TABLE files ADD COLUMN name_key TEXT NOT NULL DEFAULT ''`,
+			`UPDATE files SET name_key = name`,
+			`DROP INDEX files_name`,
+			`CREATE UNIQUE INDEX files_name ON files (user_id, IFNULL(folder_id, 0), name_key)`,
+		},
+	},
+	{
+		version:     4,
+		description: "add the profiles of users",
+		statements: []string{
+			// Users registered before profiles existed have no creation time, stored as 0
+			`ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version:     5,
+		description: "add the password hashes of users",
+		statements: []string{
+			// Users registered before passwords existed have no password hash
+			`ALTER TABLE users ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+	{
+		version:     6,
+		description: "add the groups of users and the permissions of folders and files",
+		statements: []string{
+			// Group names never contain white space, so they are kept in a single space-separated column.
+			// Folders and files created before permissions existed are owned by the users whose trees hold them and
+			// get the default modes 0700 and 0600.
+			`ALTER TABLE users ADD COLUMN group_names TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE folders ADD COLUMN owner_id INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE folders ADD COLUMN group_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE folders ADD COLUMN mode INTEGER NOT NULL DEFAULT 448`,
+			`UPDATE folders SET owner_id = user_id`,
+			`ALTER TABLE files ADD COLUMN owner_id INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE files ADD COLUMN group_name TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE files ADD COLUMN mode INTEGER NOT NULL DEFAULT 384`,
+			`UPDATE files SET owner_id = user_id`,
+		},
+	},
+	{
+		version:     7,
+		description: "add the access control lists of folders",
+		statements: []string{
+			// Deleting a folder or a user deletes the shares of the folder and the shares with the user
+			`CREATE TABLE folder_shares (
+				folder_id INTEGER NOT NULL REFERENCES folders (id) ON DELETE CASCADE,
+				user_id   INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				access    TEXT NOT NULL,
+				PRIMARY KEY (folder_id, user_id)
+			)`,
+			`CREATE INDEX folder_shares_user ON folder_shares (user_id)`,
+		},
+	},
+	{
+		version:     8,
+		description: "add the trash of users",
+		statements: []string{
+			// The folders and files held by an entry are kept as JSON in their stored form, see storedTrashItems
+			`CREATE TABLE trash (
+				id         INTEGER PRIMARY KEY,
+				user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				deleted_by INTEGER NOT NULL,
+				deleted_at INTEGER NOT NULL,
+				path       TEXT NOT NULL,
+				items      TEXT NOT NULL
+			)`,
+			`CREATE INDEX trash_user ON trash (user_id)`,
+			`CREATE INDEX trash_deleted_at ON trash (deleted_at)`,
+		},
+	},
+	{
+		version:     9,
+		description: "add the versions of files",
+		statements: []string{
+			// The versions of a deleted file are removed with its content by the service, so they don't reference
+			// the file; Fsck finds the versions of missing files
+			`CREATE TABLE versions (
+				file_id    INTEGER NOT NULL,
+				number     INTEGER NOT NULL,
+				author_id  INTEGER NOT NULL,
+				created_at INTEGER NOT NULL,
+				size       INTEGER NOT NULL,
+				hash       TEXT NOT NULL,
+				PRIMARY KEY (file_id, number)
+			)`,
+		},
+	},
+	{
+		version:     10,
+		description: "store contents as blobs addressed by their hash",
+		statements: []string{
+			`CREATE TABLE blobs (
+				hash TEXT PRIMARY KEY,
+				data BLOB NOT NULL,
+				refs INTEGER NOT NULL DEFAULT 0
+			)`,
+			`ALTER TABLE files ADD COLUMN content_hash TEXT NOT NULL DEFAULT ''`,
+		},
+		upgrade: upgradeContents,
+	},
+	{
+		version:     11,
+		description: "split blobs into chunks addressed by their hash",
+		statements: []string{
+			`CREATE TABLE chunks (
+				hash TEXT PRIMARY KEY,
+				data BLOB NOT NULL
+			)`,
+			`CREATE TABLE blob_chunks (
+				blob_hash  TEXT NOT NULL REFERENCES blobs (hash) ON DELETE CASCADE,
+				position   INTEGER NOT NULL,
+				chunk_hash TEXT NOT NULL REFERENCES chunks (hash),
+				PRIMARY KEY (blob_hash, position)
+			)`,
+			`CREATE INDEX blob_chunks_chunk ON blob_chunks (chunk_hash)`,
+			`ALTER TABLE blobs ADD COLUMN size INTEGER NOT NULL DEFAULT 0`,
+		},
+		upgrade: upgradeBlobChunks,
+	},
+	{
+		version:     12,
+		description: "never reuse the IDs of users, folders, files and trash entries",
+		statements: []string{
+			// Without AUTOINCREMENT, SQLite hands out the highest ID again once its row is deleted. The tables are
+			// rebuilt with their rows, and the sequences start at the highest IDs in use.
+			`CREATE TABLE users_new (
+				id            INTEGER PRIMARY KEY AUTOINCREMENT,
+				username      TEXT NOT NULL COLLATE NOCASE,
+				username_key  TEXT NOT NULL DEFAULT '',
+				display_name  TEXT NOT NULL DEFAULT '',
+				email         TEXT NOT NULL DEFAULT '',
+				created_at    INTEGER NOT NULL DEFAULT 0,
+				password_hash TEXT NOT NULL DEFAULT '',
+				group_names   TEXT NOT NULL DEFAULT ''
+			)`,
+			`INSERT INTO users_new (id, username, username_key, display_name, email, created_at, password_hash, group_names)
+				SELECT id, username, username_key, display_name, email, created_at, password_hash, group_names FROM users`,
+			`DROP TABLE users`,
+			`ALTER TABLE users_new RENAME TO users`,
+			`CREATE UNIQUE INDEX users_username ON users (username_key)`,
+			`CREATE TABLE folders_new (
+				id          INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id     INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				parent_id   INTEGER REFERENCES folders (id) ON DELETE CASCADE,
+				name        TEXT NOT NULL COLLATE NOCASE,
+				path        TEXT NOT NULL COLLATE NOCASE,
+				description TEXT NOT NULL DEFAULT '',
+				created_at  INTEGER NOT NULL,
+				path_key    TEXT NOT NULL DEFAULT '',
+				owner_id    INTEGER NOT NULL DEFAULT 0,
+				group_name  TEXT NOT NULL DEFAULT '',
+				mode        INTEGER NOT NULL DEFAULT 448
+			)`,
+			`INSERT INTO folders_new (id, user_id, parent_id, name, path, description, created_at, path_key, owner_id, group_name, mode)
+				SELECT id, user_id, parent_id, name, path, description, created_at, path_key, owner_id, group_name, mode FROM folders`,
+			`DROP TABLE folders`,
+			`ALTER TABLE folders_new RENAME TO folders`,
+			`CREATE UNIQUE INDEX folders_path ON folders (user_id, path_key)`,
+			`CREATE INDEX folders_parent ON folders (user_id, parent_id)`,
+			`CREATE TABLE files_new (
+				id           INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				folder_id    INTEGER REFERENCES folders (id) ON DELETE CASCADE,
+				name         TEXT NOT NULL,
+				description  TEXT NOT NULL DEFAULT '',
+				size         INTEGER NOT NULL DEFAULT 0,
+				created_at   INTEGER NOT NULL,
+				modified_at  INTEGER NOT NULL,
+				name_key     TEXT NOT NULL DEFAULT '',
+				owner_id     INTEGER NOT NULL DEFAULT 0,
+				group_name   TEXT NOT NULL DEFAULT '',
+				mode         INTEGER NOT NULL DEFAULT 384,
+				content_hash TEXT NOT NULL DEFAULT ''
+			)`,
+			`INSERT INTO files_new (id, user_id, folder_id, name, description, size, created_at, modified_at, name_key, owner_id, group_name, mode, content_hash)
+				SELECT id, user_id, folder_id, name, description, size, created_at, modified_at, name_key, owner_id, group_name, mode, content_hash FROM files`,
+			`DROP TABLE files`,
+			`ALTER TABLE files_new RENAME TO files`,
+			`CREATE UNIQUE INDEX files_name ON files (user_id, IFNULL(folder_id, 0), name_key)`,
+			`CREATE TABLE trash_new (
+				id         INTEGER PRIMARY KEY AUTOINCREMENT,
+				user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				deleted_by INTEGER NOT NULL,
+				deleted_at INTEGER NOT NULL,
+				path       TEXT NOT NULL,
+				items      TEXT NOT NULL
+			)`,
+			`INSERT INTO trash_new (id, user_id, deleted_by, deleted_at, path, items)
+				SELECT id, user_id, deleted_by, deleted_at, path, items FROM trash`,
+			`DROP TABLE trash`,
+			`ALTER TABLE trash_new RENAME TO trash`,
+			`CREATE INDEX trash_user ON trash (user_id)`,
+			`CREATE INDEX trash_deleted_at ON trash (deleted_at)`,
+		},
+		foreignKeysOff: true,
+	},
+	{
+		version:     13,
+		description: "add the groups users own",
+		statements: []string{
+			// Groups started before owners existed have none, so only their members change them
+			`ALTER TABLE users ADD COLUMN owned_group_names TEXT NOT NULL DEFAULT ''`,
+		},
+	},
+}
+
+// nameIndexes are the unique indexes on the keys of the names of users, folders and files, created by rekey
+var nameIndexes = map[string]string{
+	"users_username": `CREATE UNIQUE INDEX users_username ON users (username_key)`,
+	"folders_path":   `CREATE UNIQUE INDEX folders_path ON folders (user_id, path_key)`,
+	"files_name":     `CREATE UNIQUE INDEX files_name ON files (user_id, IFNULL(folder_id, 0), name_key)`,
+}
+
+// OpenSQLDatabase opens the SQLite database at dataSource, migrates its schema to the latest version and keys the names
+// of users, folders and files with the case policy.
+// The database is embedded through a pure-Go SQLite engine, so no cgo is needed. A dataSource of ":memory:"
+// opens a private in-memory database.
+func OpenSQLDatabase(dataSource string, policy models.CasePolicy) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+dataSource+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+
+	// A single connection serializes all statements, which keeps writes free of SQLITE_BUSY errors
+	// and makes an in-memory database live as long as the pool.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := rekey(db, policy); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrate applies every migration that is newer than the current schema version of the database.
+// Each migration runs in its own transaction, so a failed migration leaves the schema at the previous version.
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT IFNULL(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].version; current > latest {
+		return fmt.Errorf("the database schema version %d is newer than the latest supported version %d", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMigration applies a single migration in its own transaction and records its version
+func applyMigration(db *sql.DB, m migration) error {
+	// The pragma is ignored inside a transaction, and the database has a single connection it stays set on
+	if m.foreignKeysOff {
+		if _, err := db.Exec(`PRAGMA foreign_keys = OFF`); err != nil {
+			return err
+		}
+		defer db.Exec(`PRAGMA foreign_keys = ON`)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, statement := range m.statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+	if m.upgrade != nil {
+		if err := m.upgrade(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+	if m.foreignKeysOff {
+		if err := checkForeignKeys(tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, m.version, time.Now().UnixNano()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkForeignKeys returns an error if a row references a row that doesn't exist
+func checkForeignKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var index int
+		if err := rows.Scan(&table, &rowID, &parent, &index); err != nil {
+			return err
+		}
+		return fmt.Errorf("the row %d of the table %s references a missing row of the table %s", rowID.Int64, table, parent)
+	}
+	return rows.Err()
+}
+
+// upgradeContents moves the contents, stored under the keys of their files, trash entries and versions, into blobs
+// addressed by their hash. Files and the files held by trash entries are given the hash of their content, while versions
+// already record it. The references to every blob are counted from scratch and the contents table is dropped; contents
+// that belonged to nothing become blobs without references, which the next garbage collection removes.
+func upgradeContents(tx *sql.Tx) error {
+	type content struct {
+		key  string
+		data []byte
+	}
+	rows, err := tx.Query(`SELECT key, data FROM contents`)
+	if err != nil {
+		return err
+	}
+	var contents []content
+	for rows.Next() {
+		var c content
+		if err := rows.Scan(&c.key, &c.data); err != nil {
+			rows.Close()
+			return err
+		}
+		contents = append(contents, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Store every content as a blob, remembering the hashes of the files in the trash by entry and file ID
+	trashHashes := make(map[models.ID]map[models.ID]string)
+	for _, c := range contents {
+		hash := models.HashContent(c.data)
+		if _, err := tx.Exec(`INSERT INTO blobs (hash, data) VALUES (?, ?) ON CONFLICT (hash) DO NOTHING`, hash, nonNil(c.data)); err != nil {
+			return err
+		}
+		switch fields := strings.Split(c.key, ":"); {
+		case len(fields) == 3 && fields[0] == "trash":
+			entryID, err1 := strconv.ParseInt(fields[1], 10, 64)
+			fileID, err2 := strconv.ParseInt(fields[2], 10, 64)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			if trashHashes[models.ID(entryID)] == nil {
+				trashHashes[models.ID(entryID)] = make(map[models.ID]string)
+			}
+			trashHashes[models.ID(entryID)][models.ID(fileID)] = hash
+		case len(fields) == 1:
+			if _, err := tx.Exec(`UPDATE files SET content_hash = ? WHERE CAST(id AS TEXT) = ?`, hash, c.key); err != nil {
+				return err
+			}
+		}
+	}
+
+	for entryID, hashes := range trashHashes {
+		var data string
+		err := tx.QueryRow(`SELECT items FROM trash WHERE id = ?`, entryID).Scan(&data)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return err
+		}
+		var items storedTrashItems
+		if err := json.Unmarshal([]byte(data), &items); err != nil {
+			return err
+		}
+		for i, f := range items.Files {
+			items.Files[i].ContentHash = hashes[f.ID]
+		}
+		updated, err := json.Marshal(items)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE trash SET items = ? WHERE id = ?`, string(updated), entryID); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(blobReferences + `UPDATE blobs SET refs = IFNULL((SELECT refs FROM expected e WHERE e.hash = blobs.hash), 0)`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DROP TABLE contents`)
+	return err
+}
+
+// upgradeBlobChunks splits the data of every blob into chunks, see ChunkSize, and drops the data column of the blobs.
+// The blobs are split one after the other, so only one of them is held in memory.
+func upgradeBlobChunks(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT hash FROM blobs`)
+	if err != nil {
+		return err
+	}
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		var data []byte
+		if err := tx.QueryRow(`SELECT data FROM blobs WHERE hash = ?`, hash).Scan(&data); err != nil {
+			return err
+		}
+		for position, chunk := range splitChunks(data) {
+			chunkHash := models.HashContent(chunk)
+			if _, err := tx.Exec(`INSERT INTO chunks (hash, data) VALUES (?, ?) ON CONFLICT (hash) DO NOTHING`, chunkHash, chunk); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT INTO blob_chunks (blob_hash, position, chunk_hash) VALUES (?, ?, ?)`, hash, position, chunkHash); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`UPDATE blobs SET size = ? WHERE hash = ?`, len(data), hash); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`ALTER TABLE blobs DROP COLUMN data`)
+	return err
+}
+
+// rekey recomputes the keys under which the names of users, folders and files are matched, so the unique indexes on
+// the keys enforce the case policy even if the database was written with another one. The indexes are dropped while
+// the keys change and created again afterwards, in a single transaction, so the database is left untouched if names
+// collide under the policy.
+func rekey(db *sql.DB, policy models.CasePolicy) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for index := range nameIndexes {
+		if _, err := tx.Exec(`DROP INDEX IF EXISTS ` + index); err != nil {
+			return err
+		}
+	}
+	for _, column := range []struct{ table, name, key string }{
+		{"users", "username", "username_key"},
+		{"folders", "path", "path_key"},
+		{"files", "name", "name_key"},
+	} {
+		keys, err := nameKeys(tx, policy, column.table, column.name, column.key)
+		if err != nil {
+			return err
+		}
+		for id, key := range keys {
+			if _, err := tx.Exec(`UPDATE `+column.table+` SET `+column.key+` = ? WHERE id = ?`, key, id); err != nil {
+				return err
+			}
+		}
+	}
+	for index, statement := range nameIndexes {
+		if _, err := tx.Exec(statement); isUniqueError(err) {
+			return fmt.Errorf("the index %s has names that are equal under the %s case policy", index, policy)
+		} else if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// nameKeys returns the keys of the names in a column that differ from the stored keys, by the IDs of their rows
+func nameKeys(tx *sql.Tx, policy models.CasePolicy, table, name, key string) (map[int64]string, error) {
+	rows, err := tx.Query(`SELECT id, ` + name + `, ` + key + ` FROM ` + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var value, stored string
+		if err := rows.Scan(&id, &value, &stored); err != nil {
+			return nil, err
+		}
+		if newKey := policy.Key(value); newKey != stored {
+			keys[id] = newKey
+		}
+	}
+	return keys, rows.Err()
+}
+
+// isConstraintError reports whether err was caused by the violation of the given SQLite constraint,
+// e.g. sqlite3.SQLITE_CONSTRAINT_UNIQUE
+func isConstraintError(err error, code int) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == code
+}
+
+// isUniqueError reports whether err was caused by a duplicate row in a unique index
+func isUniqueError(err error) bool {
+	return isConstraintError(err, sqlite3.SQLITE_CONSTRAINT_UNIQUE)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// lookupUserID returns the ID of the user, or sql.ErrNoRows if the user is not registered
+func lookupUserID(q querier, policy models.CasePolicy, username string) (int64, error) {
+	var id int64
+	err := q.QueryRow(`SELECT id FROM users WHERE username_key = ?`, policy.Key(username)).Scan(&id)
+	return id, err
+}
+
+// lookupFolderID returns the ID of the folder of the user at folderPath, or sql.ErrNoRows if there is no such folder.
+// The root path has no folder row and is returned as a NULL ID.
+func lookupFolderID(q querier, policy models.CasePolicy, userID int64, folderPath string) (sql.NullInt64, error) {
+	var id sql.NullInt64
+	if folderPath = models.CleanPath(folderPath); folderPath == models.RootPath {
+		return id, nil
+	}
+	err := q.QueryRow(`SELECT id FROM folders WHERE user_id = ? AND path_key = ?`, userID, policy.Key(folderPath)).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,277 @@
+package repository_test
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/repository"
+)
+
+// openSQLDatabase opens a migrated in-memory database in which user1 is registered
+func openSQLDatabase(t *testing.T) *sql.DB {
+	db, err := repository.OpenSQLDatabase(":memory:", models.CasePreserving)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	assert.NoError(t, repository.NewSQLUserRepository(db, models.CasePreserving).Register(models.User{Username: "user1"}))
+	return db
+}
+
+// TestSQLDatabase tests the schema migrations and the constraints of the SQL database
+func TestSQLDatabase(t *testing.T) {
+	tests := []struct {
+		name     string
+		testFunc func(t *testing.T, dir string)
+	}{
+		{
+			name: "MigrationsRunOnce",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.NoError(t, store.Close())
+
+				// Reopening the database keeps the data and doesn't apply the migrations again
+				store, err = repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				defer store.Close()
+
+				var migrations int
+				assert.NoError(t, store.DB.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&migrations))
+				assert.Equal(t, 13, migrations)
+				exists, err := store.Users.Exists("user1")
+				assert.NoError(t, err)
+				assert.True(t, exists)
+				assert.NoError(t, store.Validate())
+			},
+		},
+		{
+			name: "NewerSchemaIsRejected",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				_, err = store.DB.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (999, 0)`)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Close())
+
+				_, err = repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.Error(t, err)
+			},
+		},
+		{
+			name: "UniqueIndexesRejectDuplicates",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				defer store.Close()
+
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.EqualError(t, store.Users.Register(models.User{Username: "USER1"}), customErrors.ErrUserExists("USER1").Error())
+				assert.NoError(t, store.Folders.CreateFolder(newFolder("/", "folder1")))
+				assert.EqualError(t, store.Folders.CreateFolder(newFolder("/", "FOLDER1")), customErrors.ErrFolderExists("/FOLDER1").Error())
+				assert.NoError(t, store.Files.CreateFile(newFile("file1")))
+				assert.EqualError(t, store.Files.CreateFile(newFile("file1")), customErrors.ErrFileExists("file1").Error())
+			},
+		},
+		{
+			name: "ForeignKeysCascadeFolderDeletion",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				defer store.Close()
+
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.EqualError(t, store.Folders.CreateFolder(newFolder("/missing", "folder1")), customErrors.ErrFolderNotFound("/missing").Error())
+				assert.NoError(t, store.Folders.CreateFolder(newFolder("/", "folder1")))
+				assert.NoError(t, store.Files.CreateFile(newFile("file1")))
+				_, err = store.Folders.DeleteFolder("user1", "/folder1", true)
+				assert.NoError(t, err)
+
+				_, err = store.Files.GetFile("user1", "/folder1", "file1")
+				assert.EqualError(t, err, customErrors.ErrFileNotFound("file1").Error())
+				assert.NoError(t, store.Validate())
+			},
+		},
+		{
+			name: "RekeyEnforcesTheCasePolicy",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenSQLStore(dir, models.CaseSensitive)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "file1"}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "FILE1"}))
+				assert.NoError(t, store.Close())
+
+				// The names collide once they are matched regardless of case, so the database is left as it is
+				_, err = repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.Error(t, err)
+
+				store, err = repository.OpenSQLStore(dir, models.CaseSensitive)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Files.DeleteFile("user1", "/", "FILE1"))
+				assert.NoError(t, store.Close())
+
+				store, err = repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				defer store.Close()
+				file, err := store.Files.GetFile("USER1", "/", "File1")
+				assert.NoError(t, err)
+				assert.Equal(t, "file1", file.Name)
+				assert.NoError(t, store.Validate())
+			},
+		},
+		{
+			name: "UpgradeStoresContentsAsBlobs",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "file1"}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "file2"}))
+				file1, err := store.Files.GetFile("user1", "/", "file1")
+				assert.NoError(t, err)
+				user, err := store.Users.GetUser("user1")
+				assert.NoError(t, err)
+				deleted := models.File{ID: 7, UserID: user.ID, Username: "user1", FolderPath: "/", Name: "old"}
+				entry, err := store.Trash.AddTrash(models.TrashEntry{UserID: user.ID, DeletedBy: user.ID, Path: "/old", Files: []models.File{deleted}})
+				assert.NoError(t, err)
+
+				// Take the schema back to the contents keyed by files, trash entries and versions
+				for _, statement := range []string{
+					`DROP TABLE blob_chunks`,
+					`DROP TABLE chunks`,
+					`DROP TABLE blobs`,
+					`ALTER TABLE files DROP COLUMN content_hash`,
+					`CREATE TABLE contents (key TEXT PRIMARY KEY, data BLOB NOT NULL)`,
+					`DELETE FROM schema_migrations WHERE version >= 10`,
+				} {
+					_, err := store.DB.Exec(statement)
+					assert.NoError(t, err)
+				}
+				for key, data := range map[string]string{
+					file1.ID.String():                   "hello",
+					fmt.Sprintf("trash:%d:7", entry.ID): "bye",
+					"999":                               "orphan",
+				} {
+					_, err := store.DB.Exec(`INSERT INTO contents (key, data) VALUES (?, ?)`, key, []byte(data))
+					assert.NoError(t, err)
+				}
+				assert.NoError(t, store.Close())
+
+				store, err = repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				defer store.Close()
+				file1, err = store.Files.GetFile("user1", "/", "file1")
+				assert.NoError(t, err)
+				data, err := store.Blobs.GetBlob(file1.ContentHash)
+				assert.NoError(t, err)
+				assert.Equal(t, "hello", string(data))
+				file2, err := store.Files.GetFile("user1", "/", "file2")
+				assert.NoError(t, err)
+				assert.Empty(t, file2.ContentHash)
+				entries, err := store.Trash.ListTrash(user.ID)
+				assert.NoError(t, err)
+				if assert.Len(t, entries, 1) && assert.Len(t, entries[0].Files, 1) {
+					data, err := store.Blobs.GetBlob(entries[0].Files[0].ContentHash)
+					assert.NoError(t, err)
+					assert.Equal(t, "bye", string(data))
+				}
+
+				// The content that belonged to no file is left for the garbage collection
+				removed, err := store.Blobs.CollectGarbage()
+				assert.NoError(t, err)
+				assert.Equal(t, 1, removed)
+				orphans, err := store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Empty(t, orphans)
+			},
+		},
+		{
+			name: "UpgradeSplitsBlobsIntoChunks",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				data := bytes.Repeat([]byte("a"), repository.ChunkSize+5)
+				hash, err := store.Blobs.PutBlob(data)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "file1", ContentHash: hash}))
+
+				// Take the schema back to the data held by the blobs
+				for _, statement := range []string{
+					`DROP TABLE blob_chunks`,
+					`DROP TABLE chunks`,
+					`ALTER TABLE blobs DROP COLUMN size`,
+					`ALTER TABLE blobs ADD COLUMN data BLOB NOT NULL DEFAULT x''`,
+					`DELETE FROM schema_migrations WHERE version >= 11`,
+				} {
+					_, err := store.DB.Exec(statement)
+					assert.NoError(t, err)
+				}
+				_, err = store.DB.Exec(`UPDATE blobs SET data = ? WHERE hash = ?`, data, hash)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Close())
+
+				store, err = repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				defer store.Close()
+				stored, err := store.Blobs.GetBlob(hash)
+				assert.NoError(t, err)
+				assert.Equal(t, data, stored)
+				var chunks int
+				assert.NoError(t, store.DB.QueryRow(`SELECT COUNT(*) FROM chunks`).Scan(&chunks))
+				assert.Equal(t, 2, chunks)
+				orphans, err := store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Empty(t, orphans)
+			},
+		},
+		{
+			name: "FsckRepairsOrphanBlobs",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenSQLStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				defer store.Close()
+
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.NoError(t, store.Folders.CreateFolder(newFolder("/", "folder1")))
+				kept, err := store.Blobs.PutBlob([]byte("kept"))
+				assert.NoError(t, err)
+				orphan, err := store.Blobs.PutBlob([]byte("orphan"))
+				assert.NoError(t, err)
+				file1 := newFile("file1")
+				file1.ContentHash = kept
+				assert.NoError(t, store.Files.CreateFile(file1))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "file2", ContentHash: kept}))
+
+				orphans, err := store.Fsck(true)
+				assert.NoError(t, err)
+				assert.Len(t, orphans, 2) // the orphan blob and the missing reference of file2
+				orphans, err = store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Empty(t, orphans)
+				_, err = store.Blobs.GetBlob(orphan)
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+
+				// Deleting a folder cascades to its files, so the blob is left with the references of file2 only
+				_, err = store.Folders.DeleteFolder("user1", "/folder1", true)
+				assert.NoError(t, err)
+				orphans, err = store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"the blob [" + kept + "] has 2 references instead of 1"}, orphans)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.testFunc(t, filepath.Join(t.TempDir(), "data"))
+		})
+	}
+}
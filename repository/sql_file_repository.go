@@ -0,0 +1,254 @@
+// repository/sql_file_repository.go
+
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// SQLFileRepository handles the repository logic for files in a SQL database.
+// Every file row references its owner and its folder through foreign keys, and a unique index on the owner, the folder
+// and the key of the name under the case policy rejects duplicate files.
+type SQLFileRepository struct {
+	db     *sql.DB
+	policy models.CasePolicy
+}
+
+// NewSQLFileRepository creates a new instance of SQLFileRepository that matches names with the policy
+func NewSQLFileRepository(db *sql.DB, policy models.CasePolicy) *SQLFileRepository {
+	return &SQLFileRepository{
+		db:     db,
+		policy: policy,
+	}
+}
+
+// selectFiles selects the columns scanned by scanFile, joined with the owner and the folder of every file
+const selectFiles = `SELECT f.id, f.user_id, IFNULL(f.folder_id, 0), u.username, IFNULL(d.path, '/'), f.name, f.description, f.size, f.content_hash, f.created_at,
+	f.modified_at, f.owner_id, f.group_name, f.mode
+	FROM files f JOIN users u ON u.id = f.user_id LEFT JOIN folders d ON d.id = f.folder_id`
+
+// scanFile scans a row selected by selectFiles into a domain file
+func scanFile(row interface{ Scan(dest ...any) error }) (models.File, error) {
+	var file models.File
+	var createdAt, modifiedAt int64
+	if err := row.Scan(&file.ID, &file.UserID, &file.FolderID, &file.Username, &file.FolderPath, &file.Name, &file.Description, &file.Size, &file.ContentHash, &createdAt,
+		&modifiedAt, &file.OwnerID, &file.Group, &file.Mode); err != nil {
+		return models.File{}, err
+	}
+	file.CreatedAt = time.Unix(0, createdAt)
+	file.ModifiedAt = time.Unix(0, modifiedAt)
+	return file, nil
+}
+
+// whereFile selects the file of a user inside a folder by the keys of the username, the folder path and the file name
+const whereFile = ` WHERE u.username_key = ? AND IFNULL(d.path_key, '/') = ? AND f.name_key = ?`
+
+// lookupFileID returns the ID of the file of the user named fileName inside folderPath, or sql.ErrNoRows if there is no such file
+func lookupFileID(q querier, policy models.CasePolicy, username, folderPath, fileName string) (int64, error) {
+	var id int64
+	err := q.QueryRow(`SELECT f.id FROM files f JOIN users u ON u.id = f.user_id LEFT JOIN folders d ON d.id = f.folder_id`+whereFile,
+		policy.Key(username), policy.Key(models.CleanPath(folderPath)), policy.Key(fileName)).Scan(&id)
+	return id, err
+}
+
+// CreateFile adds a new file to the database
+func (r *SQLFileRepository) CreateFile(file models.File) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, err := lookupUserID(tx, r.policy, file.Username)
+	if err == sql.ErrNoRows {
+		return customErrors.ErrUserNotExists(file.Username)
+	} else if err != nil {
+		return err
+	}
+
+	folderPath := models.CleanPath(file.FolderPath)
+	folderID, err := lookupFolderID(tx, r.policy, userID, folderPath)
+	if err == sql.ErrNoRows {
+		return customErrors.ErrFolderNotFound(folderPath)
+	} else if err != nil {
+		return err
+	}
+
+	if file.OwnerID == 0 {
+		file.OwnerID = models.ID(userID)
+	}
+	_, err = tx.Exec(`INSERT INTO files (user_id, folder_id, name, name_key, description, size, content_hash, created_at, modified_at, owner_id, group_name, mode)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, folderID, r.policy.Normalize(file.Name), r.policy.Key(file.Name), file.Description, file.Size, file.ContentHash, file.CreatedAt.UnixNano(),
+		file.ModifiedAt.UnixNano(), file.OwnerID, file.Group, file.Mode)
+	if isUniqueError(err) {
+		return customErrors.ErrFileExists(file.Name)
+	} else if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetFile returns a single file of the database
+func (r *SQLFileRepository) GetFile(username, folderPath, fileName string) (models.File, error) {
+	file, err := scanFile(r.db.QueryRow(selectFiles+whereFile,
+		r.policy.Key(username), r.policy.Key(models.CleanPath(folderPath)), r.policy.Key(fileName)))
+	if err == sql.ErrNoRows {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	}
+	return file, err
+}
+
+// UpdateFile replaces the description, size, content hash, modification time and permissions of an existing file
+func (r *SQLFileRepository) UpdateFile(file models.File) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	fileID, err := lookupFileID(tx, r.policy, file.Username, file.FolderPath, file.Name)
+	if err == sql.ErrNoRows {
+		return customErrors.ErrFileNotFound(file.Name)
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE files SET description = ?, size = ?, content_hash = ?, modified_at = ?, owner_id = ?, group_name = ?, mode = ? WHERE id = ?`,
+		file.Description, file.Size, file.ContentHash, file.ModifiedAt.UnixNano(), file.OwnerID, file.Group, file.Mode, fileID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteFile removes a file from the database
+func (r *SQLFileRepository) DeleteFile(username, folderPath, fileName string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	fileID, err := lookupFileID(tx, r.policy, username, folderPath, fileName)
+	if err == sql.ErrNoRows {
+		return customErrors.ErrFileNotFound(fileName)
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM files WHERE id = ?`, fileID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveFile moves a file to newFolderPath under newFileName, keeping its ID, description, size and times.
+// An existing file at the destination is replaced if overwrite is set, otherwise ErrFileExists is returned.
+func (r *SQLFileRepository) MoveFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) error {
+	_, err := r.relocateFile(username, folderPath, fileName, newFolderPath, newFileName, overwrite, false)
+	return err
+}
+
+// CopyFile copies a file to newFolderPath under newFileName, keeping its description, size and times, and returns
+// the copy, which gets an ID of its own.
+// An existing file at the destination is replaced if overwrite is set, otherwise ErrFileExists is returned.
+func (r *SQLFileRepository) CopyFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) (models.File, error) {
+	return r.relocateFile(username, folderPath, fileName, newFolderPath, newFileName, overwrite, true)
+}
+
+// relocateFile moves or copies a file in a single transaction and returns the relocated file
+func (r *SQLFileRepository) relocateFile(username, folderPath, fileName, newFolderPath, newFileName string, overwrite, keepSource bool) (models.File, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.File{}, err
+	}
+	defer tx.Rollback()
+
+	sourceID, err := lookupFileID(tx, r.policy, username, folderPath, fileName)
+	if err == sql.ErrNoRows {
+		return models.File{}, customErrors.ErrFileNotFound(fileName)
+	} else if err != nil {
+		return models.File{}, err
+	}
+
+	userID, err := lookupUserID(tx, r.policy, username)
+	if err != nil {
+		return models.File{}, err
+	}
+	newFolderPath = models.CleanPath(newFolderPath)
+	folderID, err := lookupFolderID(tx, r.policy, userID, newFolderPath)
+	if err == sql.ErrNoRows {
+		return models.File{}, customErrors.ErrFolderNotFound(newFolderPath)
+	} else if err != nil {
+		return models.File{}, err
+	}
+
+	relocatedID := sourceID
+	targetID, err := lookupFileID(tx, r.policy, username, newFolderPath, newFileName)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return models.File{}, err
+	case targetID == sourceID && keepSource:
+		return models.File{}, customErrors.ErrFileExists(newFileName) // a file can't be copied onto itself
+	case targetID == sourceID:
+		// Moving a file onto itself at most changes the case of its name
+	case !overwrite:
+		return models.File{}, customErrors.ErrFileExists(newFileName)
+	default:
+		if _, err := tx.Exec(`DELETE FROM files WHERE id = ?`, targetID); err != nil {
+			return models.File{}, err
+		}
+	}
+
+	if keepSource {
+		result, err := tx.Exec(`INSERT INTO files (user_id, folder_id, name, name_key, description, size, content_hash, created_at, modified_at, owner_id, group_name, mode)
+			SELECT user_id, ?, ?, ?, description, size, content_hash, created_at, modified_at, owner_id, group_name, mode FROM files WHERE id = ?`,
+			folderID, r.policy.Normalize(newFileName), r.policy.Key(newFileName), sourceID)
+		if err != nil {
+			return models.File{}, err
+		}
+		if relocatedID, err = result.LastInsertId(); err != nil {
+			return models.File{}, err
+		}
+	} else if _, err := tx.Exec(`UPDATE files SET folder_id = ?, name = ?, name_key = ? WHERE id = ?`,
+		folderID, r.policy.Normalize(newFileName), r.policy.Key(newFileName), sourceID); err != nil {
+		return models.File{}, err
+	}
+
+	relocated, err := scanFile(tx.QueryRow(selectFiles+` WHERE f.id = ?`, relocatedID))
+	if err != nil {
+		return models.File{}, err
+	}
+	return relocated, tx.Commit()
+}
+
+// ListFiles returns a slice of files sorted based on the specified field and order.
+func (r *SQLFileRepository) ListFiles(username, folderPath, sortField, sortOrder string) ([]models.File, error) {
+	rows, err := r.db.Query(selectFiles+` WHERE u.username_key = ? AND IFNULL(d.path_key, '/') = ?`,
+		r.policy.Key(username), r.policy.Key(models.CleanPath(folderPath)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortFiles(files, sortField, sortOrder)
+	return files, nil
+}
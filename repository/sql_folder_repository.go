@@ -0,0 +1,331 @@
+// repository/sql_folder_repository.go
+
+package repository
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// SQLFolderRepository handles the repository logic for folders in a SQL database.
+// Every folder row references its owner and its parent folder through foreign keys, and a unique index on the owner
+// and the key of the path under the case policy rejects duplicate folders.
+type SQLFolderRepository struct {
+	db     *sql.DB
+	policy models.CasePolicy
+}
+
+// NewSQLFolderRepository creates a new instance of SQLFolderRepository that matches names with the policy
+func NewSQLFolderRepository(db *sql.DB, policy models.CasePolicy) *SQLFolderRepository {
+	return &SQLFolderRepository{
+		db:     db,
+		policy: policy,
+	}
+}
+
+// Exists checks if a folder already exists for a user
+func (r *SQLFolderRepository) Exists(userName, folderPath string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM folders f JOIN users u ON u.id = f.user_id WHERE u.username_key = ? AND f.path_key = ?
+	)`, r.policy.Key(userName), r.policy.Key(models.CleanPath(folderPath))).Scan(&exists)
+	return exists, err
+}
+
+// GetFolder returns the folder of the user at folderPath
+func (r *SQLFolderRepository) GetFolder(username, folderPath string) (models.Folder, error) {
+	folderPath = models.CleanPath(folderPath)
+	folder, err := scanFolder(r.db.QueryRow(selectFolders+` WHERE u.username_key = ? AND f.path_key = ?`,
+		r.policy.Key(username), r.policy.Key(folderPath)))
+	if err == sql.ErrNoRows {
+		return models.Folder{}, customErrors.ErrFolderNotFound(folderPath)
+	}
+	return folder, err
+}
+
+// selectFolders selects the columns scanned by scanFolder, joined with the owner of every folder.
+// The shares of a folder are selected as a single space-separated list of "user_id:access" entries.
+const selectFolders = `SELECT f.id, f.user_id, IFNULL(f.parent_id, 0), u.username, f.name, f.path, f.description, f.created_at,
+	f.owner_id, f.group_name, f.mode,
+	IFNULL((SELECT group_concat(s.user_id || ':' || s.access, ' ') FROM folder_shares s WHERE s.folder_id = f.id), '')
+	FROM folders f JOIN users u ON u.id = f.user_id`
+
+// scanFolder scans a row selected by selectFolders into a domain folder
+func scanFolder(row interface{ Scan(dest ...any) error }) (models.Folder, error) {
+	var folder models.Folder
+	var folderPath string
+	var createdAt int64
+	var shares string
+	if err := row.Scan(&folder.ID, &folder.UserID, &folder.ParentID, &folder.Username, &folder.Name, &folderPath, &folder.Description, &createdAt,
+		&folder.OwnerID, &folder.Group, &folder.Mode, &shares); err != nil {
+		return models.Folder{}, err
+	}
+	folder.ParentPath, _ = models.SplitPath(folderPath)
+	folder.CreatedAt = time.Unix(0, createdAt)
+	for _, entry := range strings.Fields(shares) {
+		userID, access, _ := strings.Cut(entry, ":")
+		id, err := strconv.ParseInt(userID, 10, 64)
+		if err != nil {
+			return models.Folder{}, err
+		}
+		shareAccess, err := models.ParseShareAccess(access)
+		if err != nil {
+			return models.Folder{}, err
+		}
+		folder.Shares = append(folder.Shares, models.Share{UserID: models.ID(id), Access: shareAccess})
+	}
+	return folder, nil
+}
+
+// CreateFolder adds a new folder to the database
+func (r *SQLFolderRepository) CreateFolder(folder models.Folder) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	userID, err := lookupUserID(tx, r.policy, folder.Username)
+	if err == sql.ErrNoRows {
+		return customErrors.ErrUserNotExists(folder.Username)
+	} else if err != nil {
+		return err
+	}
+
+	parentPath := models.CleanPath(folder.ParentPath)
+	parentID, err := lookupFolderID(tx, r.policy, userID, parentPath)
+	if err == sql.ErrNoRows {
+		return customErrors.ErrFolderNotFound(parentPath)
+	} else if err != nil {
+		return err
+	}
+
+	// The path is built from the stored path of the parent, which may be spelled in another case than parentPath
+	name := r.policy.Normalize(folder.Name)
+	if parentID.Valid {
+		if err := tx.QueryRow(`SELECT path FROM folders WHERE id = ?`, parentID).Scan(&parentPath); err != nil {
+			return err
+		}
+	}
+	folderPath := models.JoinPath(parentPath, name)
+	if folder.OwnerID == 0 {
+		folder.OwnerID = models.ID(userID)
+	}
+	_, err = tx.Exec(`INSERT INTO folders (user_id, parent_id, name, path, path_key, description, created_at, owner_id, group_name, mode) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, parentID, name, folderPath, r.policy.Key(folderPath), folder.Description, folder.CreatedAt.UnixNano(), folder.OwnerID, folder.Group, folder.Mode)
+	if isUniqueError(err) {
+		return customErrors.ErrFolderExists(folder.Path())
+	} else if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteFolder deletes a folder and returns the files that were inside it or inside its nested folders.
+// The foreign keys cascade the deletion to the nested folders and their files. Unless recursive is set, a folder that
+// still holds folders or files is not deleted.
+func (r *SQLFolderRepository) DeleteFolder(username, folderPath string, recursive bool) ([]models.File, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	folderPath = models.CleanPath(folderPath)
+	var folderID, userID int64
+	var storedPath string
+	err = tx.QueryRow(`SELECT f.id, f.user_id, f.path FROM folders f JOIN users u ON u.id = f.user_id
+		WHERE u.username_key = ? AND f.path_key = ?`, r.policy.Key(username), r.policy.Key(folderPath)).Scan(&folderID, &userID, &storedPath)
+	if err == sql.ErrNoRows {
+		return nil, customErrors.ErrFolderNotFound(folderPath)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !recursive {
+		var nonEmpty bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM folders WHERE parent_id = ?1) OR EXISTS (SELECT 1 FROM files WHERE folder_id = ?1)`,
+			folderID).Scan(&nonEmpty)
+		if err != nil {
+			return nil, err
+		}
+		if nonEmpty {
+			return nil, customErrors.ErrFolderNotEmpty(storedPath)
+		}
+	}
+
+	// Collect the files before the cascade removes them
+	deleted, err := filesWithin(tx, userID, folderID, r.policy.Key(storedPath))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM folders WHERE id = ?`, folderID); err != nil {
+		return nil, err
+	}
+	return deleted, tx.Commit()
+}
+
+// RenameFolder renames a folder and updates the paths of all the folders nested inside it, which index them by path.
+// The files reference their folders by ID, so they move along without being updated.
+func (r *SQLFolderRepository) RenameFolder(username, folderPath, newFolderName string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	folderPath = models.CleanPath(folderPath)
+	var folderID, userID int64
+	var storedPath string
+	err = tx.QueryRow(`SELECT f.id, f.user_id, f.path FROM folders f JOIN users u ON u.id = f.user_id
+		WHERE u.username_key = ? AND f.path_key = ?`, r.policy.Key(username), r.policy.Key(folderPath)).Scan(&folderID, &userID, &storedPath)
+	if err == sql.ErrNoRows {
+		return customErrors.ErrFolderNotFound(folderPath)
+	} else if err != nil {
+		return err
+	}
+
+	parentPath, _ := models.SplitPath(storedPath)
+	newFolderName = r.policy.Normalize(newFolderName)
+	newFolderPath := models.JoinPath(parentPath, newFolderName)
+	_, err = tx.Exec(`UPDATE folders SET name = ?, path = ?, path_key = ? WHERE id = ?`,
+		newFolderName, newFolderPath, r.policy.Key(newFolderPath), folderID)
+	if isUniqueError(err) {
+		return customErrors.ErrFolderExists(newFolderPath)
+	} else if err != nil {
+		return err
+	}
+
+	// Re-parent the nested folders onto the new path. Folding works on every path element on its own, so the key of a
+	// nested path starts with the key of the prefix.
+	prefixKey := r.policy.Key(storedPath + "/")
+	_, err = tx.Exec(`UPDATE folders SET path = ?1 || substr(path, length(?2) + 1), path_key = ?3 || substr(path_key, length(?4) + 1)
+		WHERE user_id = ?5 AND substr(path_key, 1, length(?4)) = ?4`,
+		newFolderPath+"/", storedPath+"/", r.policy.Key(newFolderPath+"/"), prefixKey, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// filesWithin returns the files of the user inside the folder and inside the folders nested in it, given the key of the
+// path of the folder
+func filesWithin(tx *sql.Tx, userID, folderID int64, folderPathKey string) ([]models.File, error) {
+	rows, err := tx.Query(selectFiles+` WHERE f.user_id = ?1 AND (f.folder_id = ?2 OR substr(d.path_key, 1, length(?3)) = ?3)`,
+		userID, folderID, folderPathKey+"/")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// UpdateFolder replaces the description, permissions and access control list of an existing folder
+func (r *SQLFolderRepository) UpdateFolder(folder models.Folder) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	folderPath := folder.Path()
+	var folderID int64
+	err = tx.QueryRow(`SELECT f.id FROM folders f JOIN users u ON u.id = f.user_id WHERE u.username_key = ? AND f.path_key = ?`,
+		r.policy.Key(folder.Username), r.policy.Key(folderPath)).Scan(&folderID)
+	if err == sql.ErrNoRows {
+		return customErrors.ErrFolderNotFound(folderPath)
+	} else if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE folders SET description = ?, owner_id = ?, group_name = ?, mode = ? WHERE id = ?`,
+		folder.Description, folder.OwnerID, folder.Group, folder.Mode, folderID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM folder_shares WHERE folder_id = ?`, folderID); err != nil {
+		return err
+	}
+	for _, share := range folder.Shares {
+		if _, err := tx.Exec(`INSERT INTO folder_shares (folder_id, user_id, access) VALUES (?, ?, ?)`,
+			folderID, share.UserID, share.Access); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListFolders returns a slice of the folders directly inside parentPath, sorted based on the specified field and order.
+// The slice is empty if parentPath has no subfolders.
+func (r *SQLFolderRepository) ListFolders(username, parentPath, sortField, sortOrder string) ([]models.Folder, error) {
+	// Top-level folders have no parent row
+	query := selectFolders + ` WHERE u.username_key = ?1 AND f.parent_id IS NULL`
+	if parentPath = models.CleanPath(parentPath); parentPath != models.RootPath {
+		query = selectFolders + ` JOIN folders p ON p.id = f.parent_id WHERE u.username_key = ?1 AND p.path_key = ?2`
+	}
+	rows, err := r.db.Query(query, r.policy.Key(username), r.policy.Key(parentPath))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userFolders []models.Folder
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		userFolders = append(userFolders, folder)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortFolders(userFolders, sortField, sortOrder)
+	return userFolders, nil
+}
+
+// ListShared returns the folders other users share with the user and the folders the user shares with others, ordered
+// by ID
+func (r *SQLFolderRepository) ListShared(username string) ([]models.Folder, error) {
+	userID, err := lookupUserID(r.db, r.policy, username)
+	if err == sql.ErrNoRows {
+		return nil, customErrors.ErrUserNotExists(username)
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(selectFolders+` WHERE f.id IN (SELECT folder_id FROM folder_shares WHERE user_id = ?1)
+		OR (f.user_id = ?1 AND EXISTS (SELECT 1 FROM folder_shares WHERE folder_id = f.id))
+		ORDER BY f.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []models.Folder
+	for rows.Next() {
+		folder, err := scanFolder(rows)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
@@ -0,0 +1,202 @@
+// repository/sql_store.go
+
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// SQLDatabaseFileName is the name of the SQLite database inside the data directory
+const SQLDatabaseFileName = "vfs.db"
+
+// SQLStore groups the SQL repositories that keep their data together in one database inside a data directory
+type SQLStore struct {
+	Dir      string
+	DB       *sql.DB
+	Users    *SQLUserRepository
+	Folders  *SQLFolderRepository
+	Files    *SQLFileRepository
+	Trash    *SQLTrashRepository
+	Versions *SQLVersionRepository
+	Blobs    *SQLBlobRepository
+}
+
+// OpenSQLStore creates the data directory if it doesn't exist yet, opens the database inside it and migrates its schema.
+// The repositories match the names of users, folders and files with the case policy.
+func OpenSQLStore(dir string, policy models.CasePolicy) (*SQLStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := OpenSQLDatabase(filepath.Join(dir, SQLDatabaseFileName), policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLStore{
+		Dir:      dir,
+		DB:       db,
+		Users:    NewSQLUserRepository(db, policy),
+		Folders:  NewSQLFolderRepository(db, policy),
+		Files:    NewSQLFileRepository(db, policy),
+		Trash:    NewSQLTrashRepository(db),
+		Versions: NewSQLVersionRepository(db),
+		Blobs:    NewSQLBlobRepository(db),
+	}, nil
+}
+
+// Paths returns the paths of the database and of the journal files SQLite may keep next to it
+func (s *SQLStore) Paths() []string {
+	database := filepath.Join(s.Dir, SQLDatabaseFileName)
+	return []string{database, database + "-journal", database + "-wal", database + "-shm"}
+}
+
+// Validate checks the integrity of the database and that every foreign key references an existing row
+func (s *SQLStore) Validate() error {
+	database := filepath.Join(s.Dir, SQLDatabaseFileName)
+
+	var result string
+	if err := s.DB.QueryRow(`PRAGMA integrity_check`).Scan(&result); err != nil {
+		return customErrors.ErrInvalidStore(database, err)
+	}
+	if result != "ok" {
+		return customErrors.ErrInvalidStore(database, fmt.Errorf("the integrity check reported %s", result))
+	}
+
+	rows, err := s.DB.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return customErrors.ErrInvalidStore(database, err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowID, foreignKey sql.NullInt64
+		if err := rows.Scan(&table, &rowID, &parent, &foreignKey); err != nil {
+			return customErrors.ErrInvalidStore(database, err)
+		}
+		return customErrors.ErrInvalidStore(database, fmt.Errorf("a row of %s references a missing row of %s", table, parent))
+	}
+	return rows.Err()
+}
+
+// Close closes the database
+func (s *SQLStore) Close() error {
+	return s.DB.Close()
+}
+
+// orphanVersions selects the versions of files that no longer exist
+const orphanVersions = `FROM versions WHERE file_id NOT IN (SELECT id FROM files)`
+
+// blobReferences is a common table expression that counts the expected references to every blob, held by the files,
+// the versions of existing files and the files in the trash, as expected (hash, refs)
+const blobReferences = `WITH refs (hash) AS (
+		SELECT content_hash FROM files
+		UNION ALL SELECT v.hash FROM versions v JOIN files f ON f.id = v.file_id
+		UNION ALL SELECT json_extract(i.value, '$.contentHash') FROM trash t, json_each(t.items, '$.files') i
+	), expected (hash, refs) AS (
+		SELECT hash, COUNT(*) FROM refs WHERE IFNULL(hash, '') != '' GROUP BY hash
+	) `
+
+// Fsck finds the data the store keeps for entities that no longer exist. The foreign keys already remove the files of
+// deleted folders and the trash of deleted users, so only versions of missing files, blobs that belong to no file,
+// trash entry or version, and chunks that belong to no blob can be left behind. It also checks the references counted
+// by every blob and reports blobs that are referenced but missing or miss a chunk. If repair is set, the orphans are
+// removed and the references are counted again; missing blobs and chunks can't be restored.
+// It returns a description of every problem found.
+func (s *SQLStore) Fsck(repair bool) ([]string, error) {
+	s.Blobs.mu.Lock()
+	defer s.Blobs.mu.Unlock()
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var orphans []string
+	for _, query := range []struct{ query, format string }{
+		{`SELECT file_id, number, '' ` + orphanVersions, "the version %[2]s of the file with ID %[1]s belongs to no file"},
+		{blobReferences + `SELECT b.hash, '', '' FROM blobs b LEFT JOIN expected e ON e.hash = b.hash WHERE e.hash IS NULL`,
+			"the blob [%[1]s] belongs to no file"},
+		{blobReferences + `SELECT b.hash, b.refs, e.refs FROM blobs b JOIN expected e ON e.hash = b.hash WHERE b.refs != e.refs`,
+			"the blob [%[1]s] has %[2]s references instead of %[3]s"},
+		{blobReferences + `SELECT hash, '', '' FROM expected WHERE hash NOT IN (SELECT hash FROM blobs)`,
+			"the blob [%[1]s] is referenced but missing"},
+		{`SELECT bc.blob_hash, bc.chunk_hash, '' FROM blob_chunks bc LEFT JOIN chunks c ON c.hash = bc.chunk_hash WHERE c.hash IS NULL`,
+			"the blob [%[1]s] misses the chunk [%[2]s]"},
+	} {
+		rows, err := tx.Query(query.query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var values [3]string
+			if err := rows.Scan(&values[0], &values[1], &values[2]); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			orphans = append(orphans, fmt.Sprintf(query.format, values[0], values[1], values[2]))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	strays, err := strayChunks(tx, s.Blobs.held)
+	if err != nil {
+		return nil, err
+	}
+	for _, hash := range strays {
+		orphans = append(orphans, fmt.Sprintf("the chunk [%s] belongs to no blob", hash))
+	}
+
+	if !repair || len(orphans) == 0 {
+		return orphans, nil
+	}
+	for _, statement := range []string{
+		`DELETE ` + orphanVersions,
+		blobReferences + `UPDATE blobs SET refs = IFNULL((SELECT refs FROM expected e WHERE e.hash = blobs.hash), 0)`,
+		`DELETE FROM blobs WHERE refs <= 0`,
+	} {
+		if _, err := tx.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	// The chunks of the blobs removed above belong to no blob now
+	if strays, err = strayChunks(tx, s.Blobs.held); err != nil {
+		return nil, err
+	}
+	for _, hash := range strays {
+		if _, err := tx.Exec(`DELETE FROM chunks WHERE hash = ?`, hash); err != nil {
+			return nil, err
+		}
+	}
+	return orphans, tx.Commit()
+}
+
+// strayChunks returns the hashes of the chunks that no blob lists and nobody holds. Chunks held by a blob being written
+// or read belong to no blob yet, or any longer, and are left alone.
+func strayChunks(tx *sql.Tx, held heldChunks) ([]string, error) {
+	rows, err := tx.Query(`SELECT hash FROM chunks WHERE hash NOT IN (SELECT chunk_hash FROM blob_chunks) ORDER BY hash`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var strays []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		if held[hash] == 0 {
+			strays = append(strays, hash)
+		}
+	}
+	return strays, rows.Err()
+}
@@ -0,0 +1,111 @@
+// repository/sql_trash_repository.go
+
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// SQLTrashRepository handles the repository logic for the trash of every user in a SQL database.
+// Every entry row references its user through a foreign key, so deleting a user also deletes their trash.
+type SQLTrashRepository struct {
+	db *sql.DB
+}
+
+// NewSQLTrashRepository creates a new instance of SQLTrashRepository
+func NewSQLTrashRepository(db *sql.DB) *SQLTrashRepository {
+	return &SQLTrashRepository{db: db}
+}
+
+// AddTrash adds an entry to the trash of its user, assigns it the next ID and returns it
+func (r *SQLTrashRepository) AddTrash(entry models.TrashEntry) (models.TrashEntry, error) {
+	items, err := json.Marshal(newStoredTrashItems(entry))
+	if err != nil {
+		return models.TrashEntry{}, err
+	}
+	result, err := r.db.Exec(`INSERT INTO trash (user_id, deleted_by, deleted_at, path, items) VALUES (?, ?, ?, ?, ?)`,
+		entry.UserID, entry.DeletedBy, entry.DeletedAt.UnixNano(), entry.Path, string(items))
+	if err != nil {
+		return models.TrashEntry{}, err
+	}
+	id, err := result.LastInsertId()
+	entry.ID = models.ID(id)
+	return entry, err
+}
+
+// GetTrash returns the entry with the ID from the trash of the user
+func (r *SQLTrashRepository) GetTrash(userID, id models.ID) (models.TrashEntry, error) {
+	entry, err := scanTrashEntry(r.db.QueryRow(selectTrash+` WHERE user_id = ? AND id = ?`, userID, id))
+	if err == sql.ErrNoRows {
+		return models.TrashEntry{}, customErrors.ErrTrashNotFound(id.String())
+	}
+	return entry, err
+}
+
+// ListTrash returns the entries in the trash of the user, in the order they were deleted
+func (r *SQLTrashRepository) ListTrash(userID models.ID) ([]models.TrashEntry, error) {
+	return r.list(selectTrash+` WHERE user_id = ? ORDER BY id`, userID)
+}
+
+// ListExpiredTrash returns the entries of every user deleted before deletedBefore, in the order they were deleted
+func (r *SQLTrashRepository) ListExpiredTrash(deletedBefore time.Time) ([]models.TrashEntry, error) {
+	return r.list(selectTrash+` WHERE deleted_at < ? ORDER BY id`, deletedBefore.UnixNano())
+}
+
+// list returns the entries selected by the query
+func (r *SQLTrashRepository) list(query string, args ...any) ([]models.TrashEntry, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.TrashEntry
+	for rows.Next() {
+		entry, err := scanTrashEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteTrash permanently removes the entry with the ID from the trash of the user
+func (r *SQLTrashRepository) DeleteTrash(userID, id models.ID) error {
+	result, err := r.db.Exec(`DELETE FROM trash WHERE user_id = ? AND id = ?`, userID, id)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return customErrors.ErrTrashNotFound(id.String())
+	}
+	return nil
+}
+
+// selectTrash selects the columns scanned by scanTrashEntry
+const selectTrash = `SELECT id, user_id, deleted_by, deleted_at, path, items FROM trash`
+
+// scanTrashEntry scans a row selected by selectTrash into a domain trash entry
+func scanTrashEntry(row interface{ Scan(dest ...any) error }) (models.TrashEntry, error) {
+	var entry models.TrashEntry
+	var deletedAt int64
+	var data string
+	if err := row.Scan(&entry.ID, &entry.UserID, &entry.DeletedBy, &deletedAt, &entry.Path, &data); err != nil {
+		return models.TrashEntry{}, err
+	}
+	entry.DeletedAt = time.Unix(0, deletedAt)
+
+	var items storedTrashItems
+	if err := json.Unmarshal([]byte(data), &items); err != nil {
+		return models.TrashEntry{}, err
+	}
+	return entry, items.toDomain(&entry)
+}
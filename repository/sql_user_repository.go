@@ -0,0 +1,185 @@
+// repository/sql_user_repository.go
+
+package repository
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// SQLUserRepository handles the repository logic for users in a SQL database.
+// Usernames are matched by their keys under the case policy, and a unique index on the keys rejects duplicate users.
+// Folders and files reference their owner through foreign keys, so deleting a user cascades to its folders and files.
+type SQLUserRepository struct {
+	db     *sql.DB
+	policy models.CasePolicy
+}
+
+// NewSQLUserRepository creates a new instance of a SQL user repository that matches usernames with the policy
+func NewSQLUserRepository(db *sql.DB, policy models.CasePolicy) *SQLUserRepository {
+	return &SQLUserRepository{
+		db:     db,
+		policy: policy,
+	}
+}
+
+// selectUsers selects the columns scanned by scanUser
+const selectUsers = `SELECT id, username, display_name, email, password_hash, group_names, owned_group_names, created_at FROM users`
+
+// scanUser scans a row selected by selectUsers into a domain user. Users registered before profiles existed have the
+// zero creation time.
+func scanUser(row interface{ Scan(dest ...any) error }) (models.User, error) {
+	var user models.User
+	var groupNames, ownedGroupNames string
+	var createdAt int64
+	if err := row.Scan(&user.ID, &user.Username, &user.DisplayName, &user.Email, &user.PasswordHash, &groupNames, &ownedGroupNames, &createdAt); err != nil {
+		return models.User{}, err
+	}
+	user.Groups = strings.Fields(groupNames)
+	user.OwnedGroups = strings.Fields(ownedGroupNames)
+	if createdAt != 0 {
+		user.CreatedAt = time.Unix(0, createdAt)
+	}
+	return user, nil
+}
+
+// Register adds a new user to the database
+func (r *SQLUserRepository) Register(user models.User) error {
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	_, err := r.db.Exec(`INSERT INTO users (username, username_key, display_name, email, password_hash, group_names, owned_group_names, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.policy.Normalize(user.Username), r.policy.Key(user.Username), user.DisplayName, user.Email, user.PasswordHash, strings.Join(user.Groups, " "), strings.Join(user.OwnedGroups, " "), user.CreatedAt.UnixNano())
+	if isUniqueError(err) {
+		return errors.ErrUserExists(user.Username)
+	}
+	return err
+}
+
+// GetUser returns the user registered under the username
+func (r *SQLUserRepository) GetUser(username string) (models.User, error) {
+	user, err := scanUser(r.db.QueryRow(selectUsers+` WHERE username_key = ?`, r.policy.Key(username)))
+	if err == sql.ErrNoRows {
+		return models.User{}, errors.ErrUserNotExists(username)
+	}
+	return user, err
+}
+
+// Exists checks if a username already exists in the database
+func (r *SQLUserRepository) Exists(username string) (bool, error) {
+	_, err := lookupUserID(r.db, r.policy, username)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ListUsers returns all the users, sorted based on the specified field and order
+func (r *SQLUserRepository) ListUsers(sortField, sortOrder string) ([]models.User, error) {
+	rows, err := r.db.Query(selectUsers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sortUsers(users, sortField, sortOrder)
+	return users, nil
+}
+
+// UpdateUser replaces the display name, email, password hash, groups and owned groups of an existing user
+func (r *SQLUserRepository) UpdateUser(user models.User) error {
+	result, err := r.db.Exec(`UPDATE users SET display_name = ?, email = ?, password_hash = ?, group_names = ?, owned_group_names = ? WHERE username_key = ?`,
+		user.DisplayName, user.Email, user.PasswordHash, strings.Join(user.Groups, " "), strings.Join(user.OwnedGroups, " "), r.policy.Key(user.Username))
+	if err != nil {
+		return err
+	}
+
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return errors.ErrUserNotExists(user.Username)
+	}
+	return nil
+}
+
+// RenameUser changes the username of a user. Folders and files reference their user by ID, so they move along without
+// being updated.
+func (r *SQLUserRepository) RenameUser(username, newUsername string) error {
+	result, err := r.db.Exec(`UPDATE users SET username = ?, username_key = ? WHERE username_key = ?`,
+		r.policy.Normalize(newUsername), r.policy.Key(newUsername), r.policy.Key(username))
+	if isUniqueError(err) {
+		return errors.ErrUserExists(newUsername)
+	} else if err != nil {
+		return err
+	}
+
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return errors.ErrUserNotExists(username)
+	}
+	return nil
+}
+
+// DeleteUser deletes a user and returns the files it owned. The foreign keys cascade the deletion to the folders and
+// files of the user, while the folders and files the user owns in the trees of other users are handed over to the
+// users whose trees hold them.
+func (r *SQLUserRepository) DeleteUser(username string) ([]models.File, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	userID, err := lookupUserID(tx, r.policy, username)
+	if err == sql.ErrNoRows {
+		return nil, errors.ErrUserNotExists(username)
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Collect the files before the cascade removes them
+	rows, err := tx.Query(selectFiles+` WHERE f.user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deleted []models.File
+	for rows.Next() {
+		file, err := scanFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for _, table := range []string{"folders", "files"} {
+		if _, err := tx.Exec(`UPDATE `+table+` SET owner_id = user_id WHERE owner_id = ?`, userID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return nil, err
+	}
+	return deleted, tx.Commit()
+}
@@ -0,0 +1,161 @@
+// repository/sql_version_repository.go
+
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// SQLVersionRepository handles the repository logic for the versions of files in a SQL database
+type SQLVersionRepository struct {
+	db *sql.DB
+}
+
+// NewSQLVersionRepository creates a new instance of SQLVersionRepository
+func NewSQLVersionRepository(db *sql.DB) *SQLVersionRepository {
+	return &SQLVersionRepository{db: db}
+}
+
+// AddVersion adds the next version to the history of its file and prunes the versions the policy no longer keeps,
+// in a single transaction. It returns the added version and the pruned versions.
+func (r *SQLVersionRepository) AddVersion(version models.Version, policy models.VersionPolicy) (models.Version, []models.Version, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return models.Version{}, nil, err
+	}
+	defer tx.Rollback()
+
+	history, err := listVersions(tx, version.FileID)
+	if err != nil {
+		return models.Version{}, nil, err
+	}
+	version.Number = 1
+	if len(history) > 0 {
+		version.Number = history[len(history)-1].Number + 1
+	}
+	if _, err := tx.Exec(`INSERT INTO versions (file_id, number, author_id, created_at, size, hash) VALUES (?, ?, ?, ?, ?, ?)`,
+		version.FileID, version.Number, version.AuthorID, version.CreatedAt.UnixNano(), version.Size, version.Hash); err != nil {
+		return models.Version{}, nil, err
+	}
+
+	_, pruned := policy.Prune(append(history, version), version.CreatedAt)
+	for _, v := range pruned {
+		if _, err := tx.Exec(`DELETE FROM versions WHERE file_id = ? AND number = ?`, v.FileID, v.Number); err != nil {
+			return models.Version{}, nil, err
+		}
+	}
+	return version, pruned, tx.Commit()
+}
+
+// PruneVersions prunes the versions of every file the policy no longer keeps at the time now, in a single transaction,
+// and returns them
+func (r *SQLVersionRepository) PruneVersions(policy models.VersionPolicy, now time.Time) ([]models.Version, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Group the versions by file, in the order of their numbers
+	rows, err := tx.Query(selectVersions + ` ORDER BY file_id, number`)
+	if err != nil {
+		return nil, err
+	}
+	var histories [][]models.Version
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if n := len(histories); n == 0 || histories[n-1][0].FileID != version.FileID {
+			histories = append(histories, nil)
+		}
+		histories[len(histories)-1] = append(histories[len(histories)-1], version)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var pruned []models.Version
+	for _, history := range histories {
+		_, prunedVersions := policy.Prune(history, now)
+		for _, v := range prunedVersions {
+			if _, err := tx.Exec(`DELETE FROM versions WHERE file_id = ? AND number = ?`, v.FileID, v.Number); err != nil {
+				return nil, err
+			}
+		}
+		pruned = append(pruned, prunedVersions...)
+	}
+	return pruned, tx.Commit()
+}
+
+// GetVersion returns the version of the file with the number
+func (r *SQLVersionRepository) GetVersion(fileID models.ID, number int) (models.Version, error) {
+	version, err := scanVersion(r.db.QueryRow(selectVersions+` WHERE file_id = ? AND number = ?`, fileID, number))
+	if err == sql.ErrNoRows {
+		return models.Version{}, customErrors.ErrVersionNotFound(fileID.String(), number)
+	}
+	return version, err
+}
+
+// ListVersions returns the versions of the file ordered by number
+func (r *SQLVersionRepository) ListVersions(fileID models.ID) ([]models.Version, error) {
+	return listVersions(r.db, fileID)
+}
+
+// DeleteVersions removes the whole history of the file and returns the removed versions
+func (r *SQLVersionRepository) DeleteVersions(fileID models.ID) ([]models.Version, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	versions, err := listVersions(tx, fileID)
+	if err != nil || len(versions) == 0 {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM versions WHERE file_id = ?`, fileID); err != nil {
+		return nil, err
+	}
+	return versions, tx.Commit()
+}
+
+// selectVersions selects the columns scanned by scanVersion
+const selectVersions = `SELECT file_id, number, author_id, created_at, size, hash FROM versions`
+
+// listVersions returns the versions of the file ordered by number
+func listVersions(q querier, fileID models.ID) ([]models.Version, error) {
+	rows, err := q.Query(selectVersions+` WHERE file_id = ? ORDER BY number`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.Version
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// scanVersion scans a row selected by selectVersions into a domain version
+func scanVersion(row interface{ Scan(dest ...any) error }) (models.Version, error) {
+	var version models.Version
+	var createdAt int64
+	if err := row.Scan(&version.FileID, &version.Number, &version.AuthorID, &createdAt, &version.Size, &version.Hash); err != nil {
+		return models.Version{}, err
+	}
+	version.CreatedAt = time.Unix(0, createdAt)
+	return version, nil
+}
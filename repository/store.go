@@ -0,0 +1,450 @@
+// repository/store.go
+
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// Names of the files and directories that make up a store inside its data directory
+const (
+	UsersFileName    = "users.json"
+	FoldersFileName  = "folders.txt"
+	FilesFileName    = "files.txt"
+	TrashFileName    = "trash.json"
+	VersionsFileName = "versions.json"
+	BlobsFileName    = "blobs.json"
+	BlobsLogFileName = "blobs.log"
+	ChunksDirName    = "chunks"
+	IDsFileName      = "ids.json"
+)
+
+// Store groups the file-based repositories that keep their data together in one data directory
+type Store struct {
+	Dir      string
+	Users    *FileUserRepository
+	Folders  *FileFolderRepository
+	Files    *FileRepository
+	Trash    *FileTrashRepository
+	Versions *FileVersionRepository
+	Blobs    *FileBlobRepository
+}
+
+// OpenStore creates the data directory if it doesn't exist yet and returns the repositories stored inside it.
+// A change spanning several files that was interrupted by a crash is finished from its journal, and temporary files
+// left behind by interrupted writes are removed; the files they were meant to replace are still intact, so the store
+// recovers to its last complete state. A store written before entities had IDs, before users had profiles, before
+// contents were stored as blobs or before blobs were split into chunks is upgraded.
+// The repositories match the names of users, folders and files with the case policy.
+func OpenStore(dir string, policy models.CasePolicy) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := recoverJournal(dir); err != nil {
+		return nil, customErrors.ErrInvalidStore(filepath.Join(dir, JournalFileName), err)
+	}
+	for _, d := range []string{dir, filepath.Join(dir, ChunksDirName)} {
+		if err := removeTempFiles(d); err != nil {
+			return nil, err
+		}
+	}
+	if err := upgradeStore(dir); err != nil {
+		return nil, customErrors.ErrInvalidStore(dir, err)
+	}
+
+	users := NewFileUserRepository(filepath.Join(dir, UsersFileName), policy)
+	files := NewFileRepository(filepath.Join(dir, FilesFileName))
+	return &Store{
+		Dir:      dir,
+		Users:    users,
+		Folders:  NewFileFolderRepository(filepath.Join(dir, FoldersFileName), users, files),
+		Files:    files,
+		Trash:    NewFileTrashRepository(filepath.Join(dir, TrashFileName)),
+		Versions: NewFileVersionRepository(filepath.Join(dir, VersionsFileName)),
+		Blobs:    NewFileBlobRepository(filepath.Join(dir, ChunksDirName), filepath.Join(dir, BlobsFileName), filepath.Join(dir, BlobsLogFileName)),
+	}, nil
+}
+
+// Paths returns the paths of all the files and directories that make up the store
+func (s *Store) Paths() []string {
+	return []string{
+		filepath.Join(s.Dir, UsersFileName),
+		filepath.Join(s.Dir, FoldersFileName),
+		filepath.Join(s.Dir, FilesFileName),
+		filepath.Join(s.Dir, TrashFileName),
+		filepath.Join(s.Dir, VersionsFileName),
+		filepath.Join(s.Dir, BlobsFileName),
+		filepath.Join(s.Dir, BlobsLogFileName),
+		filepath.Join(s.Dir, IDsFileName),
+		filepath.Join(s.Dir, JournalFileName),
+		filepath.Join(s.Dir, ChunksDirName),
+	}
+}
+
+// Close releases the store. The file-based repositories hold no open resources, so there is nothing to release.
+func (s *Store) Close() error {
+	return nil
+}
+
+// Validate loads every repository of the store and checks that the stored data is well-formed and consistent:
+// IDs must be unique, names must be valid and unique inside their folder, every folder must belong to a registered
+// user and an existing parent folder without being nested inside itself, and every file must belong to a registered
+// user. Files left behind by a deleted folder are tolerated; Fsck finds and removes them. Trash entries must have
+// unique IDs and hold well-formed folders and files. The versions of a file must have unique positive numbers, and every
+// blob must be made of as many chunks as its size takes. The file of the IDs handed out must be well-formed.
+// Names are compared with the case policy of the store, so names that only differ in case are rejected unless the
+// policy is case sensitive.
+func (s *Store) Validate() error {
+	usersPath := filepath.Join(s.Dir, UsersFileName)
+	foldersPath := filepath.Join(s.Dir, FoldersFileName)
+	filesPath := filepath.Join(s.Dir, FilesFileName)
+
+	// Validate the users
+	s.Users.mu.RLock()
+	users, err := s.Users.loadUsers()
+	s.Users.mu.RUnlock()
+	if err != nil {
+		return customErrors.ErrInvalidStore(usersPath, err)
+	}
+	registered := make(map[models.ID]bool, len(users))
+	usernames := make(map[string]bool, len(users))
+	for _, user := range users {
+		if err := models.CheckName(user.Username); err != nil {
+			return customErrors.ErrInvalidStore(usersPath, err)
+		}
+		if usernames[s.Users.policy.Key(user.Username)] {
+			return customErrors.ErrInvalidStore(usersPath, fmt.Errorf("the user [%s] is registered twice", user.Username))
+		}
+		if user.ID <= 0 || registered[user.ID] {
+			return customErrors.ErrInvalidStore(usersPath, fmt.Errorf("the user [%s] has the invalid or duplicate ID %d", user.Username, user.ID))
+		}
+		usernames[s.Users.policy.Key(user.Username)] = true
+		registered[user.ID] = true
+	}
+
+	// Validate the folders
+	s.Folders.mu.RLock()
+	folders, err := s.Folders.loadFolders()
+	s.Folders.mu.RUnlock()
+	if err != nil {
+		return customErrors.ErrInvalidStore(foldersPath, err)
+	}
+	byID := make(map[models.ID]storedFolder, len(folders))
+	for _, f := range folders {
+		if _, ok := byID[f.ID]; ok || f.ID <= 0 {
+			return customErrors.ErrInvalidStore(foldersPath, fmt.Errorf("the folder [%s] has the invalid or duplicate ID %d", f.Name, f.ID))
+		}
+		if _, err := f.toDomain(); err != nil {
+			return customErrors.ErrInvalidStore(foldersPath, err)
+		}
+		byID[f.ID] = f
+	}
+	siblings := make(map[string]bool, len(folders))
+	for _, f := range folders {
+		key := fmt.Sprintf("%d/%d/%s", f.UserID, f.ParentID, s.Users.policy.Key(f.Name))
+		parent, parentExists := byID[f.ParentID]
+		switch {
+		case !registered[f.UserID]:
+			return customErrors.ErrInvalidStore(foldersPath, fmt.Errorf("the folder [%s] belongs to the missing user with ID %d", f.Name, f.UserID))
+		case models.CheckName(f.Name) != nil:
+			return customErrors.ErrInvalidStore(foldersPath, models.CheckName(f.Name))
+		case siblings[key]:
+			return customErrors.ErrInvalidStore(foldersPath, customErrors.ErrFolderExists(f.Name))
+		case f.ParentID != 0 && (!parentExists || parent.UserID != f.UserID):
+			return customErrors.ErrInvalidStore(foldersPath, fmt.Errorf("the folder [%s] belongs to the missing folder with ID %d", f.Name, f.ParentID))
+		}
+		siblings[key] = true
+
+		// Walking up from a folder must reach the root before visiting every folder
+		parentID := f.ParentID
+		for steps := 0; parentID != 0; steps++ {
+			if steps == len(folders) {
+				return customErrors.ErrInvalidStore(foldersPath, fmt.Errorf("the folder [%s] is nested inside itself", f.Name))
+			}
+			parentID = byID[parentID].ParentID
+		}
+	}
+
+	// Validate the files
+	s.Files.mu.RLock()
+	files, err := s.Files.loadFiles()
+	s.Files.mu.RUnlock()
+	if err != nil {
+		return customErrors.ErrInvalidStore(filesPath, err)
+	}
+	fileIDs := make(map[models.ID]bool, len(files))
+	seenFiles := make(map[string]bool, len(files))
+	for _, f := range files {
+		if _, err := f.toDomain("", ""); err != nil {
+			return customErrors.ErrInvalidStore(filesPath, err)
+		}
+		key := fmt.Sprintf("%d/%d/%s", f.UserID, f.FolderID, s.Users.policy.Key(f.Name))
+		switch {
+		case f.ID <= 0 || fileIDs[f.ID]:
+			return customErrors.ErrInvalidStore(filesPath, fmt.Errorf("the file [%s] has the invalid or duplicate ID %d", f.Name, f.ID))
+		case !registered[f.UserID]:
+			return customErrors.ErrInvalidStore(filesPath, fmt.Errorf("the file [%s] belongs to the missing user with ID %d", f.Name, f.UserID))
+		case models.CheckName(f.Name) != nil:
+			return customErrors.ErrInvalidStore(filesPath, models.CheckName(f.Name))
+		case seenFiles[key]:
+			return customErrors.ErrInvalidStore(filesPath, customErrors.ErrFileExists(f.Name))
+		}
+		fileIDs[f.ID] = true
+		seenFiles[key] = true
+	}
+
+	// Validate the trash
+	trashPath := filepath.Join(s.Dir, TrashFileName)
+	s.Trash.mu.RLock()
+	entries, err := s.Trash.loadTrash()
+	s.Trash.mu.RUnlock()
+	if err != nil {
+		return customErrors.ErrInvalidStore(trashPath, err)
+	}
+	entryIDs := make(map[models.ID]bool, len(entries))
+	for _, e := range entries {
+		if e.ID <= 0 || entryIDs[e.ID] {
+			return customErrors.ErrInvalidStore(trashPath, fmt.Errorf("the trash entry [%s] has the invalid or duplicate ID %d", e.Path, e.ID))
+		}
+		if _, err := e.toDomain(); err != nil {
+			return customErrors.ErrInvalidStore(trashPath, err)
+		}
+		entryIDs[e.ID] = true
+	}
+
+	// Validate the versions
+	versionsPath := filepath.Join(s.Dir, VersionsFileName)
+	s.Versions.mu.RLock()
+	versions, err := s.Versions.loadVersions()
+	s.Versions.mu.RUnlock()
+	if err != nil {
+		return customErrors.ErrInvalidStore(versionsPath, err)
+	}
+	numbers := make(map[string]bool, len(versions))
+	for _, v := range versions {
+		key := fmt.Sprintf("%d/%d", v.FileID, v.Number)
+		if v.Number <= 0 || numbers[key] {
+			return customErrors.ErrInvalidStore(versionsPath, fmt.Errorf("the file with ID %d has the invalid or duplicate version %d", v.FileID, v.Number))
+		}
+		numbers[key] = true
+	}
+
+	// Validate the blobs
+	s.Blobs.mu.RLock()
+	blobs, err := s.Blobs.loadBlobs()
+	s.Blobs.mu.RUnlock()
+	if err != nil {
+		return customErrors.ErrInvalidStore(s.Blobs.indexPath, err)
+	}
+	for hash, b := range blobs {
+		if b.Size < 0 || int64(len(b.Chunks)) != (b.Size+ChunkSize-1)/ChunkSize {
+			return customErrors.ErrInvalidStore(s.Blobs.indexPath, fmt.Errorf("the blob [%s] of %d bytes is made of %d chunks", hash, b.Size, len(b.Chunks)))
+		}
+	}
+
+	// Validate the IDs handed out
+	idsPath := filepath.Join(s.Dir, IDsFileName)
+	idsMu.Lock()
+	_, err = loadIDs(idsPath)
+	idsMu.Unlock()
+	if err != nil {
+		return customErrors.ErrInvalidStore(idsPath, err)
+	}
+
+	return nil
+}
+
+// Fsck finds the data the store keeps for entities that no longer exist: files of a missing folder or of an
+// unregistered user, trash entries of an unregistered user, versions of a missing file, blobs that belong to no file,
+// trash entry or version, and chunks that belong to no blob. Such orphans were left behind by folders deleted before
+// folder deletion removed the files inside them, or by a crash in the middle of a deletion. It also checks the
+// references counted by every blob and reports blobs that are referenced but missing or miss a chunk. If repair is
+// set, the orphans are removed and the references are counted again; missing blobs and chunks can't be restored.
+// It returns a description of every problem found.
+func (s *Store) Fsck(repair bool) ([]string, error) {
+	s.Users.mu.RLock()
+	defer s.Users.mu.RUnlock()
+	s.Folders.mu.RLock()
+	defer s.Folders.mu.RUnlock()
+	s.Files.mu.Lock()
+	defer s.Files.mu.Unlock()
+	s.Trash.mu.Lock()
+	defer s.Trash.mu.Unlock()
+	s.Versions.mu.Lock()
+	defer s.Versions.mu.Unlock()
+	s.Blobs.mu.Lock()
+	defer s.Blobs.mu.Unlock()
+
+	users, err := s.Users.loadUsers()
+	if err != nil {
+		return nil, err
+	}
+	registered := make(map[models.ID]bool, len(users))
+	for _, user := range users {
+		registered[user.ID] = true
+	}
+	folders, err := s.Folders.loadFolders()
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[models.ID]models.ID, len(folders))
+	for _, f := range folders {
+		owners[f.ID] = f.UserID
+	}
+	files, err := s.Files.loadFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the files whose user or folder is missing, counting the references of the remaining data to every blob
+	var orphans []string
+	expected := make(map[string]int, len(files))
+	reference := func(hash string) {
+		if hash != "" {
+			expected[hash]++
+		}
+	}
+	fileIDs := make(map[models.ID]bool, len(files))
+	remaining := files[:0]
+	for _, f := range files {
+		switch owner, ok := owners[f.FolderID]; {
+		case !registered[f.UserID]:
+			orphans = append(orphans, fmt.Sprintf("the file [%s] with ID %d belongs to the missing user with ID %d", f.Name, f.ID, f.UserID))
+		case f.FolderID != 0 && (!ok || owner != f.UserID):
+			orphans = append(orphans, fmt.Sprintf("the file [%s] with ID %d belongs to the missing folder with ID %d", f.Name, f.ID, f.FolderID))
+		default:
+			reference(f.ContentHash)
+			fileIDs[f.ID] = true
+			remaining = append(remaining, f)
+		}
+	}
+	fileOrphans := len(orphans)
+
+	// Find the trash entries whose user is missing
+	trash, err := s.Trash.loadTrash()
+	if err != nil {
+		return nil, err
+	}
+	remainingEntries := trash[:0]
+	for _, e := range trash {
+		if !registered[e.UserID] {
+			orphans = append(orphans, fmt.Sprintf("the trash entry [%s] with ID %d belongs to the missing user with ID %d", e.Path, e.ID, e.UserID))
+			continue
+		}
+		for _, f := range e.Files {
+			reference(f.ContentHash)
+		}
+		remainingEntries = append(remainingEntries, e)
+	}
+	trashOrphans := len(orphans) - fileOrphans
+
+	// Find the versions whose file is missing
+	versions, err := s.Versions.loadVersions()
+	if err != nil {
+		return nil, err
+	}
+	remainingVersions := versions[:0]
+	for _, v := range versions {
+		if !fileIDs[v.FileID] {
+			orphans = append(orphans, fmt.Sprintf("the version %d of the file with ID %d belongs to no file", v.Number, v.FileID))
+			continue
+		}
+		reference(v.Hash)
+		remainingVersions = append(remainingVersions, v)
+	}
+	versionOrphans := len(orphans) - fileOrphans - trashOrphans
+
+	// Compare the stored blobs and their references with the references of the remaining data
+	blobs, err := s.Blobs.loadBlobs()
+	if err != nil {
+		return nil, err
+	}
+	listed := listedChunks(blobs) // the chunks of orphaned blobs are removed with them, so they aren't reported
+	hashes := make([]string, 0, len(blobs))
+	for hash := range blobs {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		b := blobs[hash]
+		switch {
+		case expected[hash] == 0:
+			orphans = append(orphans, fmt.Sprintf("the blob [%s] belongs to no file", hash))
+			delete(blobs, hash)
+			continue
+		case b.Refs != expected[hash]:
+			orphans = append(orphans, fmt.Sprintf("the blob [%s] has %d references instead of %d", hash, b.Refs, expected[hash]))
+			b.Refs = expected[hash]
+			blobs[hash] = b
+		}
+	}
+	for hash := range expected {
+		if _, ok := blobs[hash]; !ok {
+			orphans = append(orphans, fmt.Sprintf("the blob [%s] is referenced but missing", hash))
+		}
+	}
+
+	// Compare the stored chunks with the chunks the remaining blobs are made of
+	entries, err := os.ReadDir(s.Blobs.dirPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	stored := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		hash := entry.Name()
+		if entry.IsDir() || strings.Contains(hash, tempFileMarker) {
+			continue
+		}
+		stored[hash] = true
+		if !listed[hash] && s.Blobs.held[hash] == 0 {
+			orphans = append(orphans, fmt.Sprintf("the chunk [%s] belongs to no blob", hash))
+		}
+	}
+	for _, hash := range hashes {
+		for _, chunk := range blobs[hash].Chunks {
+			if !stored[chunk] {
+				orphans = append(orphans, fmt.Sprintf("the blob [%s] misses the chunk [%s]", hash, chunk))
+			}
+		}
+	}
+	blobProblems := len(orphans) - fileOrphans - trashOrphans - versionOrphans
+
+	if !repair {
+		return orphans, nil
+	}
+	if fileOrphans > 0 {
+		if err := s.Files.saveFiles(remaining); err != nil {
+			return nil, err
+		}
+	}
+	if trashOrphans > 0 {
+		if err := s.Trash.saveTrash(remainingEntries); err != nil {
+			return nil, err
+		}
+	}
+	if versionOrphans > 0 {
+		if err := s.Versions.saveVersions(remainingVersions); err != nil {
+			return nil, err
+		}
+	}
+	if blobProblems > 0 {
+		if err := s.Blobs.saveBlobs(blobs); err != nil {
+			return nil, err
+		}
+		garbage, err := s.Blobs.garbage(blobs)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Blobs.removeChunks(garbage); err != nil {
+			return nil, err
+		}
+	}
+	return orphans, nil
+}
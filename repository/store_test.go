@@ -0,0 +1,459 @@
+package repository_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/repository"
+)
+
+// TestStoreRecovery tests that a store recovers its last complete state after writes were interrupted by a crash
+func TestStoreRecovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		testFunc func(t *testing.T, dir string)
+	}{
+		{
+			name: "WritesLeaveNoTempFiles",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.NoError(t, store.Folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "folder1", CreatedAt: time.Now()}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/folder1", Name: "file1", CreatedAt: time.Now()}))
+				file, err := store.Files.GetFile("user1", "/folder1", "file1")
+				assert.NoError(t, err)
+				file.ContentHash, err = store.Blobs.PutBlob([]byte("hello"))
+				assert.NoError(t, err)
+				assert.NoError(t, store.Files.UpdateFile(file))
+
+				for _, d := range []string{dir, filepath.Join(dir, repository.ChunksDirName)} {
+					matches, err := filepath.Glob(filepath.Join(d, "*.tmp-*"))
+					assert.NoError(t, err)
+					assert.Empty(t, matches)
+				}
+			},
+		},
+		{
+			name: "InterruptedWritesAreDiscarded",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.NoError(t, store.Folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "folder1", CreatedAt: time.Now()}))
+
+				// Simulate a crash in the middle of rewriting the folders
+				torn := filepath.Join(dir, repository.FoldersFileName+".tmp-123")
+				assert.NoError(t, os.WriteFile(torn, []byte(`[{"name":"fol`), 0644))
+
+				store, err = repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoFileExists(t, torn)
+				assert.NoError(t, store.Validate())
+
+				exists, err := store.Folders.Exists("user1", "/folder1")
+				assert.NoError(t, err)
+				assert.True(t, exists)
+			},
+		},
+		{
+			name: "InterruptedFolderDeletionIsFinished",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.NoError(t, store.Folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "folder1", CreatedAt: time.Now()}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/folder1", Name: "file1", CreatedAt: time.Now()}))
+
+				// Simulate a crash after journaling a recursive deletion, before replacing the folders and files
+				journal, err := json.Marshal(map[string][]byte{
+					repository.FoldersFileName: []byte(`[]`),
+					repository.FilesFileName:   []byte(`[]`),
+				})
+				assert.NoError(t, err)
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, repository.JournalFileName), journal, 0644))
+
+				store, err = repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoFileExists(t, filepath.Join(dir, repository.JournalFileName))
+				exists, err := store.Folders.Exists("user1", "/folder1")
+				assert.NoError(t, err)
+				assert.False(t, exists)
+				_, err = store.Files.GetFile("user1", "/folder1", "file1")
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+			},
+		},
+		{
+			name: "FsckRepairsOrphans",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.NoError(t, store.Folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "folder1", CreatedAt: time.Now()}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/folder1", Name: "file1", CreatedAt: time.Now()}))
+				kept, err := store.Files.GetFile("user1", "/folder1", "file1")
+				assert.NoError(t, err)
+				kept.ContentHash, err = store.Blobs.PutBlob([]byte("kept"))
+				assert.NoError(t, err)
+				assert.NoError(t, store.Files.UpdateFile(kept))
+
+				// Leave behind a file of a deleted folder and its blob, as deletions did before they cascaded
+				assert.NoError(t, store.Folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "deleted", CreatedAt: time.Now()}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/deleted", Name: "file2", CreatedAt: time.Now()}))
+				orphan, err := store.Files.GetFile("user1", "/deleted", "file2")
+				assert.NoError(t, err)
+				orphan.ContentHash, err = store.Blobs.PutBlob([]byte("orphan"))
+				assert.NoError(t, err)
+				assert.NoError(t, store.Files.UpdateFile(orphan))
+				dropFolder(t, dir, orphan.FolderID)
+
+				orphans, err := store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Len(t, orphans, 2)
+				orphans, err = store.Fsck(true)
+				assert.NoError(t, err)
+				assert.Len(t, orphans, 2)
+
+				orphans, err = store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Empty(t, orphans)
+				files, err := store.Files.ListFiles("user1", "/", "", "")
+				assert.NoError(t, err)
+				assert.Empty(t, files)
+				data, err := store.Blobs.GetBlob(kept.ContentHash)
+				assert.NoError(t, err)
+				assert.Equal(t, "kept", string(data))
+				_, err = store.Blobs.GetBlob(orphan.ContentHash)
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+			},
+		},
+		{
+			name: "UpgradeAssignsIDs",
+			testFunc: func(t *testing.T, dir string) {
+				// Write a store in the format used before users, folders and files had IDs
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, "users.txt"), []byte("user1\nuser2\n"), 0644))
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, repository.FoldersFileName), []byte(`[
+					{"name":"2024","parent":"/folder1","username":"user1"},
+					{"name":"folder1","parent":"/","username":"user1"}
+				]`), 0644))
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, repository.FilesFileName), []byte(`[
+					{"username":"user1","folderPath":"/folder1/2024","name":"file1","size":5,"createdAt":"2024-01-02T03:04:05","modifiedAt":"2024-01-02T03:04:05"}
+				]`), 0644))
+				writeLegacyContent(t, dir, "/user1/folder1/2024/file1", "hello")
+
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Validate())
+				exists, err := store.Users.Exists("user2")
+				assert.NoError(t, err)
+				assert.True(t, exists)
+				file, err := store.Files.GetFile("user1", "/folder1/2024", "file1")
+				assert.NoError(t, err)
+				assert.NotZero(t, file.ID)
+				assert.Equal(t, int64(5), file.Size)
+				data, err := store.Blobs.GetBlob(file.ContentHash)
+				assert.NoError(t, err)
+				assert.Equal(t, "hello", string(data))
+				assert.NoDirExists(t, filepath.Join(dir, "contents"))
+
+				// The upgraded store is left as it is when opened again
+				orphans, err := store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Empty(t, orphans)
+				store, err = repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				reopened, err := store.Files.GetFile("user1", "/folder1/2024", "file1")
+				assert.NoError(t, err)
+				assert.Equal(t, file.ID, reopened.ID)
+			},
+		},
+		{
+			name: "UpgradeStoresContentsAsBlobs",
+			testFunc: func(t *testing.T, dir string) {
+				// Write a store in the format used before contents were stored as blobs
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				user, err := store.Users.GetUser("user1")
+				assert.NoError(t, err)
+				for _, name := range []string{"file1", "file2"} {
+					assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: name, Size: 5, CreatedAt: time.Now()}))
+				}
+				file1, err := store.Files.GetFile("user1", "/", "file1")
+				assert.NoError(t, err)
+				file2, err := store.Files.GetFile("user1", "/", "file2")
+				assert.NoError(t, err)
+				hello := models.HashContent([]byte("hello"))
+				_, _, err = store.Versions.AddVersion(models.Version{FileID: file1.ID, Number: 1, CreatedAt: time.Now(), Size: 5, Hash: hello}, models.DefaultVersionPolicy())
+				assert.NoError(t, err)
+				deleted := models.File{ID: 7, UserID: user.ID, Username: "user1", FolderPath: "/", Name: "old", CreatedAt: time.Now()}
+				entry, err := store.Trash.AddTrash(models.TrashEntry{UserID: user.ID, DeletedBy: user.ID, DeletedAt: time.Now(), Path: "/old", Files: []models.File{deleted}})
+				assert.NoError(t, err)
+				writeLegacyContent(t, dir, file1.ID.String(), "hello")
+				writeLegacyContent(t, dir, file2.ID.String(), "hello")
+				writeLegacyContent(t, dir, fmt.Sprintf("version:%d:1", file1.ID), "hello")
+				writeLegacyContent(t, dir, fmt.Sprintf("trash:%d:7", entry.ID), "bye")
+
+				store, err = repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Validate())
+				assert.NoDirExists(t, filepath.Join(dir, "contents"))
+				for _, name := range []string{"file1", "file2"} {
+					file, err := store.Files.GetFile("user1", "/", name)
+					assert.NoError(t, err)
+					assert.Equal(t, hello, file.ContentHash)
+				}
+				entries, err := store.Trash.ListTrash(user.ID)
+				assert.NoError(t, err)
+				if assert.Len(t, entries, 1) && assert.Len(t, entries[0].Files, 1) {
+					data, err := store.Blobs.GetBlob(entries[0].Files[0].ContentHash)
+					assert.NoError(t, err)
+					assert.Equal(t, "bye", string(data))
+				}
+
+				// Equal contents share a blob that counts every reference
+				blobs, err := os.ReadDir(filepath.Join(dir, repository.ChunksDirName))
+				assert.NoError(t, err)
+				assert.Len(t, blobs, 2)
+				orphans, err := store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Empty(t, orphans)
+			},
+		},
+		{
+			name: "UpgradeSplitsBlobsIntoChunks",
+			testFunc: func(t *testing.T, dir string) {
+				// Write a store in the format used before blobs were split into chunks
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				content := bytes.Repeat([]byte("a"), repository.ChunkSize+5)
+				hash := models.HashContent(content)
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "file1", Size: int64(len(content)), ContentHash: hash, CreatedAt: time.Now()}))
+				assert.NoError(t, os.MkdirAll(filepath.Join(dir, "blobs"), 0755))
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, "blobs", hash), content, 0644))
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, repository.BlobsFileName), []byte(fmt.Sprintf(`{"%s":1}`, hash)), 0644))
+
+				store, err = repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Validate())
+				assert.NoDirExists(t, filepath.Join(dir, "blobs"))
+				data, err := store.Blobs.GetBlob(hash)
+				assert.NoError(t, err)
+				assert.Equal(t, content, data)
+				chunks, err := os.ReadDir(filepath.Join(dir, repository.ChunksDirName))
+				assert.NoError(t, err)
+				assert.Len(t, chunks, 2)
+				orphans, err := store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Empty(t, orphans)
+			},
+		},
+		{
+			name: "FsckRecountsBlobReferences",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				hash, err := store.Blobs.PutBlob([]byte("hello"))
+				assert.NoError(t, err)
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "file1", ContentHash: hash, CreatedAt: time.Now()}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "file2", ContentHash: hash, CreatedAt: time.Now()}))
+
+				// The second file never retained the blob, e.g. after a crash, so releasing it must not remove the blob
+				orphans, err := store.Fsck(true)
+				assert.NoError(t, err)
+				assert.Len(t, orphans, 1)
+				assert.NoError(t, store.Blobs.ReleaseBlob(hash))
+				_, err = store.Blobs.CollectGarbage()
+				assert.NoError(t, err)
+				data, err := store.Blobs.GetBlob(hash)
+				assert.NoError(t, err)
+				assert.Equal(t, "hello", string(data))
+
+				// A blob that misses a chunk can't be repaired, while its references are counted again
+				assert.NoError(t, os.Remove(filepath.Join(dir, repository.ChunksDirName, hash)))
+				orphans, err = store.Fsck(true)
+				assert.NoError(t, err)
+				assert.Len(t, orphans, 2)
+				orphans, err = store.Fsck(false)
+				assert.NoError(t, err)
+				assert.Len(t, orphans, 1)
+			},
+		},
+		{
+			name: "BlobReferencesAreLogged",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				var hash string
+				for i := 0; i < 20; i++ {
+					hash, err = store.Blobs.PutBlob([]byte(fmt.Sprint("content", i)))
+					assert.NoError(t, err)
+				}
+				indexPath, logPath := filepath.Join(dir, repository.BlobsFileName), filepath.Join(dir, repository.BlobsLogFileName)
+				index, err := os.ReadFile(indexPath)
+				assert.NoError(t, err)
+
+				// Changing the references only appends to the log, which a reopened store replays
+				assert.NoError(t, store.Blobs.RetainBlob(hash))
+				assert.NoError(t, store.Blobs.RetainBlob(hash))
+				assert.NoError(t, store.Blobs.ReleaseBlob(hash))
+				unchanged, err := os.ReadFile(indexPath)
+				assert.NoError(t, err)
+				assert.Equal(t, index, unchanged)
+				assert.FileExists(t, logPath)
+
+				store, err = repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Validate())
+				for _, expected := range []int{0, 1} {
+					assert.NoError(t, store.Blobs.ReleaseBlob(hash))
+					removed, err := store.Blobs.CollectGarbage()
+					assert.NoError(t, err)
+					assert.Equal(t, expected, removed) // the blob had 2 references
+				}
+
+				// Collecting garbage compacts the log into the index
+				assert.NoFileExists(t, logPath)
+			},
+		},
+		{
+			name: "InterruptedBlobLogWritesAreDiscarded",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				var hash string
+				for i := 0; i < 20; i++ {
+					hash, err = store.Blobs.PutBlob([]byte(fmt.Sprint("content", i)))
+					assert.NoError(t, err)
+				}
+				logPath := filepath.Join(dir, repository.BlobsLogFileName)
+				assert.NoError(t, store.Blobs.RetainBlob(hash))
+				assert.NoError(t, store.Blobs.RetainBlob(hash))
+				stale, err := os.ReadFile(logPath)
+				assert.NoError(t, err)
+
+				// Simulate a crash after the log was compacted into the index but before it was removed
+				assert.NoError(t, store.Blobs.ReleaseBlob(hash))
+				assert.NoError(t, store.Blobs.ReleaseBlob(hash))
+				_, err = store.Blobs.CollectGarbage()
+				assert.NoError(t, err)
+				assert.NoError(t, os.WriteFile(logPath, stale, 0644))
+
+				// Simulate a crash in the middle of appending a record
+				store, err = repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Blobs.RetainBlob(hash))
+				f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0644)
+				assert.NoError(t, err)
+				_, err = f.WriteString(`{"hash":"` + hash)
+				assert.NoError(t, err)
+				assert.NoError(t, f.Close())
+
+				store, err = repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Validate())
+				for _, expected := range []int{0, 1} {
+					assert.NoError(t, store.Blobs.ReleaseBlob(hash))
+					removed, err := store.Blobs.CollectGarbage()
+					assert.NoError(t, err)
+					assert.Equal(t, expected, removed) // the blob had 2 references
+				}
+			},
+		},
+		{
+			name: "UpgradeConvertsUsersFile",
+			testFunc: func(t *testing.T, dir string) {
+				// Write the users file used before users had profiles
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, "users.txt"), []byte("1 user1\n3 user2\n"), 0644))
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, repository.FilesFileName), []byte(`[
+					{"id":1,"userId":3,"folderId":0,"name":"file1","createdAt":"2024-01-02T03:04:05"}
+				]`), 0644))
+
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Validate())
+				_, err = os.Stat(filepath.Join(dir, "users.txt"))
+				assert.True(t, os.IsNotExist(err))
+				user, err := store.Users.GetUser("user2")
+				assert.NoError(t, err)
+				assert.Equal(t, models.ID(3), user.ID)
+				assert.True(t, user.CreatedAt.IsZero())
+				_, err = store.Files.GetFile("user2", "/", "file1")
+				assert.NoError(t, err)
+
+				// New users get the next ID
+				assert.NoError(t, store.Users.Register(models.User{Username: "user3"}))
+				user, err = store.Users.GetUser("user3")
+				assert.NoError(t, err)
+				assert.Equal(t, models.ID(4), user.ID)
+			},
+		},
+		{
+			name: "ValidateAppliesTheCasePolicy",
+			testFunc: func(t *testing.T, dir string) {
+				store, err := repository.OpenStore(dir, models.CaseSensitive)
+				assert.NoError(t, err)
+				assert.NoError(t, store.Users.Register(models.User{Username: "user1"}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "file1", CreatedAt: time.Now()}))
+				assert.NoError(t, store.Files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "FILE1", CreatedAt: time.Now()}))
+				assert.NoError(t, store.Validate())
+
+				// The names collide once they are matched regardless of case
+				store, err = repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.ErrorIs(t, store.Validate(), customErrors.ErrCorrupt)
+			},
+		},
+		{
+			name: "ValidateRejectsCorruptStore",
+			testFunc: func(t *testing.T, dir string) {
+				assert.NoError(t, os.WriteFile(filepath.Join(dir, repository.FilesFileName), []byte(`[{"name":"fil`), 0644))
+
+				store, err := repository.OpenStore(dir, models.CasePreserving)
+				assert.NoError(t, err)
+				assert.ErrorIs(t, store.Validate(), customErrors.ErrCorrupt)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.testFunc(t, t.TempDir())
+		})
+	}
+}
+
+// writeLegacyContent writes the content stored under the key as it was stored before contents were stored as blobs
+func writeLegacyContent(t *testing.T, dir, key, content string) {
+	contentsDir := filepath.Join(dir, "contents")
+	assert.NoError(t, os.MkdirAll(contentsDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(contentsDir, models.HashContent([]byte(key))), []byte(content), 0644))
+}
+
+// dropFolder removes the folder with the ID from the folders file of the store in dir, leaving its files behind
+func dropFolder(t *testing.T, dir string, id models.ID) {
+	foldersPath := filepath.Join(dir, repository.FoldersFileName)
+	data, err := os.ReadFile(foldersPath)
+	assert.NoError(t, err)
+	var folders []map[string]any
+	assert.NoError(t, json.Unmarshal(data, &folders))
+	kept := folders[:0]
+	for _, folder := range folders {
+		if folder["id"] != float64(id) {
+			kept = append(kept, folder)
+		}
+	}
+	data, err = json.Marshal(kept)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(foldersPath, data, 0644))
+}
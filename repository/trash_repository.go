@@ -0,0 +1,209 @@
+// repository/trash_repository.go
+
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// FileTrashRepository handles the repository logic for the trash of every user.
+// The file holds a JSON array of the entries, each with the folders and files it holds in their stored form.
+type FileTrashRepository struct {
+	filePath string
+	mu       sync.RWMutex // ensures thread-safe access to the file
+}
+
+// storedTrashEntry represents the trash entry structure stored in the file
+type storedTrashEntry struct {
+	ID        models.ID `json:"id"`
+	UserID    models.ID `json:"user_id"`
+	DeletedBy models.ID `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Path      string    `json:"path"`
+	storedTrashItems
+}
+
+// storedTrashItems represents the folders and files held by a trash entry, which keep the paths they were deleted from
+type storedTrashItems struct {
+	Folders []storedTrashFolder `json:"folders,omitempty"`
+	Files   []storedTrashFile   `json:"files,omitempty"`
+}
+
+// storedTrashFolder represents a folder held by a trash entry
+type storedTrashFolder struct {
+	storedFolder
+	ParentPath string `json:"parent_path"`
+}
+
+// storedTrashFile represents a file held by a trash entry
+type storedTrashFile struct {
+	storedFile
+	FolderPath string `json:"folderPath"`
+}
+
+// newStoredTrashItems returns the stored form of the folders and files held by the entry
+func newStoredTrashItems(entry models.TrashEntry) storedTrashItems {
+	var items storedTrashItems
+	for _, folder := range entry.Folders {
+		items.Folders = append(items.Folders, storedTrashFolder{storedFolder: newStoredFolder(folder), ParentPath: folder.ParentPath})
+	}
+	for _, file := range entry.Files {
+		items.Files = append(items.Files, storedTrashFile{storedFile: newStoredFile(file), FolderPath: file.FolderPath})
+	}
+	return items
+}
+
+// toDomain converts the stored folders and files into the domain folders and files of the entry
+func (items storedTrashItems) toDomain(entry *models.TrashEntry) error {
+	for _, f := range items.Folders {
+		folder, err := f.storedFolder.toDomain()
+		if err != nil {
+			return err
+		}
+		folder.ParentPath = f.ParentPath
+		entry.Folders = append(entry.Folders, folder)
+	}
+	for _, f := range items.Files {
+		file, err := f.storedFile.toDomain("", f.FolderPath)
+		if err != nil {
+			return err
+		}
+		entry.Files = append(entry.Files, file)
+	}
+	return nil
+}
+
+// toDomain converts the stored trash entry into a domain trash entry
+func (e storedTrashEntry) toDomain() (models.TrashEntry, error) {
+	entry := models.TrashEntry{ID: e.ID, UserID: e.UserID, DeletedBy: e.DeletedBy, DeletedAt: e.DeletedAt, Path: e.Path}
+	return entry, e.storedTrashItems.toDomain(&entry)
+}
+
+// NewFileTrashRepository creates a new instance of FileTrashRepository
+func NewFileTrashRepository(filePath string) *FileTrashRepository {
+	return &FileTrashRepository{
+		filePath: filePath,
+	}
+}
+
+// loadTrash reads all the stored entries from the file. The caller must hold r.mu.
+func (r *FileTrashRepository) loadTrash() ([]storedTrashEntry, error) {
+	data, err := os.ReadFile(r.filePath)
+	if os.IsNotExist(err) {
+		return []storedTrashEntry{}, nil // nothing has been deleted yet
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []storedTrashEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// saveTrash atomically replaces the stored entries. The caller must hold r.mu.
+func (r *FileTrashRepository) saveTrash(entries []storedTrashEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.filePath, data, 0644)
+}
+
+// AddTrash adds an entry to the trash of its user, assigns it the next ID, which is never reused, and returns it
+func (r *FileTrashRepository) AddTrash(entry models.TrashEntry) (models.TrashEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries, err := r.loadTrash()
+	if err != nil {
+		return models.TrashEntry{}, err
+	}
+	var highest models.ID
+	for _, e := range entries {
+		highest = max(highest, e.ID)
+	}
+	if entry.ID, err = nextID(filepath.Dir(r.filePath), trashIDs, highest); err != nil {
+		return models.TrashEntry{}, err
+	}
+
+	entries = append(entries, storedTrashEntry{
+		ID:               entry.ID,
+		UserID:           entry.UserID,
+		DeletedBy:        entry.DeletedBy,
+		DeletedAt:        entry.DeletedAt,
+		Path:             entry.Path,
+		storedTrashItems: newStoredTrashItems(entry),
+	})
+	return entry, r.saveTrash(entries)
+}
+
+// GetTrash returns the entry with the ID from the trash of the user
+func (r *FileTrashRepository) GetTrash(userID, id models.ID) (models.TrashEntry, error) {
+	entries, err := r.list(func(e storedTrashEntry) bool { return e.UserID == userID && e.ID == id })
+	if err != nil {
+		return models.TrashEntry{}, err
+	}
+	if len(entries) == 0 {
+		return models.TrashEntry{}, customErrors.ErrTrashNotFound(id.String())
+	}
+	return entries[0], nil
+}
+
+// ListTrash returns the entries in the trash of the user, in the order they were deleted
+func (r *FileTrashRepository) ListTrash(userID models.ID) ([]models.TrashEntry, error) {
+	return r.list(func(e storedTrashEntry) bool { return e.UserID == userID })
+}
+
+// ListExpiredTrash returns the entries of every user deleted before deletedBefore, in the order they were deleted
+func (r *FileTrashRepository) ListExpiredTrash(deletedBefore time.Time) ([]models.TrashEntry, error) {
+	return r.list(func(e storedTrashEntry) bool { return e.DeletedAt.Before(deletedBefore) })
+}
+
+// list returns the entries matching keep. Entries are appended as they are deleted, so the file keeps them in order.
+func (r *FileTrashRepository) list(keep func(storedTrashEntry) bool) ([]models.TrashEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, err := r.loadTrash()
+	if err != nil {
+		return nil, err
+	}
+	var entries []models.TrashEntry
+	for _, e := range stored {
+		if !keep(e) {
+			continue
+		}
+		entry, err := e.toDomain()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// DeleteTrash permanently removes the entry with the ID from the trash of the user
+func (r *FileTrashRepository) DeleteTrash(userID, id models.ID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries, err := r.loadTrash()
+	if err != nil {
+		return err
+	}
+	for i, e := range entries {
+		if e.UserID == userID && e.ID == id {
+			return r.saveTrash(append(entries[:i], entries[i+1:]...))
+		}
+	}
+	return customErrors.ErrTrashNotFound(id.String())
+}
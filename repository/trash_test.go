@@ -0,0 +1,190 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/repository"
+)
+
+// trashRepositories creates the user and trash repositories of every implementation
+var trashRepositories = map[string]func(t *testing.T) (models.UserRepository, models.TrashRepository){
+	"File": func(t *testing.T) (models.UserRepository, models.TrashRepository) {
+		store, err := repository.OpenStore(t.TempDir(), models.CasePreserving)
+		assert.NoError(t, err)
+		return store.Users, store.Trash
+	},
+	"Memory": func(t *testing.T) (models.UserRepository, models.TrashRepository) {
+		return repository.NewMemoryUserRepository(models.CasePreserving), repository.NewMemoryTrashRepository()
+	},
+	"SQL": func(t *testing.T) (models.UserRepository, models.TrashRepository) {
+		store, err := repository.OpenSQLStore(t.TempDir(), models.CasePreserving)
+		assert.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store.Users, store.Trash
+	},
+}
+
+// TestTrash tests that every repository keeps the trash of each user apart, returns the deleted folders and files as
+// they were added and lists the expired entries of all users
+func TestTrash(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	for implementation, newRepositories := range trashRepositories {
+		t.Run(implementation, func(t *testing.T) {
+			users, trash := newRepositories(t)
+			for _, username := range []string{"alice", "bob"} {
+				assert.NoError(t, users.Register(models.User{Username: username}))
+			}
+			alice, err := users.GetUser("alice")
+			assert.NoError(t, err)
+			bob, err := users.GetUser("bob")
+			assert.NoError(t, err)
+
+			folder := models.Folder{
+				ID:          4,
+				UserID:      alice.ID,
+				ParentID:    2,
+				Username:    "alice",
+				ParentPath:  "/projects",
+				Name:        "2024",
+				Description: "reports",
+				CreatedAt:   now.Add(-time.Hour),
+				Permissions: models.Permissions{OwnerID: bob.ID, Group: "dev", Mode: 0o750},
+				Shares:      []models.Share{{UserID: bob.ID, Access: models.ShareRead}},
+			}
+			file := models.File{
+				ID:          7,
+				UserID:      alice.ID,
+				FolderID:    4,
+				Username:    "alice",
+				FolderPath:  "/projects/2024",
+				Name:        "q3.txt",
+				Size:        5,
+				CreatedAt:   now.Add(-time.Hour),
+				ModifiedAt:  now.Add(-time.Minute),
+				Permissions: models.Permissions{OwnerID: alice.ID, Mode: 0o640},
+			}
+			expired, err := trash.AddTrash(models.TrashEntry{UserID: alice.ID, DeletedBy: bob.ID, DeletedAt: now.Add(-48 * time.Hour), Path: "/projects/2024", Folders: []models.Folder{folder}, Files: []models.File{file}})
+			assert.NoError(t, err)
+			kept, err := trash.AddTrash(models.TrashEntry{UserID: alice.ID, DeletedBy: alice.ID, DeletedAt: now, Path: "/notes.txt", Files: []models.File{{ID: 8, UserID: alice.ID, Username: "alice", FolderPath: "/", Name: "notes.txt"}}})
+			assert.NoError(t, err)
+			other, err := trash.AddTrash(models.TrashEntry{UserID: bob.ID, DeletedBy: bob.ID, DeletedAt: now.Add(-72 * time.Hour), Path: "/old"})
+			assert.NoError(t, err)
+			assert.NotEqual(t, expired.ID, kept.ID)
+
+			// Entries come back as they were added
+			entry, err := trash.GetTrash(alice.ID, expired.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, alice.ID, entry.UserID)
+			assert.Equal(t, bob.ID, entry.DeletedBy)
+			assert.True(t, expired.DeletedAt.Equal(entry.DeletedAt))
+			assert.Equal(t, "/projects/2024", entry.Path)
+			assert.True(t, entry.IsFolder())
+			assert.Equal(t, int64(5), entry.Size())
+			if assert.Len(t, entry.Folders, 1) && assert.Len(t, entry.Files, 1) {
+				assert.Equal(t, folder.Path(), entry.Folders[0].Path())
+				assert.Equal(t, folder.Description, entry.Folders[0].Description)
+				assert.Equal(t, folder.Permissions, entry.Folders[0].Permissions)
+				assert.Equal(t, folder.Shares, entry.Folders[0].Shares)
+				assert.Equal(t, file.ID, entry.Files[0].ID)
+				assert.Equal(t, "/projects/2024/q3.txt", models.JoinPath(entry.Files[0].FolderPath, entry.Files[0].Name))
+				assert.Equal(t, file.Permissions, entry.Files[0].Permissions)
+				assert.True(t, file.ModifiedAt.Equal(entry.Files[0].ModifiedAt))
+			}
+
+			// The trash of a user only holds their own entries
+			_, err = trash.GetTrash(bob.ID, expired.ID)
+			assert.EqualError(t, err, customErrors.ErrTrashNotFound(expired.ID.String()).Error())
+			assertEntries := func(entries []models.TrashEntry, err error, expected ...models.ID) {
+				assert.NoError(t, err)
+				var ids []models.ID
+				for _, e := range entries {
+					ids = append(ids, e.ID)
+				}
+				assert.Equal(t, expected, ids)
+			}
+			entries, err := trash.ListTrash(alice.ID)
+			assertEntries(entries, err, expired.ID, kept.ID)
+			entries, err = trash.ListExpiredTrash(now.Add(-24 * time.Hour))
+			assertEntries(entries, err, expired.ID, other.ID)
+
+			// Deleted entries are gone
+			assert.EqualError(t, trash.DeleteTrash(bob.ID, expired.ID), customErrors.ErrTrashNotFound(expired.ID.String()).Error())
+			assert.NoError(t, trash.DeleteTrash(alice.ID, expired.ID))
+			_, err = trash.GetTrash(alice.ID, expired.ID)
+			assert.ErrorIs(t, err, customErrors.ErrNotFound)
+			entries, err = trash.ListTrash(alice.ID)
+			assertEntries(entries, err, kept.ID)
+		})
+	}
+}
+
+// TestTrashIDsAreNeverReused tests that every repository gives a new entry a new ID even once the entry with the
+// highest ID is gone
+func TestTrashIDsAreNeverReused(t *testing.T) {
+	for implementation, newRepositories := range trashRepositories {
+		t.Run(implementation, func(t *testing.T) {
+			users, trash := newRepositories(t)
+			assert.NoError(t, users.Register(models.User{Username: "alice"}))
+			alice, err := users.GetUser("alice")
+			assert.NoError(t, err)
+
+			entry := models.TrashEntry{UserID: alice.ID, DeletedBy: alice.ID, DeletedAt: time.Now(), Path: "/notes.txt"}
+			first, err := trash.AddTrash(entry)
+			assert.NoError(t, err)
+			assert.NoError(t, trash.DeleteTrash(alice.ID, first.ID))
+			second, err := trash.AddTrash(entry)
+			assert.NoError(t, err)
+			assert.Greater(t, second.ID, first.ID)
+		})
+	}
+}
+
+// TestFsckTrash tests that the stores keep the blobs of the files in the trash, and remove them once the trash entry
+// is gone
+func TestFsckTrash(t *testing.T) {
+	type fsckStore interface {
+		Fsck(repair bool) ([]string, error)
+	}
+	stores := map[string]func(t *testing.T) (models.UserRepository, models.TrashRepository, models.BlobRepository, fsckStore){
+		"File": func(t *testing.T) (models.UserRepository, models.TrashRepository, models.BlobRepository, fsckStore) {
+			store, err := repository.OpenStore(t.TempDir(), models.CasePreserving)
+			assert.NoError(t, err)
+			return store.Users, store.Trash, store.Blobs, store
+		},
+		"SQL": func(t *testing.T) (models.UserRepository, models.TrashRepository, models.BlobRepository, fsckStore) {
+			store, err := repository.OpenSQLStore(t.TempDir(), models.CasePreserving)
+			assert.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store.Users, store.Trash, store.Blobs, store
+		},
+	}
+
+	for implementation, open := range stores {
+		t.Run(implementation, func(t *testing.T) {
+			users, trash, blobs, store := open(t)
+			assert.NoError(t, users.Register(models.User{Username: "alice"}))
+			alice, err := users.GetUser("alice")
+			assert.NoError(t, err)
+			hash, err := blobs.PutBlob([]byte("hello"))
+			assert.NoError(t, err)
+			file := models.File{ID: 3, UserID: alice.ID, Username: "alice", FolderPath: "/", Name: "notes.txt", ContentHash: hash}
+			entry, err := trash.AddTrash(models.TrashEntry{UserID: alice.ID, DeletedBy: alice.ID, DeletedAt: time.Now(), Path: "/notes.txt", Files: []models.File{file}})
+			assert.NoError(t, err)
+
+			orphans, err := store.Fsck(false)
+			assert.NoError(t, err)
+			assert.Empty(t, orphans)
+
+			assert.NoError(t, trash.DeleteTrash(alice.ID, entry.ID))
+			orphans, err = store.Fsck(true)
+			assert.NoError(t, err)
+			assert.Len(t, orphans, 1) // the blob of the file
+			_, err = blobs.GetBlob(hash)
+			assert.ErrorIs(t, err, customErrors.ErrNotFound)
+		})
+	}
+}
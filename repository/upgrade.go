@@ -0,0 +1,443 @@
+// repository/upgrade.go
+
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// legacyFolder is a folder stored before folders had IDs, when it referenced its owner by username and its parent by path
+type legacyFolder struct {
+	Name        string    `json:"name"`
+	Parent      string    `json:"parent"`
+	Description string    `json:"description"`
+	Username    string    `json:"username"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// legacyFile is a file stored before files had IDs, when it referenced its owner by username and its folder by path
+type legacyFile struct {
+	Username    string `json:"username"`
+	FolderPath  string `json:"folderPath"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Size        int64  `json:"size"`
+	CreatedAt   string `json:"createdAt"`
+	ModifiedAt  string `json:"modifiedAt"`
+}
+
+// missingID references the owner or folder of a legacy file that no longer existed. It resolves to no entity, so Fsck
+// still reports and removes the file, and it is never assigned to a new entity.
+const missingID models.ID = -1
+
+// legacyUsersFileName is the name of the users file written before users had profiles, holding one user per line
+const legacyUsersFileName = "users.txt"
+
+// upgradeStore converts the data directory of a store written by an older version, one step after the other
+func upgradeStore(dir string) error {
+	if err := upgradeIDs(dir); err != nil {
+		return err
+	}
+	if err := upgradeUsers(dir); err != nil {
+		return err
+	}
+	if err := upgradeBlobs(dir); err != nil {
+		return err
+	}
+	return upgradeChunks(dir)
+}
+
+// legacyContentsDirName is the name of the directory that kept the contents before they were stored as blobs
+const legacyContentsDirName = "contents"
+
+// legacyBlobsDirName is the name of the directory that kept every blob in a single host file before blobs were split
+// into chunks
+const legacyBlobsDirName = "blobs"
+
+// legacyContentPath returns the path of the host file that kept the content stored under the key before contents were
+// stored as blobs, named after the hash of the key. A file stored its content under its ID, e.g. "12", a file in the
+// trash under the IDs of its entry and of itself, e.g. "trash:3:12", and a version under the ID of its file and its
+// number, e.g. "version:12:3".
+func legacyContentPath(dir, key string) string {
+	return filepath.Join(dir, legacyContentsDirName, models.HashContent([]byte(key)))
+}
+
+// upgradeIDs converts the data directory of a store written before users, folders and files had IDs.
+// Such a store is recognized by a legacy users file that holds bare usernames. Every entity is given an ID, references
+// by name and path are replaced by references by ID, and contents are renamed after the IDs of their files.
+// The new contents are written first and the metadata is replaced in a single journaled write, so an interrupted
+// upgrade is simply repeated; only the old contents left behind are removed after the upgrade, and Fsck removes them if
+// that is interrupted.
+func upgradeIDs(dir string) error {
+	usersData, err := os.ReadFile(filepath.Join(dir, legacyUsersFileName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	lines := strings.Fields(string(usersData))
+	for _, line := range strings.Split(string(usersData), "\n") {
+		if strings.Contains(line, " ") {
+			return nil // the users already have IDs
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	// Give every user an ID in the order of registration
+	users := make([]models.User, len(lines))
+	userIDs := make(map[string]models.ID, len(lines))
+	for i, username := range lines {
+		users[i] = models.User{ID: models.ID(i + 1), Username: username}
+		userIDs[strings.ToLower(username)] = users[i].ID
+	}
+
+	// Give every folder an ID, adding the parent folders before the folders nested inside them
+	var folders []legacyFolder
+	if err := readLegacy(filepath.Join(dir, FoldersFileName), &folders); err != nil {
+		return err
+	}
+	sort.SliceStable(folders, func(i, j int) bool {
+		return len(models.PathElements(folders[i].Parent)) < len(models.PathElements(folders[j].Parent))
+	})
+	tree := newFolderTree(models.CasePreserving) // stores without IDs matched folder names regardless of case
+	for _, f := range folders {
+		folderPath := models.JoinPath(f.Parent, f.Name)
+		userID, ok := userIDs[strings.ToLower(f.Username)]
+		if !ok {
+			return fmt.Errorf("the folder [%s] belongs to the missing user [%s]", folderPath, f.Username)
+		}
+		parentID, ok := tree.resolve(userID, f.Parent)
+		if !ok {
+			return fmt.Errorf("the folder [%s] belongs to the missing folder [%s]", folderPath, models.CleanPath(f.Parent))
+		}
+		if _, ok := tree.child(userID, parentID, f.Name); ok {
+			return fmt.Errorf("the folder [%s] is stored twice", folderPath)
+		}
+		tree.insert(models.Folder{
+			ID:          tree.nextID(),
+			UserID:      userID,
+			ParentID:    parentID,
+			Name:        f.Name,
+			Description: f.Description,
+			CreatedAt:   f.CreatedAt,
+			Permissions: models.Permissions{OwnerID: userID, Mode: models.DefaultFolderMode},
+		})
+	}
+
+	// Give every file an ID and copy its content under the key derived from the ID
+	var files []legacyFile
+	if err := readLegacy(filepath.Join(dir, FilesFileName), &files); err != nil {
+		return err
+	}
+	upgradedFiles := make([]storedFile, len(files))
+	var oldContents []string
+	for i, f := range files {
+		file := storedFile{
+			ID:          models.ID(i + 1),
+			UserID:      missingID,
+			FolderID:    missingID,
+			Name:        f.Name,
+			Description: f.Description,
+			Size:        f.Size,
+			CreatedAt:   f.CreatedAt,
+			ModifiedAt:  f.ModifiedAt,
+		}
+		if userID, ok := userIDs[strings.ToLower(f.Username)]; ok {
+			file.UserID = userID
+			if folderID, ok := tree.resolve(userID, f.FolderPath); ok {
+				file.FolderID = folderID
+			}
+		}
+		upgradedFiles[i] = file
+
+		oldContent := legacyContentPath(dir, models.JoinPath(models.RootPath+f.Username, models.JoinPath(f.FolderPath, f.Name)))
+		data, err := os.ReadFile(oldContent)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(legacyContentPath(dir, file.ID.String()), data, 0644); err != nil {
+			return err
+		}
+		oldContents = append(oldContents, oldContent)
+	}
+
+	// Replace the metadata in a single write, then remove the old contents
+	folderData, err := marshalTree(tree)
+	if err != nil {
+		return err
+	}
+	fileData, err := json.Marshal(upgradedFiles)
+	if err != nil {
+		return err
+	}
+	if err := writeFilesAtomic(dir, map[string][]byte{
+		legacyUsersFileName: formatLegacyUsers(users),
+		FoldersFileName:     folderData,
+		FilesFileName:       fileData,
+	}, 0644); err != nil {
+		return err
+	}
+	for _, oldContent := range oldContents {
+		if err := os.Remove(oldContent); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeBlobs converts the contents directory of a store written before contents were stored as blobs. Every content
+// is copied into a blob, files and the files held by trash entries are given the hash of their content, while versions
+// already record it, and the references to every blob are counted from scratch.
+// The chunks of the blobs are written first and the metadata is replaced together with the index of the blobs in a
+// single journaled write, so an interrupted upgrade is simply repeated, keeping the blobs of contents that were already
+// removed; the contents directory is removed last.
+func upgradeBlobs(dir string) error {
+	contentsDir := filepath.Join(dir, legacyContentsDirName)
+	if _, err := os.Stat(contentsDir); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	repo := NewFileBlobRepository(filepath.Join(dir, ChunksDirName), filepath.Join(dir, BlobsFileName), filepath.Join(dir, BlobsLogFileName))
+	blobs, err := repo.loadBlobs()
+	if err != nil {
+		return err
+	}
+	for hash, blob := range blobs {
+		blob.Refs = 0
+		blobs[hash] = blob
+	}
+	putBlob := func(key, hash string) (string, error) {
+		data, err := os.ReadFile(legacyContentPath(dir, key))
+		if os.IsNotExist(err) {
+			return hash, nil
+		} else if err != nil {
+			return "", err
+		}
+		hash, blob, err := storeUpgradedBlob(repo, data)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := blobs[hash]; !ok {
+			blobs[hash] = blob // equal contents share the blob and its references
+		}
+		return hash, nil
+	}
+	reference := func(hash string) {
+		if blob, ok := blobs[hash]; ok {
+			blob.Refs++
+			blobs[hash] = blob
+		}
+	}
+
+	var files []storedFile
+	if err := readLegacy(filepath.Join(dir, FilesFileName), &files); err != nil {
+		return err
+	}
+	var trash []storedTrashEntry
+	if err := readLegacy(filepath.Join(dir, TrashFileName), &trash); err != nil {
+		return err
+	}
+	var versions []storedVersion
+	if err := readLegacy(filepath.Join(dir, VersionsFileName), &versions); err != nil {
+		return err
+	}
+
+	for i, f := range files {
+		if files[i].ContentHash, err = putBlob(f.ID.String(), f.ContentHash); err != nil {
+			return err
+		}
+		reference(files[i].ContentHash)
+	}
+	for _, e := range trash {
+		for i, f := range e.Files {
+			if e.Files[i].ContentHash, err = putBlob(fmt.Sprintf("trash:%d:%d", e.ID, f.ID), f.ContentHash); err != nil {
+				return err
+			}
+			reference(e.Files[i].ContentHash)
+		}
+	}
+	for _, v := range versions {
+		if _, err := putBlob(fmt.Sprintf("version:%d:%d", v.FileID, v.Number), v.Hash); err != nil {
+			return err
+		}
+		reference(v.Hash)
+	}
+
+	// Replace the metadata and the index in a single write, then remove the contents
+	fileData, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	trashData, err := json.Marshal(trash)
+	if err != nil {
+		return err
+	}
+	blobsData, err := json.Marshal(blobs)
+	if err != nil {
+		return err
+	}
+	if err := writeFilesAtomic(dir, map[string][]byte{
+		FilesFileName: fileData,
+		TrashFileName: trashData,
+		BlobsFileName: blobsData,
+	}, 0644); err != nil {
+		return err
+	}
+	return os.RemoveAll(contentsDir)
+}
+
+// upgradeChunks converts the blobs directory of a store written before blobs were split into chunks, which kept every
+// blob in a host file named after its hash and only the number of its references in the index. Every blob is split
+// into chunks, and blobs whose host file is missing are dropped from the index, so Fsck reports them as missing.
+// The chunks are written first and the index is replaced in a single write, so an interrupted upgrade is simply
+// repeated, keeping the blobs already converted; the blobs directory is removed last.
+func upgradeChunks(dir string) error {
+	blobsDir := filepath.Join(dir, legacyBlobsDirName)
+	if _, err := os.Stat(blobsDir); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var index map[string]json.RawMessage
+	if err := readLegacy(filepath.Join(dir, BlobsFileName), &index); err != nil {
+		return err
+	}
+	repo := NewFileBlobRepository(filepath.Join(dir, ChunksDirName), filepath.Join(dir, BlobsFileName), filepath.Join(dir, BlobsLogFileName))
+	blobs := make(map[string]storedBlob, len(index))
+	for hash, value := range index {
+		var blob storedBlob
+		if err := json.Unmarshal(value, &blob); err == nil {
+			blobs[hash] = blob // converted already
+			continue
+		}
+		var refs int
+		if err := json.Unmarshal(value, &refs); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(blobsDir, hash))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, blob, err = storeUpgradedBlob(repo, data); err != nil {
+			return err
+		}
+		blob.Refs = refs
+		blobs[hash] = blob
+	}
+
+	data, err := json.Marshal(blobs)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, BlobsFileName), data, 0644); err != nil {
+		return err
+	}
+	return os.RemoveAll(blobsDir)
+}
+
+// storeUpgradedBlob stores the data as the chunks of a blob in the repository and returns the hash of the blob and
+// the blob, which is yet to be added to the index
+func storeUpgradedBlob(repo *FileBlobRepository, data []byte) (string, storedBlob, error) {
+	blob := storedBlob{Size: int64(len(data))}
+	for _, chunk := range splitChunks(data) {
+		hash, err := repo.putChunk(chunk)
+		if err != nil {
+			return "", storedBlob{}, err
+		}
+		blob.Chunks = append(blob.Chunks, hash)
+	}
+	return models.HashContent(data), blob, nil
+}
+
+// upgradeUsers converts the legacy users file, which holds the ID of a user followed by a space and the username on
+// every line, e.g. "1 alice", into the users file. Users registered before profiles existed have no creation time.
+// The users file is written before the legacy one is removed, so an interrupted upgrade only leaves the legacy file
+// behind, which is then removed.
+func upgradeUsers(dir string) error {
+	legacyPath := filepath.Join(dir, legacyUsersFileName)
+	data, err := os.ReadFile(legacyPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	usersPath := filepath.Join(dir, UsersFileName)
+	if _, err := os.Stat(usersPath); os.IsNotExist(err) {
+		users, err := parseLegacyUsers(data)
+		if err != nil {
+			return err
+		}
+		usersData, err := marshalUsers(users)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(usersPath, usersData, 0644); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	if err := os.Remove(legacyPath); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// parseLegacyUsers reads the users from the content of a legacy users file
+func parseLegacyUsers(data []byte) ([]models.User, error) {
+	var users []models.User
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("the line [%s] doesn't hold an ID and a username", scanner.Text())
+		}
+		id, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("the line [%s] doesn't start with an ID", scanner.Text())
+		}
+		users = append(users, models.User{ID: models.ID(id), Username: fields[1]})
+	}
+	return users, scanner.Err()
+}
+
+// formatLegacyUsers returns the content of a legacy users file holding the given users
+func formatLegacyUsers(users []models.User) []byte {
+	var data strings.Builder
+	for _, user := range users {
+		fmt.Fprintf(&data, "%d %s\n", user.ID, user.Username)
+	}
+	return []byte(data.String())
+}
+
+// readLegacy decodes the JSON file at filePath into v, leaving v untouched if the file doesn't exist
+func readLegacy(filePath string, v any) error {
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -3,87 +3,328 @@
 package repository
 
 import (
-	"bufio"
+	"encoding/json"
 	"os"
-	"regexp"
-	"strings"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/terenzio/vfs/domain/errors"
 	"github.com/terenzio/vfs/domain/models"
 )
 
-// FileUserRepository handles the repository logic for users
+// FileUserRepository handles the repository logic for users.
+// The file holds a JSON array of the users with their IDs and profiles.
+// Usernames are matched with the case policy, and so are the names in the folder and file repositories linked to it.
+// Deleting a user also deletes its folders and files, so the repository works together with the folder repository
+// attached to it by NewFileFolderRepository.
 type FileUserRepository struct {
 	filePath string
-	mu       sync.Mutex // ensures thread-safe access to the file
+	policy   models.CasePolicy
+	folders  *FileFolderRepository
+	mu       sync.RWMutex // ensures thread-safe access to the file
 }
 
-// NewFileUserRepository creates a new instance of a file-based user repository
-func NewFileUserRepository(filePath string) *FileUserRepository {
+// storedUser represents the user structure stored in the file
+type storedUser struct {
+	ID           models.ID `json:"id"`
+	Username     string    `json:"username"`
+	DisplayName  string    `json:"display_name,omitempty"`
+	Email        string    `json:"email,omitempty"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Groups       []string  `json:"groups,omitempty"`
+	OwnedGroups  []string  `json:"owned_groups,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// NewFileUserRepository creates a new instance of a file-based user repository that matches names with the policy
+func NewFileUserRepository(filePath string, policy models.CasePolicy) *FileUserRepository {
 	return &FileUserRepository{
 		filePath: filePath,
+		policy:   policy,
 	}
 }
 
 // Register adds a new user to the file
-// The Register method adds a new user to the file. It takes a user model as an argument and writes the username to the file.
-// The method uses a mutex to ensure that only one goroutine can write to the file at a time.
+// The Register method adds a new user to the file. It takes a user model as an argument, assigns it the next ID, which
+// is never reused, and rewrites the file with the user appended, so a crash during the write never leaves a partially
+// written file behind.
+// The method holds the mutex from loading to rewriting the file, so that concurrent registrations never overwrite each other.
 func (r *FileUserRepository) Register(user models.User) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	file, err := os.OpenFile(r.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	users, err := r.loadUsers()
+	if err != nil {
+		return err
+	}
+
+	// Check for an existing user inside the same critical section as the write
+	var highest models.ID
+	for _, u := range users {
+		if r.policy.Equal(u.Username, user.Username) {
+			return errors.ErrUserExists(user.Username)
+		}
+		if u.ID > highest {
+			highest = u.ID
+		}
+	}
+	if user.ID, err = nextID(filepath.Dir(r.filePath), userIDs, highest); err != nil {
+		return err
+	}
+	user.Username = r.policy.Normalize(user.Username)
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+
+	return r.saveUsers(append(users, user))
+}
+
+// loadUsers reads all the users from the file. The caller must hold r.mu.
+func (r *FileUserRepository) loadUsers() ([]models.User, error) {
+	data, err := os.ReadFile(r.filePath)
+	if err != nil {
+		// If the file doesn't exist, we treat it as no users exist yet.
+		if os.IsNotExist(err) {
+			return []models.User{}, nil
+		}
+		return nil, err
+	}
+
+	var stored []storedUser
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+
+	users := make([]models.User, len(stored))
+	for i, u := range stored {
+		users[i] = models.User{
+			ID:           u.ID,
+			Username:     u.Username,
+			DisplayName:  u.DisplayName,
+			Email:        u.Email,
+			PasswordHash: u.PasswordHash,
+			Groups:       u.Groups,
+			OwnedGroups:  u.OwnedGroups,
+			CreatedAt:    u.CreatedAt,
+		}
+	}
+	return users, nil
+}
+
+// saveUsers atomically replaces the stored users. The caller must hold r.mu.
+func (r *FileUserRepository) saveUsers(users []models.User) error {
+	data, err := marshalUsers(users)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	return writeFileAtomic(r.filePath, data, 0644)
+}
+
+// marshalUsers returns the content of a users file holding the given users
+func marshalUsers(users []models.User) ([]byte, error) {
+	stored := make([]storedUser, len(users))
+	for i, u := range users {
+		stored[i] = storedUser{
+			ID:           u.ID,
+			Username:     u.Username,
+			DisplayName:  u.DisplayName,
+			Email:        u.Email,
+			PasswordHash: u.PasswordHash,
+			Groups:       u.Groups,
+			OwnedGroups:  u.OwnedGroups,
+			CreatedAt:    u.CreatedAt,
+		}
+	}
+	return json.Marshal(stored)
+}
+
+// GetUser returns the user registered under the username
+func (r *FileUserRepository) GetUser(username string) (models.User, error) {
+	user, ok, err := r.find(username)
+	if err != nil {
+		return models.User{}, err
+	}
+	if !ok {
+		return models.User{}, errors.ErrUserNotExists(username)
+	}
+	return user, nil
+}
+
+// find returns the user registered under the username, and false if there is no such user
+func (r *FileUserRepository) find(username string) (models.User, bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users, err := r.loadUsers()
+	if err != nil {
+		return models.User{}, false, err
+	}
+
+	i := r.indexOf(users, username)
+	if i < 0 {
+		return models.User{}, false, nil
+	}
+	return users[i], true, nil
+}
 
-	_, err = file.WriteString(user.Username + "\n")
-	return err
+// indexOf returns the index of the user registered under the username, or -1 if there is no such user
+func (r *FileUserRepository) indexOf(users []models.User, username string) int {
+	for i, u := range users {
+		if r.policy.Equal(u.Username, username) {
+			return i
+		}
+	}
+	return -1
 }
 
 // Exists checks if a username already exists in the file
-// The Exists method checks if a username already exists in the file. It opens the file and scans through it line by line to find a match.
-// It uses a mutex to ensure that only one goroutine can read from the file at a time.
+// The Exists method loads the users from the file and scans through them to find a match.
+// It holds a read lock so that concurrent lookups don't block each other.
 func (r *FileUserRepository) Exists(username string) (bool, error) {
+	_, ok, err := r.find(username)
+	return ok, err
+}
+
+// ListUsers returns all the users, sorted based on the specified field and order
+func (r *FileUserRepository) ListUsers(sortField, sortOrder string) ([]models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	users, err := r.loadUsers()
+	if err != nil {
+		return nil, err
+	}
+	sortUsers(users, sortField, sortOrder)
+	return users, nil
+}
+
+// UpdateUser replaces the display name, email, password hash, groups and owned groups of an existing user
+func (r *FileUserRepository) UpdateUser(user models.User) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	file, err := os.Open(r.filePath)
+	users, err := r.loadUsers()
 	if err != nil {
-		// If the file doesn't exist, we treat it as no users exist yet.
-		if os.IsNotExist(err) {
-			return false, nil
-		}
-		return false, err
+		return err
+	}
+	i := r.indexOf(users, user.Username)
+	if i < 0 {
+		return errors.ErrUserNotExists(user.Username)
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		if strings.EqualFold(scanner.Text(), username) {
-			return true, nil
-		}
+	users[i].DisplayName, users[i].Email, users[i].PasswordHash = user.DisplayName, user.Email, user.PasswordHash
+	users[i].Groups, users[i].OwnedGroups = user.Groups, user.OwnedGroups
+	return r.saveUsers(users)
+}
+
+// RenameUser changes the username of a user. Folders and files reference their user by ID, so they move along without
+// being updated.
+func (r *FileUserRepository) RenameUser(username, newUsername string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users, err := r.loadUsers()
+	if err != nil {
+		return err
+	}
+	i := r.indexOf(users, username)
+	if i < 0 {
+		return errors.ErrUserNotExists(username)
+	}
+	if existing := r.indexOf(users, newUsername); existing >= 0 && existing != i {
+		return errors.ErrUserExists(newUsername)
 	}
 
-	return false, scanner.Err()
+	users[i].Username = r.policy.Normalize(newUsername)
+	return r.saveUsers(users)
 }
 
-// ValidateUsername checks if the username is valid.
-// It must contain only alphabets (uppercase and lowercase) and numbers, no spaces.
-// The length of the username must be less than or equal to 30 characters.
-func (r *FileUserRepository) ValidateUsername(username string) error {
-	// Check the length of the username first
-	if len(username) > 30 {
-		return errors.ErrNameTooLong(username)
+// DeleteUser deletes a user together with all its folders and files, and returns the deleted files.
+// The folders and files the user owns in the trees of other users are handed over to the users whose trees hold them.
+// The users, folders and files are replaced in a single journaled write, so a crash never leaves the folders or files
+// of a deleted user behind.
+func (r *FileUserRepository) DeleteUser(username string) ([]models.File, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users, err := r.loadUsers()
+	if err != nil {
+		return nil, err
+	}
+	i := r.indexOf(users, username)
+	if i < 0 {
+		return nil, errors.ErrUserNotExists(username)
+	}
+	user := users[i]
+	users = append(users[:i], users[i+1:]...)
+	if r.folders == nil {
+		return nil, r.saveUsers(users)
+	}
+
+	r.folders.mu.Lock()
+	defer r.folders.mu.Unlock()
+	r.folders.files.mu.Lock()
+	defer r.folders.files.mu.Unlock()
+
+	tree, err := r.folders.loadTree()
+	if err != nil {
+		return nil, err
+	}
+	files, err := r.folders.files.loadFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []models.File
+	remainingFiles := files[:0]
+	for _, f := range files {
+		if f.UserID != user.ID {
+			if f.OwnerID == user.ID {
+				f.OwnerID = f.UserID
+			}
+			remainingFiles = append(remainingFiles, f)
+			continue
+		}
+		file, err := f.toDomain(user.Username, tree.path(f.FolderID))
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, file)
 	}
+	for _, folderID := range tree.owned(user.ID)[1:] {
+		tree.remove(folderID)
+	}
+	tree.handOver(user.ID)
+	tree.revoke(user.ID)
+	return deleted, r.saveUsersTreeAndFiles(users, tree, remainingFiles)
+}
 
-	// Regular expression to match usernames containing only alphabets and numbers.
-	validUsernameRegex := regexp.MustCompile(`^[A-Za-z0-9]+$`)
-	if !validUsernameRegex.MatchString(username) {
-		return errors.ErrInvalidName(username)
+// saveUsersTreeAndFiles atomically replaces the stored users, folders and files, so a crash never leaves a change that
+// spans them half applied. The caller must hold r.mu, r.folders.mu and r.folders.files.mu.
+func (r *FileUserRepository) saveUsersTreeAndFiles(users []models.User, tree *folderTree, files []storedFile) error {
+	userData, err := marshalUsers(users)
+	if err != nil {
+		return err
+	}
+	folderData, err := marshalTree(tree)
+	if err != nil {
+		return err
+	}
+	fileData, err := json.Marshal(files)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// The journal is kept next to the users and names every file relative to it
+	dir := filepath.Dir(r.filePath)
+	contents := map[string][]byte{filepath.Base(r.filePath): userData}
+	for filePath, data := range map[string][]byte{r.folders.filePath: folderData, r.folders.files.filePath: fileData} {
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		contents[rel] = data
+	}
+	return writeFilesAtomic(dir, contents, 0644)
 }
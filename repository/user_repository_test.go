@@ -0,0 +1,217 @@
+package repository_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// TestUserManagement tests that every user repository lists, updates, renames and deletes users the same way
+func TestUserManagement(t *testing.T) {
+	tests := []struct {
+		name     string
+		testFunc func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository)
+	}{
+		{
+			name: "ListUsers",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository) {
+				assert.NoError(t, users.Register(models.User{Username: "carol", CreatedAt: time.Now().Add(-time.Hour)}))
+				assert.NoError(t, users.Register(models.User{Username: "bob", CreatedAt: time.Now()}))
+
+				listed, err := users.ListUsers("", "")
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"bob", "carol", "user1"}, usernames(listed))
+				listed, err = users.ListUsers("--sort-name", "desc")
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"user1", "carol", "bob"}, usernames(listed))
+				listed, err = users.ListUsers("--sort-created", "asc")
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"carol", "user1", "bob"}, usernames(listed))
+			},
+		},
+		{
+			name: "UpdateUser",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository) {
+				registered, err := users.GetUser("user1")
+				assert.NoError(t, err)
+				assert.False(t, registered.CreatedAt.IsZero())
+
+				assert.NoError(t, users.UpdateUser(models.User{Username: "USER1", DisplayName: "User One", Email: "one@example.com"}))
+				user, err := users.GetUser("user1")
+				assert.NoError(t, err)
+				assert.Equal(t, "user1", user.Username)
+				assert.Equal(t, "User One", user.DisplayName)
+				assert.Equal(t, "one@example.com", user.Email)
+				assert.Equal(t, registered.ID, user.ID)
+				assert.True(t, registered.CreatedAt.Equal(user.CreatedAt))
+
+				err = users.UpdateUser(models.User{Username: "ghost"})
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+			},
+		},
+		{
+			name: "UpdateUserGroups",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository) {
+				assert.NoError(t, users.UpdateUser(models.User{Username: "user1", Groups: []string{"dev", "ops"}, OwnedGroups: []string{"ops"}}))
+				user, err := users.GetUser("user1")
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"dev", "ops"}, user.Groups)
+				assert.Equal(t, []string{"ops"}, user.OwnedGroups)
+
+				assert.NoError(t, users.UpdateUser(models.User{Username: "user1"}))
+				user, err = users.GetUser("user1")
+				assert.NoError(t, err)
+				assert.Empty(t, user.Groups)
+				assert.Empty(t, user.OwnedGroups)
+			},
+		},
+		{
+			name: "RenameUserKeepsFoldersAndFiles",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository) {
+				assert.NoError(t, folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "docs", CreatedAt: time.Now()}))
+				assert.NoError(t, files.CreateFile(models.File{Username: "user1", FolderPath: "/docs", Name: "report", CreatedAt: time.Now()}))
+				original, err := users.GetUser("user1")
+				assert.NoError(t, err)
+
+				assert.NoError(t, users.RenameUser("user1", "alice"))
+				exists, err := users.Exists("user1")
+				assert.NoError(t, err)
+				assert.False(t, exists)
+				renamed, err := users.GetUser("alice")
+				assert.NoError(t, err)
+				assert.Equal(t, original.ID, renamed.ID)
+				file, err := files.GetFile("alice", "/docs", "report")
+				assert.NoError(t, err)
+				assert.Equal(t, "alice", file.Username)
+
+				// Only the case of a username can change without a conflict with itself
+				assert.NoError(t, users.RenameUser("alice", "Alice"))
+				assert.NoError(t, users.Register(models.User{Username: "bob"}))
+				assert.ErrorIs(t, users.RenameUser("bob", "ALICE"), customErrors.ErrConflict)
+				assert.ErrorIs(t, users.RenameUser("ghost", "carol"), customErrors.ErrNotFound)
+			},
+		},
+		{
+			name: "DeleteUserCascades",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository) {
+				assert.NoError(t, users.Register(models.User{Username: "bob"}))
+				for _, username := range []string{"user1", "bob"} {
+					assert.NoError(t, folders.CreateFolder(models.Folder{Username: username, ParentPath: "/", Name: "docs", CreatedAt: time.Now()}))
+					assert.NoError(t, folders.CreateFolder(models.Folder{Username: username, ParentPath: "/docs", Name: "2024", CreatedAt: time.Now()}))
+					assert.NoError(t, files.CreateFile(models.File{Username: username, FolderPath: "/", Name: "top", CreatedAt: time.Now()}))
+					assert.NoError(t, files.CreateFile(models.File{Username: username, FolderPath: "/docs/2024", Name: "nested", CreatedAt: time.Now()}))
+				}
+
+				deleted, err := users.DeleteUser("USER1")
+				assert.NoError(t, err)
+				paths := make([]string, 0, len(deleted))
+				for _, file := range deleted {
+					assert.Equal(t, "user1", file.Username)
+					paths = append(paths, file.Path())
+				}
+				assert.ElementsMatch(t, []string{"/top", "/docs/2024/nested"}, paths)
+
+				exists, err := users.Exists("user1")
+				assert.NoError(t, err)
+				assert.False(t, exists)
+				_, err = users.DeleteUser("user1")
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+
+				// The folders and files of other users are kept, and a new user with the same name starts empty
+				listed, err := files.ListFiles("bob", "/docs/2024", "", "")
+				assert.NoError(t, err)
+				assert.Len(t, listed, 1)
+				assert.NoError(t, users.Register(models.User{Username: "user1"}))
+				userFolders, err := folders.ListFolders("user1", "/", "", "")
+				assert.NoError(t, err)
+				assert.Empty(t, userFolders)
+				listed, err = files.ListFiles("user1", "/", "", "")
+				assert.NoError(t, err)
+				assert.Empty(t, listed)
+			},
+		},
+		{
+			name: "DeleteUserHandsOverOwnership",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository) {
+				assert.NoError(t, users.Register(models.User{Username: "bob"}))
+				user1, err := users.GetUser("user1")
+				assert.NoError(t, err)
+				bob, err := users.GetUser("bob")
+				assert.NoError(t, err)
+				permissions := models.Permissions{OwnerID: user1.ID, Group: "dev", Mode: 0o750}
+				assert.NoError(t, folders.CreateFolder(models.Folder{Username: "bob", ParentPath: "/", Name: "shared", CreatedAt: time.Now(), Permissions: permissions}))
+				assert.NoError(t, files.CreateFile(models.File{Username: "bob", FolderPath: "/shared", Name: "notes", CreatedAt: time.Now(), Permissions: permissions}))
+
+				_, err = users.DeleteUser("user1")
+				assert.NoError(t, err)
+
+				// The folder and file user1 owned in the tree of bob now belong to bob, with the same group and mode
+				handedOver := models.Permissions{OwnerID: bob.ID, Group: "dev", Mode: 0o750}
+				folder, err := folders.GetFolder("bob", "/shared")
+				assert.NoError(t, err)
+				assert.Equal(t, handedOver, folder.Permissions)
+				file, err := files.GetFile("bob", "/shared", "notes")
+				assert.NoError(t, err)
+				assert.Equal(t, handedOver, file.Permissions)
+			},
+		},
+		{
+			name: "IDsAreNeverReused",
+			testFunc: func(t *testing.T, users models.UserRepository, folders models.FolderRepository, files models.FileRepository) {
+				// The newest user, folder and file hold the highest IDs when they are deleted
+				assert.NoError(t, users.Register(models.User{Username: "bob"}))
+				bob, err := users.GetUser("bob")
+				assert.NoError(t, err)
+				assert.NoError(t, folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "docs", CreatedAt: time.Now()}))
+				docs, err := folders.GetFolder("user1", "/docs")
+				assert.NoError(t, err)
+				assert.NoError(t, files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "notes", CreatedAt: time.Now()}))
+				notes, err := files.GetFile("user1", "/", "notes")
+				assert.NoError(t, err)
+				_, err = users.DeleteUser("bob")
+				assert.NoError(t, err)
+				_, err = folders.DeleteFolder("user1", "/docs", false)
+				assert.NoError(t, err)
+				assert.NoError(t, files.DeleteFile("user1", "/", "notes"))
+
+				assert.NoError(t, users.Register(models.User{Username: "mallory"}))
+				mallory, err := users.GetUser("mallory")
+				assert.NoError(t, err)
+				assert.Greater(t, mallory.ID, bob.ID)
+				assert.NoError(t, folders.CreateFolder(models.Folder{Username: "user1", ParentPath: "/", Name: "docs", CreatedAt: time.Now()}))
+				folder, err := folders.GetFolder("user1", "/docs")
+				assert.NoError(t, err)
+				assert.Greater(t, folder.ID, docs.ID)
+				assert.NoError(t, files.CreateFile(models.File{Username: "user1", FolderPath: "/", Name: "notes", CreatedAt: time.Now()}))
+				file, err := files.GetFile("user1", "/", "notes")
+				assert.NoError(t, err)
+				assert.Greater(t, file.ID, notes.ID)
+				copied, err := files.CopyFile("user1", "/", "notes", "/", "copy", false)
+				assert.NoError(t, err)
+				assert.Greater(t, copied.ID, file.ID)
+			},
+		},
+	}
+
+	for implementation, newRepositories := range caseRepositories {
+		for _, tt := range tests {
+			t.Run(implementation+"/"+tt.name, func(t *testing.T) {
+				users, folders, files := newRepositories(t, models.CasePreserving)
+				assert.NoError(t, users.Register(models.User{Username: "user1"}))
+				tt.testFunc(t, users, folders, files)
+			})
+		}
+	}
+}
+
+// usernames returns the usernames of the users in order
+func usernames(users []models.User) []string {
+	names := make([]string, len(users))
+	for i, user := range users {
+		names[i] = user.Username
+	}
+	return names
+}
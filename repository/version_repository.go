@@ -0,0 +1,192 @@
+// repository/version_repository.go
+
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// FileVersionRepository handles the repository logic for the versions of files.
+// The file holds a JSON array of the versions of every file, each file's versions ordered by number.
+type FileVersionRepository struct {
+	filePath string
+	mu       sync.RWMutex // ensures thread-safe access to the file
+}
+
+// storedVersion represents the version structure stored in the file
+type storedVersion struct {
+	FileID    models.ID `json:"file_id"`
+	Number    int       `json:"number"`
+	AuthorID  models.ID `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+	Hash      string    `json:"hash"`
+}
+
+// toDomain converts the stored version into a domain version
+func (v storedVersion) toDomain() models.Version {
+	return models.Version{FileID: v.FileID, Number: v.Number, AuthorID: v.AuthorID, CreatedAt: v.CreatedAt, Size: v.Size, Hash: v.Hash}
+}
+
+// NewFileVersionRepository creates a new instance of FileVersionRepository
+func NewFileVersionRepository(filePath string) *FileVersionRepository {
+	return &FileVersionRepository{
+		filePath: filePath,
+	}
+}
+
+// loadVersions reads all the stored versions from the file. The caller must hold r.mu.
+func (r *FileVersionRepository) loadVersions() ([]storedVersion, error) {
+	data, err := os.ReadFile(r.filePath)
+	if os.IsNotExist(err) {
+		return []storedVersion{}, nil // no content has been written yet
+	} else if err != nil {
+		return nil, err
+	}
+
+	var versions []storedVersion
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// saveVersions atomically replaces the stored versions. The caller must hold r.mu.
+func (r *FileVersionRepository) saveVersions(versions []storedVersion) error {
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.filePath, data, 0644)
+}
+
+// AddVersion adds the next version to the history of its file and prunes the versions the policy no longer keeps.
+// It returns the added version and the pruned versions.
+func (r *FileVersionRepository) AddVersion(version models.Version, policy models.VersionPolicy) (models.Version, []models.Version, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.loadVersions()
+	if err != nil {
+		return models.Version{}, nil, err
+	}
+
+	// Split the versions of the file from the others, keeping their order
+	var history []models.Version
+	others := stored[:0]
+	for _, v := range stored {
+		if v.FileID == version.FileID {
+			history = append(history, v.toDomain())
+		} else {
+			others = append(others, v)
+		}
+	}
+	version.Number = 1
+	if len(history) > 0 {
+		version.Number = history[len(history)-1].Number + 1
+	}
+	history = append(history, version)
+
+	kept, pruned := policy.Prune(history, version.CreatedAt)
+	for _, v := range kept {
+		others = append(others, storedVersion{FileID: v.FileID, Number: v.Number, AuthorID: v.AuthorID, CreatedAt: v.CreatedAt, Size: v.Size, Hash: v.Hash})
+	}
+	return version, pruned, r.saveVersions(others)
+}
+
+// PruneVersions prunes the versions of every file the policy no longer keeps at the time now and returns them
+func (r *FileVersionRepository) PruneVersions(policy models.VersionPolicy, now time.Time) ([]models.Version, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.loadVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Group the versions by file, keeping their order
+	var fileIDs []models.ID
+	histories := make(map[models.ID][]models.Version)
+	for _, v := range stored {
+		if _, ok := histories[v.FileID]; !ok {
+			fileIDs = append(fileIDs, v.FileID)
+		}
+		histories[v.FileID] = append(histories[v.FileID], v.toDomain())
+	}
+
+	var pruned []models.Version
+	kept := stored[:0]
+	for _, fileID := range fileIDs {
+		keptVersions, prunedVersions := policy.Prune(histories[fileID], now)
+		pruned = append(pruned, prunedVersions...)
+		for _, v := range keptVersions {
+			kept = append(kept, storedVersion{FileID: v.FileID, Number: v.Number, AuthorID: v.AuthorID, CreatedAt: v.CreatedAt, Size: v.Size, Hash: v.Hash})
+		}
+	}
+	if len(pruned) == 0 {
+		return nil, nil
+	}
+	return pruned, r.saveVersions(kept)
+}
+
+// GetVersion returns the version of the file with the number
+func (r *FileVersionRepository) GetVersion(fileID models.ID, number int) (models.Version, error) {
+	versions, err := r.ListVersions(fileID)
+	if err != nil {
+		return models.Version{}, err
+	}
+	for _, version := range versions {
+		if version.Number == number {
+			return version, nil
+		}
+	}
+	return models.Version{}, customErrors.ErrVersionNotFound(fileID.String(), number)
+}
+
+// ListVersions returns the versions of the file ordered by number
+func (r *FileVersionRepository) ListVersions(fileID models.ID) ([]models.Version, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, err := r.loadVersions()
+	if err != nil {
+		return nil, err
+	}
+	var versions []models.Version
+	for _, v := range stored {
+		if v.FileID == fileID {
+			versions = append(versions, v.toDomain())
+		}
+	}
+	return versions, nil
+}
+
+// DeleteVersions removes the whole history of the file and returns the removed versions
+func (r *FileVersionRepository) DeleteVersions(fileID models.ID) ([]models.Version, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, err := r.loadVersions()
+	if err != nil {
+		return nil, err
+	}
+	var deleted []models.Version
+	remaining := stored[:0]
+	for _, v := range stored {
+		if v.FileID == fileID {
+			deleted = append(deleted, v.toDomain())
+		} else {
+			remaining = append(remaining, v)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+	return deleted, r.saveVersions(remaining)
+}
@@ -0,0 +1,198 @@
+package repository_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/repository"
+)
+
+// versionRepositories creates the version repository of every implementation
+var versionRepositories = map[string]func(t *testing.T) models.VersionRepository{
+	"File": func(t *testing.T) models.VersionRepository {
+		store, err := repository.OpenStore(t.TempDir(), models.CasePreserving)
+		assert.NoError(t, err)
+		return store.Versions
+	},
+	"Memory": func(t *testing.T) models.VersionRepository {
+		return repository.NewMemoryVersionRepository()
+	},
+	"SQL": func(t *testing.T) models.VersionRepository {
+		store, err := repository.OpenSQLStore(t.TempDir(), models.CasePreserving)
+		assert.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store.Versions
+	},
+}
+
+// TestVersions tests that every repository numbers the versions of each file apart, enforces the policy and deletes
+// the whole history of a file
+func TestVersions(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	numbers := func(versions []models.Version) []int {
+		var n []int
+		for _, v := range versions {
+			n = append(n, v.Number)
+		}
+		return n
+	}
+
+	for implementation, newRepository := range versionRepositories {
+		t.Run(implementation, func(t *testing.T) {
+			versions := newRepository(t)
+			keepTwo := models.VersionPolicy{KeepLast: 2}
+
+			// Versions are numbered per file
+			for i := 0; i < 3; i++ {
+				version, pruned, err := versions.AddVersion(models.Version{FileID: 3, AuthorID: 1, CreatedAt: now, Size: int64(i), Hash: "abc"}, keepTwo)
+				assert.NoError(t, err)
+				assert.Equal(t, i+1, version.Number)
+				if i < 2 {
+					assert.Empty(t, pruned)
+				} else {
+					assert.Equal(t, []int{1}, numbers(pruned))
+				}
+			}
+			other, _, err := versions.AddVersion(models.Version{FileID: 4, AuthorID: 2, CreatedAt: now}, keepTwo)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, other.Number)
+
+			// Versions come back as they were added
+			history, err := versions.ListVersions(3)
+			assert.NoError(t, err)
+			assert.Equal(t, []int{2, 3}, numbers(history))
+			version, err := versions.GetVersion(3, 3)
+			assert.NoError(t, err)
+			assert.Equal(t, models.ID(1), version.AuthorID)
+			assert.Equal(t, int64(2), version.Size)
+			assert.Equal(t, "abc", version.Hash)
+			assert.True(t, now.Equal(version.CreatedAt))
+			_, err = versions.GetVersion(3, 1)
+			assert.EqualError(t, err, customErrors.ErrVersionNotFound("3", 1).Error())
+
+			// Versions older than the retention period are pruned, except the last one
+			keepForHour := models.VersionPolicy{KeepFor: time.Hour}
+			version, pruned, err := versions.AddVersion(models.Version{FileID: 3, CreatedAt: now.Add(2 * time.Hour)}, keepForHour)
+			assert.NoError(t, err)
+			assert.Equal(t, 4, version.Number)
+			assert.Equal(t, []int{2, 3}, numbers(pruned))
+			history, err = versions.ListVersions(3)
+			assert.NoError(t, err)
+			assert.Equal(t, []int{4}, numbers(history))
+
+			// Deleting the history of a file leaves the other files alone
+			deleted, err := versions.DeleteVersions(3)
+			assert.NoError(t, err)
+			assert.Equal(t, []int{4}, numbers(deleted))
+			history, err = versions.ListVersions(3)
+			assert.NoError(t, err)
+			assert.Empty(t, history)
+			history, err = versions.ListVersions(4)
+			assert.NoError(t, err)
+			assert.Equal(t, []int{1}, numbers(history))
+		})
+	}
+}
+
+// TestPruneVersions tests that every repository prunes the expired versions of all the files at once, even of files
+// no version was added to since, and keeps the last version of every file
+func TestPruneVersions(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	keys := func(versions []models.Version) []string {
+		var k []string
+		for _, v := range versions {
+			k = append(k, fmt.Sprintf("%d@%d", v.FileID, v.Number))
+		}
+		sort.Strings(k)
+		return k
+	}
+
+	for implementation, newRepository := range versionRepositories {
+		t.Run(implementation, func(t *testing.T) {
+			versions := newRepository(t)
+			keepAll := models.VersionPolicy{}
+			for _, v := range []models.Version{
+				{FileID: 3, CreatedAt: now.Add(-3 * time.Hour)},
+				{FileID: 3, CreatedAt: now.Add(-2 * time.Hour)},
+				{FileID: 3, CreatedAt: now.Add(-time.Minute)},
+				{FileID: 4, CreatedAt: now.Add(-3 * time.Hour)},
+				{FileID: 4, CreatedAt: now.Add(-2 * time.Hour)},
+				{FileID: 5, CreatedAt: now.Add(-time.Minute)},
+			} {
+				_, _, err := versions.AddVersion(v, keepAll)
+				assert.NoError(t, err)
+			}
+
+			pruned, err := versions.PruneVersions(models.VersionPolicy{KeepFor: time.Hour}, now)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"3@1", "3@2", "4@1"}, keys(pruned))
+			for fileID, expected := range map[models.ID][]string{3: {"3@3"}, 4: {"4@2"}, 5: {"5@1"}} {
+				history, err := versions.ListVersions(fileID)
+				assert.NoError(t, err)
+				assert.Equal(t, expected, keys(history))
+			}
+
+			// Nothing is left to prune
+			pruned, err = versions.PruneVersions(models.VersionPolicy{KeepFor: time.Hour}, now)
+			assert.NoError(t, err)
+			assert.Empty(t, pruned)
+		})
+	}
+}
+
+// TestFsckVersions tests that the stores keep the versions of existing files with their blobs, and remove the versions
+// of missing files together with the blobs only they reference
+func TestFsckVersions(t *testing.T) {
+	type fsckStore interface {
+		Fsck(repair bool) ([]string, error)
+	}
+	stores := map[string]func(t *testing.T) (models.UserRepository, models.FolderRepository, models.FileRepository, models.VersionRepository, models.BlobRepository, fsckStore){
+		"File": func(t *testing.T) (models.UserRepository, models.FolderRepository, models.FileRepository, models.VersionRepository, models.BlobRepository, fsckStore) {
+			store, err := repository.OpenStore(t.TempDir(), models.CasePreserving)
+			assert.NoError(t, err)
+			return store.Users, store.Folders, store.Files, store.Versions, store.Blobs, store
+		},
+		"SQL": func(t *testing.T) (models.UserRepository, models.FolderRepository, models.FileRepository, models.VersionRepository, models.BlobRepository, fsckStore) {
+			store, err := repository.OpenSQLStore(t.TempDir(), models.CasePreserving)
+			assert.NoError(t, err)
+			t.Cleanup(func() { store.Close() })
+			return store.Users, store.Folders, store.Files, store.Versions, store.Blobs, store
+		},
+	}
+
+	for implementation, open := range stores {
+		t.Run(implementation, func(t *testing.T) {
+			users, folders, files, versions, blobs, store := open(t)
+			assert.NoError(t, users.Register(models.User{Username: "alice"}))
+			assert.NoError(t, folders.CreateFolder(models.Folder{Username: "alice", ParentPath: "/", Name: "docs"}))
+			hash, err := blobs.PutBlob([]byte("hello"))
+			assert.NoError(t, err)
+			assert.NoError(t, files.CreateFile(models.File{Username: "alice", FolderPath: "/docs", Name: "notes.txt", ContentHash: hash}))
+			file, err := files.GetFile("alice", "/docs", "notes.txt")
+			assert.NoError(t, err)
+			assert.NoError(t, blobs.RetainBlob(hash))
+			_, _, err = versions.AddVersion(models.Version{FileID: file.ID, CreatedAt: time.Now(), Hash: hash}, models.DefaultVersionPolicy())
+			assert.NoError(t, err)
+
+			orphans, err := store.Fsck(false)
+			assert.NoError(t, err)
+			assert.Empty(t, orphans)
+
+			// The blob is still counted twice once the file is gone
+			assert.NoError(t, files.DeleteFile("alice", "/docs", "notes.txt"))
+			orphans, err = store.Fsck(true)
+			assert.NoError(t, err)
+			assert.Len(t, orphans, 2) // the version and the blob
+			history, err := versions.ListVersions(file.ID)
+			assert.NoError(t, err)
+			assert.Empty(t, history)
+			_, err = blobs.GetBlob(hash)
+			assert.ErrorIs(t, err, customErrors.ErrNotFound)
+		})
+	}
+}
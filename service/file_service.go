@@ -3,6 +3,9 @@
 package service
 
 import (
+	stderrors "errors"
+	"fmt"
+	"io"
 	"time"
 
 	"github.com/terenzio/vfs/domain/errors"
@@ -16,99 +19,574 @@ import (
 
 // FileService handles the service logic for files
 type FileService struct {
-	fileRepo   models.FileRepository
-	folderRepo models.FolderRepository
-	userRepo   models.UserRepository
+	fileRepo    models.FileRepository
+	folderRepo  models.FolderRepository
+	userRepo    models.UserRepository
+	blobRepo    models.BlobRepository
+	trashRepo   models.TrashRepository
+	versionRepo models.VersionRepository
+	validator   models.Validator
+	versions    models.VersionPolicy
+	maxTruncate int64 // the largest size Truncate pads a file to, or 0 for no limit
 }
 
-// NewFileService creates a new instance of FileService
-func NewFileService(repo models.FileRepository, folderRepo models.FolderRepository, userRepo models.UserRepository) *FileService {
-	return &FileService{fileRepo: repo, folderRepo: folderRepo, userRepo: userRepo}
+// NewFileService creates a new instance of FileService that checks new file names with the naming policy.
+// The contents of files are kept in blobRepo, where files and versions with equal contents share a blob.
+// Deleted files are moved to trashRepo. Every change of the content of a file is recorded in versionRepo, which keeps
+// the versions the policy allows. Truncate pads files with zero bytes up to at most maxTruncate bytes, so a mistyped
+// size can't fill the disk; a maxTruncate of zero doesn't limit it.
+func NewFileService(repo models.FileRepository, folderRepo models.FolderRepository, userRepo models.UserRepository, blobRepo models.BlobRepository, trashRepo models.TrashRepository, versionRepo models.VersionRepository, names models.NamePolicy, versions models.VersionPolicy, maxTruncate int64) *FileService {
+	return &FileService{fileRepo: repo, folderRepo: folderRepo, userRepo: userRepo, blobRepo: blobRepo, trashRepo: trashRepo, versionRepo: versionRepo, validator: models.NewValidator(names), versions: versions, maxTruncate: maxTruncate}
 }
 
-// CreateFile creates a new file
-func (s *FileService) CreateFile(userName, folderName, fileName, description string) error {
+// CreateFile creates a new file inside the folder at folderPath, owned by the acting user and belonging to the group of
+// the folder
+func (s *FileService) CreateFile(userName, folderPath, fileName, description string) error {
 
 	// Check if the user exists
-	exists, err := s.userRepo.Exists(userName)
+	sc, folderPath, err := resolveScope(s.userRepo, s.validator, userName, folderPath)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return errors.ErrUserNotExists(userName)
-	}
 
-	// Check if the folder exists
-	exists, err = s.folderRepo.Exists(userName, folderName)
+	// Check if the folder exists and the user may create files inside it
+	folder, err := openFolder(s.folderRepo, sc, folderPath, models.Write|models.Execute, "change the entries of")
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return errors.ErrFolderNotFound(folderName)
-	}
 
 	// Check if the file fileName is valid
-	if err := s.fileRepo.ValidateFileName(fileName); err != nil {
+	if fileName, err = s.validator.NewFileName(fileName); err != nil {
 		return err
 	}
 
 	// Create the file
+	now := time.Now()
 	file := models.File{
-		Username:    userName,
-		FolderName:  folderName,
+		Username:    sc.tree.Username,
+		FolderPath:  folderPath,
 		Name:        fileName,
 		Description: description,
-		CreatedAt:   time.Now(),
+		CreatedAt:   now,
+		ModifiedAt:  now,
+		Permissions: models.Permissions{OwnerID: sc.actor.ID, Group: folder.Group, Mode: models.DefaultFileMode},
 	}
 	return s.fileRepo.CreateFile(file)
 }
 
-// DeleteFile deletes a file
-func (s *FileService) DeleteFile(userName, folderName, fileName string) error {
+// DeleteFile moves a file to the trash of the tree that holds it, together with its content. Its history is discarded.
+func (s *FileService) DeleteFile(userName, folderPath, fileName string) error {
+	sc, file, err := s.lookupFile(userName, folderPath, fileName, models.Write|models.Execute, "change the entries of")
+	if err != nil {
+		return err
+	}
+
+	// Move the file and its content to the trash
+	return deleteFile(s.fileRepo, s.trashRepo, s.versionRepo, s.blobRepo, sc, file)
+}
+
+// ListFiles lists the files in a folder
+func (s *FileService) ListFiles(userName, folderPath, sortField, sortOrder string) ([]models.File, error) {
 
 	// Check if the user exists
-	exists, err := s.userRepo.Exists(userName)
+	sc, folderPath, err := resolveScope(s.userRepo, s.validator, userName, folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the folder exists and the user may list it
+	if _, err := openFolder(s.folderRepo, sc, folderPath, models.Read|models.Execute, "list"); err != nil {
+		return nil, err
+	}
+
+	// List the files
+	return s.fileRepo.ListFiles(sc.tree.Username, folderPath, sortField, sortOrder)
+}
+
+// GetFile returns a file with its metadata. Like stat on Unix, it only requires searching the folders along its path.
+func (s *FileService) GetFile(userName, folderPath, fileName string) (models.File, error) {
+	_, file, err := s.lookupFile(userName, folderPath, fileName, models.Execute, "search")
+	return file, err
+}
+
+// RenameFile renames a file, keeping it inside the same folder with its description, times and content
+func (s *FileService) RenameFile(userName, folderPath, fileName, newFileName string) error {
+	_, _, err := s.relocateFile(userName, folderPath, fileName, folderPath, newFileName, ConflictFail, false)
+	return err
+}
+
+// UpdateFileDescription replaces the description of a file
+func (s *FileService) UpdateFileDescription(userName, folderPath, fileName, description string) error {
+	sc, file, err := s.lookupFile(userName, folderPath, fileName, models.Execute, "search")
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return errors.ErrUserNotExists(userName)
+	if err := checkFileAccess(sc, file, models.Write, "change"); err != nil {
+		return err
+	}
+
+	file.Description = description
+	return s.fileRepo.UpdateFile(file)
+}
+
+// ConflictPolicy decides what happens when a file is moved or copied onto an existing file
+type ConflictPolicy int
+
+const (
+	// ConflictFail rejects the move or copy with ErrFileExists
+	ConflictFail ConflictPolicy = iota
+	// ConflictOverwrite replaces the existing file and its content
+	ConflictOverwrite
+	// ConflictSkip leaves both files untouched
+	ConflictSkip
+	// ConflictRename gives the moved or copied file the first free name made of its name and a number, e.g. "report1"
+	ConflictRename
+)
+
+// MoveFile moves a file to the folder at destPath under destName, which defaults to the current name.
+// The file keeps its description, times, permissions, content and history. It returns the moved file, or false if it was skipped.
+func (s *FileService) MoveFile(userName, folderPath, fileName, destPath, destName string, policy ConflictPolicy) (models.File, bool, error) {
+	return s.relocateFile(userName, folderPath, fileName, destPath, destName, policy, false)
+}
+
+// CopyFile copies a file to the folder at destPath under destName, which defaults to the current name.
+// The copy keeps the description, times, mode and content of the file, but is owned by the acting user and belongs to
+// the group of the destination folder. Its history starts with the copied content. It returns the copy, or false if it
+// was skipped.
+func (s *FileService) CopyFile(userName, folderPath, fileName, destPath, destName string, policy ConflictPolicy) (models.File, bool, error) {
+	return s.relocateFile(userName, folderPath, fileName, destPath, destName, policy, true)
+}
+
+// relocateFile moves or copies a file within the tree that holds it, resolving a name conflict with the policy.
+// Moving a file requires changing the entries of both folders, while copying it requires reading it and changing the
+// entries of the destination folder.
+func (s *FileService) relocateFile(userName, folderPath, fileName, destPath, destName string, policy ConflictPolicy, keepSource bool) (models.File, bool, error) {
+	folderAccess, folderAction := models.Write|models.Execute, "change the entries of"
+	if keepSource {
+		folderAccess, folderAction = models.Execute, "search"
+	}
+	sc, file, err := s.lookupFile(userName, folderPath, fileName, folderAccess, folderAction)
+	if err != nil {
+		return models.File{}, false, err
+	}
+	if keepSource {
+		if err := checkFileAccess(sc, file, models.Read, "read"); err != nil {
+			return models.File{}, false, err
+		}
 	}
 
-	// Check if the folder exists
-	exists, err = s.folderRepo.Exists(userName, folderName)
+	// Check if the destination folder exists in the same tree and the user may change its entries
+	destScope, destPath, err := resolveTree(s.userRepo, s.validator, sc.actor, destPath)
+	if err != nil {
+		return models.File{}, false, err
+	}
+	if destScope.tree.ID != sc.tree.ID {
+		return models.File{}, false, errors.ErrOtherTree(destScope.displayPath(destPath))
+	}
+	destFolder, err := openFolder(s.folderRepo, sc, destPath, models.Write|models.Execute, "change the entries of")
+	if err != nil {
+		return models.File{}, false, err
+	}
+
+	// Check if the destination name is valid
+	if destName == "" {
+		destName = file.Name
+	}
+	if destName, err = s.validator.NewFileName(destName); err != nil {
+		return models.File{}, false, err
+	}
+
+	// Resolve a conflict with an existing file. The repository matches names with its case policy, so the existing
+	// file may be the relocated file itself under a name that only differs in case.
+	dest := file
+	dest.FolderPath = destPath
+	dest.Name = destName
+	existing, exists, err := s.findFile(sc.tree.Username, destPath, destName)
+	if err != nil {
+		return models.File{}, false, err
+	}
+	if exists && existing.ID == file.ID && !keepSource {
+		if existing.Name == destName {
+			return file, true, nil // the file is already there
+		}
+		exists = false // moving the file onto itself only changes the case of its name
+	}
+	if exists {
+		switch policy {
+		case ConflictSkip:
+			return existing, false, nil
+		case ConflictRename:
+			if dest.Name, err = s.freeFileName(sc.tree.Username, destPath, destName); err != nil {
+				return models.File{}, false, err
+			}
+		case ConflictOverwrite:
+			if existing.ID == file.ID {
+				return models.File{}, false, errors.ErrFileExists(destName) // a file can't be copied onto itself
+			}
+		default:
+			return models.File{}, false, errors.ErrFileExists(destName)
+		}
+	}
+
+	// Relocate the file. A moved file keeps its ID and its content, while a copy shares the blob holding the content.
+	// The copy takes its reference to the blob before it exists, so a failure leaks the reference at worst.
+	overwrite := policy == ConflictOverwrite
+	if keepSource {
+		if err := s.blobRepo.RetainBlob(file.ContentHash); err != nil {
+			return models.File{}, false, err
+		}
+		dest, err = s.fileRepo.CopyFile(sc.tree.Username, file.FolderPath, file.Name, dest.FolderPath, dest.Name, overwrite)
+	} else {
+		err = s.fileRepo.MoveFile(sc.tree.Username, file.FolderPath, file.Name, dest.FolderPath, dest.Name, overwrite)
+	}
+	if err != nil {
+		return models.File{}, false, err
+	}
+	var errs []error
+	if keepSource {
+		permissions := models.Permissions{OwnerID: sc.actor.ID, Group: destFolder.Group, Mode: file.Mode}
+		if dest.Permissions != permissions {
+			dest.Permissions = permissions
+			errs = append(errs, s.fileRepo.UpdateFile(dest))
+		}
+		errs = append(errs, s.recordVersion(sc, dest))
+	}
+
+	// The content and history of a replaced file go with it. They are released last, as the file is gone even if a
+	// step before failed.
+	if exists && overwrite {
+		errs = append(errs, s.blobRepo.ReleaseBlob(existing.ContentHash), discardVersions(s.versionRepo, s.blobRepo, []models.File{existing}))
+	}
+	if err := stderrors.Join(errs...); err != nil {
+		return models.File{}, false, err
+	}
+	return dest, true, nil
+}
+
+// findFile returns the file of the user named fileName inside folderPath, and whether there is such a file
+func (s *FileService) findFile(userName, folderPath, fileName string) (models.File, bool, error) {
+	file, err := s.fileRepo.GetFile(userName, folderPath, fileName)
+	if stderrors.Is(err, errors.ErrNotFound) {
+		return models.File{}, false, nil
+	}
+	return file, err == nil, err
+}
+
+// freeFileName returns the first name made of fileName and a number that no file inside folderPath has.
+// The number goes before the extension, so "report.pdf" becomes "report1.pdf".
+func (s *FileService) freeFileName(userName, folderPath, fileName string) (string, error) {
+	base, ext := models.SplitExtension(fileName)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s%d%s", base, i, ext)
+		if _, err := s.validator.NewFileName(candidate); err != nil {
+			return "", err
+		}
+		if _, exists, err := s.findFile(userName, folderPath, candidate); err != nil || !exists {
+			return candidate, err
+		}
+	}
+}
+
+// ReadFile returns the content of a file
+func (s *FileService) ReadFile(userName, folderPath, fileName string) ([]byte, error) {
+	_, file, err := s.openFile(userName, folderPath, fileName, models.Read, "read")
+	if err != nil {
+		return nil, err
+	}
+
+	return s.blobRepo.GetBlob(file.ContentHash)
+}
+
+// WriteFile replaces the content of a file
+func (s *FileService) WriteFile(userName, folderPath, fileName string, data []byte) error {
+	sc, file, err := s.openFile(userName, folderPath, fileName, models.Write, "write")
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return errors.ErrFolderNotFound(folderName)
+
+	return s.replaceContent(sc, file, data)
+}
+
+// AppendFile adds data to the end of the content of a file. The old content is streamed into the new one, so it is
+// never held in memory as a whole.
+func (s *FileService) AppendFile(userName, folderPath, fileName string, data []byte) error {
+	sc, file, err := s.openFile(userName, folderPath, fileName, models.Write, "write")
+	if err != nil {
+		return err
 	}
 
-	// Delete the file
-	return s.fileRepo.DeleteFile(userName, folderName, fileName)
+	content, err := s.blobRepo.OpenBlob(file.ContentHash)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	var size int64
+	hash, err := s.streamContent(func(w io.Writer) error {
+		n, err := io.Copy(w, content)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		size = n + int64(len(data))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.setContent(sc, file, hash, size)
 }
 
-// ListFiles lists the files in a folder
-func (s *FileService) ListFiles(userName, folderName, sortField, sortOrder string) ([]models.File, error) {
+// Truncate changes the size of the content of a file.
+// Content beyond size is discarded, and content shorter than size is padded with zero bytes up to the limit of the
+// service.
+func (s *FileService) Truncate(userName, folderPath, fileName string, size int64) error {
+	if size < 0 {
+		return errors.ErrInvalidSize(size)
+	}
 
-	// Check if the user exists
-	exists, err := s.userRepo.Exists(userName)
+	sc, file, err := s.openFile(userName, folderPath, fileName, models.Write, "write")
+	if err != nil {
+		return err
+	}
+	if s.maxTruncate > 0 && size > s.maxTruncate && size > file.Size {
+		return errors.ErrSizeTooLarge(size, s.maxTruncate)
+	}
+
+	content, err := s.blobRepo.OpenBlob(file.ContentHash)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	hash, err := s.streamContent(func(w io.Writer) error {
+		n, err := io.Copy(w, io.LimitReader(content, size))
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(w, zeros{}, size-n)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.setContent(sc, file, hash, size)
+}
+
+// Open returns a reader of the content of a file, which loads the content one chunk at a time and can seek to any
+// offset, so files larger than the memory can be read. The reader must be closed.
+func (s *FileService) Open(userName, folderPath, fileName string) (io.ReadSeekCloser, error) {
+	_, file, err := s.openFile(userName, folderPath, fileName, models.Read, "read")
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
-		return nil, errors.ErrUserNotExists(userName)
+
+	return s.blobRepo.OpenBlob(file.ContentHash)
+}
+
+// ReadFileRange returns at most length bytes of the content of a file starting at offset. A range reaching beyond the
+// end of the content returns the bytes up to the end.
+func (s *FileService) ReadFileRange(userName, folderPath, fileName string, offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, errors.ErrInvalidRange(offset, length)
 	}
 
-	// Check if the folder exists
-	exists, err = s.folderRepo.Exists(userName, folderName)
+	content, err := s.Open(userName, folderPath, fileName)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
-		return nil, errors.ErrFolderNotFound(folderName)
+	defer content.Close()
+	if _, err := content.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
 	}
+	return io.ReadAll(io.LimitReader(content, length))
+}
 
-	// List the files
-	return s.fileRepo.ListFiles(userName, folderName, sortField, sortOrder)
+// Create returns a writer of the new content of a file, creating the file like CreateFile if it doesn't exist yet.
+// The data written is streamed into the blob store one chunk at a time, so files larger than the memory can be written.
+// The content of the file is replaced once the writer is closed, so the file keeps its old content until then. Like
+// io.PipeWriter, the writer also has a CloseWithError method, which discards the content written instead.
+func (s *FileService) Create(userName, folderPath, fileName string) (*FileWriter, error) {
+	sc, file, err := s.openFile(userName, folderPath, fileName, models.Write, "write")
+	if errors.CodeOf(err) == errors.CodeFileNotFound {
+		if err := s.CreateFile(userName, folderPath, fileName, ""); err != nil {
+			return nil, err
+		}
+		sc, file, err = s.openFile(userName, folderPath, fileName, models.Write, "write")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileWriter{service: s, sc: sc, file: file, blob: s.blobRepo.CreateBlob()}, nil
+}
+
+// FileWriter streams the new content of a file into a blob, which becomes the content of the file once it is closed.
+// It is created by FileService.Create.
+type FileWriter struct {
+	service *FileService
+	sc      scope
+	file    models.File
+	blob    models.BlobWriter
+	size    int64
+	err     error // the first error, which discards the content
+}
+
+// Write adds data to the new content
+func (w *FileWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.blob.Write(p)
+	w.size += int64(n)
+	w.err = err
+	return n, err
+}
+
+// Close stores the new content and makes it the content of the file, unless writing failed
+func (w *FileWriter) Close() error {
+	if w.err != nil {
+		return w.CloseWithError(w.err)
+	}
+	w.err = io.ErrClosedPipe
+
+	hash, err := w.blob.Commit()
+	if err != nil {
+		return err
+	}
+
+	// The file may have changed while its content was written, so the content goes to its latest state
+	file, err := w.service.fileRepo.GetFile(w.sc.tree.Username, w.file.FolderPath, w.file.Name)
+	if err == nil && file.ID != w.file.ID {
+		err = errors.ErrFileNotFound(w.file.Name)
+	}
+	if err != nil {
+		return stderrors.Join(err, w.service.blobRepo.ReleaseBlob(hash))
+	}
+	return w.service.setContent(w.sc, file, hash, w.size)
+}
+
+// CloseWithError discards the new content, leaving the file unchanged, and returns err
+func (w *FileWriter) CloseWithError(err error) error {
+	if w.err != io.ErrClosedPipe {
+		w.err = io.ErrClosedPipe
+		w.blob.Abort()
+	}
+	return err
+}
+
+// ChangeFileMode changes the permission bits of a file as mode describes, either in octal, e.g. "640", or as symbolic
+// changes, e.g. "g+r,o-rw"
+func (s *FileService) ChangeFileMode(userName, folderPath, fileName, mode string) error {
+	return s.changePermissions(userName, folderPath, fileName, func(sc scope, file models.File) (models.Permissions, error) {
+		return changeMode(sc, file.Permissions, errors.KindFile, filePath(sc, file), mode)
+	})
+}
+
+// ChangeFileOwner gives a file to the user named owner
+func (s *FileService) ChangeFileOwner(userName, folderPath, fileName, owner string) error {
+	return s.changePermissions(userName, folderPath, fileName, func(sc scope, file models.File) (models.Permissions, error) {
+		return changeOwner(s.userRepo, s.validator, sc, file.Permissions, errors.KindFile, filePath(sc, file), owner)
+	})
+}
+
+// ChangeFileGroup gives a file to the group, or to no group if group is empty. The acting user must be a member of
+// the group.
+func (s *FileService) ChangeFileGroup(userName, folderPath, fileName, group string) error {
+	return s.changePermissions(userName, folderPath, fileName, func(sc scope, file models.File) (models.Permissions, error) {
+		return changeGroup(s.validator, sc, file.Permissions, errors.KindFile, filePath(sc, file), group)
+	})
+}
+
+// changePermissions replaces the permissions of a file with the permissions returned by change
+func (s *FileService) changePermissions(userName, folderPath, fileName string, change func(scope, models.File) (models.Permissions, error)) error {
+	sc, file, err := s.lookupFile(userName, folderPath, fileName, models.Execute, "search")
+	if err != nil {
+		return err
+	}
+	if file.Permissions, err = change(sc, file); err != nil {
+		return err
+	}
+	return s.fileRepo.UpdateFile(file)
+}
+
+// openFile returns the requested file together with its scope once the acting user is granted the access to it
+func (s *FileService) openFile(userName, folderPath, fileName string, access models.Access, action string) (scope, models.File, error) {
+	sc, file, err := s.lookupFile(userName, folderPath, fileName, models.Execute, "search")
+	if err != nil {
+		return sc, models.File{}, err
+	}
+	return sc, file, checkFileAccess(sc, file, access, action)
+}
+
+// lookupFile checks that the user and the folder exist and returns the requested file together with its scope, once
+// the acting user is granted the access to the folder
+func (s *FileService) lookupFile(userName, folderPath, fileName string, folderAccess models.Access, folderAction string) (scope, models.File, error) {
+
+	// Check if the user exists
+	sc, folderPath, err := resolveScope(s.userRepo, s.validator, userName, folderPath)
+	if err != nil {
+		return sc, models.File{}, err
+	}
+
+	// Check if the folder exists and the user may access it
+	if _, err := openFolder(s.folderRepo, sc, folderPath, folderAccess, folderAction); err != nil {
+		return sc, models.File{}, err
+	}
+
+	// Check if the file fileName is valid
+	if fileName, err = s.validator.FileName(fileName); err != nil {
+		return sc, models.File{}, err
+	}
+	file, err := s.fileRepo.GetFile(sc.tree.Username, folderPath, fileName)
+	return sc, file, err
+}
+
+// replaceContent stores data as the new content of a file the acting user is changing, see setContent
+func (s *FileService) replaceContent(sc scope, file models.File, data []byte) error {
+	hash, err := s.blobRepo.PutBlob(data)
+	if err != nil {
+		return err
+	}
+	return s.setContent(sc, file, hash, int64(len(data)))
+}
+
+// streamContent stores the data that write writes as a new blob and returns its hash. The blob is discarded if write
+// fails.
+func (s *FileService) streamContent(write func(w io.Writer) error) (string, error) {
+	w := s.blobRepo.CreateBlob()
+	if err := write(w); err != nil {
+		w.Abort()
+		return "", err
+	}
+	return w.Commit()
+}
+
+// setContent makes the stored blob with the hash, which holds size bytes, the new content of a file the acting user is
+// changing, adds the new content to the history of the file and releases the blob of the old content.
+// Every step takes its reference to a blob before anything points to it, and the old content is released last, so a
+// failure halfway leaks a reference at worst, which fsck repairs, but never leaves the file or a version pointing to a
+// released blob. The errors of the steps after the file was updated are combined.
+func (s *FileService) setContent(sc scope, file models.File, hash string, size int64) error {
+	oldHash := file.ContentHash
+	file.ContentHash = hash
+	file.Size = size
+	file.ModifiedAt = time.Now()
+	if err := s.fileRepo.UpdateFile(file); err != nil {
+		return err
+	}
+	return stderrors.Join(s.recordVersion(sc, file), s.blobRepo.ReleaseBlob(oldHash))
+}
+
+// zeros is a reader of endless zero bytes, which pad content up to a size
+type zeros struct{}
+
+// Read fills p with zero bytes
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// CollectGarbage removes the blobs that no file, version or trash entry references any longer and returns how many
+// were removed
+func (s *FileService) CollectGarbage() (int, error) {
+	return s.blobRepo.CollectGarbage()
 }
@@ -1,6 +1,10 @@
 package service_test
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,26 +15,166 @@ import (
 
 // MockFileRepository is a mock of FileRepository
 type MockFileRepository struct {
-	CreateFileFunc       func(models.File) error
-	DeleteFileFunc       func(string, string, string) error
-	ListFilesFunc        func(string, string, string, string) ([]models.File, error)
-	ValidateFileNameFunc func(string) error
+	CreateFileFunc func(models.File) error
+	GetFileFunc    func(string, string, string) (models.File, error)
+	UpdateFileFunc func(models.File) error
+	DeleteFileFunc func(string, string, string) error
+	MoveFileFunc   func(string, string, string, string, string, bool) error
+	CopyFileFunc   func(string, string, string, string, string, bool) (models.File, error)
+	ListFilesFunc  func(string, string, string, string) ([]models.File, error)
 }
 
 func (m *MockFileRepository) CreateFile(file models.File) error {
 	return m.CreateFileFunc(file)
 }
 
-func (m *MockFileRepository) DeleteFile(userName, folderName, fileName string) error {
-	return m.DeleteFileFunc(userName, folderName, fileName)
+func (m *MockFileRepository) GetFile(userName, folderPath, fileName string) (models.File, error) {
+	return m.GetFileFunc(userName, folderPath, fileName)
 }
 
-func (m *MockFileRepository) ListFiles(userName, folderName, sortField, sortOrder string) ([]models.File, error) {
-	return m.ListFilesFunc(userName, folderName, sortField, sortOrder)
+func (m *MockFileRepository) UpdateFile(file models.File) error {
+	return m.UpdateFileFunc(file)
 }
 
-func (m *MockFileRepository) ValidateFileName(fileName string) error {
-	return m.ValidateFileNameFunc(fileName)
+func (m *MockFileRepository) DeleteFile(userName, folderPath, fileName string) error {
+	return m.DeleteFileFunc(userName, folderPath, fileName)
+}
+
+func (m *MockFileRepository) MoveFile(userName, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) error {
+	return m.MoveFileFunc(userName, folderPath, fileName, newFolderPath, newFileName, overwrite)
+}
+
+func (m *MockFileRepository) CopyFile(userName, folderPath, fileName, newFolderPath, newFileName string, overwrite bool) (models.File, error) {
+	return m.CopyFileFunc(userName, folderPath, fileName, newFolderPath, newFileName, overwrite)
+}
+
+func (m *MockFileRepository) ListFiles(userName, folderPath, sortField, sortOrder string) ([]models.File, error) {
+	return m.ListFilesFunc(userName, folderPath, sortField, sortOrder)
+}
+
+// testFilePermissions are the permissions of a file the test user (ID 1) created
+var testFilePermissions = models.Permissions{OwnerID: 1, Mode: models.DefaultFileMode}
+
+// MockBlobRepository is a mock of BlobRepository
+type MockBlobRepository struct {
+	PutBlobFunc        func([]byte) (string, error)
+	GetBlobFunc        func(string) ([]byte, error)
+	OpenBlobFunc       func(string) (io.ReadSeekCloser, error)
+	CreateBlobFunc     func() models.BlobWriter
+	RetainBlobFunc     func(string) error
+	ReleaseBlobFunc    func(string) error
+	CollectGarbageFunc func() (int, error)
+}
+
+func (m *MockBlobRepository) PutBlob(data []byte) (string, error) {
+	return m.PutBlobFunc(data)
+}
+
+func (m *MockBlobRepository) GetBlob(hash string) ([]byte, error) {
+	return m.GetBlobFunc(hash)
+}
+
+func (m *MockBlobRepository) OpenBlob(hash string) (io.ReadSeekCloser, error) {
+	return m.OpenBlobFunc(hash)
+}
+
+func (m *MockBlobRepository) CreateBlob() models.BlobWriter {
+	return m.CreateBlobFunc()
+}
+
+func (m *MockBlobRepository) RetainBlob(hash string) error {
+	return m.RetainBlobFunc(hash)
+}
+
+func (m *MockBlobRepository) ReleaseBlob(hash string) error {
+	return m.ReleaseBlobFunc(hash)
+}
+
+func (m *MockBlobRepository) CollectGarbage() (int, error) {
+	return m.CollectGarbageFunc()
+}
+
+// MockBlobWriter is a mock of BlobWriter that buffers the data written to it and passes it to CommitFunc
+type MockBlobWriter struct {
+	bytes.Buffer
+	CommitFunc func([]byte) (string, error)
+	Aborted    bool
+}
+
+func (w *MockBlobWriter) Commit() (string, error) {
+	return w.CommitFunc(w.Bytes())
+}
+
+func (w *MockBlobWriter) Abort() error {
+	w.Aborted = true
+	return nil
+}
+
+// blobReader returns a reader of the content like the ones OpenBlob returns
+func blobReader(content string) io.ReadSeekCloser {
+	return nopCloser{strings.NewReader(content)}
+}
+
+// nopCloser adds a Close method that does nothing to a reader
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// newBlobs returns a blob repository that keeps the data of every blob and the number of its references in the maps.
+// A blob is dropped from both maps once its last reference is released.
+func newBlobs(data map[string][]byte, refs map[string]int) *MockBlobRepository {
+	put := func(content []byte) (string, error) {
+		hash := models.HashContent(content)
+		data[hash] = append([]byte{}, content...)
+		refs[hash]++
+		return hash, nil
+	}
+	get := func(hash string) ([]byte, error) {
+		content, ok := data[hash]
+		if hash != "" && !ok {
+			return nil, customErrors.ErrBlobNotFound(hash)
+		}
+		return append([]byte{}, content...), nil
+	}
+	return &MockBlobRepository{
+		PutBlobFunc: put,
+		GetBlobFunc: get,
+		OpenBlobFunc: func(hash string) (io.ReadSeekCloser, error) {
+			content, err := get(hash)
+			if err != nil {
+				return nil, err
+			}
+			return blobReader(string(content)), nil
+		},
+		CreateBlobFunc: func() models.BlobWriter {
+			return &MockBlobWriter{CommitFunc: put}
+		},
+		RetainBlobFunc: func(hash string) error {
+			if _, ok := data[hash]; hash != "" && !ok {
+				return customErrors.ErrBlobNotFound(hash)
+			}
+			if hash != "" {
+				refs[hash]++
+			}
+			return nil
+		},
+		ReleaseBlobFunc: func(hash string) error {
+			if refs[hash]--; refs[hash] <= 0 {
+				delete(refs, hash)
+				delete(data, hash)
+			}
+			return nil
+		},
+	}
+}
+
+// hashOf returns the hash of the blob holding the content
+func hashOf(content string) string {
+	return models.HashContent([]byte(content))
 }
 
 // TestFileService_CreateFile tests the CreateFile method using table-driven tests
@@ -59,7 +203,23 @@ func TestCreateFile(t *testing.T) {
 				folderRepo.ExistsFunc = func(string, string) (bool, error) { return true, nil }
 			},
 			mockFileSetup: func(fileRepo *MockFileRepository) {
-				fileRepo.ValidateFileNameFunc = func(string) error { return nil }
+				fileRepo.CreateFileFunc = func(models.File) error { return nil }
+			},
+			expectedError: nil,
+		},
+		{
+			name:        "ValidFileCreationAtRoot",
+			userName:    "testUser",
+			folderName:  "/",
+			fileName:    "testFile",
+			description: "A test file",
+			mockUserSetup: func(userRepo *MockUserRepository) {
+				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.ExistsFunc = func(string, string) (bool, error) { return false, nil }
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
 				fileRepo.CreateFileFunc = func(models.File) error { return nil }
 			},
 			expectedError: nil,
@@ -77,7 +237,6 @@ func TestCreateFile(t *testing.T) {
 				folderRepo.ExistsFunc = func(string, string) (bool, error) { return true, nil }
 			},
 			mockFileSetup: func(fileRepo *MockFileRepository) {
-				fileRepo.ValidateFileNameFunc = func(string) error { return nil }
 				fileRepo.CreateFileFunc = func(models.File) error { return nil }
 			},
 			expectedError: customErrors.ErrUserNotExists("unknownUser"),
@@ -95,10 +254,9 @@ func TestCreateFile(t *testing.T) {
 				folderRepo.ExistsFunc = func(string, string) (bool, error) { return false, nil }
 			},
 			mockFileSetup: func(fileRepo *MockFileRepository) {
-				fileRepo.ValidateFileNameFunc = func(string) error { return nil }
 				fileRepo.CreateFileFunc = func(models.File) error { return nil }
 			},
-			expectedError: customErrors.ErrFolderNotFound("unknownFolder"),
+			expectedError: customErrors.ErrFolderNotFound("/unknownFolder"),
 		},
 		{
 			name:        "InvalidFileName",
@@ -114,10 +272,9 @@ func TestCreateFile(t *testing.T) {
 				folderRepo.ExistsFunc = func(string, string) (bool, error) { return true, nil }
 			},
 			mockFileSetup: func(fileRepo *MockFileRepository) {
-				fileRepo.ValidateFileNameFunc = func(string) error { return customErrors.ErrInvalidName("invalid@file") }
 				fileRepo.CreateFileFunc = func(models.File) error { return nil }
 			},
-			expectedError: customErrors.ErrInvalidName("invalid@file"),
+			expectedError: customErrors.ErrInvalidName("invalid@file", allowedChars),
 		},
 	}
 
@@ -129,7 +286,7 @@ func TestCreateFile(t *testing.T) {
 			tt.mockUserSetup(mockUserRepository)
 			mockFileRepository := &MockFileRepository{}
 			tt.mockFileSetup(mockFileRepository)
-			fileService := service.NewFileService(mockFileRepository, mockFolderRepository, mockUserRepository)
+			fileService := service.NewFileService(mockFileRepository, mockFolderRepository, mockUserRepository, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
 
 			err := fileService.CreateFile(tt.userName, tt.folderName, tt.fileName, tt.description)
 			if tt.expectedError != nil {
@@ -140,3 +297,719 @@ func TestCreateFile(t *testing.T) {
 		})
 	}
 }
+
+// TestCreateFileNamePolicy tests that CreateFile checks file names with the naming policy of the service
+func TestCreateFileNamePolicy(t *testing.T) {
+	policy := func(change func(p *models.NamePolicy)) models.NamePolicy {
+		p := models.DefaultNamePolicy()
+		change(&p)
+		return p
+	}
+
+	tests := []struct {
+		name          string
+		policy        models.NamePolicy
+		fileName      string
+		expectedName  string
+		expectedError error
+	}{
+		{
+			name:         "DefaultAllowsExtensions",
+			policy:       models.DefaultNamePolicy(),
+			fileName:     "report.pdf",
+			expectedName: "report.pdf",
+		},
+		{
+			name:         "DefaultAllowsUnicode",
+			policy:       models.DefaultNamePolicy(),
+			fileName:     "報告_2024.txt",
+			expectedName: "報告_2024.txt",
+		},
+		{
+			name:         "DefaultNormalizesToNFC",
+			policy:       models.DefaultNamePolicy(),
+			fileName:     "cafe\u0301",
+			expectedName: "caf\u00e9",
+		},
+		{
+			name:         "NormalizationDisabled",
+			policy:       policy(func(p *models.NamePolicy) { p.NFC = false }),
+			fileName:     "cafe\u0301",
+			expectedName: "cafe\u0301",
+		},
+		{
+			name:          "DefaultRejectsDotDot",
+			policy:        models.DefaultNamePolicy(),
+			fileName:      "..",
+			expectedError: customErrors.ErrInvalidName("..", ""),
+		},
+		{
+			name: "ASCIIOnly",
+			policy: policy(func(p *models.NamePolicy) {
+				p.Classes = []models.CharClass{models.ASCIILetters, models.ASCIIDigits}
+				p.Symbols = ""
+			}),
+			fileName:      "café",
+			expectedError: customErrors.ErrInvalidName("café", "ASCII letters and ASCII digits"),
+		},
+		{
+			name:          "LengthCountedInRunes",
+			policy:        policy(func(p *models.NamePolicy) { p.MaxLength = 4 }),
+			fileName:      "日本語です",
+			expectedError: customErrors.ErrNameTooLong("日本語です", 4),
+		},
+		{
+			name:          "ExtensionNotAllowed",
+			policy:        policy(func(p *models.NamePolicy) { p.Extensions = []string{".txt", ".md"} }),
+			fileName:      "report.pdf",
+			expectedError: customErrors.ErrInvalidExtension("report.pdf", ".txt, .md"),
+		},
+		{
+			name:          "ExtensionMissing",
+			policy:        policy(func(p *models.NamePolicy) { p.Extensions = []string{".txt"} }),
+			fileName:      "report",
+			expectedError: customErrors.ErrInvalidExtension("report", ".txt"),
+		},
+		{
+			name:         "ExtensionMatchedRegardlessOfCase",
+			policy:       policy(func(p *models.NamePolicy) { p.Extensions = []string{".txt"} }),
+			fileName:     "notes.TXT",
+			expectedName: "notes.TXT",
+		},
+		{
+			name:          "ReservedName",
+			policy:        policy(func(p *models.NamePolicy) { p.Reserved = []string{"CON"} }),
+			fileName:      "con",
+			expectedError: customErrors.ErrReservedName("con"),
+		},
+		{
+			name:          "ReservedNameWithExtension",
+			policy:        policy(func(p *models.NamePolicy) { p.Reserved = []string{"CON"} }),
+			fileName:      "Con.txt",
+			expectedError: customErrors.ErrReservedName("Con.txt"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var created models.File
+			mockUserRepository := &MockUserRepository{ExistsFunc: func(string) (bool, error) { return true, nil }}
+			mockFolderRepository := &MockFolderRepository{}
+			mockFileRepository := &MockFileRepository{CreateFileFunc: func(file models.File) error { created = file; return nil }}
+			fileService := service.NewFileService(mockFileRepository, mockFolderRepository, mockUserRepository, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, tt.policy, models.DefaultVersionPolicy(), 0)
+
+			err := fileService.CreateFile("testUser", "/", tt.fileName, "")
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				assert.Equal(t, customErrors.CodeOf(tt.expectedError), customErrors.CodeOf(err))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedName, created.Name)
+			}
+		})
+	}
+}
+
+// TestNewFileNamesFollowTheNamingPolicy tests that a file can be renamed, moved and copied to exactly the names it can
+// be created with
+func TestNewFileNamesFollowTheNamingPolicy(t *testing.T) {
+	policy := models.DefaultNamePolicy()
+	policy.Extensions = []string{".txt"}
+	policy.Reserved = []string{"CON"}
+
+	tests := []struct {
+		name          string
+		fileName      string
+		expectedError error
+	}{
+		{name: "Valid", fileName: "report.txt"},
+		{name: "Unicode", fileName: "résumé.txt"},
+		{name: "InvalidChar", fileName: "bad@name.txt", expectedError: customErrors.ErrInvalidName("bad@name.txt", allowedChars)},
+		{name: "TooLong", fileName: strings.Repeat("a", 27) + ".txt", expectedError: customErrors.ErrNameTooLong(strings.Repeat("a", 27)+".txt", 30)},
+		{name: "Reserved", fileName: "con.txt", expectedError: customErrors.ErrReservedName("con.txt")},
+		{name: "InvalidExtension", fileName: "report.pdf", expectedError: customErrors.ErrInvalidExtension("report.pdf", ".txt")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserRepository := &MockUserRepository{ExistsFunc: func(string) (bool, error) { return true, nil }}
+			mockFolderRepository := &MockFolderRepository{ExistsFunc: func(string, string) (bool, error) { return true, nil }}
+			mockFileRepository := &MockFileRepository{
+				GetFileFunc: func(_, folderPath, fileName string) (models.File, error) {
+					if fileName == "old.txt" {
+						return models.File{ID: 1, Username: "testUser", FolderPath: folderPath, Name: fileName, Permissions: testFilePermissions}, nil
+					}
+					return models.File{}, customErrors.ErrFileNotFound(fileName)
+				},
+				CreateFileFunc: func(models.File) error { return nil },
+				MoveFileFunc:   func(string, string, string, string, string, bool) error { return nil },
+				CopyFileFunc: func(userName, _, _, newFolderPath, newFileName string, _ bool) (models.File, error) {
+					return models.File{ID: 2, Username: userName, FolderPath: newFolderPath, Name: newFileName, Permissions: testFilePermissions}, nil
+				},
+			}
+			mockBlobRepository := &MockBlobRepository{RetainBlobFunc: func(string) error { return nil }}
+			fileService := service.NewFileService(mockFileRepository, mockFolderRepository, mockUserRepository, mockBlobRepository, &MockTrashRepository{}, &MockVersionRepository{}, policy, models.DefaultVersionPolicy(), 0)
+
+			errs := map[string]error{"CreateFile": fileService.CreateFile("testUser", "/docs", tt.fileName, "")}
+			errs["RenameFile"] = fileService.RenameFile("testUser", "/docs", "old.txt", tt.fileName)
+			_, _, errs["MoveFile"] = fileService.MoveFile("testUser", "/docs", "old.txt", "/archive", tt.fileName, service.ConflictFail)
+			_, _, errs["CopyFile"] = fileService.CopyFile("testUser", "/docs", "old.txt", "/archive", tt.fileName, service.ConflictFail)
+			for method, err := range errs {
+				if tt.expectedError != nil {
+					assert.EqualError(t, err, tt.expectedError.Error(), method)
+				} else {
+					assert.NoError(t, err, method)
+				}
+			}
+		})
+	}
+}
+
+// TestFileContent tests the content operations of FileService using table-driven tests
+func TestFileContent(t *testing.T) {
+	existingFile := models.File{ID: 7, Username: "testUser", FolderPath: "/testFolder", Name: "testFile", Size: 5, ContentHash: hashOf("hello"), Permissions: testFilePermissions}
+
+	tests := []struct {
+		name          string
+		testFunc      func(t *testing.T, fileService *service.FileService, updated *models.File)
+		mockFileSetup func(fileRepo *MockFileRepository)
+		mockBlobSetup func(blobRepo *MockBlobRepository)
+	}{
+		{
+			name: "ReadFile",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				data, err := fileService.ReadFile("testUser", "testFolder", "testFile")
+				assert.NoError(t, err)
+				assert.Equal(t, "hello", string(data))
+			},
+			mockBlobSetup: func(blobRepo *MockBlobRepository) {
+				blobRepo.GetBlobFunc = func(hash string) ([]byte, error) {
+					assert.Equal(t, hashOf("hello"), hash)
+					return []byte("hello"), nil
+				}
+			},
+		},
+		{
+			name: "WriteFileTracksSize",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				err := fileService.WriteFile("testUser", "testFolder", "testFile", []byte("hello world"))
+				assert.NoError(t, err)
+				assert.Equal(t, int64(11), updated.Size)
+				assert.Equal(t, hashOf("hello world"), updated.ContentHash)
+				assert.False(t, updated.ModifiedAt.IsZero())
+			},
+			mockBlobSetup: func(blobRepo *MockBlobRepository) {
+				blobRepo.PutBlobFunc = func(data []byte) (string, error) { return models.HashContent(data), nil }
+				blobRepo.RetainBlobFunc = func(string) error { return nil }
+				blobRepo.ReleaseBlobFunc = func(hash string) error {
+					assert.Equal(t, hashOf("hello"), hash)
+					return nil
+				}
+			},
+		},
+		{
+			name: "AppendFileTracksSize",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				err := fileService.AppendFile("testUser", "testFolder", "testFile", []byte(" world"))
+				assert.NoError(t, err)
+				assert.Equal(t, int64(11), updated.Size)
+			},
+			mockBlobSetup: func(blobRepo *MockBlobRepository) {
+				blobRepo.OpenBlobFunc = func(string) (io.ReadSeekCloser, error) { return blobReader("hello"), nil }
+				blobRepo.CreateBlobFunc = func() models.BlobWriter {
+					return &MockBlobWriter{CommitFunc: func(data []byte) (string, error) {
+						assert.Equal(t, "hello world", string(data))
+						return models.HashContent(data), nil
+					}}
+				}
+				blobRepo.RetainBlobFunc = func(string) error { return nil }
+				blobRepo.ReleaseBlobFunc = func(string) error { return nil }
+			},
+		},
+		{
+			name: "TruncateTracksSize",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				err := fileService.Truncate("testUser", "testFolder", "testFile", 2)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(2), updated.Size)
+			},
+			mockBlobSetup: func(blobRepo *MockBlobRepository) {
+				blobRepo.OpenBlobFunc = func(string) (io.ReadSeekCloser, error) { return blobReader("hello"), nil }
+				blobRepo.CreateBlobFunc = func() models.BlobWriter {
+					return &MockBlobWriter{CommitFunc: func(data []byte) (string, error) {
+						assert.Equal(t, "he", string(data))
+						return models.HashContent(data), nil
+					}}
+				}
+				blobRepo.RetainBlobFunc = func(string) error { return nil }
+				blobRepo.ReleaseBlobFunc = func(string) error { return nil }
+			},
+		},
+		{
+			name: "TruncatePadsWithZeros",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				err := fileService.Truncate("testUser", "testFolder", "testFile", 7)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(7), updated.Size)
+			},
+			mockBlobSetup: func(blobRepo *MockBlobRepository) {
+				blobRepo.OpenBlobFunc = func(string) (io.ReadSeekCloser, error) { return blobReader("hello"), nil }
+				blobRepo.CreateBlobFunc = func() models.BlobWriter {
+					return &MockBlobWriter{CommitFunc: func(data []byte) (string, error) {
+						assert.Equal(t, "hello\x00\x00", string(data))
+						return models.HashContent(data), nil
+					}}
+				}
+				blobRepo.RetainBlobFunc = func(string) error { return nil }
+				blobRepo.ReleaseBlobFunc = func(string) error { return nil }
+			},
+		},
+		{
+			name: "TruncateAboveLimit",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				err := fileService.Truncate("testUser", "testFolder", "testFile", 1025)
+				assert.EqualError(t, err, customErrors.ErrSizeTooLarge(1025, 1024).Error())
+				assert.Zero(t, *updated)
+			},
+			// The blob mock has no functions, so padding the content fails the test
+		},
+		{
+			name: "TruncateNegativeSize",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				err := fileService.Truncate("testUser", "testFolder", "testFile", -1)
+				assert.EqualError(t, err, customErrors.ErrInvalidSize(-1).Error())
+			},
+		},
+		{
+			name: "UpdateFileDescription",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				err := fileService.UpdateFileDescription("testUser", "testFolder", "testFile", "new description")
+				assert.NoError(t, err)
+				assert.Equal(t, "new description", updated.Description)
+				assert.Equal(t, int64(5), updated.Size)
+			},
+		},
+		{
+			name: "DeleteFileKeepsBlobForTrash",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				assert.NoError(t, fileService.DeleteFile("testUser", "testFolder", "testFile"))
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
+				fileRepo.DeleteFileFunc = func(string, string, string) error { return nil }
+			},
+			// The blob mock has no functions, so touching the blob while deleting the file fails the test
+		},
+		{
+			name: "WriteMissingFile",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File) {
+				err := fileService.WriteFile("testUser", "testFolder", "missingFile", []byte("hello"))
+				assert.EqualError(t, err, customErrors.ErrFileNotFound("missingFile").Error())
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
+				fileRepo.GetFileFunc = func(_, _, fileName string) (models.File, error) {
+					return models.File{}, customErrors.ErrFileNotFound(fileName)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updated models.File
+			mockUserRepository := &MockUserRepository{ExistsFunc: func(string) (bool, error) { return true, nil }}
+			mockFolderRepository := &MockFolderRepository{ExistsFunc: func(string, string) (bool, error) { return true, nil }}
+			mockFileRepository := &MockFileRepository{
+				GetFileFunc:    func(string, string, string) (models.File, error) { return existingFile, nil },
+				UpdateFileFunc: func(file models.File) error { updated = file; return nil },
+			}
+			if tt.mockFileSetup != nil {
+				tt.mockFileSetup(mockFileRepository)
+			}
+			mockBlobRepository := &MockBlobRepository{}
+			if tt.mockBlobSetup != nil {
+				tt.mockBlobSetup(mockBlobRepository)
+			}
+			fileService := service.NewFileService(mockFileRepository, mockFolderRepository, mockUserRepository, mockBlobRepository, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 1024)
+
+			tt.testFunc(t, fileService, &updated)
+		})
+	}
+}
+
+// TestFileStreams tests the streaming and range operations of FileService using table-driven tests
+func TestFileStreams(t *testing.T) {
+	hello := hashOf("hello")
+	existingFile := models.File{ID: 7, Username: "testUser", FolderPath: "/testFolder", Name: "testFile", Size: 5, ContentHash: hello, Permissions: testFilePermissions}
+
+	tests := []struct {
+		name          string
+		testFunc      func(t *testing.T, fileService *service.FileService, updated *models.File, refs map[string]int)
+		mockFileSetup func(fileRepo *MockFileRepository)
+		mockBlobSetup func(blobRepo *MockBlobRepository)
+	}{
+		{
+			name: "OpenSeeks",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File, refs map[string]int) {
+				r, err := fileService.Open("testUser", "testFolder", "testFile")
+				assert.NoError(t, err)
+				defer r.Close()
+				_, err = r.Seek(1, io.SeekStart)
+				assert.NoError(t, err)
+				data, err := io.ReadAll(r)
+				assert.NoError(t, err)
+				assert.Equal(t, "ello", string(data))
+			},
+		},
+		{
+			name: "ReadFileRange",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File, refs map[string]int) {
+				for _, r := range []struct {
+					offset, length int64
+					expected       string
+				}{{1, 3, "ell"}, {3, 10, "lo"}, {10, 2, ""}, {0, 0, ""}} {
+					data, err := fileService.ReadFileRange("testUser", "testFolder", "testFile", r.offset, r.length)
+					assert.NoError(t, err)
+					assert.Equal(t, r.expected, string(data))
+				}
+			},
+		},
+		{
+			name: "ReadFileRangeInvalid",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File, refs map[string]int) {
+				_, err := fileService.ReadFileRange("testUser", "testFolder", "testFile", -1, 2)
+				assert.EqualError(t, err, customErrors.ErrInvalidRange(-1, 2).Error())
+			},
+		},
+		{
+			name: "CreateReplacesContentOnClose",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File, refs map[string]int) {
+				w, err := fileService.Create("testUser", "testFolder", "testFile")
+				assert.NoError(t, err)
+				for _, part := range []string{"hello", " world"} {
+					_, err := io.WriteString(w, part)
+					assert.NoError(t, err)
+				}
+				assert.Equal(t, map[string]int{hello: 1}, refs) // the file keeps its content until the writer is closed
+				assert.NoError(t, w.Close())
+				assert.Equal(t, int64(11), updated.Size)
+				assert.Equal(t, hashOf("hello world"), updated.ContentHash)
+				assert.Equal(t, map[string]int{hashOf("hello world"): 2}, refs) // the file and its version
+				_, err = w.Write([]byte("late"))
+				assert.Error(t, err)
+			},
+		},
+		{
+			name: "CreateClosedWithErrorKeepsContent",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File, refs map[string]int) {
+				w, err := fileService.Create("testUser", "testFolder", "testFile")
+				assert.NoError(t, err)
+				_, err = io.WriteString(w, "partial")
+				assert.NoError(t, err)
+				failed := fmt.Errorf("the source failed")
+				assert.Equal(t, failed, w.CloseWithError(failed))
+				assert.Zero(t, *updated)
+				assert.Equal(t, map[string]int{hello: 1}, refs)
+			},
+		},
+		{
+			name: "CreateMissingFile",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File, refs map[string]int) {
+				w, err := fileService.Create("testUser", "testFolder", "newFile")
+				assert.NoError(t, err)
+				_, err = io.WriteString(w, "new")
+				assert.NoError(t, err)
+				assert.NoError(t, w.Close())
+				assert.Equal(t, "newFile", updated.Name)
+				assert.Equal(t, int64(3), updated.Size)
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
+				var created *models.File
+				fileRepo.CreateFileFunc = func(file models.File) error {
+					file.ID = 8
+					created = &file
+					return nil
+				}
+				fileRepo.GetFileFunc = func(_, _, fileName string) (models.File, error) {
+					if created == nil {
+						return models.File{}, customErrors.ErrFileNotFound(fileName)
+					}
+					return *created, nil
+				}
+			},
+		},
+		{
+			name: "CreateDiscardsContentOfRemovedFile",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File, refs map[string]int) {
+				w, err := fileService.Create("testUser", "testFolder", "testFile")
+				assert.NoError(t, err)
+				_, err = io.WriteString(w, "bye")
+				assert.NoError(t, err)
+				assert.EqualError(t, w.Close(), customErrors.ErrFileNotFound("testFile").Error())
+				assert.Equal(t, map[string]int{hello: 1}, refs)
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
+				lookups := 0
+				fileRepo.GetFileFunc = func(_, _, fileName string) (models.File, error) {
+					if lookups++; lookups > 1 {
+						return models.File{}, customErrors.ErrFileNotFound(fileName) // deleted while it was written
+					}
+					return existingFile, nil
+				}
+			},
+		},
+		{
+			name: "CreateReportsReleaseFailure",
+			testFunc: func(t *testing.T, fileService *service.FileService, updated *models.File, refs map[string]int) {
+				w, err := fileService.Create("testUser", "testFolder", "testFile")
+				assert.NoError(t, err)
+				_, err = io.WriteString(w, "bye")
+				assert.NoError(t, err)
+				err = w.Close()
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+				assert.ErrorContains(t, err, "the blob store failed")
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
+				lookups := 0
+				fileRepo.GetFileFunc = func(_, _, fileName string) (models.File, error) {
+					if lookups++; lookups > 1 {
+						return models.File{}, customErrors.ErrFileNotFound(fileName) // deleted while it was written
+					}
+					return existingFile, nil
+				}
+			},
+			mockBlobSetup: func(blobRepo *MockBlobRepository) {
+				blobRepo.ReleaseBlobFunc = func(string) error { return fmt.Errorf("the blob store failed") }
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updated models.File
+			data := map[string][]byte{hello: []byte("hello")}
+			refs := map[string]int{hello: 1}
+			mockUserRepository := &MockUserRepository{ExistsFunc: func(string) (bool, error) { return true, nil }}
+			mockFolderRepository := &MockFolderRepository{ExistsFunc: func(string, string) (bool, error) { return true, nil }}
+			mockFileRepository := &MockFileRepository{
+				GetFileFunc:    func(string, string, string) (models.File, error) { return existingFile, nil },
+				UpdateFileFunc: func(file models.File) error { updated = file; return nil },
+			}
+			if tt.mockFileSetup != nil {
+				tt.mockFileSetup(mockFileRepository)
+			}
+			mockBlobRepository := newBlobs(data, refs)
+			if tt.mockBlobSetup != nil {
+				tt.mockBlobSetup(mockBlobRepository)
+			}
+			fileService := service.NewFileService(mockFileRepository, mockFolderRepository, mockUserRepository, mockBlobRepository, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
+
+			tt.testFunc(t, fileService, &updated, refs)
+		})
+	}
+}
+
+// TestRelocateFile tests the MoveFile and CopyFile methods of FileService using table-driven tests
+func TestRelocateFile(t *testing.T) {
+	hello, taken := hashOf("hello"), hashOf("taken")
+	sourceFile := models.File{ID: 1, Username: "testUser", FolderPath: "/source", Name: "testFile", Description: "report", Size: 5, ContentHash: hello, Permissions: testFilePermissions}
+
+	tests := []struct {
+		name            string
+		testFunc        func(t *testing.T, fileService *service.FileService, refs map[string]int)
+		mockFolderSetup func(folderRepo *MockFolderRepository)
+		mockFileSetup   func(fileRepo *MockFileRepository)
+	}{
+		{
+			name: "MoveCarriesMetadataAndContent",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				moved, ok, err := fileService.MoveFile("testUser", "/source", "testFile", "/dest", "", service.ConflictFail)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, "/dest/testFile", moved.Path())
+				assert.Equal(t, "report", moved.Description)
+				assert.Equal(t, map[string]int{hello: 1, taken: 1}, refs) // the blob stays with the file
+			},
+		},
+		{
+			name: "CopyKeepsSourceContent",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				copied, ok, err := fileService.CopyFile("testUser", "/source", "testFile", "/dest", "newFile", service.ConflictFail)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, "/dest/newFile", copied.Path())
+				assert.Equal(t, hello, copied.ContentHash)
+				assert.Equal(t, map[string]int{hello: 3, taken: 1}, refs) // the copy and its first version share the blob
+			},
+		},
+		{
+			name: "ConflictFails",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				_, _, err := fileService.MoveFile("testUser", "/source", "testFile", "/dest", "taken", service.ConflictFail)
+				assert.EqualError(t, err, customErrors.ErrFileExists("taken").Error())
+			},
+		},
+		{
+			name: "ConflictSkips",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				_, ok, err := fileService.MoveFile("testUser", "/source", "testFile", "/dest", "taken", service.ConflictSkip)
+				assert.NoError(t, err)
+				assert.False(t, ok)
+				assert.Equal(t, map[string]int{hello: 1, taken: 1}, refs)
+			},
+		},
+		{
+			name: "ConflictRenames",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				copied, ok, err := fileService.CopyFile("testUser", "/source", "testFile", "/dest", "taken", service.ConflictRename)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, "taken2", copied.Name) // taken1 is taken too
+			},
+		},
+		{
+			name: "ConflictRenamesBeforeExtension",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				copied, ok, err := fileService.CopyFile("testUser", "/source", "testFile", "/dest", "taken.pdf", service.ConflictRename)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, "taken1.pdf", copied.Name)
+			},
+		},
+		{
+			name: "ConflictOverwrites",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				_, ok, err := fileService.MoveFile("testUser", "/source", "testFile", "/dest", "taken", service.ConflictOverwrite)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, map[string]int{hello: 1}, refs) // the replaced file releases its blob
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
+				fileRepo.MoveFileFunc = func(_, _, _, _, _ string, overwrite bool) error {
+					assert.True(t, overwrite)
+					return nil
+				}
+			},
+		},
+		{
+			name: "RenameFile",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				err := fileService.RenameFile("testUser", "/source", "testFile", "newFile")
+				assert.NoError(t, err)
+				assert.Equal(t, map[string]int{hello: 1, taken: 1}, refs)
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
+				fileRepo.MoveFileFunc = func(_, folderPath, _, newFolderPath, newFileName string, overwrite bool) error {
+					assert.Equal(t, folderPath, newFolderPath)
+					assert.Equal(t, "newFile", newFileName)
+					assert.False(t, overwrite)
+					return nil
+				}
+			},
+		},
+		{
+			name: "RenameFileOntoExistingFile",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				err := fileService.RenameFile("testUser", "/source", "testFile", "taken")
+				assert.EqualError(t, err, customErrors.ErrFileExists("taken").Error())
+			},
+		},
+		{
+			name: "RenameFileToInvalidName",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				err := fileService.RenameFile("testUser", "/source", "testFile", "bad@name")
+				assert.EqualError(t, err, customErrors.ErrInvalidName("bad@name", allowedChars).Error())
+			},
+		},
+		{
+			name: "RenameFileChangingCase",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				err := fileService.RenameFile("testUser", "/source", "testFile", "TESTFILE")
+				assert.NoError(t, err)
+				assert.Equal(t, map[string]int{hello: 1, taken: 1}, refs) // the file isn't replaced by itself
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
+				// The repository matches names regardless of case, so the new name finds the renamed file itself
+				fileRepo.GetFileFunc = func(_, _, fileName string) (models.File, error) {
+					if strings.EqualFold(fileName, "testFile") {
+						return sourceFile, nil
+					}
+					return models.File{}, customErrors.ErrFileNotFound(fileName)
+				}
+				fileRepo.MoveFileFunc = func(_, _, _, _, newFileName string, overwrite bool) error {
+					assert.Equal(t, "TESTFILE", newFileName)
+					assert.False(t, overwrite)
+					return nil
+				}
+			},
+		},
+		{
+			name: "MoveOntoItself",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				moved, ok, err := fileService.MoveFile("testUser", "/source", "testFile", "/source", "", service.ConflictFail)
+				assert.NoError(t, err)
+				assert.True(t, ok)
+				assert.Equal(t, sourceFile, moved)
+			},
+			mockFileSetup: func(fileRepo *MockFileRepository) {
+				fileRepo.MoveFileFunc = func(string, string, string, string, string, bool) error {
+					t.Error("a file already in place isn't moved")
+					return nil
+				}
+			},
+		},
+		{
+			name: "CopyOntoItself",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				_, _, err := fileService.CopyFile("testUser", "/source", "testFile", "/source", "", service.ConflictOverwrite)
+				assert.EqualError(t, err, customErrors.ErrFileExists("testFile").Error())
+			},
+		},
+		{
+			name: "MissingDestinationFolder",
+			testFunc: func(t *testing.T, fileService *service.FileService, refs map[string]int) {
+				_, _, err := fileService.MoveFile("testUser", "/source", "testFile", "/missing", "", service.ConflictFail)
+				assert.EqualError(t, err, customErrors.ErrFolderNotFound("/missing").Error())
+			},
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.ExistsFunc = func(_, folderPath string) (bool, error) { return folderPath != "/missing", nil }
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string][]byte{hello: []byte("hello"), taken: []byte("taken")}
+			refs := map[string]int{hello: 1, taken: 1}
+			mockUserRepository := &MockUserRepository{ExistsFunc: func(string) (bool, error) { return true, nil }}
+			mockFolderRepository := &MockFolderRepository{ExistsFunc: func(string, string) (bool, error) { return true, nil }}
+			if tt.mockFolderSetup != nil {
+				tt.mockFolderSetup(mockFolderRepository)
+			}
+			mockFileRepository := &MockFileRepository{
+				GetFileFunc: func(_, folderPath, fileName string) (models.File, error) {
+					switch {
+					case folderPath == "/source" && fileName == "testFile":
+						return sourceFile, nil
+					case fileName == "taken":
+						return models.File{ID: 2, Username: "testUser", FolderPath: folderPath, Name: fileName, ContentHash: taken, Permissions: testFilePermissions}, nil
+					case fileName == "taken1":
+						return models.File{ID: 3, Username: "testUser", FolderPath: folderPath, Name: fileName, Permissions: testFilePermissions}, nil
+					case fileName == "taken.pdf":
+						return models.File{ID: 5, Username: "testUser", FolderPath: folderPath, Name: fileName, Permissions: testFilePermissions}, nil
+					}
+					return models.File{}, customErrors.ErrFileNotFound(fileName)
+				},
+				MoveFileFunc: func(string, string, string, string, string, bool) error { return nil },
+				CopyFileFunc: func(userName, _, _, newFolderPath, newFileName string, _ bool) (models.File, error) {
+					return models.File{ID: 4, Username: userName, FolderPath: newFolderPath, Name: newFileName, ContentHash: hello, Permissions: testFilePermissions}, nil
+				},
+			}
+			if tt.mockFileSetup != nil {
+				tt.mockFileSetup(mockFileRepository)
+			}
+			fileService := service.NewFileService(mockFileRepository, mockFolderRepository, mockUserRepository, newBlobs(data, refs), &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
+
+			tt.testFunc(t, fileService, refs)
+		})
+	}
+}
@@ -16,91 +16,301 @@ import (
 
 // FolderService handles the service logic for folders
 type FolderService struct {
-	folderRepo models.FolderRepository
-	userRepo   models.UserRepository
+	folderRepo  models.FolderRepository
+	userRepo    models.UserRepository
+	blobRepo    models.BlobRepository
+	trashRepo   models.TrashRepository
+	versionRepo models.VersionRepository
+	validator   models.Validator
 }
 
-// NewFolderService creates a new instance of FolderService
-func NewFolderService(folderRepo models.FolderRepository, userRepo models.UserRepository) *FolderService {
-	return &FolderService{folderRepo: folderRepo, userRepo: userRepo}
+// NewFolderService creates a new instance of FolderService that checks new folder names with the naming policy.
+// Deleted folders are moved to trashRepo, and the history of the files inside them is removed from versionRepo.
+func NewFolderService(folderRepo models.FolderRepository, userRepo models.UserRepository, blobRepo models.BlobRepository, trashRepo models.TrashRepository, versionRepo models.VersionRepository, names models.NamePolicy) *FolderService {
+	return &FolderService{folderRepo: folderRepo, userRepo: userRepo, blobRepo: blobRepo, trashRepo: trashRepo, versionRepo: versionRepo, validator: models.NewValidator(names)}
 }
 
-// CreateFolder creates a new folder
-func (s *FolderService) CreateFolder(userName, folderName, description string) error {
+// CreateFolder creates a new folder at the given path, owned by the acting user and belonging to the group of its
+// parent folder. The parent folder must already exist; top-level folders are created inside the root path "/".
+func (s *FolderService) CreateFolder(userName, folderPath, description string) error {
 
 	// Check if the user exists
-	exists, err := s.userRepo.Exists(userName)
+	actor, err := getActor(s.userRepo, s.validator, userName)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return errors.ErrUserNotExists(userName)
-	}
 
 	// Check if the folder folderName is valid
-	if err := s.folderRepo.ValidateFolderName(folderName); err != nil {
+	parentPath, folderName := models.SplitPath(folderPath)
+	if folderName, err = s.validator.NewFolderName(folderName); err != nil {
+		return err
+	}
+
+	// Check if the parent folder exists and the user may create folders inside it
+	sc, parentPath, err := resolveTree(s.userRepo, s.validator, actor, parentPath)
+	if err != nil {
+		return err
+	}
+	parent, err := openFolder(s.folderRepo, sc, parentPath, models.Write|models.Execute, "change the entries of")
+	if err != nil {
 		return err
 	}
 
 	// Create the folder
 	folder := models.Folder{
-		Username:    userName,
+		Username:    sc.tree.Username,
+		ParentPath:  parentPath,
 		Name:        folderName,
 		Description: description,
 		CreatedAt:   time.Now(),
+		Permissions: models.Permissions{OwnerID: actor.ID, Group: parent.Group, Mode: models.DefaultFolderMode},
 	}
 	return s.folderRepo.CreateFolder(folder)
 }
 
-// DeleteFolder deletes a folder
-func (s *FolderService) DeleteFolder(userName, folderName string) error {
+// DeleteFolder moves a folder to the trash of the tree that holds it. Unless recursive is set, only an empty folder can
+// be deleted; otherwise every folder nested inside it and all the files inside them go with it, together with their
+// content.
+// Deleting a folder recursively requires full access to it and to every folder nested inside it.
+func (s *FolderService) DeleteFolder(userName, folderPath string, recursive bool) error {
 
-	// Check if the user exists
-	exists, err := s.userRepo.Exists(userName)
+	// Check if the user exists and every folder name along the path is valid
+	sc, folderPath, err := resolveFolder(s.userRepo, s.validator, userName, folderPath)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return errors.ErrUserNotExists(userName)
+
+	// Check if the user may delete the folder, then move it to the trash
+	folders, err := walkFolders(s.folderRepo, sc, folderPath)
+	if err != nil {
+		return err
 	}
+	return deleteFolder(s.folderRepo, s.trashRepo, s.versionRepo, s.blobRepo, sc, folders, recursive)
+}
 
-	// Check if the folder folderName is valid
-	if err := s.folderRepo.ValidateFolderName(folderName); err != nil {
+// RenameFolder renames the folder at folderPath, keeping it inside the same parent folder.
+// The folders nested inside it and all the files inside them move along with their content, which is stored under the
+// IDs of the files and therefore stays in place.
+func (s *FolderService) RenameFolder(userName, folderPath, newFolderName string) error {
+
+	// Check if the user exists and every folder name along the path and the new name are valid
+	sc, folderPath, err := resolveFolder(s.userRepo, s.validator, userName, folderPath)
+	if err != nil {
+		return err
+	}
+	if newFolderName, err = s.validator.NewFolderName(newFolderName); err != nil {
 		return err
 	}
 
-	// Delete the folder
-	return s.folderRepo.DeleteFolder(userName, folderName)
+	// Check if the user may rename the folder
+	folders, err := walkFolders(s.folderRepo, sc, folderPath)
+	if err != nil {
+		return err
+	}
+	if err := checkAccess(sc, folders[len(folders)-2], models.Write|models.Execute, "change the entries of"); err != nil {
+		return err
+	}
+
+	// Rename the folder
+	return s.folderRepo.RenameFolder(sc.tree.Username, folderPath, newFolderName)
 }
 
-// RenameFolder renames a folder
-func (s *FolderService) RenameFolder(userName, folderName, newFolderName string) error {
+// UpdateFolderDescription replaces the description of the folder at folderPath
+func (s *FolderService) UpdateFolderDescription(userName, folderPath, description string) error {
 
-	// Check if the user exists
-	exists, err := s.userRepo.Exists(userName)
+	// Check if the user exists and every folder name along the path is valid
+	sc, folderPath, err := resolveFolder(s.userRepo, s.validator, userName, folderPath)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return errors.ErrUserNotExists(userName)
+
+	// Update the folder
+	folder, err := openFolder(s.folderRepo, sc, folderPath, models.Write, "change")
+	if err != nil {
+		return err
 	}
+	folder.Description = description
+	return s.folderRepo.UpdateFolder(folder)
+}
 
-	// Rename the folder
-	return s.folderRepo.RenameFolder(userName, folderName, newFolderName)
+// GetFolder returns the folder at folderPath, which may be the root folder of a tree. Like stat on Unix, it only
+// requires searching the folders above it.
+func (s *FolderService) GetFolder(userName, folderPath string) (models.Folder, error) {
+
+	// Check if the user exists
+	sc, folderPath, err := resolveScope(s.userRepo, s.validator, userName, folderPath)
+	if err != nil {
+		return models.Folder{}, err
+	}
+
+	// Check if the folder exists and the user may reach it
+	return openFolder(s.folderRepo, sc, folderPath, 0, "search")
 }
 
-// ListFolders lists the folders
-func (s *FolderService) ListFolders(userName, sortField, sortOrder string) ([]models.Folder, error) {
+// ListFolders lists the folders directly inside parentPath. The root folder of the acting user also lists the folders
+// other users share with them, after their own folders.
+func (s *FolderService) ListFolders(userName, parentPath, sortField, sortOrder string) ([]models.Folder, error) {
 
 	// Check if the user exists
-	exists, err := s.userRepo.Exists(userName)
+	sc, parentPath, err := resolveScope(s.userRepo, s.validator, userName, parentPath)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
-		return nil, errors.ErrUserNotExists(userName)
+
+	// Check if the parent folder exists and the user may list it
+	if _, err := openFolder(s.folderRepo, sc, parentPath, models.Read|models.Execute, "list"); err != nil {
+		return nil, err
 	}
 
 	// List the folders
-	return s.folderRepo.ListFolders(userName, sortField, sortOrder)
+	folders, err := s.folderRepo.ListFolders(sc.tree.Username, parentPath, sortField, sortOrder)
+	if err != nil || parentPath != models.RootPath || sc.tree.ID != sc.actor.ID {
+		return folders, err
+	}
+	shared, err := s.folderRepo.ListShared(sc.actor.Username)
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range shared {
+		if folder.UserID != sc.actor.ID {
+			folders = append(folders, folder)
+		}
+	}
+	return folders, nil
+}
+
+// ShareFolder shares the folder at folderPath with the user named username, who gets the access to the folder and to
+// everything inside it. Sharing a folder again with the same user replaces the access.
+// Like its permissions, a folder can only be shared by its owner and by the user whose tree holds it.
+func (s *FolderService) ShareFolder(userName, folderPath, username, access string) error {
+	shareAccess, err := models.ParseShareAccess(access)
+	if err != nil {
+		return err
+	}
+	return s.changeShares(userName, folderPath, username, func(folder models.Folder, user models.User) []models.Share {
+		return folder.Share(user.ID, shareAccess)
+	})
+}
+
+// UnshareFolder stops sharing the folder at folderPath with the user named username. Unsharing a folder that isn't
+// shared with the user does nothing.
+func (s *FolderService) UnshareFolder(userName, folderPath, username string) error {
+	return s.changeShares(userName, folderPath, username, func(folder models.Folder, user models.User) []models.Share {
+		return folder.Unshare(user.ID)
+	})
+}
+
+// changeShares replaces the shares of the folder at folderPath with the shares returned by change for the user named
+// username
+func (s *FolderService) changeShares(userName, folderPath, username string, change func(models.Folder, models.User) []models.Share) error {
+
+	// Check if the user exists and every folder name along the path is valid
+	sc, folderPath, err := resolveFolder(s.userRepo, s.validator, userName, folderPath)
+	if err != nil {
+		return err
+	}
+
+	// Check if the user the folder is shared with exists
+	user, err := getActor(s.userRepo, s.validator, username)
+	if err != nil {
+		return err
+	}
+
+	// Find the folder, then update its shares
+	folders, err := walkFolders(s.folderRepo, sc, folderPath)
+	if err != nil {
+		return err
+	}
+	folder := folders[len(folders)-1]
+	if !sc.mayChangePermissions(folder.Permissions) {
+		return errors.ErrPermissionDenied(errors.KindFolder, sc.displayPath(folder.Path()), "share")
+	}
+	folder.Shares = change(folder, user)
+	return s.folderRepo.UpdateFolder(folder)
+}
+
+// ListShared lists the folders other users share with the acting user and the folders the acting user shares with
+// others
+func (s *FolderService) ListShared(userName string) ([]models.Folder, error) {
+	actor, err := getActor(s.userRepo, s.validator, userName)
+	if err != nil {
+		return nil, err
+	}
+	return s.folderRepo.ListShared(actor.Username)
+}
+
+// ChangeFolderMode changes the permission bits of the folder at folderPath as mode describes, either in octal, e.g.
+// "750", or as symbolic changes, e.g. "g+rx,o-rwx"
+func (s *FolderService) ChangeFolderMode(userName, folderPath, mode string) error {
+	return s.changePermissions(userName, folderPath, func(sc scope, folder models.Folder) (models.Permissions, error) {
+		return changeMode(sc, folder.Permissions, errors.KindFolder, sc.displayPath(folder.Path()), mode)
+	})
+}
+
+// ChangeFolderOwner gives the folder at folderPath to the user named owner
+func (s *FolderService) ChangeFolderOwner(userName, folderPath, owner string) error {
+	return s.changePermissions(userName, folderPath, func(sc scope, folder models.Folder) (models.Permissions, error) {
+		return changeOwner(s.userRepo, s.validator, sc, folder.Permissions, errors.KindFolder, sc.displayPath(folder.Path()), owner)
+	})
+}
+
+// ChangeFolderGroup gives the folder at folderPath to the group, or to no group if group is empty.
+// The acting user must be a member of the group.
+func (s *FolderService) ChangeFolderGroup(userName, folderPath, group string) error {
+	return s.changePermissions(userName, folderPath, func(sc scope, folder models.Folder) (models.Permissions, error) {
+		return changeGroup(s.validator, sc, folder.Permissions, errors.KindFolder, sc.displayPath(folder.Path()), group)
+	})
+}
+
+// changePermissions replaces the permissions of the folder at folderPath with the permissions returned by change
+func (s *FolderService) changePermissions(userName, folderPath string, change func(scope, models.Folder) (models.Permissions, error)) error {
+
+	// Check if the user exists and every folder name along the path is valid
+	sc, folderPath, err := resolveFolder(s.userRepo, s.validator, userName, folderPath)
+	if err != nil {
+		return err
+	}
+
+	// Find the folder, then update its permissions
+	folders, err := walkFolders(s.folderRepo, sc, folderPath)
+	if err != nil {
+		return err
+	}
+	folder := folders[len(folders)-1]
+	if folder.Permissions, err = change(sc, folder); err != nil {
+		return err
+	}
+	return s.folderRepo.UpdateFolder(folder)
+}
+
+// checkUserExists checks the username of an existing user and returns it normalized, or an error if the user does not
+// exist
+func checkUserExists(userRepo models.UserRepository, validator models.Validator, userName string) (string, error) {
+	userName, err := validator.Username(userName)
+	if err != nil {
+		return userName, err
+	}
+
+	exists, err := userRepo.Exists(userName)
+	if err != nil {
+		return userName, err
+	}
+	if !exists {
+		return userName, errors.ErrUserNotExists(userName)
+	}
+	return userName, nil
+}
+
+// resolveFolder checks the acting user and the path of a folder, and returns the scope of the path and the path inside
+// the tree, cleaned and normalized. The root path of a tree is rejected, as it names no folder that could be changed
+// or deleted.
+func resolveFolder(userRepo models.UserRepository, validator models.Validator, userName, folderPath string) (scope, string, error) {
+	sc, treePath, err := resolveScope(userRepo, validator, userName, folderPath)
+	if err != nil {
+		return sc, treePath, err
+	}
+	if treePath == models.RootPath {
+		return sc, treePath, errors.ErrInvalidName(sc.displayPath(treePath), "")
+	}
+	return sc, treePath, nil
 }
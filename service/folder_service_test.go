@@ -11,36 +11,68 @@ import (
 
 // MockFolderRepository is a mock of FolderRepository
 type MockFolderRepository struct {
-	ExistsFunc             func(string, string) (bool, error)
-	CreateFolderFunc       func(models.Folder) error
-	DeleteFolderFunc       func(string, string) error
-	RenameFolderFunc       func(string, string, string) error
-	ListFoldersFunc        func(string, string, string) ([]models.Folder, error)
-	ValidateFolderNameFunc func(string) error
+	ExistsFunc       func(string, string) (bool, error)
+	GetFolderFunc    func(string, string) (models.Folder, error)
+	CreateFolderFunc func(models.Folder) error
+	DeleteFolderFunc func(string, string, bool) ([]models.File, error)
+	RenameFolderFunc func(string, string, string) error
+	UpdateFolderFunc func(models.Folder) error
+	ListFoldersFunc  func(string, string, string, string) ([]models.Folder, error)
+	ListSharedFunc   func(string) ([]models.Folder, error)
 }
 
-func (m *MockFolderRepository) Exists(userName, folderName string) (bool, error) {
-	return m.ExistsFunc(userName, folderName)
+func (m *MockFolderRepository) Exists(userName, folderPath string) (bool, error) {
+	return m.ExistsFunc(userName, folderPath)
+}
+
+// GetFolder returns the folder from GetFolderFunc, or else a folder of user 1 with the default mode unless ExistsFunc
+// reports that it doesn't exist
+func (m *MockFolderRepository) GetFolder(username, folderPath string) (models.Folder, error) {
+	if m.GetFolderFunc == nil {
+		exists, err := true, error(nil)
+		if m.ExistsFunc != nil {
+			exists, err = m.ExistsFunc(username, folderPath)
+		}
+		if err != nil || !exists {
+			return models.Folder{}, orError(err, customErrors.ErrFolderNotFound(folderPath))
+		}
+		parentPath, name := models.SplitPath(folderPath)
+		return models.Folder{
+			Username:    username,
+			ParentPath:  parentPath,
+			Name:        name,
+			Permissions: models.Permissions{OwnerID: 1, Mode: models.DefaultFolderMode},
+		}, nil
+	}
+	return m.GetFolderFunc(username, folderPath)
 }
 
 func (m *MockFolderRepository) CreateFolder(folder models.Folder) error {
 	return m.CreateFolderFunc(folder)
 }
 
-func (m *MockFolderRepository) DeleteFolder(username, folderName string) error {
-	return m.DeleteFolderFunc(username, folderName)
+func (m *MockFolderRepository) DeleteFolder(username, folderPath string, recursive bool) ([]models.File, error) {
+	return m.DeleteFolderFunc(username, folderPath, recursive)
+}
+
+func (m *MockFolderRepository) RenameFolder(username, folderPath, newFolderName string) error {
+	return m.RenameFolderFunc(username, folderPath, newFolderName)
 }
 
-func (m *MockFolderRepository) RenameFolder(username, folderName, newFolderName string) error {
-	return m.RenameFolderFunc(username, folderName, newFolderName)
+func (m *MockFolderRepository) UpdateFolder(folder models.Folder) error {
+	return m.UpdateFolderFunc(folder)
 }
 
-func (m *MockFolderRepository) ListFolders(username, sortField, sortOrder string) ([]models.Folder, error) {
-	return m.ListFoldersFunc(username, sortField, sortOrder)
+func (m *MockFolderRepository) ListFolders(username, parentPath, sortField, sortOrder string) ([]models.Folder, error) {
+	return m.ListFoldersFunc(username, parentPath, sortField, sortOrder)
 }
 
-func (m *MockFolderRepository) ValidateFolderName(folderName string) error {
-	return m.ValidateFolderNameFunc(folderName)
+// ListShared returns the folders from ListSharedFunc, or else no folders
+func (m *MockFolderRepository) ListShared(username string) ([]models.Folder, error) {
+	if m.ListSharedFunc == nil {
+		return nil, nil
+	}
+	return m.ListSharedFunc(username)
 }
 
 // TestCreateFolder tests the CreateFolder method of FolderService using table-driven tests
@@ -63,11 +95,45 @@ func TestCreateFolder(t *testing.T) {
 				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
 			},
 			mockFolderSetup: func(folderRepo *MockFolderRepository) {
-				folderRepo.ValidateFolderNameFunc = func(string) error { return nil }
 				folderRepo.CreateFolderFunc = func(models.Folder) error { return nil }
 			},
 			expectedError: nil,
 		},
+		{
+			name:        "ValidNestedFolderCreation",
+			userName:    "testUser",
+			folderName:  "/projects/2024/q3",
+			description: "A nested test folder",
+			mockUserSetup: func(userRepo *MockUserRepository) {
+				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.ExistsFunc = func(_, folderPath string) (bool, error) {
+					return folderPath == "/projects" || folderPath == "/projects/2024", nil
+				}
+				folderRepo.CreateFolderFunc = func(folder models.Folder) error {
+					if folder.ParentPath != "/projects/2024" || folder.Name != "q3" {
+						return customErrors.ErrFolderNotFound(folder.Path())
+					}
+					return nil
+				}
+			},
+			expectedError: nil,
+		},
+		{
+			name:        "ParentFolderDoesNotExist",
+			userName:    "testUser",
+			folderName:  "/projects/2024/q3",
+			description: "A nested test folder",
+			mockUserSetup: func(userRepo *MockUserRepository) {
+				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.ExistsFunc = func(string, string) (bool, error) { return false, nil }
+				folderRepo.CreateFolderFunc = func(models.Folder) error { return nil }
+			},
+			expectedError: customErrors.ErrFolderNotFound("/projects/2024"),
+		},
 		{
 			name:        "UserDoesNotExist",
 			userName:    "unknownUser",
@@ -77,7 +143,6 @@ func TestCreateFolder(t *testing.T) {
 				userRepo.ExistsFunc = func(string) (bool, error) { return false, nil }
 			},
 			mockFolderSetup: func(folderRepo *MockFolderRepository) {
-				folderRepo.ValidateFolderNameFunc = func(string) error { return nil }
 				folderRepo.CreateFolderFunc = func(models.Folder) error { return nil }
 			},
 			expectedError: customErrors.ErrUserNotExists("unknownUser"),
@@ -91,10 +156,9 @@ func TestCreateFolder(t *testing.T) {
 				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
 			},
 			mockFolderSetup: func(folderRepo *MockFolderRepository) {
-				folderRepo.ValidateFolderNameFunc = func(string) error { return customErrors.ErrInvalidName("invalid@folder") }
 				folderRepo.CreateFolderFunc = func(models.Folder) error { return nil }
 			},
-			expectedError: customErrors.ErrInvalidName("invalid@folder"),
+			expectedError: customErrors.ErrInvalidName("invalid@folder", allowedChars),
 		},
 	}
 
@@ -104,7 +168,7 @@ func TestCreateFolder(t *testing.T) {
 			tt.mockFolderSetup(mockFolderRepository)
 			mockUserRepository := &MockUserRepository{}
 			tt.mockUserSetup(mockUserRepository)
-			folderService := service.NewFolderService(mockFolderRepository, mockUserRepository)
+			folderService := service.NewFolderService(mockFolderRepository, mockUserRepository, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy())
 
 			err := folderService.CreateFolder(tt.userName, tt.folderName, tt.description)
 			if tt.expectedError != nil {
@@ -126,7 +190,7 @@ func TestFolderService(t *testing.T) {
 		{
 			name: "RenameExistingFolder",
 			testFunc: func(t *testing.T, folderService *service.FolderService) {
-				err := folderService.RenameFolder("testUser", "testFolder", "newTestFolder")
+				err := folderService.RenameFolder("testUser", "/projects/testFolder", "newTestFolder")
 				assert.NoError(t, err)
 			},
 			mockUserSetup: func(userRepo *MockUserRepository) {
@@ -136,17 +200,101 @@ func TestFolderService(t *testing.T) {
 				folderRepo.RenameFolderFunc = func(string, string, string) error { return nil }
 			},
 		},
+		{
+			name: "RenameFolderToInvalidName",
+			testFunc: func(t *testing.T, folderService *service.FolderService) {
+				err := folderService.RenameFolder("testUser", "/projects/testFolder", "bad@name")
+				assert.EqualError(t, err, customErrors.ErrInvalidName("bad@name", allowedChars).Error())
+			},
+			mockUserSetup: func(userRepo *MockUserRepository) {
+				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			mockFolderSetup: func(*MockFolderRepository) {},
+		},
+		{
+			name: "RenameFolderNormalizesNewName",
+			testFunc: func(t *testing.T, folderService *service.FolderService) {
+				err := folderService.RenameFolder("testUser", "/projects/testFolder", "cafe\u0301")
+				assert.NoError(t, err)
+			},
+			mockUserSetup: func(userRepo *MockUserRepository) {
+				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.RenameFolderFunc = func(_, folderPath, newFolderName string) error {
+					assert.Equal(t, "/projects/testFolder", folderPath)
+					assert.Equal(t, "caf\u00e9", newFolderName)
+					return nil
+				}
+			},
+		},
+		{
+			name: "RenameRootFolder",
+			testFunc: func(t *testing.T, folderService *service.FolderService) {
+				err := folderService.RenameFolder("testUser", "/", "newRoot")
+				assert.ErrorIs(t, err, customErrors.ErrInvalid)
+			},
+			mockUserSetup: func(userRepo *MockUserRepository) {
+				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			mockFolderSetup: func(*MockFolderRepository) {},
+		},
+		{
+			name: "UpdateFolderDescription",
+			testFunc: func(t *testing.T, folderService *service.FolderService) {
+				err := folderService.UpdateFolderDescription("testUser", "/projects/testFolder", "new description")
+				assert.NoError(t, err)
+			},
+			mockUserSetup: func(userRepo *MockUserRepository) {
+				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.UpdateFolderFunc = func(folder models.Folder) error {
+					assert.Equal(t, "/projects/testFolder", folder.Path())
+					assert.Equal(t, "new description", folder.Description)
+					return nil
+				}
+			},
+		},
+		{
+			name: "UpdateDescriptionOfMissingFolder",
+			testFunc: func(t *testing.T, folderService *service.FolderService) {
+				err := folderService.UpdateFolderDescription("testUser", "/missing", "new description")
+				assert.EqualError(t, err, customErrors.ErrFolderNotFound("/missing").Error())
+			},
+			mockUserSetup: func(userRepo *MockUserRepository) {
+				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.UpdateFolderFunc = func(folder models.Folder) error { return customErrors.ErrFolderNotFound(folder.Path()) }
+			},
+		},
 		{
 			name: "ListFolders",
 			testFunc: func(t *testing.T, folderService *service.FolderService) {
-				_, err := folderService.ListFolders("testUser", "", "")
+				_, err := folderService.ListFolders("testUser", "/", "", "")
 				assert.NoError(t, err)
 			},
 			mockUserSetup: func(userRepo *MockUserRepository) {
 				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
 			},
 			mockFolderSetup: func(folderRepo *MockFolderRepository) {
-				folderRepo.ListFoldersFunc = func(string, string, string) ([]models.Folder, error) { return nil, nil }
+				folderRepo.ListFoldersFunc = func(string, string, string, string) ([]models.Folder, error) { return nil, nil }
+			},
+		},
+		{
+			name: "ListFoldersOfMissingParent",
+			testFunc: func(t *testing.T, folderService *service.FolderService) {
+				_, err := folderService.ListFolders("testUser", "/missing", "", "")
+				assert.EqualError(t, err, customErrors.ErrFolderNotFound("/missing").Error())
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+				assert.Equal(t, customErrors.CodeFolderNotFound, customErrors.CodeOf(err))
+			},
+			mockUserSetup: func(userRepo *MockUserRepository) {
+				userRepo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.ExistsFunc = func(string, string) (bool, error) { return false, nil }
 			},
 		},
 	}
@@ -157,9 +305,126 @@ func TestFolderService(t *testing.T) {
 			tt.mockFolderSetup(mockFolderRepository)
 			mockUserRepository := &MockUserRepository{}
 			tt.mockUserSetup(mockUserRepository)
-			folderService := service.NewFolderService(mockFolderRepository, mockUserRepository)
+			folderService := service.NewFolderService(mockFolderRepository, mockUserRepository, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy())
 
 			tt.testFunc(t, folderService)
 		})
 	}
 }
+
+// TestNewFolderNamesFollowTheNamingPolicy tests that a folder can be renamed to exactly the names it can be created with
+func TestNewFolderNamesFollowTheNamingPolicy(t *testing.T) {
+	policy := models.DefaultNamePolicy()
+	policy.Reserved = []string{"tmp"}
+	policy.MaxLength = 8
+
+	tests := []struct {
+		name          string
+		folderName    string
+		expectedError error
+	}{
+		{name: "Valid", folderName: "my_notes"},
+		{name: "Unicode", folderName: "Übung"},
+		{name: "InvalidChar", folderName: "bad@name", expectedError: customErrors.ErrInvalidName("bad@name", allowedChars)},
+		{name: "Space", folderName: "a b", expectedError: customErrors.ErrInvalidName("a b", "")},
+		{name: "TooLong", folderName: "ninechars", expectedError: customErrors.ErrNameTooLong("ninechars", 8)},
+		{name: "Reserved", folderName: "TMP", expectedError: customErrors.ErrReservedName("TMP")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserRepository := &MockUserRepository{ExistsFunc: func(string) (bool, error) { return true, nil }}
+			mockFolderRepository := &MockFolderRepository{
+				ExistsFunc:       func(string, string) (bool, error) { return true, nil },
+				CreateFolderFunc: func(models.Folder) error { return nil },
+				RenameFolderFunc: func(string, string, string) error { return nil },
+			}
+			folderService := service.NewFolderService(mockFolderRepository, mockUserRepository, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, policy)
+
+			created := folderService.CreateFolder("testUser", "/projects/"+tt.folderName, "")
+			renamed := folderService.RenameFolder("testUser", "/projects/old", tt.folderName)
+			if tt.expectedError != nil {
+				assert.EqualError(t, created, tt.expectedError.Error())
+				assert.EqualError(t, renamed, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, created)
+				assert.NoError(t, renamed)
+			}
+		})
+	}
+}
+
+// TestDeleteFolder tests the DeleteFolder method using table-driven tests
+func TestDeleteFolder(t *testing.T) {
+	tests := []struct {
+		name            string
+		recursive       bool
+		mockFolderSetup func(folderRepo *MockFolderRepository)
+		expectedError   error
+		expectedTrashed []string
+	}{
+		{
+			name:      "DeleteEmptyFolder",
+			recursive: false,
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.DeleteFolderFunc = func(string, string, bool) ([]models.File, error) { return nil, nil }
+			},
+		},
+		{
+			name:      "RefuseNonEmptyFolder",
+			recursive: false,
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.DeleteFolderFunc = func(_, folderPath string, recursive bool) ([]models.File, error) {
+					assert.False(t, recursive)
+					return nil, customErrors.ErrFolderNotEmpty(folderPath)
+				}
+			},
+			expectedError: customErrors.ErrFolderNotEmpty("/projects"),
+		},
+		{
+			name:      "RecursiveDeleteMovesContentToTrash",
+			recursive: true,
+			mockFolderSetup: func(folderRepo *MockFolderRepository) {
+				folderRepo.DeleteFolderFunc = func(_, folderPath string, recursive bool) ([]models.File, error) {
+					assert.True(t, recursive)
+					return []models.File{
+						{ID: 3, Username: "testUser", FolderPath: "/projects", Name: "plan", ContentHash: hashOf("plan")},
+						{ID: 5, Username: "testUser", FolderPath: "/projects/2024", Name: "report", ContentHash: hashOf("report")},
+					}, nil
+				}
+				folderRepo.ListFoldersFunc = func(string, string, string, string) ([]models.Folder, error) { return nil, nil }
+			},
+			expectedTrashed: []string{hashOf("plan"), hashOf("report")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entry models.TrashEntry
+			mockUserRepository := &MockUserRepository{ExistsFunc: func(string) (bool, error) { return true, nil }}
+			mockFolderRepository := &MockFolderRepository{}
+			tt.mockFolderSetup(mockFolderRepository)
+			mockTrashRepository := &MockTrashRepository{AddTrashFunc: func(e models.TrashEntry) (models.TrashEntry, error) {
+				e.ID = 1
+				entry = e
+				return e, nil
+			}}
+			// The blob mock has no functions, as the blobs of the files in the trash are left alone
+			folderService := service.NewFolderService(mockFolderRepository, mockUserRepository, &MockBlobRepository{}, mockTrashRepository, &MockVersionRepository{}, models.DefaultNamePolicy())
+
+			err := folderService.DeleteFolder("testUser", "/projects", tt.recursive)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, "/projects", entry.Path)
+				assert.Len(t, entry.Folders, 1)
+			}
+			var trashed []string
+			for _, file := range entry.Files {
+				trashed = append(trashed, file.ContentHash)
+			}
+			assert.Equal(t, tt.expectedTrashed, trashed)
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// service/fs.go
+
+package service
+
+import (
+	stderrors "errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// FS is a read-only view of the folders and files a user can reach from a folder, which implements fs.FS,
+// fs.ReadDirFS, fs.StatFS and fs.ReadFileFS, so the tools written against io/fs, such as fs.WalkDir, template.ParseFS
+// and http.FS, work on the VFS. Names are slash-separated paths relative to the folder, see fs.ValidPath, and "."
+// names the folder itself.
+// Every access goes through FolderService and FileService, so it is checked for the user like any other. The errors of
+// the services are returned inside *fs.PathError and match fs.ErrNotExist, fs.ErrPermission and fs.ErrInvalid by
+// their category, as well as the errors of the services.
+// A folder and a file may have the same name inside a folder, but a file system can hold only one of them, so the
+// folder hides the file.
+type FS struct {
+	folderService *FolderService
+	fileService   *FileService
+	userName      string
+	root          string
+}
+
+// NewFS returns the view of the folder at root as the user named userName sees it. The folder may be in the tree of
+// another user, e.g. "~alice/shared".
+func NewFS(folderService *FolderService, fileService *FileService, userName, root string) *FS {
+	return &FS{folderService: folderService, fileService: fileService, userName: userName, root: root}
+}
+
+// Open opens the folder or file with the name. A folder is opened as an fs.ReadDirFile, while a file reads its
+// content one chunk at a time and can seek to any offset.
+func (f *FS) Open(name string) (fs.File, error) {
+	e, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.isDir {
+		return &fsDir{fsys: f, name: name, info: folderInfo(name, e.folder)}, nil
+	}
+
+	content, err := f.fileService.Open(f.userName, path.Dir(e.path), e.file.Name)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &fsFile{ReadSeekCloser: content, info: fileInfo(name, e.file)}, nil
+}
+
+// Stat describes the folder or file with the name
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	e, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.isDir {
+		return folderInfo(name, e.folder), nil
+	}
+	return fileInfo(name, e.file), nil
+}
+
+// ReadFile returns the content of the file with the name
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	e, err := f.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.isDir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid} // a folder has no content
+	}
+
+	data, err := f.fileService.ReadFile(f.userName, path.Dir(e.path), e.file.Name)
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+	return data, nil
+}
+
+// ReadDir lists the folders and files inside the folder with the name, sorted by name. Folders that other users share
+// with the user are left out of their root folder, as they aren't inside it.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	folderPath, err := f.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := f.folderService.GetFolder(f.userName, folderPath)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	folders, err := f.folderService.ListFolders(f.userName, folderPath, "--sort-name", "asc")
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	files, err := f.fileService.ListFiles(f.userName, folderPath, "--sort-name", "asc")
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+
+	entries := make([]fs.DirEntry, 0, len(folders)+len(files))
+	names := make(map[string]bool, len(folders))
+	for _, folder := range folders {
+		if folder.UserID == parent.UserID && folder.ParentID == parent.ID {
+			entries = append(entries, fs.FileInfoToDirEntry(folderInfo(folder.Name, folder)))
+			names[folder.Name] = true
+		}
+	}
+	for _, file := range files {
+		if !names[file.Name] {
+			entries = append(entries, fs.FileInfoToDirEntry(fileInfo(file.Name, file)))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// fsEntry is the folder or file found under a name, together with its path in the VFS, which starts with the tree
+// prefix if the folder of FS is in the tree of another user
+type fsEntry struct {
+	path   string
+	isDir  bool
+	folder models.Folder
+	file   models.File
+}
+
+// lookup returns the folder with the name if there is one, or else the file with the name
+func (f *FS) lookup(op, name string) (fsEntry, error) {
+	entryPath, err := f.path(op, name)
+	if err != nil {
+		return fsEntry{}, err
+	}
+	folder, err := f.folderService.GetFolder(f.userName, entryPath)
+	if err == nil {
+		return fsEntry{path: entryPath, isDir: true, folder: folder}, nil
+	} else if name == "." || errors.CodeOf(err) != errors.CodeFolderNotFound {
+		return fsEntry{}, pathError(op, name, err)
+	}
+
+	// The name may be the name of a file inside the parent folder
+	file, err := f.fileService.GetFile(f.userName, path.Dir(entryPath), path.Base(entryPath))
+	if err != nil {
+		return fsEntry{}, pathError(op, name, err)
+	}
+	return fsEntry{path: entryPath, file: file}, nil
+}
+
+// path returns the path in the VFS of the folder or file with the name
+func (f *FS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(models.CleanPath(f.root), name), nil
+}
+
+// fsError is an error of the services that also matches the error of the io/fs package of its category
+type fsError struct {
+	err    error
+	target error
+}
+
+func (e *fsError) Error() string {
+	return e.err.Error()
+}
+
+func (e *fsError) Unwrap() []error {
+	return []error{e.err, e.target}
+}
+
+// pathError returns the error of the services for the operation on the name as an *fs.PathError
+func pathError(op, name string, err error) error {
+	for _, category := range []struct{ err, target error }{
+		{errors.ErrNotFound, fs.ErrNotExist},
+		{errors.ErrForbidden, fs.ErrPermission},
+		{errors.ErrInvalid, fs.ErrInvalid},
+	} {
+		if stderrors.Is(err, category.err) {
+			err = &fsError{err: err, target: category.target}
+			break
+		}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// info describes a folder or file as fs.FileInfo. Sys returns the models.Folder or models.File it describes.
+type info struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	sys     any
+}
+
+func (i *info) Name() string       { return i.name }
+func (i *info) Size() int64        { return i.size }
+func (i *info) Mode() fs.FileMode  { return i.mode }
+func (i *info) ModTime() time.Time { return i.modTime }
+func (i *info) IsDir() bool        { return i.mode.IsDir() }
+func (i *info) Sys() any           { return i.sys }
+
+// folderInfo describes the folder found under the name
+func folderInfo(name string, folder models.Folder) *info {
+	return &info{name: path.Base(name), mode: fs.ModeDir | fs.FileMode(folder.Mode&models.ModePerm), modTime: folder.CreatedAt, sys: folder}
+}
+
+// fileInfo describes the file found under the name. A file that was never modified reports its creation time.
+func fileInfo(name string, file models.File) *info {
+	modTime := file.ModifiedAt
+	if modTime.IsZero() {
+		modTime = file.CreatedAt
+	}
+	return &info{name: path.Base(name), size: file.Size, mode: fs.FileMode(file.Mode & models.ModePerm), modTime: modTime, sys: file}
+}
+
+// fsFile is a file of FS, which reads the content of a file
+type fsFile struct {
+	io.ReadSeekCloser
+	info *info
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// fsDir is a folder of FS, which lists the folders and files inside a folder once they are first read
+type fsDir struct {
+	fsys    *FS
+	name    string
+	info    *info
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the folder, or all the remaining entries if n isn't positive, see
+// fs.ReadDirFile
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
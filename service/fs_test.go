@@ -0,0 +1,209 @@
+package service_test
+
+import (
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/service"
+)
+
+// newTestFS returns the view of the root folder of testUser (ID 1) over the folders and files, keyed by their paths,
+// whose contents are kept in data. Bob (ID 2) shares the folder /shared of his tree with testUser.
+func newTestFS(folders map[string]models.Folder, files map[string]models.File, data map[string][]byte) *service.FS {
+	mockUserRepository := &MockUserRepository{ExistsFunc: func(string) (bool, error) { return true, nil }}
+	mockFolderRepository := &MockFolderRepository{
+		GetFolderFunc: func(_, folderPath string) (models.Folder, error) {
+			if folder, ok := folders[folderPath]; ok {
+				return folder, nil
+			}
+			return models.Folder{}, customErrors.ErrFolderNotFound(folderPath)
+		},
+		ListFoldersFunc: func(_, parentPath, _, _ string) ([]models.Folder, error) {
+			var list []models.Folder
+			for _, folder := range folders {
+				if folder.ParentPath == parentPath {
+					list = append(list, folder)
+				}
+			}
+			return list, nil
+		},
+		ListSharedFunc: func(string) ([]models.Folder, error) {
+			shared := models.Folder{ID: 9, UserID: 2, Username: "bob", ParentPath: "/", Name: "shared", Permissions: models.Permissions{OwnerID: 2, Mode: models.DefaultFolderMode}}
+			shared.Shares = []models.Share{{UserID: 1, Access: models.ShareRead}}
+			return []models.Folder{shared}, nil
+		},
+	}
+	mockFileRepository := &MockFileRepository{
+		GetFileFunc: func(_, folderPath, fileName string) (models.File, error) {
+			if file, ok := files[models.JoinPath(folderPath, fileName)]; ok {
+				return file, nil
+			}
+			return models.File{}, customErrors.ErrFileNotFound(fileName)
+		},
+		ListFilesFunc: func(_, folderPath, _, _ string) ([]models.File, error) {
+			var list []models.File
+			for _, file := range files {
+				if file.FolderPath == folderPath {
+					list = append(list, file)
+				}
+			}
+			return list, nil
+		},
+	}
+	blobs := newBlobs(data, map[string]int{})
+	folderService := service.NewFolderService(mockFolderRepository, mockUserRepository, blobs, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy())
+	fileService := service.NewFileService(mockFileRepository, mockFolderRepository, mockUserRepository, blobs, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
+	return service.NewFS(folderService, fileService, "testUser", "/")
+}
+
+// testTree returns the folders, files and contents of the tree of testUser
+func testTree() (map[string]models.Folder, map[string]models.File, map[string][]byte) {
+	created := time.Date(2024, 3, 12, 3, 20, 41, 0, time.UTC)
+	folder := func(id, parentID models.ID, parentPath, name string) models.Folder {
+		return models.Folder{ID: id, UserID: 1, ParentID: parentID, Username: "testUser", ParentPath: parentPath, Name: name, CreatedAt: created, Permissions: models.Permissions{OwnerID: 1, Mode: models.DefaultFolderMode}}
+	}
+	file := func(id, folderID models.ID, folderPath, name, content string) models.File {
+		f := models.File{ID: id, UserID: 1, FolderID: folderID, Username: "testUser", FolderPath: folderPath, Name: name, Size: int64(len(content)), CreatedAt: created, Permissions: testFilePermissions}
+		if content != "" {
+			f.ContentHash = hashOf(content)
+			f.ModifiedAt = created.Add(time.Hour)
+		}
+		return f
+	}
+
+	folders := map[string]models.Folder{
+		"/docs":      folder(1, 0, "/", "docs"),
+		"/docs/2024": folder(2, 1, "/docs", "2024"),
+		"/empty":     folder(3, 0, "/", "empty"),
+	}
+	files := map[string]models.File{
+		"/readme":           file(1, 0, "/", "readme", "welcome\n"),
+		"/docs/notes.txt":   file(2, 1, "/docs", "notes.txt", "hello"),
+		"/docs/2024":        file(3, 1, "/docs", "2024", "hidden by the folder"),
+		"/docs/2024/report": file(4, 2, "/docs/2024", "report", "quarterly"),
+		"/docs/2024/blank":  file(5, 2, "/docs/2024", "blank", ""),
+	}
+	data := make(map[string][]byte)
+	for _, content := range []string{"welcome\n", "hello", "hidden by the folder", "quarterly"} {
+		data[hashOf(content)] = []byte(content)
+	}
+	return folders, files, data
+}
+
+// TestFS tests that FS behaves like a file system of the io/fs package
+func TestFS(t *testing.T) {
+	fsys := newTestFS(testTree())
+	assert.NoError(t, fstest.TestFS(fsys, "readme", "docs/notes.txt", "docs/2024/report", "docs/2024/blank", "empty"))
+}
+
+// TestFSOperations tests the operations of FS using table-driven tests
+func TestFSOperations(t *testing.T) {
+	tests := []struct {
+		name      string
+		testFunc  func(t *testing.T, fsys *service.FS)
+		setupFunc func(folders map[string]models.Folder, files map[string]models.File)
+	}{
+		{
+			name: "ReadDirLeavesOutSharedFolders",
+			testFunc: func(t *testing.T, fsys *service.FS) {
+				entries, err := fsys.ReadDir(".")
+				assert.NoError(t, err)
+				var names []string
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				assert.Equal(t, []string{"docs", "empty", "readme"}, names)
+			},
+		},
+		{
+			name: "FolderHidesFile",
+			testFunc: func(t *testing.T, fsys *service.FS) {
+				info, err := fsys.Stat("docs/2024")
+				assert.NoError(t, err)
+				assert.True(t, info.IsDir())
+				_, err = fsys.ReadFile("docs/2024")
+				assert.ErrorIs(t, err, fs.ErrInvalid)
+			},
+		},
+		{
+			name: "StatDescribesFile",
+			testFunc: func(t *testing.T, fsys *service.FS) {
+				info, err := fsys.Stat("docs/notes.txt")
+				assert.NoError(t, err)
+				assert.Equal(t, "notes.txt", info.Name())
+				assert.Equal(t, int64(5), info.Size())
+				assert.Equal(t, fs.FileMode(0o600), info.Mode())
+				assert.Equal(t, time.Date(2024, 3, 12, 4, 20, 41, 0, time.UTC), info.ModTime())
+				file, ok := info.Sys().(models.File)
+				assert.True(t, ok)
+				assert.Equal(t, models.ID(2), file.ID)
+			},
+		},
+		{
+			name: "OpenSeeks",
+			testFunc: func(t *testing.T, fsys *service.FS) {
+				file, err := fsys.Open("docs/2024/report")
+				assert.NoError(t, err)
+				defer file.Close()
+				_, err = file.(io.Seeker).Seek(-5, io.SeekEnd)
+				assert.NoError(t, err)
+				data, err := io.ReadAll(file)
+				assert.NoError(t, err)
+				assert.Equal(t, "terly", string(data))
+			},
+		},
+		{
+			name: "MissingFile",
+			testFunc: func(t *testing.T, fsys *service.FS) {
+				_, err := fsys.Open("docs/missing")
+				assert.ErrorIs(t, err, fs.ErrNotExist)
+				assert.ErrorIs(t, err, customErrors.ErrNotFound)
+				var pathErr *fs.PathError
+				if assert.ErrorAs(t, err, &pathErr) {
+					assert.Equal(t, "open", pathErr.Op)
+					assert.Equal(t, "docs/missing", pathErr.Path)
+				}
+				_, err = fsys.ReadDir("missing")
+				assert.ErrorIs(t, err, fs.ErrNotExist)
+			},
+		},
+		{
+			name: "InvalidName",
+			testFunc: func(t *testing.T, fsys *service.FS) {
+				for _, name := range []string{"/readme", "docs/../readme", "docs/"} {
+					_, err := fsys.Stat(name)
+					assert.ErrorIs(t, err, fs.ErrInvalid, name)
+				}
+			},
+		},
+		{
+			name: "PermissionDenied",
+			testFunc: func(t *testing.T, fsys *service.FS) {
+				_, err := fsys.ReadFile("docs/notes.txt")
+				assert.ErrorIs(t, err, fs.ErrPermission)
+				assert.ErrorIs(t, err, customErrors.ErrForbidden)
+			},
+			setupFunc: func(folders map[string]models.Folder, files map[string]models.File) {
+				docs := folders["/docs"]
+				docs.Mode = 0o600 // testUser may no longer search the folder
+				folders["/docs"] = docs
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folders, files, data := testTree()
+			if tt.setupFunc != nil {
+				tt.setupFunc(folders, files)
+			}
+			tt.testFunc(t, newTestFS(folders, files, data))
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// service/permission.go
+
+package service
+
+import (
+	stderrors "errors"
+
+	"github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// Every folder and file has an owner, a group and a mode, which the services check for the acting user: the user
+// whose username is passed to them. A path names a folder in the tree of the acting user, or in the tree of another
+// user if it starts with the tree prefix, e.g. "~alice/projects".
+// Like on Unix, reaching a folder or file requires searching (execute access to) every folder along its path.
+// Listing a folder requires read access to it, while creating, deleting and renaming the folders and files inside it
+// requires write access. Reading and writing the content of a file requires read and write access to the file, and
+// changing the description of a folder or file requires write access to it.
+// The permissions of a folder or file can be changed by its owner and by the user whose tree holds it, so nobody can
+// be locked out of their own tree.
+// On top of its permissions, a folder can be shared with other users for reading or for reading and writing. A share
+// grants its access to the folder and to everything inside it, and lets the user search the folders above it, so the
+// shared folder can be reached whatever their modes.
+
+// scope is the acting user together with the user whose tree a path is in.
+// granted holds the access the shares of the walked folders grant the acting user to every folder, and reachable the
+// folders above a folder shared with the acting user, which they may search.
+type scope struct {
+	actor     models.User
+	tree      models.User
+	granted   map[models.ID]models.Access
+	reachable map[models.ID]bool
+}
+
+// newScope returns the scope of a path in the tree of a user for the acting user
+func newScope(actor, tree models.User) scope {
+	return scope{actor: actor, tree: tree, granted: make(map[models.ID]models.Access), reachable: make(map[models.ID]bool)}
+}
+
+// access returns the accesses the acting user is granted to the folder, by its permissions or by the shares
+func (sc scope) access(folder models.Folder) models.Access {
+	access := folder.Access(sc.actor) | sc.granted[folder.ID]
+	if sc.reachable[folder.ID] {
+		access |= models.Execute
+	}
+	return access
+}
+
+// inherit records the access the shares grant the acting user to a folder inside the parent folder
+func (sc scope) inherit(parent, folder models.Folder) {
+	sc.granted[folder.ID] = sc.granted[parent.ID] | folder.SharedWith(sc.actor.ID).Access()
+}
+
+// displayPath returns a path inside the tree as the acting user names it: paths in the tree of another user start
+// with the tree prefix, e.g. "~alice/projects"
+func (sc scope) displayPath(folderPath string) string {
+	if sc.tree.ID == sc.actor.ID {
+		return folderPath
+	}
+	return models.TreePrefix + sc.tree.Username + folderPath
+}
+
+// mayChangePermissions reports whether the acting user may change the permissions: only the owner and the user whose
+// tree holds the folder or file may
+func (sc scope) mayChangePermissions(permissions models.Permissions) bool {
+	return sc.actor.ID == permissions.OwnerID || sc.actor.ID == sc.tree.ID
+}
+
+// getActor checks the username of the acting user and returns the user
+func getActor(userRepo models.UserRepository, validator models.Validator, userName string) (models.User, error) {
+	userName, err := validator.Username(userName)
+	if err != nil {
+		return models.User{}, err
+	}
+	return userRepo.GetUser(userName)
+}
+
+// resolveScope checks the acting user and the path, and returns the scope of the path and the path inside the tree,
+// cleaned and normalized
+func resolveScope(userRepo models.UserRepository, validator models.Validator, userName, folderPath string) (scope, string, error) {
+	actor, err := getActor(userRepo, validator, userName)
+	if err != nil {
+		return scope{}, folderPath, err
+	}
+	sc, folderPath, err := resolveTree(userRepo, validator, actor, folderPath)
+	return sc, folderPath, err
+}
+
+// resolveTree returns the scope of a path for the acting user and the path inside the tree, cleaned and normalized
+func resolveTree(userRepo models.UserRepository, validator models.Validator, actor models.User, folderPath string) (scope, string, error) {
+	sc := newScope(actor, actor)
+	treeName, folderPath := models.SplitTree(folderPath)
+	if treeName != "" {
+		treeName, err := validator.Username(treeName)
+		if err != nil {
+			return sc, folderPath, err
+		}
+		if sc.tree, err = userRepo.GetUser(treeName); err != nil {
+			return sc, folderPath, err
+		}
+	}
+	folderPath, err := validator.FolderPath(folderPath)
+	return sc, folderPath, err
+}
+
+// rootFolder returns the root folder of the tree of the user, which has no stored folder of its own
+func rootFolder(user models.User) models.Folder {
+	return models.Folder{UserID: user.ID, Username: user.Username, ParentPath: models.RootPath, Permissions: models.RootPermissions(user)}
+}
+
+// walkFolders returns the folders along folderPath, from the root folder of the tree to the folder at the path, after
+// checking that the acting user may search every folder it passes through.
+// The folders are loaded before they are checked, since a folder shared with the acting user lets them search the
+// folders above it. A missing folder is only reported once the folders above it have been checked.
+func walkFolders(folderRepo models.FolderRepository, sc scope, folderPath string) ([]models.Folder, error) {
+	folders := []models.Folder{rootFolder(sc.tree)}
+	found := true
+	for _, name := range models.PathElements(folderPath) {
+		parent := folders[len(folders)-1]
+		folder, err := folderRepo.GetFolder(sc.tree.Username, models.JoinPath(parent.Path(), name))
+		if stderrors.Is(err, errors.ErrNotFound) {
+			found = false
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		sc.inherit(parent, folder)
+		folders = append(folders, folder)
+	}
+
+	// Let the acting user search the folders above the folders shared with them
+	for i := len(folders) - 1; i > 0; i-- {
+		if folders[i].SharedWith(sc.actor.ID) != "" || sc.reachable[folders[i].ID] {
+			sc.reachable[folders[i-1].ID] = true
+		}
+	}
+
+	searched := folders[:len(folders)-1]
+	if !found {
+		searched = folders
+	}
+	for _, folder := range searched {
+		if err := checkAccess(sc, folder, models.Execute, "search"); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, errors.ErrFolderNotFound(sc.displayPath(folderPath))
+	}
+	return folders, nil
+}
+
+// openFolder returns the folder at folderPath once the acting user is granted the access to it
+func openFolder(folderRepo models.FolderRepository, sc scope, folderPath string, access models.Access, action string) (models.Folder, error) {
+	folders, err := walkFolders(folderRepo, sc, folderPath)
+	if err != nil {
+		return models.Folder{}, err
+	}
+	folder := folders[len(folders)-1]
+	return folder, checkAccess(sc, folder, access, action)
+}
+
+// checkAccess returns an error unless the acting user is granted the access to the folder
+func checkAccess(sc scope, folder models.Folder, access models.Access, action string) error {
+	if sc.access(folder)&access != access {
+		return errors.ErrPermissionDenied(errors.KindFolder, sc.displayPath(folder.Path()), action)
+	}
+	return nil
+}
+
+// checkFileAccess returns an error unless the acting user is granted the access to the file. The shares of the
+// folders above the file grant it the same access as its folder, except for executing it.
+func checkFileAccess(sc scope, file models.File, access models.Access, action string) error {
+	if (file.Access(sc.actor)|sc.granted[file.FolderID]&^models.Execute)&access != access {
+		return errors.ErrPermissionDenied(errors.KindFile, filePath(sc, file), action)
+	}
+	return nil
+}
+
+// filePath returns the path of a file as the acting user names it
+func filePath(sc scope, file models.File) string {
+	return sc.displayPath(models.JoinPath(file.FolderPath, file.Name))
+}
+
+// changeMode returns the permissions with the mode changed as spec describes, see models.ParseMode.
+// kind and name describe the folder or file in a permission error.
+func changeMode(sc scope, permissions models.Permissions, kind errors.Kind, name, spec string) (models.Permissions, error) {
+	if !sc.mayChangePermissions(permissions) {
+		return permissions, errors.ErrPermissionDenied(kind, name, "change the permissions of")
+	}
+	mode, err := models.ParseMode(spec, permissions.Mode)
+	if err != nil {
+		return permissions, err
+	}
+	permissions.Mode = mode
+	return permissions, nil
+}
+
+// changeOwner returns the permissions given to the registered user named owner
+func changeOwner(userRepo models.UserRepository, validator models.Validator, sc scope, permissions models.Permissions, kind errors.Kind, name, owner string) (models.Permissions, error) {
+	if !sc.mayChangePermissions(permissions) {
+		return permissions, errors.ErrPermissionDenied(kind, name, "change the owner of")
+	}
+	user, err := getActor(userRepo, validator, owner)
+	if err != nil {
+		return permissions, err
+	}
+	permissions.OwnerID = user.ID
+	return permissions, nil
+}
+
+// changeGroup returns the permissions given to the group, or to no group if group is empty.
+// Like on Unix, the acting user must be a member of the new group.
+func changeGroup(validator models.Validator, sc scope, permissions models.Permissions, kind errors.Kind, name, group string) (models.Permissions, error) {
+	if !sc.mayChangePermissions(permissions) {
+		return permissions, errors.ErrPermissionDenied(kind, name, "change the group of")
+	}
+	if group != "" {
+		var err error
+		if group, err = validator.GroupName(group); err != nil {
+			return permissions, err
+		}
+		if !sc.actor.InGroup(group) {
+			return permissions, errors.ErrPermissionDenied(errors.KindGroup, group, "give folders and files to")
+		}
+	}
+	permissions.Group = group
+	return permissions, nil
+}
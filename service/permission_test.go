@@ -0,0 +1,366 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/service"
+)
+
+// permissionUsers are the users of the permission tests: alice and bob are members of the group dev, carol is not
+var permissionUsers = map[string]models.User{
+	"alice": {ID: 1, Username: "alice", Groups: []string{"dev"}},
+	"bob":   {ID: 2, Username: "bob", Groups: []string{"dev"}},
+	"carol": {ID: 3, Username: "carol"},
+}
+
+// permissionFolders are the folders in the tree of alice: /shared is open to the group dev, /private to nobody else
+var permissionFolders = map[string]models.Folder{
+	"/shared":  {ID: 1, UserID: 1, Username: "alice", ParentPath: "/", Name: "shared", Permissions: models.Permissions{OwnerID: 1, Group: "dev", Mode: 0o750}},
+	"/private": {ID: 2, UserID: 1, Username: "alice", ParentPath: "/", Name: "private", Permissions: models.Permissions{OwnerID: 1, Mode: 0o700}},
+}
+
+// permissionFile is the file /shared/notes.txt of alice, which the group dev may read
+var permissionFile = models.File{ID: 9, UserID: 1, Username: "alice", FolderPath: "/shared", Name: "notes.txt", Permissions: models.Permissions{OwnerID: 1, Group: "dev", Mode: 0o640}}
+
+// TestPermissions tests that the folder and file services enforce the permissions for the acting user using
+// table-driven tests
+func TestPermissions(t *testing.T) {
+	tests := []struct {
+		name          string
+		act           func(folders *service.FolderService, files *service.FileService) error
+		expectedError error
+	}{
+		{
+			name: "OwnerListsOwnRoot",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				_, err := folders.ListFolders("alice", "/", "", "")
+				return err
+			},
+		},
+		{
+			name: "OthersCannotListRoot",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				_, err := folders.ListFolders("bob", "~alice", "", "")
+				return err
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/", "list"),
+		},
+		{
+			name: "GroupListsSharedFolder",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				_, err := folders.ListFolders("bob", "~alice/shared", "", "")
+				return err
+			},
+		},
+		{
+			name: "OthersCannotListSharedFolder",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				_, err := files.ListFiles("carol", "~alice/shared", "", "")
+				return err
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/shared", "list"),
+		},
+		{
+			name: "OthersCannotSearchPrivateFolder",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.CreateFolder("bob", "~alice/private/inbox/mail", "")
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/private", "search"),
+		},
+		{
+			name: "MissingFolderInOtherTree",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				_, err := files.ListFiles("bob", "~alice/missing", "", "")
+				return err
+			},
+			expectedError: customErrors.ErrFolderNotFound("~alice/missing"),
+		},
+		{
+			name: "GroupCannotCreateFilesWithoutWrite",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				return files.CreateFile("bob", "~alice/shared", "todo.txt", "")
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/shared", "change the entries of"),
+		},
+		{
+			name: "GroupReadsFile",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				_, err := files.ReadFile("bob", "~alice/shared", "notes.txt")
+				return err
+			},
+		},
+		{
+			name: "GroupCannotWriteFile",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				return files.WriteFile("bob", "~alice/shared", "notes.txt", []byte("hello"))
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFile, "~alice/shared/notes.txt", "write"),
+		},
+		{
+			name: "GroupCannotChangeFileDescription",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				return files.UpdateFileDescription("bob", "~alice/shared", "notes.txt", "mine")
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFile, "~alice/shared/notes.txt", "change"),
+		},
+		{
+			name: "GroupCannotRenameFolder",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.RenameFolder("bob", "~alice/shared", "mine")
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/", "change the entries of"),
+		},
+		{
+			name: "CopyToOtherTree",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				_, _, err := files.CopyFile("bob", "~alice/shared", "notes.txt", "/", "", service.ConflictFail)
+				return err
+			},
+			expectedError: customErrors.ErrOtherTree("/"),
+		},
+		{
+			name: "OwnerListsPrivateFolder",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				_, err := folders.ListFolders("alice", "/private", "", "")
+				return err
+			},
+		},
+		{
+			name: "OthersCannotDeleteFolderRecursively",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.DeleteFolder("carol", "~alice/shared", true)
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/", "change the entries of"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folderService, fileService, _, _ := newPermissionServices()
+
+			err := tt.act(folderService, fileService)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				if customErrors.CodeOf(tt.expectedError) == customErrors.CodePermissionDenied {
+					assert.ErrorIs(t, err, customErrors.ErrForbidden)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestChangePermissions tests changing the mode, owner and group of folders and files using table-driven tests
+func TestChangePermissions(t *testing.T) {
+	tests := []struct {
+		name                string
+		change              func(folders *service.FolderService, files *service.FileService) error
+		expectedError       error
+		expectedPermissions models.Permissions
+	}{
+		{
+			name: "ChangeFolderModeSymbolic",
+			change: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.ChangeFolderMode("alice", "/shared", "g+w,o+rx")
+			},
+			expectedPermissions: models.Permissions{OwnerID: 1, Group: "dev", Mode: 0o775},
+		},
+		{
+			name: "ChangeFolderModeOctal",
+			change: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.ChangeFolderMode("alice", "/private", "711")
+			},
+			expectedPermissions: models.Permissions{OwnerID: 1, Mode: 0o711},
+		},
+		{
+			name: "ChangeFolderModeInvalid",
+			change: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.ChangeFolderMode("alice", "/shared", "999")
+			},
+			expectedError: customErrors.ErrInvalidMode("999"),
+		},
+		{
+			name: "ChangeRootMode",
+			change: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.ChangeFolderMode("alice", "/", "777")
+			},
+			expectedError: customErrors.ErrInvalidName("/", ""),
+		},
+		{
+			name: "GroupCannotChangeFolderMode",
+			change: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.ChangeFolderMode("bob", "~alice/shared", "777")
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/shared", "change the permissions of"),
+		},
+		{
+			name: "ChangeFolderOwner",
+			change: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.ChangeFolderOwner("alice", "/shared", "bob")
+			},
+			expectedPermissions: models.Permissions{OwnerID: 2, Group: "dev", Mode: 0o750},
+		},
+		{
+			name: "ChangeFolderOwnerToUnknownUser",
+			change: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.ChangeFolderOwner("alice", "/shared", "ghost")
+			},
+			expectedError: customErrors.ErrUserNotExists("ghost"),
+		},
+		{
+			name: "ClearFolderGroup",
+			change: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.ChangeFolderGroup("alice", "/shared", "")
+			},
+			expectedPermissions: models.Permissions{OwnerID: 1, Mode: 0o750},
+		},
+		{
+			name: "ChangeFolderGroupToForeignGroup",
+			change: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.ChangeFolderGroup("alice", "/private", "ops")
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindGroup, "ops", "give folders and files to"),
+		},
+		{
+			name: "ChangeFileMode",
+			change: func(_ *service.FolderService, files *service.FileService) error {
+				return files.ChangeFileMode("alice", "/shared", "notes.txt", "a=r")
+			},
+			expectedPermissions: models.Permissions{OwnerID: 1, Group: "dev", Mode: 0o444},
+		},
+		{
+			name: "OthersCannotChangeFileOwner",
+			change: func(_ *service.FolderService, files *service.FileService) error {
+				return files.ChangeFileOwner("bob", "~alice/shared", "notes.txt", "bob")
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFile, "~alice/shared/notes.txt", "change the owner of"),
+		},
+		{
+			name: "ChangeFileGroup",
+			change: func(_ *service.FolderService, files *service.FileService) error {
+				return files.ChangeFileGroup("alice", "/shared", "notes.txt", "")
+			},
+			expectedPermissions: models.Permissions{OwnerID: 1, Mode: 0o640},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folderService, fileService, updatedFolder, updatedFile := newPermissionServices()
+
+			err := tt.change(folderService, fileService)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				return
+			}
+			assert.NoError(t, err)
+			if updatedFolder.ID != 0 {
+				assert.Equal(t, tt.expectedPermissions, updatedFolder.Permissions)
+			} else {
+				assert.Equal(t, tt.expectedPermissions, updatedFile.Permissions)
+			}
+		})
+	}
+}
+
+// TestNewItemsInheritTheGroup tests that new folders, files and copies are owned by the acting user and belong to the
+// group of the folder holding them
+func TestNewItemsInheritTheGroup(t *testing.T) {
+	var created models.Folder
+	var createdFile, updatedFile models.File
+	userRepo := &MockUserRepository{GetUserFunc: getPermissionUser}
+	folderRepo := &MockFolderRepository{
+		GetFolderFunc: func(_, folderPath string) (models.Folder, error) {
+			folder := models.Folder{ID: 1, UserID: 1, Username: "alice", ParentPath: "/", Name: "team", Permissions: models.Permissions{OwnerID: 1, Group: "dev", Mode: 0o770}}
+			if folderPath != "/team" {
+				return models.Folder{}, customErrors.ErrFolderNotFound(folderPath)
+			}
+			return folder, nil
+		},
+		CreateFolderFunc: func(folder models.Folder) error { created = folder; return nil },
+	}
+	fileRepo := &MockFileRepository{
+		CreateFileFunc: func(file models.File) error { createdFile = file; return nil },
+		GetFileFunc: func(_, folderPath, fileName string) (models.File, error) {
+			if fileName != "notes.txt" {
+				return models.File{}, customErrors.ErrFileNotFound(fileName)
+			}
+			return models.File{ID: 4, UserID: 1, Username: "alice", FolderPath: "/team", Name: "notes.txt", Permissions: models.Permissions{OwnerID: 1, Mode: 0o664}}, nil
+		},
+		CopyFileFunc: func(_, _, _, newFolderPath, newFileName string, _ bool) (models.File, error) {
+			return models.File{ID: 5, UserID: 1, Username: "alice", FolderPath: newFolderPath, Name: newFileName, Permissions: models.Permissions{OwnerID: 1, Mode: 0o664}}, nil
+		},
+		UpdateFileFunc: func(file models.File) error { updatedFile = file; return nil },
+	}
+	blobRepo := &MockBlobRepository{
+		PutBlobFunc:     func(data []byte) (string, error) { return models.HashContent(data), nil },
+		GetBlobFunc:     func(string) ([]byte, error) { return nil, nil },
+		RetainBlobFunc:  func(string) error { return nil },
+		ReleaseBlobFunc: func(string) error { return nil },
+	}
+	folderService := service.NewFolderService(folderRepo, userRepo, blobRepo, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy())
+	fileService := service.NewFileService(fileRepo, folderRepo, userRepo, blobRepo, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
+
+	assert.NoError(t, folderService.CreateFolder("bob", "~alice/team/drafts", ""))
+	assert.Equal(t, "alice", created.Username)
+	assert.Equal(t, models.Permissions{OwnerID: 2, Group: "dev", Mode: models.DefaultFolderMode}, created.Permissions)
+
+	assert.NoError(t, fileService.CreateFile("bob", "~alice/team", "todo.txt", ""))
+	assert.Equal(t, models.Permissions{OwnerID: 2, Group: "dev", Mode: models.DefaultFileMode}, createdFile.Permissions)
+
+	_, _, err := fileService.CopyFile("bob", "~alice/team", "notes.txt", "~alice/team", "copy.txt", service.ConflictFail)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Permissions{OwnerID: 2, Group: "dev", Mode: 0o664}, updatedFile.Permissions)
+}
+
+// getPermissionUser returns the user named username among the permissionUsers
+func getPermissionUser(username string) (models.User, error) {
+	user, ok := permissionUsers[username]
+	if !ok {
+		return models.User{}, customErrors.ErrUserNotExists(username)
+	}
+	return user, nil
+}
+
+// newPermissionServices returns the folder and file services over the tree of alice, together with the last folder
+// and file they updated
+func newPermissionServices() (*service.FolderService, *service.FileService, *models.Folder, *models.File) {
+	var updatedFolder models.Folder
+	var updatedFile models.File
+	userRepo := &MockUserRepository{GetUserFunc: getPermissionUser}
+	folderRepo := &MockFolderRepository{
+		GetFolderFunc: func(username, folderPath string) (models.Folder, error) {
+			folder, ok := permissionFolders[folderPath]
+			if !ok || username != "alice" {
+				return models.Folder{}, customErrors.ErrFolderNotFound(folderPath)
+			}
+			return folder, nil
+		},
+		ListFoldersFunc: func(string, string, string, string) ([]models.Folder, error) { return nil, nil },
+		UpdateFolderFunc: func(folder models.Folder) error {
+			updatedFolder = folder
+			return nil
+		},
+	}
+	fileRepo := &MockFileRepository{
+		GetFileFunc: func(_, folderPath, fileName string) (models.File, error) {
+			if folderPath != permissionFile.FolderPath || fileName != permissionFile.Name {
+				return models.File{}, customErrors.ErrFileNotFound(fileName)
+			}
+			return permissionFile, nil
+		},
+		ListFilesFunc: func(string, string, string, string) ([]models.File, error) { return nil, nil },
+		UpdateFileFunc: func(file models.File) error {
+			updatedFile = file
+			return nil
+		},
+	}
+	blobRepo := &MockBlobRepository{GetBlobFunc: func(string) ([]byte, error) { return []byte("hello"), nil }}
+	folderService := service.NewFolderService(folderRepo, userRepo, blobRepo, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy())
+	fileService := service.NewFileService(fileRepo, folderRepo, userRepo, blobRepo, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
+	return folderService, fileService, &updatedFolder, &updatedFile
+}
@@ -0,0 +1,264 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/service"
+)
+
+// shareFolders are the folders in the tree of alice used by the share tests. Nobody else may search /private, but
+// /private/docs is shared with bob for reading and /private/team with carol for reading and writing.
+var shareFolders = map[string]models.Folder{
+	"/private":            {ID: 1, UserID: 1, Username: "alice", ParentPath: "/", Name: "private", Permissions: models.Permissions{OwnerID: 1, Mode: 0o700}},
+	"/private/docs":       {ID: 2, UserID: 1, ParentID: 1, Username: "alice", ParentPath: "/private", Name: "docs", Permissions: models.Permissions{OwnerID: 1, Mode: 0o700}, Shares: []models.Share{{UserID: 2, Access: models.ShareRead}}},
+	"/private/team":       {ID: 3, UserID: 1, ParentID: 1, Username: "alice", ParentPath: "/private", Name: "team", Permissions: models.Permissions{OwnerID: 1, Mode: 0o700}, Shares: []models.Share{{UserID: 3, Access: models.ShareReadWrite}}},
+	"/private/team/plans": {ID: 4, UserID: 1, ParentID: 3, Username: "alice", ParentPath: "/private/team", Name: "plans", Permissions: models.Permissions{OwnerID: 1, Mode: 0o700}},
+}
+
+// TestShares tests that the shares of folders grant access to the folders and to everything inside them using
+// table-driven tests
+func TestShares(t *testing.T) {
+	tests := []struct {
+		name          string
+		act           func(folders *service.FolderService, files *service.FileService) error
+		expectedError error
+	}{
+		{
+			name: "ReadShareListsFolder",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				_, err := files.ListFiles("bob", "~alice/private/docs", "", "")
+				return err
+			},
+		},
+		{
+			name: "ReadShareReadsFiles",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				_, err := files.ReadFile("bob", "~alice/private/docs", "readme.txt")
+				return err
+			},
+		},
+		{
+			name: "ReadShareCannotWriteFiles",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				return files.WriteFile("bob", "~alice/private/docs", "readme.txt", []byte("hello"))
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFile, "~alice/private/docs/readme.txt", "write"),
+		},
+		{
+			name: "ReadShareCannotCreateFolders",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.CreateFolder("bob", "~alice/private/docs/drafts", "")
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/private/docs", "change the entries of"),
+		},
+		{
+			name: "ReadWriteShareCreatesFolders",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.CreateFolder("carol", "~alice/private/team/drafts", "")
+			},
+		},
+		{
+			name: "ReadWriteShareGrantsNestedFolders",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				return files.CreateFile("carol", "~alice/private/team/plans", "q3.txt", "")
+			},
+		},
+		{
+			name: "ReadWriteShareDeletesNestedFolders",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				return folders.DeleteFolder("carol", "~alice/private/team/plans", true)
+			},
+		},
+		{
+			name: "ShareDoesNotListFoldersAbove",
+			act: func(folders *service.FolderService, _ *service.FileService) error {
+				_, err := folders.ListFolders("carol", "~alice/private", "", "")
+				return err
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/private", "list"),
+		},
+		{
+			name: "ShareDoesNotGrantSiblings",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				_, err := files.ListFiles("carol", "~alice/private/docs", "", "")
+				return err
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/private", "search"),
+		},
+		{
+			name: "MissingFolderInsideShare",
+			act: func(_ *service.FolderService, files *service.FileService) error {
+				_, err := files.ListFiles("carol", "~alice/private/team/missing", "", "")
+				return err
+			},
+			expectedError: customErrors.ErrFolderNotFound("~alice/private/team/missing"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folderService, fileService, _ := newShareServices()
+
+			err := tt.act(folderService, fileService)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// TestShareFolder tests the ShareFolder and UnshareFolder methods of FolderService using table-driven tests
+func TestShareFolder(t *testing.T) {
+	tests := []struct {
+		name           string
+		change         func(folders *service.FolderService) error
+		expectedShares []models.Share
+		expectedError  error
+	}{
+		{
+			name: "ShareFolder",
+			change: func(folders *service.FolderService) error {
+				return folders.ShareFolder("alice", "/private/docs", "carol", "read-write")
+			},
+			expectedShares: []models.Share{{UserID: 2, Access: models.ShareRead}, {UserID: 3, Access: models.ShareReadWrite}},
+		},
+		{
+			name: "ShareFolderAgainReplacesAccess",
+			change: func(folders *service.FolderService) error {
+				return folders.ShareFolder("alice", "/private/docs", "bob", "read-write")
+			},
+			expectedShares: []models.Share{{UserID: 2, Access: models.ShareReadWrite}},
+		},
+		{
+			name: "UnshareFolder",
+			change: func(folders *service.FolderService) error {
+				return folders.UnshareFolder("alice", "/private/docs", "bob")
+			},
+			expectedShares: []models.Share{},
+		},
+		{
+			name: "UnshareFolderNotShared",
+			change: func(folders *service.FolderService) error {
+				return folders.UnshareFolder("alice", "/private/docs", "carol")
+			},
+			expectedShares: []models.Share{{UserID: 2, Access: models.ShareRead}},
+		},
+		{
+			name: "InvalidAccess",
+			change: func(folders *service.FolderService) error {
+				return folders.ShareFolder("alice", "/private/docs", "carol", "write")
+			},
+			expectedError: customErrors.ErrInvalidShareAccess("write"),
+		},
+		{
+			name: "ShareWithMissingUser",
+			change: func(folders *service.FolderService) error {
+				return folders.ShareFolder("alice", "/private/docs", "dave", "read")
+			},
+			expectedError: customErrors.ErrUserNotExists("dave"),
+		},
+		{
+			name: "ShareRoot",
+			change: func(folders *service.FolderService) error {
+				return folders.ShareFolder("alice", "/", "bob", "read")
+			},
+			expectedError: customErrors.ErrInvalidName("/", ""),
+		},
+		{
+			name: "SharedUserCannotReshare",
+			change: func(folders *service.FolderService) error {
+				return folders.ShareFolder("carol", "~alice/private/team", "bob", "read")
+			},
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindFolder, "~alice/private/team", "share"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folderService, _, updatedFolder := newShareServices()
+
+			err := tt.change(folderService)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedShares, updatedFolder.Shares)
+		})
+	}
+}
+
+// TestListFoldersIncludesSharedFolders tests that the root folder of a user lists the folders shared with them after
+// their own folders, but not the folders they share with others
+func TestListFoldersIncludesSharedFolders(t *testing.T) {
+	own := models.Folder{ID: 5, UserID: 3, Username: "carol", ParentPath: "/", Name: "home", Permissions: models.Permissions{OwnerID: 3, Mode: 0o700}}
+	ownShared := own
+	ownShared.Shares = []models.Share{{UserID: 1, Access: models.ShareRead}}
+	userRepo := &MockUserRepository{GetUserFunc: getPermissionUser}
+	folderRepo := &MockFolderRepository{
+		ListFoldersFunc: func(string, string, string, string) ([]models.Folder, error) { return []models.Folder{own}, nil },
+		ListSharedFunc: func(string) ([]models.Folder, error) {
+			return []models.Folder{ownShared, shareFolders["/private/team"]}, nil
+		},
+	}
+	folderService := service.NewFolderService(folderRepo, userRepo, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy())
+
+	folders, err := folderService.ListFolders("carol", "/", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Folder{own, shareFolders["/private/team"]}, folders)
+
+	shared, err := folderService.ListShared("carol")
+	assert.NoError(t, err)
+	assert.Len(t, shared, 2)
+}
+
+// newShareServices returns the folder and file services over the tree of alice used by the share tests, together with
+// the last folder they updated. Every folder holds a file named readme.txt that only alice may read and write.
+func newShareServices() (*service.FolderService, *service.FileService, *models.Folder) {
+	var updatedFolder models.Folder
+	userRepo := &MockUserRepository{GetUserFunc: getPermissionUser}
+	folderRepo := &MockFolderRepository{
+		GetFolderFunc: func(username, folderPath string) (models.Folder, error) {
+			folder, ok := shareFolders[folderPath]
+			if !ok || username != "alice" {
+				return models.Folder{}, customErrors.ErrFolderNotFound(folderPath)
+			}
+			return folder, nil
+		},
+		CreateFolderFunc: func(models.Folder) error { return nil },
+		DeleteFolderFunc: func(string, string, bool) ([]models.File, error) { return nil, nil },
+		ListFoldersFunc: func(_, parentPath, _, _ string) ([]models.Folder, error) {
+			var folders []models.Folder
+			for _, folder := range shareFolders {
+				if folder.ParentPath == parentPath {
+					folders = append(folders, folder)
+				}
+			}
+			return folders, nil
+		},
+		UpdateFolderFunc: func(folder models.Folder) error {
+			updatedFolder = folder
+			return nil
+		},
+	}
+	fileRepo := &MockFileRepository{
+		CreateFileFunc: func(models.File) error { return nil },
+		GetFileFunc: func(_, folderPath, fileName string) (models.File, error) {
+			folder, ok := shareFolders[folderPath]
+			if !ok || fileName != "readme.txt" {
+				return models.File{}, customErrors.ErrFileNotFound(fileName)
+			}
+			return models.File{ID: 9, UserID: 1, FolderID: folder.ID, Username: "alice", FolderPath: folderPath, Name: fileName, Permissions: models.Permissions{OwnerID: 1, Mode: 0o600}}, nil
+		},
+		ListFilesFunc: func(string, string, string, string) ([]models.File, error) { return nil, nil },
+	}
+	blobRepo := &MockBlobRepository{GetBlobFunc: func(string) ([]byte, error) { return []byte("hello"), nil }}
+	folderService := service.NewFolderService(folderRepo, userRepo, blobRepo, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy())
+	fileService := service.NewFileService(fileRepo, folderRepo, userRepo, blobRepo, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
+	return folderService, fileService, &updatedFolder
+}
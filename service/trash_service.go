@@ -0,0 +1,415 @@
+// service/trash_service.go
+
+package service
+
+import (
+	stderrors "errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// TrashService handles the service logic for the trash of every user.
+// Deleted folders and files are moved to the trash of the user whose tree held them, see deleteFolder and deleteFile,
+// and stay there until they are restored, the trash is emptied or they outlive the retention period.
+type TrashService struct {
+	trashRepo   models.TrashRepository
+	folderRepo  models.FolderRepository
+	fileRepo    models.FileRepository
+	userRepo    models.UserRepository
+	blobRepo    models.BlobRepository
+	versionRepo models.VersionRepository
+	validator   models.Validator
+	retention   time.Duration
+	trashMu     *sync.Mutex // serializes restoring and purging trash entries with deleting users, shared with UserService
+}
+
+// NewTrashService creates a new instance of TrashService that purges the entries deleted longer than retention ago.
+// A retention of zero keeps the entries until the trash is emptied. Files moved to the trash lose their history in
+// versionRepo, so restored files start a new one.
+// trashMu serializes restoring and purging trash entries with deleting users, which purges their trash, so an entry is
+// never purged while it is restored and the purger never races the deletion of a user. It must be the lock given to
+// the UserService of the same store.
+func NewTrashService(trashRepo models.TrashRepository, folderRepo models.FolderRepository, fileRepo models.FileRepository, userRepo models.UserRepository, blobRepo models.BlobRepository, versionRepo models.VersionRepository, names models.NamePolicy, retention time.Duration, trashMu *sync.Mutex) *TrashService {
+	return &TrashService{trashRepo: trashRepo, folderRepo: folderRepo, fileRepo: fileRepo, userRepo: userRepo, blobRepo: blobRepo, versionRepo: versionRepo, validator: models.NewValidator(names), retention: retention, trashMu: trashMu}
+}
+
+// Retention returns how long deleted items stay in the trash, or zero if they stay until the trash is emptied
+func (s *TrashService) Retention() time.Duration {
+	return s.retention
+}
+
+// ListTrash lists the entries in the trash of the acting user, in the order they were deleted
+func (s *TrashService) ListTrash(userName string) ([]models.TrashEntry, error) {
+	actor, err := getActor(s.userRepo, s.validator, userName)
+	if err != nil {
+		return nil, err
+	}
+	return s.trashRepo.ListTrash(actor.ID)
+}
+
+// RestoreTrash restores the entry with the ID from the trash of the acting user to the path it was deleted from,
+// resolving a name conflict with the policy: ConflictOverwrite moves the existing folder or file to the trash in turn.
+// The folder that held the item must still exist. Restored items keep their descriptions, times, permissions and
+// shares, except that items owned by deleted users are handed over to the acting user and the shares with deleted
+// users are dropped. A user registered after the item was deleted is taken for deleted, as it can't be the user the
+// item was owned by or shared with.
+// It returns the path of the restored item, or false if it was skipped.
+func (s *TrashService) RestoreTrash(userName string, id models.ID, policy ConflictPolicy) (string, bool, error) {
+	actor, err := getActor(s.userRepo, s.validator, userName)
+	if err != nil {
+		return "", false, err
+	}
+
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+
+	entry, err := s.trashRepo.GetTrash(actor.ID, id)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Check if the folder that held the item still exists and the user may change its entries
+	sc := newScope(actor, actor)
+	parentPath, name := models.SplitPath(entry.Path)
+	if _, err := openFolder(s.folderRepo, sc, parentPath, models.Write|models.Execute, "change the entries of"); err != nil {
+		return "", false, err
+	}
+
+	// Resolve a conflict with an existing folder or file
+	exists, err := s.exists(sc, entry, parentPath, name)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		switch policy {
+		case ConflictSkip:
+			return entry.Path, false, nil
+		case ConflictRename:
+			if name, err = s.freeName(sc, entry, parentPath, name); err != nil {
+				return "", false, err
+			}
+		case ConflictOverwrite:
+			if err := s.discard(sc, entry, parentPath, name); err != nil {
+				return "", false, err
+			}
+		default:
+			if entry.IsFolder() {
+				return "", false, errors.ErrFolderExists(entry.Path)
+			}
+			return "", false, errors.ErrFileExists(name)
+		}
+	}
+
+	// Recreate the folders and files, then remove the entry. The restored files take over the blobs of the entry, so
+	// they are not released. If either step fails, the restored item is removed again, so the blobs are never held by
+	// both the entry and the restored files, and purging the entry can't take the content of the restored files.
+	restoredPath := models.JoinPath(parentPath, name)
+	created, err := s.restore(actor, entry, restoredPath)
+	if err == nil {
+		err = s.trashRepo.DeleteTrash(entry.UserID, entry.ID)
+	}
+	if err != nil {
+		if created {
+			err = stderrors.Join(err, s.unrestore(actor, entry, restoredPath))
+		}
+		return "", false, err
+	}
+	return restoredPath, true, nil
+}
+
+// exists reports whether the tree of the acting user holds a folder or file, as the entry holds, named name inside
+// parentPath
+func (s *TrashService) exists(sc scope, entry models.TrashEntry, parentPath, name string) (bool, error) {
+	if entry.IsFolder() {
+		return s.folderRepo.Exists(sc.tree.Username, models.JoinPath(parentPath, name))
+	}
+	_, err := s.fileRepo.GetFile(sc.tree.Username, parentPath, name)
+	if stderrors.Is(err, errors.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// freeName returns the first name made of name and a number that no folder or file, as the entry holds, inside
+// parentPath has. For files the number goes before the extension, so "report.pdf" becomes "report1.pdf".
+func (s *TrashService) freeName(sc scope, entry models.TrashEntry, parentPath, name string) (string, error) {
+	base, ext := name, ""
+	if !entry.IsFolder() {
+		base, ext = models.SplitExtension(name)
+	}
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s%d%s", base, i, ext)
+		var err error
+		if entry.IsFolder() {
+			_, err = s.validator.NewFolderName(candidate)
+		} else {
+			_, err = s.validator.NewFileName(candidate)
+		}
+		if err != nil {
+			return "", err
+		}
+		if exists, err := s.exists(sc, entry, parentPath, candidate); err != nil || !exists {
+			return candidate, err
+		}
+	}
+}
+
+// discard moves the folder or file in the way of the restored entry to the trash
+func (s *TrashService) discard(sc scope, entry models.TrashEntry, parentPath, name string) error {
+	if entry.IsFolder() {
+		folders, err := walkFolders(s.folderRepo, sc, models.JoinPath(parentPath, name))
+		if err != nil {
+			return err
+		}
+		return deleteFolder(s.folderRepo, s.trashRepo, s.versionRepo, s.blobRepo, sc, folders, true)
+	}
+	file, err := s.fileRepo.GetFile(sc.tree.Username, parentPath, name)
+	if err != nil {
+		return err
+	}
+	return deleteFile(s.fileRepo, s.trashRepo, s.versionRepo, s.blobRepo, sc, file)
+}
+
+// restore recreates the folders and files held by the entry in the tree of the user, with the item at restoredPath.
+// It reports whether the item at restoredPath was created, even if restoring what it holds failed.
+func (s *TrashService) restore(user models.User, entry models.TrashEntry, restoredPath string) (created bool, err error) {
+	users, err := s.userRepo.ListUsers("", "")
+	if err != nil {
+		return false, err
+	}
+	// A user registered since the deletion owns nothing in the entry, even if a store written before IDs were never
+	// reused gave it the ID of a deleted user
+	registered := make(map[models.ID]bool, len(users))
+	for _, u := range users {
+		registered[u.ID] = !u.CreatedAt.After(entry.DeletedAt)
+	}
+	permissions := func(p models.Permissions) models.Permissions {
+		if !registered[p.OwnerID] {
+			p.OwnerID = user.ID
+		}
+		return p
+	}
+
+	for _, folder := range entry.Folders {
+		parentPath, name := models.SplitPath(entry.Rebase(folder.Path(), restoredPath))
+		restored := models.Folder{
+			Username:    user.Username,
+			ParentPath:  parentPath,
+			Name:        name,
+			Description: folder.Description,
+			CreatedAt:   folder.CreatedAt,
+			Permissions: permissions(folder.Permissions),
+		}
+		if err := s.folderRepo.CreateFolder(restored); err != nil {
+			return created, err
+		}
+		created = true
+
+		// The shares with deleted users are gone
+		var shares []models.Share
+		for _, share := range folder.Shares {
+			if registered[share.UserID] {
+				shares = append(shares, share)
+			}
+		}
+		if len(shares) > 0 {
+			if restored, err = s.folderRepo.GetFolder(user.Username, restored.Path()); err != nil {
+				return created, err
+			}
+			restored.Shares = shares
+			if err := s.folderRepo.UpdateFolder(restored); err != nil {
+				return created, err
+			}
+		}
+	}
+
+	for _, file := range entry.Files {
+		folderPath, name := models.SplitPath(entry.Rebase(models.JoinPath(file.FolderPath, file.Name), restoredPath))
+		restored := models.File{
+			Username:    user.Username,
+			FolderPath:  folderPath,
+			Name:        name,
+			Description: file.Description,
+			Size:        file.Size,
+			ContentHash: file.ContentHash,
+			CreatedAt:   file.CreatedAt,
+			ModifiedAt:  file.ModifiedAt,
+			Permissions: permissions(file.Permissions),
+		}
+		if err := s.fileRepo.CreateFile(restored); err != nil {
+			return created, err
+		}
+		created = true
+	}
+	return created, nil
+}
+
+// unrestore removes the item restored from the entry at restoredPath, with everything inside it, from the tree of the
+// user. The blobs of its files stay with the entry, so they are not released.
+func (s *TrashService) unrestore(user models.User, entry models.TrashEntry, restoredPath string) error {
+	if entry.IsFolder() {
+		_, err := s.folderRepo.DeleteFolder(user.Username, restoredPath, true)
+		return err
+	}
+	folderPath, name := models.SplitPath(restoredPath)
+	return s.fileRepo.DeleteFile(user.Username, folderPath, name)
+}
+
+// EmptyTrash permanently removes every entry from the trash of the acting user and returns how many were removed
+func (s *TrashService) EmptyTrash(userName string) (int, error) {
+	actor, err := getActor(s.userRepo, s.validator, userName)
+	if err != nil {
+		return 0, err
+	}
+
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+
+	entries, err := s.trashRepo.ListTrash(actor.ID)
+	if err != nil {
+		return 0, err
+	}
+	for i, entry := range entries {
+		if err := purgeTrash(s.trashRepo, s.blobRepo, entry); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// PurgeExpired permanently removes the entries of every user deleted longer than the retention period before now and
+// returns how many were removed. Nothing expires without a retention period.
+func (s *TrashService) PurgeExpired(now time.Time) (int, error) {
+	if s.retention <= 0 {
+		return 0, nil
+	}
+
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+
+	entries, err := s.trashRepo.ListExpiredTrash(now.Add(-s.retention))
+	if err != nil {
+		return 0, err
+	}
+	for i, entry := range entries {
+		if err := purgeTrash(s.trashRepo, s.blobRepo, entry); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+// StartPurger purges the expired entries every interval in the background until the returned function is called.
+// Every interval it also runs the other purges, such as FileService.PruneVersions, after purging the trash.
+// Errors are passed to onError, if set, and the purger carries on.
+func (s *TrashService) StartPurger(interval time.Duration, onError func(error), purges ...func(now time.Time) (int, error)) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				for _, purge := range append([]func(time.Time) (int, error){s.PurgeExpired}, purges...) {
+					if _, err := purge(now); err != nil && onError != nil {
+						onError(err)
+					}
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// deleteFolder moves the folder at the end of folders, as returned by walkFolders, to the trash of the tree that holds
+// it. Unless recursive is set, only an empty folder can be deleted; otherwise every folder nested inside it and all the
+// files inside them go with it, which requires full access to each of those folders.
+func deleteFolder(folderRepo models.FolderRepository, trashRepo models.TrashRepository, versionRepo models.VersionRepository, blobRepo models.BlobRepository, sc scope, folders []models.Folder, recursive bool) error {
+	folder := folders[len(folders)-1]
+	if err := checkAccess(sc, folders[len(folders)-2], models.Write|models.Execute, "change the entries of"); err != nil {
+		return err
+	}
+	deleted := []models.Folder{folder}
+	if recursive {
+		var err error
+		if deleted, err = checkNested(folderRepo, sc, folder); err != nil {
+			return err
+		}
+	}
+
+	files, err := folderRepo.DeleteFolder(sc.tree.Username, folder.Path(), recursive)
+	if err != nil {
+		return err
+	}
+	return moveToTrash(trashRepo, versionRepo, blobRepo, sc, folder.Path(), deleted, files)
+}
+
+// checkNested returns the folder followed by every folder nested inside it, outermost first, once the acting user is
+// granted full access to each of them
+func checkNested(folderRepo models.FolderRepository, sc scope, folder models.Folder) ([]models.Folder, error) {
+	if err := checkAccess(sc, folder, models.Read|models.Write|models.Execute, "delete"); err != nil {
+		return nil, err
+	}
+	children, err := folderRepo.ListFolders(sc.tree.Username, folder.Path(), "", "")
+	if err != nil {
+		return nil, err
+	}
+	folders := []models.Folder{folder}
+	for _, child := range children {
+		sc.inherit(folder, child)
+		nested, err := checkNested(folderRepo, sc, child)
+		if err != nil {
+			return nil, err
+		}
+		folders = append(folders, nested...)
+	}
+	return folders, nil
+}
+
+// deleteFile moves the file to the trash of the tree that holds it. The caller checks that it may be deleted.
+func deleteFile(fileRepo models.FileRepository, trashRepo models.TrashRepository, versionRepo models.VersionRepository, blobRepo models.BlobRepository, sc scope, file models.File) error {
+	if err := fileRepo.DeleteFile(sc.tree.Username, file.FolderPath, file.Name); err != nil {
+		return err
+	}
+	return moveToTrash(trashRepo, versionRepo, blobRepo, sc, models.JoinPath(file.FolderPath, file.Name), nil, []models.File{file})
+}
+
+// moveToTrash adds the deleted folders and files to the trash of the tree user as an entry for the item at itemPath,
+// then discards the history of the files. The files in the trash keep referencing the blobs of their content.
+func moveToTrash(trashRepo models.TrashRepository, versionRepo models.VersionRepository, blobRepo models.BlobRepository, sc scope, itemPath string, folders []models.Folder, files []models.File) error {
+	if _, err := trashRepo.AddTrash(models.TrashEntry{
+		UserID:    sc.tree.ID,
+		DeletedBy: sc.actor.ID,
+		DeletedAt: time.Now(),
+		Path:      itemPath,
+		Folders:   folders,
+		Files:     files,
+	}); err != nil {
+		return err
+	}
+	return discardVersions(versionRepo, blobRepo, files)
+}
+
+// purgeTrash permanently removes the entry from the trash of its user and releases the blobs of its files.
+// An entry that is already gone was purged by someone else, who released its blobs, so they aren't released again.
+func purgeTrash(trashRepo models.TrashRepository, blobRepo models.BlobRepository, entry models.TrashEntry) error {
+	if err := trashRepo.DeleteTrash(entry.UserID, entry.ID); stderrors.Is(err, errors.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, file := range entry.Files {
+		if err := blobRepo.ReleaseBlob(file.ContentHash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
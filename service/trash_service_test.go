@@ -0,0 +1,417 @@
+package service_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/service"
+)
+
+// MockTrashRepository is a mock of TrashRepository
+type MockTrashRepository struct {
+	AddTrashFunc         func(models.TrashEntry) (models.TrashEntry, error)
+	GetTrashFunc         func(models.ID, models.ID) (models.TrashEntry, error)
+	ListTrashFunc        func(models.ID) ([]models.TrashEntry, error)
+	ListExpiredTrashFunc func(time.Time) ([]models.TrashEntry, error)
+	DeleteTrashFunc      func(models.ID, models.ID) error
+}
+
+// AddTrash returns the entry from AddTrashFunc, or else the entry with ID 1
+func (m *MockTrashRepository) AddTrash(entry models.TrashEntry) (models.TrashEntry, error) {
+	if m.AddTrashFunc == nil {
+		entry.ID = 1
+		return entry, nil
+	}
+	return m.AddTrashFunc(entry)
+}
+
+func (m *MockTrashRepository) GetTrash(userID, id models.ID) (models.TrashEntry, error) {
+	return m.GetTrashFunc(userID, id)
+}
+
+// ListTrash returns the entries from ListTrashFunc, or else no entries
+func (m *MockTrashRepository) ListTrash(userID models.ID) ([]models.TrashEntry, error) {
+	if m.ListTrashFunc == nil {
+		return nil, nil
+	}
+	return m.ListTrashFunc(userID)
+}
+
+func (m *MockTrashRepository) ListExpiredTrash(deletedBefore time.Time) ([]models.TrashEntry, error) {
+	return m.ListExpiredTrashFunc(deletedBefore)
+}
+
+func (m *MockTrashRepository) DeleteTrash(userID, id models.ID) error {
+	return m.DeleteTrashFunc(userID, id)
+}
+
+// trashFile is the file held by the trash entry of alice used by the trash tests, deleted from /shared
+var trashFile = models.File{ID: 9, UserID: 1, FolderID: 2, Username: "alice", FolderPath: "/shared", Name: "notes.txt", Size: 5, ContentHash: hashOf("hello"), Permissions: models.Permissions{OwnerID: 1, Mode: 0o600}}
+
+// TestDeleteFileMovesToTrash tests that deleting a file adds it to the trash of the tree that holds it, which keeps
+// the reference of the file to its blob, and discards its history
+func TestDeleteFileMovesToTrash(t *testing.T) {
+	var added models.TrashEntry
+	hello := hashOf("hello")
+	data := map[string][]byte{hello: []byte("hello")}
+	refs := map[string]int{hello: 2} // the file and its version
+	userRepo := &MockUserRepository{GetUserFunc: getPermissionUser}
+	folderRepo := &MockFolderRepository{GetFolderFunc: getTrashFolder}
+	fileRepo := &MockFileRepository{
+		GetFileFunc:    func(string, string, string) (models.File, error) { return trashFile, nil },
+		DeleteFileFunc: func(string, string, string) error { return nil },
+	}
+	trashRepo := &MockTrashRepository{AddTrashFunc: func(entry models.TrashEntry) (models.TrashEntry, error) {
+		entry.ID = 7
+		added = entry
+		return entry, nil
+	}}
+	versionRepo := &MockVersionRepository{DeleteVersionsFunc: func(fileID models.ID) ([]models.Version, error) {
+		return []models.Version{{FileID: fileID, Number: 1, Hash: hello}}, nil
+	}}
+	fileService := service.NewFileService(fileRepo, folderRepo, userRepo, newBlobs(data, refs), trashRepo, versionRepo, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
+
+	assert.NoError(t, fileService.DeleteFile("bob", "~alice/shared", "notes.txt"))
+	assert.Equal(t, models.ID(1), added.UserID)
+	assert.Equal(t, models.ID(2), added.DeletedBy)
+	assert.Equal(t, "/shared/notes.txt", added.Path)
+	assert.Equal(t, []models.File{trashFile}, added.Files)
+	assert.Equal(t, map[string]int{hello: 1}, refs)
+}
+
+// TestRestoreTrash tests the RestoreTrash method of TrashService using table-driven tests
+func TestRestoreTrash(t *testing.T) {
+	tests := []struct {
+		name             string
+		id               models.ID
+		policy           service.ConflictPolicy
+		existing         []string
+		expectedPath     string
+		expectedRestored bool
+		expectedError    error
+	}{
+		{
+			name:             "Restore",
+			id:               1,
+			expectedPath:     "/shared/notes.txt",
+			expectedRestored: true,
+		},
+		{
+			name:          "Conflict",
+			id:            1,
+			existing:      []string{"notes.txt"},
+			expectedError: customErrors.ErrFileExists("notes.txt"),
+		},
+		{
+			name:         "ConflictSkip",
+			id:           1,
+			policy:       service.ConflictSkip,
+			existing:     []string{"notes.txt"},
+			expectedPath: "/shared/notes.txt",
+		},
+		{
+			name:             "ConflictRename",
+			id:               1,
+			policy:           service.ConflictRename,
+			existing:         []string{"notes.txt", "notes1.txt"},
+			expectedPath:     "/shared/notes2.txt",
+			expectedRestored: true,
+		},
+		{
+			name:          "FolderGone",
+			id:            2,
+			expectedError: customErrors.ErrFolderNotFound("/gone"),
+		},
+		{
+			name:          "EntryNotFound",
+			id:            3,
+			expectedError: customErrors.ErrTrashNotFound("3"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := map[models.ID]models.TrashEntry{
+				1: {ID: 1, UserID: 1, Path: "/shared/notes.txt", Files: []models.File{trashFile}},
+				2: {ID: 2, UserID: 1, Path: "/gone/notes.txt", Files: []models.File{{ID: 9, FolderPath: "/gone", Name: "notes.txt"}}},
+			}
+			hello := hashOf("hello")
+			data := map[string][]byte{hello: []byte("hello")}
+			refs := map[string]int{hello: 1}
+			var created models.File
+			userRepo := &MockUserRepository{
+				GetUserFunc:   getPermissionUser,
+				ListUsersFunc: func(string, string) ([]models.User, error) { return []models.User{permissionUsers["alice"]}, nil },
+			}
+			folderRepo := &MockFolderRepository{GetFolderFunc: getTrashFolder}
+			fileRepo := &MockFileRepository{
+				CreateFileFunc: func(file models.File) error { created = file; return nil },
+				GetFileFunc: func(_, _, fileName string) (models.File, error) {
+					if created.Name == fileName {
+						created.ID = 10
+						return created, nil
+					}
+					for _, name := range tt.existing {
+						if name == fileName {
+							return models.File{Name: name}, nil
+						}
+					}
+					return models.File{}, customErrors.ErrFileNotFound(fileName)
+				},
+			}
+			trashRepo := &MockTrashRepository{
+				GetTrashFunc: func(userID, id models.ID) (models.TrashEntry, error) {
+					entry, ok := entries[id]
+					if !ok || entry.UserID != userID {
+						return models.TrashEntry{}, customErrors.ErrTrashNotFound(id.String())
+					}
+					return entry, nil
+				},
+				DeleteTrashFunc: func(_, id models.ID) error { delete(entries, id); return nil },
+			}
+			trashService := service.NewTrashService(trashRepo, folderRepo, fileRepo, userRepo, newBlobs(data, refs), &MockVersionRepository{}, models.DefaultNamePolicy(), time.Hour, &sync.Mutex{})
+
+			restoredPath, restored, err := trashService.RestoreTrash("alice", tt.id, tt.policy)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedPath, restoredPath)
+			assert.Equal(t, tt.expectedRestored, restored)
+			if !tt.expectedRestored {
+				assert.Contains(t, entries, tt.id)
+				return
+			}
+			assert.NotContains(t, entries, tt.id)
+			assert.Equal(t, models.JoinPath(created.FolderPath, created.Name), tt.expectedPath)
+			assert.Equal(t, trashFile.Permissions, created.Permissions)
+			assert.Equal(t, hello, created.ContentHash)
+			assert.Equal(t, map[string]int{hello: 1}, refs) // the restored file takes over the reference of the trash
+		})
+	}
+}
+
+// TestRestoreTrashHandsOverOwnership tests that restored folders and files owned by deleted users are handed over to the
+// acting user and lose their shares with deleted users, which includes users registered since the deletion
+func TestRestoreTrashHandsOverOwnership(t *testing.T) {
+	deletedAt := time.Date(2024, 3, 12, 3, 20, 41, 0, time.UTC)
+	alice := models.User{ID: 1, Username: "alice", CreatedAt: deletedAt.Add(-time.Hour)}
+	tests := []struct {
+		name           string
+		users          []models.User
+		expectedOwner  models.ID
+		expectedShares []models.Share
+	}{
+		{
+			name:           "OwnerRegistered",
+			users:          []models.User{alice, {ID: 2, Username: "bob", CreatedAt: deletedAt.Add(-time.Hour)}},
+			expectedOwner:  2,
+			expectedShares: []models.Share{{UserID: 2, Access: models.ShareRead}},
+		},
+		{
+			name:          "OwnerDeleted",
+			users:         []models.User{alice},
+			expectedOwner: 1,
+		},
+		{
+			name:          "OwnerDeletedAndIDTakenByNewUser",
+			users:         []models.User{alice, {ID: 2, Username: "mallory", CreatedAt: deletedAt.Add(time.Hour)}},
+			expectedOwner: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bobOwns := models.Permissions{OwnerID: 2, Mode: 0o700}
+			entry := models.TrashEntry{
+				ID:        4,
+				UserID:    1,
+				DeletedBy: 1,
+				DeletedAt: deletedAt,
+				Path:      "/shared/docs",
+				Folders: []models.Folder{{ID: 5, UserID: 1, Username: "alice", ParentPath: "/shared", Name: "docs", Permissions: bobOwns,
+					Shares: []models.Share{{UserID: 2, Access: models.ShareRead}}}},
+				Files: []models.File{{ID: 6, UserID: 1, Username: "alice", FolderPath: "/shared/docs", Name: "notes.txt", Permissions: bobOwns}},
+			}
+			var folder models.Folder
+			var file models.File
+			userRepo := &MockUserRepository{
+				GetUserFunc:   getPermissionUser,
+				ListUsersFunc: func(string, string) ([]models.User, error) { return tt.users, nil },
+			}
+			folderRepo := &MockFolderRepository{
+				ExistsFunc: func(string, string) (bool, error) { return folder.Name != "", nil },
+				GetFolderFunc: func(username, folderPath string) (models.Folder, error) {
+					if folder.Name != "" && folderPath == folder.Path() {
+						return folder, nil
+					}
+					return getTrashFolder(username, folderPath)
+				},
+				CreateFolderFunc: func(f models.Folder) error { folder = f; return nil },
+				UpdateFolderFunc: func(f models.Folder) error { folder = f; return nil },
+			}
+			fileRepo := &MockFileRepository{CreateFileFunc: func(f models.File) error { file = f; return nil }}
+			trashRepo := &MockTrashRepository{
+				GetTrashFunc:    func(models.ID, models.ID) (models.TrashEntry, error) { return entry, nil },
+				DeleteTrashFunc: func(models.ID, models.ID) error { return nil },
+			}
+			trashService := service.NewTrashService(trashRepo, folderRepo, fileRepo, userRepo, newBlobs(nil, map[string]int{}), &MockVersionRepository{}, models.DefaultNamePolicy(), time.Hour, &sync.Mutex{})
+
+			_, restored, err := trashService.RestoreTrash("alice", entry.ID, service.ConflictFail)
+			assert.NoError(t, err)
+			assert.True(t, restored)
+			assert.Equal(t, tt.expectedOwner, folder.OwnerID)
+			assert.Equal(t, tt.expectedShares, folder.Shares)
+			assert.Equal(t, tt.expectedOwner, file.OwnerID)
+		})
+	}
+}
+
+// TestRestoreTrashRollsBack tests that a restore that fails partway removes what it restored and keeps the entry, so
+// purging the entry later can't release the blobs of restored files
+func TestRestoreTrashRollsBack(t *testing.T) {
+	tests := []struct {
+		name               string
+		failFolder         bool
+		failFile           bool
+		failDelete         bool
+		expectedRolledBack bool
+	}{
+		{name: "FolderFails", failFolder: true},
+		{name: "FileFails", failFile: true, expectedRolledBack: true},
+		{name: "DeleteTrashFails", failDelete: true, expectedRolledBack: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := models.TrashEntry{
+				ID:      4,
+				UserID:  1,
+				Path:    "/shared/docs",
+				Folders: []models.Folder{{ID: 5, UserID: 1, Username: "alice", ParentPath: "/shared", Name: "docs", Permissions: models.Permissions{OwnerID: 1, Mode: 0o700}}},
+				Files:   []models.File{trashFile},
+			}
+			entry.Files[0].FolderPath = "/shared/docs"
+			hello := hashOf("hello")
+			refs := map[string]int{hello: 1}
+			var rolledBack string
+			deleted := false
+			userRepo := &MockUserRepository{
+				GetUserFunc:   getPermissionUser,
+				ListUsersFunc: func(string, string) ([]models.User, error) { return []models.User{permissionUsers["alice"]}, nil },
+			}
+			folderRepo := &MockFolderRepository{
+				ExistsFunc:    func(string, string) (bool, error) { return false, nil },
+				GetFolderFunc: getTrashFolder,
+				CreateFolderFunc: func(models.Folder) error {
+					if tt.failFolder {
+						return assert.AnError
+					}
+					return nil
+				},
+				DeleteFolderFunc: func(_, folderPath string, recursive bool) ([]models.File, error) {
+					assert.True(t, recursive)
+					rolledBack = folderPath
+					return nil, nil
+				},
+			}
+			fileRepo := &MockFileRepository{CreateFileFunc: func(models.File) error {
+				if tt.failFile {
+					return assert.AnError
+				}
+				return nil
+			}}
+			trashRepo := &MockTrashRepository{
+				GetTrashFunc: func(models.ID, models.ID) (models.TrashEntry, error) { return entry, nil },
+				DeleteTrashFunc: func(models.ID, models.ID) error {
+					if tt.failDelete {
+						return assert.AnError
+					}
+					deleted = true
+					return nil
+				},
+			}
+			trashService := service.NewTrashService(trashRepo, folderRepo, fileRepo, userRepo, newBlobs(map[string][]byte{hello: []byte("hello")}, refs), &MockVersionRepository{}, models.DefaultNamePolicy(), time.Hour, &sync.Mutex{})
+
+			_, restored, err := trashService.RestoreTrash("alice", entry.ID, service.ConflictFail)
+			assert.ErrorIs(t, err, assert.AnError)
+			assert.False(t, restored)
+			assert.False(t, deleted)
+			if tt.expectedRolledBack {
+				assert.Equal(t, "/shared/docs", rolledBack)
+			} else {
+				assert.Empty(t, rolledBack)
+			}
+			assert.Equal(t, map[string]int{hello: 1}, refs) // the entry keeps the reference of its file
+		})
+	}
+}
+
+// TestPurgeExpired tests that PurgeExpired removes the entries older than the retention period with their content,
+// and that a trash without a retention period keeps everything
+func TestPurgeExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name              string
+		retention         time.Duration
+		purgedMeanwhile   bool // whether the expired entry is removed by someone else before it is purged
+		expectedPurged    int
+		expectedRemaining int
+		expectedRefs      int
+	}{
+		{name: "Retention", retention: time.Hour, expectedPurged: 1, expectedRemaining: 1},
+		{name: "NoRetention", expectedRemaining: 2, expectedRefs: 1},
+		{name: "PurgedMeanwhile", retention: time.Hour, purgedMeanwhile: true, expectedPurged: 1, expectedRemaining: 1, expectedRefs: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := map[models.ID]models.TrashEntry{
+				1: {ID: 1, UserID: 1, DeletedAt: now.Add(-2 * time.Hour), Files: []models.File{trashFile}},
+				2: {ID: 2, UserID: 1, DeletedAt: now.Add(-time.Minute)},
+			}
+			data := map[string][]byte{hashOf("hello"): []byte("hello")}
+			refs := map[string]int{hashOf("hello"): 1}
+			trashRepo := &MockTrashRepository{
+				ListExpiredTrashFunc: func(deletedBefore time.Time) ([]models.TrashEntry, error) {
+					var expired []models.TrashEntry
+					for _, entry := range entries {
+						if entry.DeletedAt.Before(deletedBefore) {
+							expired = append(expired, entry)
+						}
+					}
+					return expired, nil
+				},
+				DeleteTrashFunc: func(_, id models.ID) error {
+					if _, ok := entries[id]; !ok || tt.purgedMeanwhile {
+						delete(entries, id)
+						return customErrors.ErrTrashNotFound(id.String())
+					}
+					delete(entries, id)
+					return nil
+				},
+			}
+			trashService := service.NewTrashService(trashRepo, &MockFolderRepository{}, &MockFileRepository{}, &MockUserRepository{}, newBlobs(data, refs), &MockVersionRepository{}, models.DefaultNamePolicy(), tt.retention, &sync.Mutex{})
+
+			purged, err := trashService.PurgeExpired(now)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedPurged, purged)
+			assert.Len(t, entries, tt.expectedRemaining)
+			assert.Len(t, refs, tt.expectedRefs) // the blob of the file in the expired entry is released only once
+		})
+	}
+}
+
+// getTrashFolder returns the folders of the tree of alice used by the trash tests: /shared, which the group dev may
+// change, and the root folder
+func getTrashFolder(username, folderPath string) (models.Folder, error) {
+	if username != "alice" || folderPath != "/shared" {
+		return models.Folder{}, customErrors.ErrFolderNotFound(folderPath)
+	}
+	return models.Folder{ID: 2, UserID: 1, Username: "alice", ParentPath: "/", Name: "shared", Permissions: models.Permissions{OwnerID: 1, Group: "dev", Mode: 0o770}}, nil
+}
@@ -1,6 +1,9 @@
 package service
 
 import (
+	"sync"
+	"time"
+
 	"github.com/terenzio/vfs/domain/errors"
 	"github.com/terenzio/vfs/domain/models"
 )
@@ -12,18 +15,31 @@ import (
 
 // UserService handles the service logic for users
 type UserService struct {
-	repo models.UserRepository
+	repo        models.UserRepository
+	blobRepo    models.BlobRepository
+	trashRepo   models.TrashRepository
+	versionRepo models.VersionRepository
+	validator   models.Validator
+	groupMu     sync.Mutex  // serializes changing the members of groups, so two users never start the same group at once
+	trashMu     *sync.Mutex // serializes deleting users with restoring and purging trash entries, shared with TrashService
 }
 
-// NewUserService creates a new instance of UserService
-func NewUserService(repo models.UserRepository) *UserService {
-	return &UserService{repo: repo}
+// NewUserService creates a new instance of UserService that checks new usernames with the naming policy.
+// The blobs of the files of deleted users are released in blobRepo, and their trash and the history of their files
+// are removed from trashRepo and versionRepo, holding trashMu, the lock given to the TrashService of the same store.
+func NewUserService(repo models.UserRepository, blobRepo models.BlobRepository, trashRepo models.TrashRepository, versionRepo models.VersionRepository, names models.NamePolicy, trashMu *sync.Mutex) *UserService {
+	return &UserService{repo: repo, blobRepo: blobRepo, trashRepo: trashRepo, versionRepo: versionRepo, validator: models.NewValidator(names), trashMu: trashMu}
 }
 
-// Register registers a new user with the given username
-// It returns an error if the username is invalid, already exists, or if the registration fails
-func (s *UserService) Register(username string) error {
-	if err := s.repo.ValidateUsername(username); err != nil {
+// Register registers a new user with the given username and password. Only a salted, slow hash of the password is
+// stored.
+// It returns an error if the username or password is invalid, the username already exists, or if the registration fails
+func (s *UserService) Register(username, password string) error {
+	username, err := s.validator.NewUsername(username)
+	if err != nil {
+		return err
+	}
+	if err := s.validator.Password(password); err != nil {
 		return err
 	}
 
@@ -35,6 +51,294 @@ func (s *UserService) Register(username string) error {
 		return errors.ErrUserExists(username)
 	}
 
-	user := models.User{Username: username}
+	passwordHash, err := models.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user := models.User{Username: username, PasswordHash: passwordHash, CreatedAt: time.Now()}
 	return s.repo.Register(user)
 }
+
+// Login checks the password of a user and returns the user, without its password hash.
+// A user registered before passwords existed has no password and can't log in until the owner of the data directory
+// sets one with ResetPassword.
+// An unknown username and a wrong password return the same error after the same time, so logins don't reveal which
+// users exist.
+func (s *UserService) Login(username, password string) (models.User, error) {
+	// Unknown and invalid usernames are checked as a user without a password, which takes as long as any other user
+	var user models.User
+	if username, err := s.validator.Username(username); err == nil {
+		user, err = s.repo.GetUser(username)
+		if errors.CodeOf(err) == errors.CodeUserNotFound {
+			user = models.User{}
+		} else if err != nil {
+			return models.User{}, err
+		}
+	}
+
+	if !checkPassword(user, password) {
+		return models.User{}, errors.ErrInvalidCredentials()
+	}
+	user.PasswordHash = ""
+	return user, nil
+}
+
+// ResetPassword replaces the password of a user without checking its current password. It is meant for the owner of
+// the data directory, to give a password to a user who has none or has forgotten it, and must not be reachable from
+// a session.
+func (s *UserService) ResetPassword(username, password string) error {
+	if err := s.validator.Password(password); err != nil {
+		return err
+	}
+	user, err := s.getUser(username)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash, err = models.HashPassword(password); err != nil {
+		return err
+	}
+	return s.repo.UpdateUser(user)
+}
+
+// ChangePassword replaces the password of a user after checking its current password
+func (s *UserService) ChangePassword(username, oldPassword, newPassword string) error {
+	if err := s.validator.Password(newPassword); err != nil {
+		return err
+	}
+	username, err := s.validator.Username(username)
+	if err != nil {
+		return err
+	}
+	user, err := s.repo.GetUser(username)
+	if err != nil {
+		return err
+	}
+	if !checkPassword(user, oldPassword) {
+		return errors.ErrInvalidCredentials()
+	}
+
+	if user.PasswordHash, err = models.HashPassword(newPassword); err != nil {
+		return err
+	}
+	return s.repo.UpdateUser(user)
+}
+
+// dummyPasswordHash is checked in place of the hash of a user that has none, so that the check takes as long as for a
+// user with a password. Its result is ignored, so which password it is the hash of doesn't matter.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := models.HashPassword("no password")
+	return hash
+})
+
+// checkPassword reports whether the password is the password of the user. A user without a password, such as the
+// zero User, matches no password, not even the empty one, but is checked as slowly as any other.
+func checkPassword(user models.User, password string) bool {
+	if user.PasswordHash == "" {
+		models.VerifyPassword(dummyPasswordHash(), password)
+		return false
+	}
+	return models.VerifyPassword(user.PasswordHash, password)
+}
+
+// GetUser returns the user registered under the username, with its profile but without its password hash
+func (s *UserService) GetUser(username string) (models.User, error) {
+	user, err := s.getUser(username)
+	user.PasswordHash = ""
+	return user, err
+}
+
+// getUser returns the user registered under the username, including its password hash
+func (s *UserService) getUser(username string) (models.User, error) {
+	username, err := s.validator.Username(username)
+	if err != nil {
+		return models.User{}, err
+	}
+	return s.repo.GetUser(username)
+}
+
+// ListUsers returns all the registered users without their password hashes, sorted based on the specified field and
+// order
+func (s *UserService) ListUsers(sortField, sortOrder string) ([]models.User, error) {
+	users, err := s.repo.ListUsers(sortField, sortOrder)
+	for i := range users {
+		users[i].PasswordHash = ""
+	}
+	return users, err
+}
+
+// SetDisplayName replaces the display name of the user. An empty display name clears it.
+func (s *UserService) SetDisplayName(username, displayName string) error {
+	displayName, err := s.validator.DisplayName(displayName)
+	if err != nil {
+		return err
+	}
+	user, err := s.getUser(username)
+	if err != nil {
+		return err
+	}
+
+	user.DisplayName = displayName
+	return s.repo.UpdateUser(user)
+}
+
+// SetEmail replaces the email address of the user. An empty address clears it.
+func (s *UserService) SetEmail(username, email string) error {
+	email, err := s.validator.Email(email)
+	if err != nil {
+		return err
+	}
+	user, err := s.getUser(username)
+	if err != nil {
+		return err
+	}
+
+	user.Email = email
+	return s.repo.UpdateUser(user)
+}
+
+// RenameUser changes the username of a user and returns the new username as it is stored, normalized by the naming
+// policy. The folders and files of the user move along with it.
+// It returns an error if the new username is invalid or already taken by another user.
+func (s *UserService) RenameUser(username, newUsername string) (string, error) {
+
+	// Check if the user exists
+	username, err := checkUserExists(s.repo, s.validator, username)
+	if err != nil {
+		return "", err
+	}
+
+	// Check if the new username is valid
+	if newUsername, err = s.validator.NewUsername(newUsername); err != nil {
+		return "", err
+	}
+
+	// Rename the user
+	if err := s.repo.RenameUser(username, newUsername); err != nil {
+		return "", err
+	}
+	return newUsername, nil
+}
+
+// DeleteUser deletes a user together with all its folders and files, the content and history of the files and its
+// trash
+func (s *UserService) DeleteUser(username string) error {
+
+	// Check if the user exists
+	user, err := s.getUser(username)
+	if err != nil {
+		return err
+	}
+
+	// Empty the trash first, as the repository may remove the entries along with the user without releasing their
+	// blobs
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+	trash, err := s.trashRepo.ListTrash(user.ID)
+	if err != nil {
+		return err
+	}
+	for _, entry := range trash {
+		if err := purgeTrash(s.trashRepo, s.blobRepo, entry); err != nil {
+			return err
+		}
+	}
+
+	// Delete the user with its folders and files, then the content and history of the files
+	deleted, err := s.repo.DeleteUser(user.Username)
+	if err != nil {
+		return err
+	}
+	for _, file := range deleted {
+		if err := s.blobRepo.ReleaseBlob(file.ContentHash); err != nil {
+			return err
+		}
+	}
+	return discardVersions(s.versionRepo, s.blobRepo, deleted)
+}
+
+// AddToGroup adds the user named member to the group, on behalf of the acting user userName. Only the members and the
+// owner of a group may add users to it. Anyone may start a new group, which nobody owns or is a member of yet, and
+// becomes its owner, so nobody else can claim the group once all its members are removed.
+// Adding a member again changes nothing.
+func (s *UserService) AddToGroup(userName, group, member string) error {
+	group, err := s.validator.NewGroupName(group)
+	if err != nil {
+		return err
+	}
+	s.groupMu.Lock()
+	defer s.groupMu.Unlock()
+	actor, err := s.getUser(userName)
+	if err != nil {
+		return err
+	}
+	user, err := s.getUser(member)
+	if err != nil {
+		return err
+	}
+
+	// Check if the acting user may add members to the group, or else start it
+	if !actor.InGroup(group) && !actor.OwnsGroup(group) {
+		users, err := s.repo.ListUsers("", "")
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if u.InGroup(group) || u.OwnsGroup(group) {
+				return errors.ErrPermissionDenied(errors.KindGroup, group, "add members to")
+			}
+		}
+		actor.OwnedGroups = append(actor.OwnedGroups, group)
+		if err := s.repo.UpdateUser(actor); err != nil {
+			return err
+		}
+		if user.ID == actor.ID {
+			user.OwnedGroups = actor.OwnedGroups
+		}
+	}
+
+	// Add the member
+	if user.InGroup(group) {
+		return nil
+	}
+	user.Groups = append(user.Groups, group)
+	return s.repo.UpdateUser(user)
+}
+
+// RemoveFromGroup removes the user named member from the group, on behalf of the acting user userName. Only the
+// members and the owner of a group may remove users from it. Removing a user who is no member changes nothing.
+// The owner keeps the group even if no members are left.
+func (s *UserService) RemoveFromGroup(userName, group, member string) error {
+	group, err := s.validator.GroupName(group)
+	if err != nil {
+		return err
+	}
+	s.groupMu.Lock()
+	defer s.groupMu.Unlock()
+	actor, err := s.getUser(userName)
+	if err != nil {
+		return err
+	}
+	user, err := s.getUser(member)
+	if err != nil {
+		return err
+	}
+
+	// Check if the acting user may remove members from the group
+	if !actor.InGroup(group) && !actor.OwnsGroup(group) {
+		return errors.ErrPermissionDenied(errors.KindGroup, group, "remove members from")
+	}
+
+	// Remove the member
+	groups := user.Groups[:0:0]
+	for _, g := range user.Groups {
+		if g != group {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) == len(user.Groups) {
+		return nil
+	}
+	user.Groups = groups
+	return s.repo.UpdateUser(user)
+}
@@ -1,6 +1,9 @@
 package service_test
 
 import (
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,9 +14,13 @@ import (
 
 // MockUserRepository provides a mock implementation of the models.UserRepository interface
 type MockUserRepository struct {
-	ExistsFunc           func(string) (bool, error)
-	RegisterFunc         func(models.User) error
-	ValidateUsernameFunc func(string) error
+	ExistsFunc     func(string) (bool, error)
+	RegisterFunc   func(models.User) error
+	GetUserFunc    func(string) (models.User, error)
+	ListUsersFunc  func(string, string) ([]models.User, error)
+	UpdateUserFunc func(models.User) error
+	RenameUserFunc func(string, string) error
+	DeleteUserFunc func(string) ([]models.File, error)
 }
 
 func (m *MockUserRepository) Exists(username string) (bool, error) {
@@ -24,10 +31,51 @@ func (m *MockUserRepository) Register(user models.User) error {
 	return m.RegisterFunc(user)
 }
 
-func (m *MockUserRepository) ValidateUsername(username string) error {
-	return m.ValidateUsernameFunc(username)
+// GetUser returns the user from GetUserFunc, or else a user with ID 1 unless ExistsFunc reports that it doesn't exist
+func (m *MockUserRepository) GetUser(username string) (models.User, error) {
+	if m.GetUserFunc == nil {
+		exists, err := true, error(nil)
+		if m.ExistsFunc != nil {
+			exists, err = m.ExistsFunc(username)
+		}
+		if err != nil || !exists {
+			return models.User{}, orError(err, customErrors.ErrUserNotExists(username))
+		}
+		return models.User{ID: 1, Username: username}, nil
+	}
+	return m.GetUserFunc(username)
+}
+
+// orError returns err, or else fallback if err is nil
+func orError(err, fallback error) error {
+	if err != nil {
+		return err
+	}
+	return fallback
+}
+
+func (m *MockUserRepository) ListUsers(sortField, sortOrder string) ([]models.User, error) {
+	return m.ListUsersFunc(sortField, sortOrder)
+}
+
+func (m *MockUserRepository) UpdateUser(user models.User) error {
+	return m.UpdateUserFunc(user)
+}
+
+func (m *MockUserRepository) RenameUser(username, newUsername string) error {
+	return m.RenameUserFunc(username, newUsername)
 }
 
+func (m *MockUserRepository) DeleteUser(username string) ([]models.File, error) {
+	return m.DeleteUserFunc(username)
+}
+
+// allowedChars describes the characters the default naming policy allows
+const allowedChars = "letters, digits, combining marks and ._-"
+
+// testPassword is a valid password used by the tests that don't test passwords
+const testPassword = "correct-horse"
+
 // TestRegister tests the Register method of UserService using table-driven tests
 func TestRegister(t *testing.T) {
 	tests := []struct {
@@ -37,18 +85,27 @@ func TestRegister(t *testing.T) {
 		expectedError error
 	}{
 		{
-			name:     "ErrorInvalidUsername",
-			username: "invalid!!user",
-			setupMock: func(repo *MockUserRepository) {
-				repo.ValidateUsernameFunc = func(string) error { return customErrors.ErrInvalidName("invalid!!user") }
-			},
-			expectedError: customErrors.ErrInvalidName("invalid!!user"),
+			name:          "ErrorInvalidUsername",
+			username:      "invalid!!user",
+			setupMock:     func(*MockUserRepository) {},
+			expectedError: customErrors.ErrInvalidName("invalid!!user", allowedChars),
+		},
+		{
+			name:          "ErrorInvalidChar",
+			username:      "two words",
+			setupMock:     func(*MockUserRepository) {},
+			expectedError: customErrors.ErrInvalidName("two words", ""),
+		},
+		{
+			name:          "ErrorNameTooLong",
+			username:      strings.Repeat("é", 31),
+			setupMock:     func(*MockUserRepository) {},
+			expectedError: customErrors.ErrNameTooLong(strings.Repeat("é", 31), 30),
 		},
 		{
 			name:     "ErrorUserExists",
 			username: "existingUser",
 			setupMock: func(repo *MockUserRepository) {
-				repo.ValidateUsernameFunc = func(string) error { return nil }
 				repo.ExistsFunc = func(string) (bool, error) { return true, nil }
 			},
 			expectedError: customErrors.ErrUserExists("existingUser"),
@@ -57,26 +114,687 @@ func TestRegister(t *testing.T) {
 			name:     "Success",
 			username: "newUser",
 			setupMock: func(repo *MockUserRepository) {
-				repo.ValidateUsernameFunc = func(string) error { return nil }
+				repo.ExistsFunc = func(string) (bool, error) { return false, nil }
+				repo.RegisterFunc = func(user models.User) error {
+					if user.CreatedAt.IsZero() {
+						return fmt.Errorf("the user has no creation time")
+					}
+					return nil
+				}
+			},
+			expectedError: nil,
+		},
+		{
+			name:     "SuccessUnicodeAndSymbols",
+			username: "Jörg_Müller-2.0",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return false, nil }
+				repo.RegisterFunc = func(models.User) error { return nil }
+			},
+			expectedError: nil,
+		},
+		{
+			name:     "SuccessLengthCountedInRunes",
+			username: strings.Repeat("é", 30),
+			setupMock: func(repo *MockUserRepository) {
 				repo.ExistsFunc = func(string) (bool, error) { return false, nil }
 				repo.RegisterFunc = func(models.User) error { return nil }
 			},
 			expectedError: nil,
 		},
+		{
+			name:     "SuccessNormalizedToNFC",
+			username: "Jose\u0301",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return false, nil }
+				repo.RegisterFunc = func(user models.User) error {
+					if user.Username != "Jos\u00e9" {
+						return fmt.Errorf("the username %q isn't normalized", user.Username)
+					}
+					return nil
+				}
+			},
+			expectedError: nil,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mockRepo := &MockUserRepository{}
 			tt.setupMock(mockRepo)
-			userService := service.NewUserService(mockRepo)
+			userService := service.NewUserService(mockRepo, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), &sync.Mutex{})
+
+			err := userService.Register(tt.username, testPassword)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// TestRegisterPassword tests that Register checks the password and stores only its salted hash
+func TestRegisterPassword(t *testing.T) {
+	tests := []struct {
+		name          string
+		password      string
+		expectedError error
+	}{
+		{
+			name:          "ErrorPasswordTooShort",
+			password:      "short",
+			expectedError: customErrors.ErrInvalidPassword(models.MinPasswordLength, models.MaxPasswordLength),
+		},
+		{
+			name:          "ErrorPasswordTooLong",
+			password:      strings.Repeat("p", models.MaxPasswordLength+1),
+			expectedError: customErrors.ErrInvalidPassword(models.MinPasswordLength, models.MaxPasswordLength),
+		},
+		{
+			name:          "ErrorPasswordControlChar",
+			password:      "pass\tword",
+			expectedError: customErrors.ErrInvalidPassword(models.MinPasswordLength, models.MaxPasswordLength),
+		},
+		{
+			name:     "SuccessWithSpacesAndUnicode",
+			password: " pässwörd with spaces ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var registered []models.User
+			mockRepo := &MockUserRepository{
+				ExistsFunc: func(string) (bool, error) { return false, nil },
+				RegisterFunc: func(user models.User) error {
+					registered = append(registered, user)
+					return nil
+				},
+			}
+			userService := service.NewUserService(mockRepo, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), &sync.Mutex{})
+
+			err := userService.Register("user1", tt.password)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				assert.Empty(t, registered)
+				return
+			}
+			assert.NoError(t, err)
+
+			// The same password registered twice is hashed with different salts
+			assert.NoError(t, userService.Register("user2", tt.password))
+			if assert.Len(t, registered, 2) {
+				assert.NotContains(t, registered[0].PasswordHash, tt.password)
+				assert.NotEqual(t, registered[0].PasswordHash, registered[1].PasswordHash)
+				assert.True(t, models.VerifyPassword(registered[0].PasswordHash, tt.password))
+				assert.False(t, models.VerifyPassword(registered[0].PasswordHash, strings.TrimSpace(tt.password)))
+			}
+		})
+	}
+}
+
+// TestLogin tests the Login method of UserService using table-driven tests
+func TestLogin(t *testing.T) {
+	passwordHash, err := models.HashPassword(testPassword)
+	assert.NoError(t, err)
+	users := map[string]models.User{
+		"user1":  {ID: 1, Username: "user1", PasswordHash: passwordHash},
+		"legacy": {ID: 2, Username: "legacy"},
+	}
+
+	tests := []struct {
+		name          string
+		username      string
+		password      string
+		expectedError error
+	}{
+		{
+			name:     "Success",
+			username: "user1",
+			password: testPassword,
+		},
+		{
+			name:          "ErrorWrongPassword",
+			username:      "user1",
+			password:      "wrong-password",
+			expectedError: customErrors.ErrInvalidCredentials(),
+		},
+		{
+			name:          "ErrorUnknownUser",
+			username:      "ghost",
+			password:      testPassword,
+			expectedError: customErrors.ErrInvalidCredentials(),
+		},
+		{
+			name:          "ErrorInvalidUsername",
+			username:      "two words",
+			password:      testPassword,
+			expectedError: customErrors.ErrInvalidCredentials(),
+		},
+		{
+			name:          "ErrorUserWithoutPasswordGivenNone",
+			username:      "legacy",
+			password:      "",
+			expectedError: customErrors.ErrInvalidCredentials(),
+		},
+		{
+			name:          "ErrorUserWithoutPasswordGivenOne",
+			username:      "legacy",
+			password:      testPassword,
+			expectedError: customErrors.ErrInvalidCredentials(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockUserRepository{
+				GetUserFunc: func(username string) (models.User, error) {
+					if user, ok := users[username]; ok {
+						return user, nil
+					}
+					return models.User{}, customErrors.ErrUserNotExists(username)
+				},
+			}
+			userService := service.NewUserService(mockRepo, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), &sync.Mutex{})
+
+			user, err := userService.Login(tt.username, tt.password)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				assert.ErrorIs(t, err, customErrors.ErrUnauthenticated)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.username, user.Username)
+			assert.Empty(t, user.PasswordHash)
+		})
+	}
+}
+
+// TestResetPassword tests that ResetPassword sets the password of a user without checking the current one, and that
+// the user is logged in by it from then on
+func TestResetPassword(t *testing.T) {
+	passwordHash, err := models.HashPassword(testPassword)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		username      string
+		password      string
+		expectedError error
+	}{
+		{
+			name:     "UserWithoutPassword",
+			username: "legacy",
+			password: "new-password",
+		},
+		{
+			name:     "UserWithPassword",
+			username: "user1",
+			password: "new-password",
+		},
+		{
+			name:          "ErrorUnknownUser",
+			username:      "ghost",
+			password:      "new-password",
+			expectedError: customErrors.ErrUserNotExists("ghost"),
+		},
+		{
+			name:          "ErrorInvalidPassword",
+			username:      "legacy",
+			password:      "short",
+			expectedError: customErrors.ErrInvalidPassword(models.MinPasswordLength, models.MaxPasswordLength),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := map[string]models.User{
+				"user1":  {ID: 1, Username: "user1", PasswordHash: passwordHash},
+				"legacy": {ID: 2, Username: "legacy"},
+			}
+			mockRepo := &MockUserRepository{
+				GetUserFunc: func(username string) (models.User, error) {
+					if user, ok := users[username]; ok {
+						return user, nil
+					}
+					return models.User{}, customErrors.ErrUserNotExists(username)
+				},
+				UpdateUserFunc: func(user models.User) error {
+					users[user.Username] = user
+					return nil
+				},
+			}
+			userService := service.NewUserService(mockRepo, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), &sync.Mutex{})
+
+			err := userService.ResetPassword(tt.username, tt.password)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				assert.Empty(t, users["legacy"].PasswordHash)
+				return
+			}
+			assert.NoError(t, err)
+			_, err = userService.Login(tt.username, tt.password)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// TestChangePassword tests the ChangePassword method of UserService using table-driven tests
+func TestChangePassword(t *testing.T) {
+	passwordHash, err := models.HashPassword(testPassword)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		stored        models.User
+		oldPassword   string
+		newPassword   string
+		expectedError error
+	}{
+		{
+			name:        "Success",
+			stored:      models.User{ID: 1, Username: "user1", Email: "one@example.com", PasswordHash: passwordHash},
+			oldPassword: testPassword,
+			newPassword: "battery-staple",
+		},
+		{
+			name:          "ErrorWrongOldPassword",
+			stored:        models.User{ID: 1, Username: "user1", PasswordHash: passwordHash},
+			oldPassword:   "wrong-password",
+			newPassword:   "battery-staple",
+			expectedError: customErrors.ErrInvalidCredentials(),
+		},
+		{
+			name:          "ErrorInvalidNewPassword",
+			stored:        models.User{ID: 1, Username: "user1", PasswordHash: passwordHash},
+			oldPassword:   testPassword,
+			newPassword:   "short",
+			expectedError: customErrors.ErrInvalidPassword(models.MinPasswordLength, models.MaxPasswordLength),
+		},
+		{
+			name:          "ErrorUserWithoutPassword",
+			stored:        models.User{ID: 2, Username: "legacy"},
+			oldPassword:   "",
+			newPassword:   "battery-staple",
+			expectedError: customErrors.ErrInvalidCredentials(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updated []models.User
+			mockRepo := &MockUserRepository{
+				GetUserFunc: func(string) (models.User, error) { return tt.stored, nil },
+				UpdateUserFunc: func(user models.User) error {
+					updated = append(updated, user)
+					return nil
+				},
+			}
+			userService := service.NewUserService(mockRepo, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), &sync.Mutex{})
+
+			err := userService.ChangePassword(tt.stored.Username, tt.oldPassword, tt.newPassword)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				assert.Empty(t, updated)
+				return
+			}
+			assert.NoError(t, err)
+			if assert.Len(t, updated, 1) {
+				assert.Equal(t, tt.stored.Email, updated[0].Email)
+				assert.True(t, models.VerifyPassword(updated[0].PasswordHash, tt.newPassword))
+			}
+		})
+	}
+}
+
+// TestRenameUser tests the RenameUser method of UserService using table-driven tests
+func TestRenameUser(t *testing.T) {
+	tests := []struct {
+		name          string
+		username      string
+		newUsername   string
+		setupMock     func(repo *MockUserRepository)
+		expectedName  string
+		expectedError error
+	}{
+		{
+			name:        "ErrorUserNotExists",
+			username:    "ghost",
+			newUsername: "user2",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return false, nil }
+			},
+			expectedError: customErrors.ErrUserNotExists("ghost"),
+		},
+		{
+			name:        "ErrorInvalidNewUsername",
+			username:    "user1",
+			newUsername: "invalid!!user",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return true, nil }
+			},
+			expectedError: customErrors.ErrInvalidName("invalid!!user", allowedChars),
+		},
+		{
+			name:        "ErrorNewUsernameTaken",
+			username:    "user1",
+			newUsername: "user2",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return true, nil }
+				repo.RenameUserFunc = func(string, string) error { return customErrors.ErrUserExists("user2") }
+			},
+			expectedError: customErrors.ErrUserExists("user2"),
+		},
+		{
+			name:        "SuccessNormalizedToNFC",
+			username:    "user1",
+			newUsername: "Jose\u0301",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return true, nil }
+				repo.RenameUserFunc = func(username, newUsername string) error {
+					if newUsername != "Jos\u00e9" {
+						return fmt.Errorf("the username %q isn't normalized", newUsername)
+					}
+					return nil
+				}
+			},
+			expectedName:  "Jos\u00e9",
+			expectedError: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockUserRepository{}
+			tt.setupMock(mockRepo)
+			userService := service.NewUserService(mockRepo, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), &sync.Mutex{})
+
+			newUsername, err := userService.RenameUser(tt.username, tt.newUsername)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedName, newUsername)
+		})
+	}
+}
+
+// TestDeleteUser tests that deleting a user also deletes the content of its files
+func TestDeleteUser(t *testing.T) {
+	tests := []struct {
+		name             string
+		username         string
+		setupMock        func(repo *MockUserRepository)
+		trash            []models.TrashEntry
+		trashPurged      bool // whether the purger removed the trash in the meantime
+		expectedError    error
+		expectedReleases []string
+	}{
+		{
+			name:     "ErrorUserNotExists",
+			username: "ghost",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return false, nil }
+			},
+			expectedError: customErrors.ErrUserNotExists("ghost"),
+		},
+		{
+			name:     "SuccessWithoutFiles",
+			username: "user1",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return true, nil }
+				repo.DeleteUserFunc = func(string) ([]models.File, error) { return nil, nil }
+			},
+		},
+		{
+			name:     "SuccessDeletesContents",
+			username: "user1",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return true, nil }
+				repo.DeleteUserFunc = func(string) ([]models.File, error) {
+					return []models.File{{ID: 3, Name: "a.txt", ContentHash: "a"}, {ID: 7, Name: "b.txt", ContentHash: "b"}}, nil
+				}
+			},
+			expectedReleases: []string{"a", "b"},
+		},
+		{
+			name:     "SuccessEmptiesTrash",
+			username: "user1",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return true, nil }
+				repo.DeleteUserFunc = func(string) ([]models.File, error) { return nil, nil }
+			},
+			trash:            []models.TrashEntry{{ID: 2, UserID: 1, Files: []models.File{{ID: 4, Name: "c.txt", ContentHash: "c"}}}},
+			expectedReleases: []string{"c"},
+		},
+		{
+			name:     "SuccessTrashPurgedMeanwhile",
+			username: "user1",
+			setupMock: func(repo *MockUserRepository) {
+				repo.ExistsFunc = func(string) (bool, error) { return true, nil }
+				repo.DeleteUserFunc = func(string) ([]models.File, error) { return nil, nil }
+			},
+			trash:       []models.TrashEntry{{ID: 2, UserID: 1, Files: []models.File{{ID: 4, Name: "c.txt", ContentHash: "c"}}}},
+			trashPurged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := &MockUserRepository{}
+			tt.setupMock(mockRepo)
+			var releases []string
+			blobRepo := &MockBlobRepository{
+				ReleaseBlobFunc: func(hash string) error {
+					releases = append(releases, hash)
+					return nil
+				},
+			}
+			trashRepo := &MockTrashRepository{
+				ListTrashFunc: func(models.ID) ([]models.TrashEntry, error) { return tt.trash, nil },
+				DeleteTrashFunc: func(_, id models.ID) error {
+					if tt.trashPurged {
+						return customErrors.ErrTrashNotFound(id.String())
+					}
+					return nil
+				},
+			}
+			userService := service.NewUserService(mockRepo, blobRepo, trashRepo, &MockVersionRepository{}, models.DefaultNamePolicy(), &sync.Mutex{})
+
+			err := userService.DeleteUser(tt.username)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedReleases, releases)
+		})
+	}
+}
+
+// TestUpdateProfile tests the SetDisplayName and SetEmail methods of UserService using table-driven tests
+func TestUpdateProfile(t *testing.T) {
+	tests := []struct {
+		name          string
+		update        func(s *service.UserService) error
+		expectedError error
+		expectedUser  models.User
+	}{
+		{
+			name:          "ErrorInvalidEmail",
+			update:        func(s *service.UserService) error { return s.SetEmail("user1", "Alice <alice@example.com>") },
+			expectedError: customErrors.ErrInvalidEmail("Alice <alice@example.com>"),
+		},
+		{
+			name:          "ErrorDisplayNameTooLong",
+			update:        func(s *service.UserService) error { return s.SetDisplayName("user1", strings.Repeat("a", 65)) },
+			expectedError: customErrors.ErrInvalidDisplayName(strings.Repeat("a", 65), models.MaxDisplayNameLength),
+		},
+		{
+			name:          "ErrorDisplayNameControlChar",
+			update:        func(s *service.UserService) error { return s.SetDisplayName("user1", "Alice\tSmith") },
+			expectedError: customErrors.ErrInvalidDisplayName("Alice\tSmith", models.MaxDisplayNameLength),
+		},
+		{
+			name:          "ErrorUserNotExists",
+			update:        func(s *service.UserService) error { return s.SetEmail("ghost", "ghost@example.com") },
+			expectedError: customErrors.ErrUserNotExists("ghost"),
+		},
+		{
+			name:         "SuccessDisplayNameKeepsEmail",
+			update:       func(s *service.UserService) error { return s.SetDisplayName("user1", "  Alice Smith ") },
+			expectedUser: models.User{ID: 1, Username: "user1", DisplayName: "Alice Smith", Email: "old@example.com", PasswordHash: "hash"},
+		},
+		{
+			name:         "SuccessEmailKeepsDisplayName",
+			update:       func(s *service.UserService) error { return s.SetEmail("user1", "alice@example.com") },
+			expectedUser: models.User{ID: 1, Username: "user1", DisplayName: "Old Name", Email: "alice@example.com", PasswordHash: "hash"},
+		},
+		{
+			name:         "SuccessClearEmail",
+			update:       func(s *service.UserService) error { return s.SetEmail("user1", "") },
+			expectedUser: models.User{ID: 1, Username: "user1", DisplayName: "Old Name", PasswordHash: "hash"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updated models.User
+			mockRepo := &MockUserRepository{
+				GetUserFunc: func(username string) (models.User, error) {
+					if username != "user1" {
+						return models.User{}, customErrors.ErrUserNotExists(username)
+					}
+					return models.User{ID: 1, Username: "user1", DisplayName: "Old Name", Email: "old@example.com", PasswordHash: "hash"}, nil
+				},
+				UpdateUserFunc: func(user models.User) error {
+					updated = user
+					return nil
+				},
+			}
+			userService := service.NewUserService(mockRepo, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), &sync.Mutex{})
+
+			err := tt.update(userService)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedUser, updated)
+		})
+	}
+}
+
+// TestGroups tests the AddToGroup and RemoveFromGroup methods of UserService using table-driven tests
+func TestGroups(t *testing.T) {
+	tests := []struct {
+		name           string
+		change         func(s *service.UserService) error
+		expectedError  error
+		expectedGroups []string // the groups of the updated user, or nil if no user was updated
+		expectedOwned  []string // the groups carol owns afterwards
+	}{
+		{
+			name:           "StartNewGroup",
+			change:         func(s *service.UserService) error { return s.AddToGroup("carol", "sec", "carol") },
+			expectedGroups: []string{"sec"},
+			expectedOwned:  []string{"sec"},
+		},
+		{
+			name:           "StartNewGroupForOthers",
+			change:         func(s *service.UserService) error { return s.AddToGroup("carol", "sec", "bob") },
+			expectedGroups: []string{"dev", "qa", "sec"},
+			expectedOwned:  []string{"sec"},
+		},
+		{
+			name:           "OwnerAddsUserToEmptyGroup",
+			change:         func(s *service.UserService) error { return s.AddToGroup("dave", "ops", "carol") },
+			expectedGroups: []string{"ops"},
+		},
+		{
+			name:          "NonOwnerCannotClaimEmptyGroup",
+			change:        func(s *service.UserService) error { return s.AddToGroup("carol", "ops", "carol") },
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindGroup, "ops", "add members to"),
+		},
+		{
+			name:           "MemberAddsUser",
+			change:         func(s *service.UserService) error { return s.AddToGroup("alice", "dev", "carol") },
+			expectedGroups: []string{"dev"},
+		},
+		{
+			name:          "NonMemberCannotJoinGroup",
+			change:        func(s *service.UserService) error { return s.AddToGroup("carol", "dev", "carol") },
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindGroup, "dev", "add members to"),
+		},
+		{
+			name:   "AddMemberAgain",
+			change: func(s *service.UserService) error { return s.AddToGroup("alice", "dev", "bob") },
+		},
+		{
+			name:          "InvalidGroupName",
+			change:        func(s *service.UserService) error { return s.AddToGroup("alice", "dev ops", "bob") },
+			expectedError: customErrors.ErrInvalidName("dev ops", ""),
+		},
+		{
+			name:          "AddUnknownUser",
+			change:        func(s *service.UserService) error { return s.AddToGroup("alice", "dev", "ghost") },
+			expectedError: customErrors.ErrUserNotExists("ghost"),
+		},
+		{
+			name:           "MemberRemovesUser",
+			change:         func(s *service.UserService) error { return s.RemoveFromGroup("alice", "dev", "bob") },
+			expectedGroups: []string{"qa"},
+		},
+		{
+			name:           "OwnerRemovesUser",
+			change:         func(s *service.UserService) error { return s.RemoveFromGroup("dave", "qa", "bob") },
+			expectedGroups: []string{"dev"},
+		},
+		{
+			name:          "NonMemberCannotRemoveUser",
+			change:        func(s *service.UserService) error { return s.RemoveFromGroup("carol", "dev", "bob") },
+			expectedError: customErrors.ErrPermissionDenied(customErrors.KindGroup, "dev", "remove members from"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := map[string]models.User{
+				"alice": {ID: 1, Username: "alice", Groups: []string{"dev"}},
+				"bob":   {ID: 2, Username: "bob", Groups: []string{"dev", "qa"}},
+				"carol": {ID: 3, Username: "carol"},
+				"dave":  {ID: 4, Username: "dave", OwnedGroups: []string{"ops", "qa"}},
+			}
+			var updated []string
+			mockRepo := &MockUserRepository{
+				GetUserFunc: func(username string) (models.User, error) {
+					user, ok := users[username]
+					if !ok {
+						return models.User{}, customErrors.ErrUserNotExists(username)
+					}
+					return user, nil
+				},
+				ListUsersFunc: func(string, string) ([]models.User, error) {
+					return []models.User{users["alice"], users["bob"], users["carol"], users["dave"]}, nil
+				},
+				UpdateUserFunc: func(user models.User) error {
+					users[user.Username] = user
+					updated = user.Groups
+					return nil
+				},
+			}
+			userService := service.NewUserService(mockRepo, &MockBlobRepository{}, &MockTrashRepository{}, &MockVersionRepository{}, models.DefaultNamePolicy(), &sync.Mutex{})
 
-			err := userService.Register(tt.username)
+			err := tt.change(userService)
 			if tt.expectedError != nil {
 				assert.EqualError(t, err, tt.expectedError.Error())
 			} else {
 				assert.NoError(t, err)
 			}
+			assert.Equal(t, tt.expectedGroups, updated)
+			assert.Equal(t, tt.expectedOwned, users["carol"].OwnedGroups)
 		})
 	}
 }
@@ -0,0 +1,155 @@
+// service/version.go
+
+package service
+
+import (
+	stderrors "errors"
+	"time"
+
+	"github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+)
+
+// History lists the versions of a file, oldest first. Every change of the content records a version, and the version
+// policy of the service bounds how many are kept.
+func (s *FileService) History(userName, folderPath, fileName string) ([]models.Version, error) {
+	_, file, err := s.openFile(userName, folderPath, fileName, models.Read, "read")
+	if err != nil {
+		return nil, err
+	}
+	return s.versionRepo.ListVersions(file.ID)
+}
+
+// ReadVersion returns the content of a file as it was in the version with the number
+func (s *FileService) ReadVersion(userName, folderPath, fileName string, number int) ([]byte, error) {
+	sc, file, err := s.openFile(userName, folderPath, fileName, models.Read, "read")
+	if err != nil {
+		return nil, err
+	}
+	return s.readVersion(sc, file, number)
+}
+
+// DiffVersions returns the line diff between the versions of a file with the numbers from and to. A to of zero stands
+// for the current content. Versions too large to diff, see models.DiffLines, are refused before they are read.
+func (s *FileService) DiffVersions(userName, folderPath, fileName string, from, to int) ([]models.DiffLine, error) {
+	sc, file, err := s.openFile(userName, folderPath, fileName, models.Read, "read")
+	if err != nil {
+		return nil, err
+	}
+
+	oldVersion, err := s.getVersion(sc, file, from)
+	if err != nil {
+		return nil, err
+	}
+	newVersion := models.Version{Size: file.Size, Hash: file.ContentHash}
+	if to != 0 {
+		if newVersion, err = s.getVersion(sc, file, to); err != nil {
+			return nil, err
+		}
+	}
+	if err := models.CheckDiffSize(oldVersion.Size); err != nil {
+		return nil, err
+	}
+	if err := models.CheckDiffSize(newVersion.Size); err != nil {
+		return nil, err
+	}
+
+	oldData, err := s.blobRepo.GetBlob(oldVersion.Hash)
+	if err != nil {
+		return nil, err
+	}
+	newData, err := s.blobRepo.GetBlob(newVersion.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return models.DiffLines(string(oldData), string(newData))
+}
+
+// RevertFile replaces the content of a file with its content in the version with the number. The history is kept, so
+// reverting records a new version.
+func (s *FileService) RevertFile(userName, folderPath, fileName string, number int) error {
+	sc, file, err := s.openFile(userName, folderPath, fileName, models.Read|models.Write, "revert")
+	if err != nil {
+		return err
+	}
+
+	data, err := s.readVersion(sc, file, number)
+	if err != nil {
+		return err
+	}
+	return s.replaceContent(sc, file, data)
+}
+
+// PruneVersions removes the versions of every file the version policy of the service no longer keeps at the time now,
+// e.g. those older than its retention period, and releases their blobs. It returns how many versions were removed.
+func (s *FileService) PruneVersions(now time.Time) (int, error) {
+	pruned, err := s.versionRepo.PruneVersions(s.versions, now)
+	if err != nil {
+		return 0, err
+	}
+	return len(pruned), releaseVersions(s.blobRepo, pruned)
+}
+
+// readVersion returns the content of the version of the file with the number
+func (s *FileService) readVersion(sc scope, file models.File, number int) ([]byte, error) {
+	version, err := s.getVersion(sc, file, number)
+	if err != nil {
+		return nil, err
+	}
+	return s.blobRepo.GetBlob(version.Hash)
+}
+
+// getVersion returns the version of the file with the number. A missing version is reported with the path of the file
+// as the acting user names it.
+func (s *FileService) getVersion(sc scope, file models.File, number int) (models.Version, error) {
+	version, err := s.versionRepo.GetVersion(file.ID, number)
+	if stderrors.Is(err, errors.ErrNotFound) {
+		return models.Version{}, errors.ErrVersionNotFound(filePath(sc, file), number)
+	}
+	return version, err
+}
+
+// recordVersion adds the current content of the file to its history on behalf of the acting user. The version shares
+// the blob of the content, taking its reference before it is added, and the blobs of the versions the policy no longer
+// keeps are released once they are gone.
+func (s *FileService) recordVersion(sc scope, file models.File) error {
+	if err := s.blobRepo.RetainBlob(file.ContentHash); err != nil {
+		return err
+	}
+	_, pruned, err := s.versionRepo.AddVersion(models.Version{
+		FileID:    file.ID,
+		AuthorID:  sc.actor.ID,
+		CreatedAt: time.Now(),
+		Size:      file.Size,
+		Hash:      file.ContentHash,
+	}, s.versions)
+	if err != nil {
+		return err
+	}
+	return releaseVersions(s.blobRepo, pruned)
+}
+
+// discardVersions removes the history of the files and releases the blobs of their versions. The IDs of deleted files
+// may be reused, so their history must not outlive them.
+func discardVersions(versionRepo models.VersionRepository, blobRepo models.BlobRepository, files []models.File) error {
+	for _, file := range files {
+		versions, err := versionRepo.DeleteVersions(file.ID)
+		if err != nil {
+			return err
+		}
+		if err := releaseVersions(blobRepo, versions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseVersions releases the blobs of the versions. The versions are gone, so a failure doesn't stop the others from
+// being released, and the errors are combined.
+func releaseVersions(blobRepo models.BlobRepository, versions []models.Version) error {
+	var errs []error
+	for _, version := range versions {
+		errs = append(errs, blobRepo.ReleaseBlob(version.Hash))
+	}
+	return stderrors.Join(errs...)
+}
@@ -0,0 +1,419 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	customErrors "github.com/terenzio/vfs/domain/errors"
+	"github.com/terenzio/vfs/domain/models"
+	"github.com/terenzio/vfs/service"
+)
+
+// MockVersionRepository is a mock implementation of the VersionRepository interface
+type MockVersionRepository struct {
+	AddVersionFunc     func(models.Version, models.VersionPolicy) (models.Version, []models.Version, error)
+	PruneVersionsFunc  func(models.VersionPolicy, time.Time) ([]models.Version, error)
+	GetVersionFunc     func(models.ID, int) (models.Version, error)
+	ListVersionsFunc   func(models.ID) ([]models.Version, error)
+	DeleteVersionsFunc func(models.ID) ([]models.Version, error)
+}
+
+// AddVersion returns the version from AddVersionFunc, or else the version with number 1 and nothing pruned
+func (m *MockVersionRepository) AddVersion(version models.Version, policy models.VersionPolicy) (models.Version, []models.Version, error) {
+	if m.AddVersionFunc == nil {
+		version.Number = 1
+		return version, nil, nil
+	}
+	return m.AddVersionFunc(version, policy)
+}
+
+// PruneVersions returns the versions from PruneVersionsFunc, or else no versions
+func (m *MockVersionRepository) PruneVersions(policy models.VersionPolicy, now time.Time) ([]models.Version, error) {
+	if m.PruneVersionsFunc == nil {
+		return nil, nil
+	}
+	return m.PruneVersionsFunc(policy, now)
+}
+
+func (m *MockVersionRepository) GetVersion(fileID models.ID, number int) (models.Version, error) {
+	return m.GetVersionFunc(fileID, number)
+}
+
+// ListVersions returns the versions from ListVersionsFunc, or else no versions
+func (m *MockVersionRepository) ListVersions(fileID models.ID) ([]models.Version, error) {
+	if m.ListVersionsFunc == nil {
+		return nil, nil
+	}
+	return m.ListVersionsFunc(fileID)
+}
+
+// DeleteVersions returns the versions from DeleteVersionsFunc, or else no versions
+func (m *MockVersionRepository) DeleteVersions(fileID models.ID) ([]models.Version, error) {
+	if m.DeleteVersionsFunc == nil {
+		return nil, nil
+	}
+	return m.DeleteVersionsFunc(fileID)
+}
+
+// TestWriteFileRecordsVersion tests that writing a file records the new content as a version by the acting user, and
+// releases the blobs of the previous content and of the versions the policy prunes
+func TestWriteFileRecordsVersion(t *testing.T) {
+	var added models.Version
+	var policy models.VersionPolicy
+	hello, helloWorld := hashOf("hello"), hashOf("hello world")
+	data := map[string][]byte{hello: []byte("hello")}
+	refs := map[string]int{hello: 2} // the file and its version
+	userRepo := &MockUserRepository{GetUserFunc: getPermissionUser}
+	folderRepo := &MockFolderRepository{GetFolderFunc: getTrashFolder}
+	fileRepo := &MockFileRepository{
+		GetFileFunc:    func(string, string, string) (models.File, error) { return trashFile, nil },
+		UpdateFileFunc: func(models.File) error { return nil },
+	}
+	versionRepo := &MockVersionRepository{AddVersionFunc: func(version models.Version, p models.VersionPolicy) (models.Version, []models.Version, error) {
+		version.Number = 2
+		added, policy = version, p
+		return version, []models.Version{{FileID: 9, Number: 1, Hash: hello}}, nil
+	}}
+	keepOne := models.VersionPolicy{KeepLast: 1}
+	fileService := service.NewFileService(fileRepo, folderRepo, userRepo, newBlobs(data, refs), &MockTrashRepository{}, versionRepo, models.DefaultNamePolicy(), keepOne, 0)
+
+	assert.NoError(t, fileService.WriteFile("alice", "/shared", "notes.txt", []byte("hello world")))
+	assert.Equal(t, keepOne, policy)
+	assert.Equal(t, models.ID(9), added.FileID)
+	assert.Equal(t, models.ID(1), added.AuthorID)
+	assert.Equal(t, int64(11), added.Size)
+	assert.Equal(t, helloWorld, added.Hash)
+	assert.False(t, added.CreatedAt.IsZero())
+	assert.Equal(t, map[string][]byte{helloWorld: []byte("hello world")}, data)
+	assert.Equal(t, map[string]int{helloWorld: 2}, refs) // the file and its version share the blob
+}
+
+// TestWriteFileFailureOnlyLeaks tests that a write failing halfway never leaves the file or a version pointing to a
+// released blob, but at worst leaks a reference
+func TestWriteFileFailureOnlyLeaks(t *testing.T) {
+	hello, helloWorld := hashOf("hello"), hashOf("hello world")
+	tests := []struct {
+		name          string
+		updateErr     error
+		addErr        error
+		releaseErr    error
+		expectedFile  string
+		expectedRefs  map[string]int
+		expectedError error
+	}{
+		{
+			name:          "UpdateFileFails",
+			updateErr:     assert.AnError,
+			expectedFile:  hello,
+			expectedRefs:  map[string]int{hello: 2, helloWorld: 1}, // the new content leaks
+			expectedError: assert.AnError,
+		},
+		{
+			name:          "AddVersionFails",
+			addErr:        assert.AnError,
+			expectedFile:  helloWorld,
+			expectedRefs:  map[string]int{hello: 1, helloWorld: 2}, // the reference of the version leaks
+			expectedError: assert.AnError,
+		},
+		{
+			name:          "ReleaseFails",
+			releaseErr:    assert.AnError,
+			expectedFile:  helloWorld,
+			expectedRefs:  map[string]int{hello: 2, helloWorld: 2}, // the old content leaks, but the version is recorded
+			expectedError: assert.AnError,
+		},
+		{
+			name:         "Success",
+			expectedFile: helloWorld,
+			expectedRefs: map[string]int{hello: 1, helloWorld: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := trashFile
+			file.ContentHash = hello
+			data := map[string][]byte{hello: []byte("hello")}
+			refs := map[string]int{hello: 2} // the file and its version
+			userRepo := &MockUserRepository{GetUserFunc: getPermissionUser}
+			folderRepo := &MockFolderRepository{GetFolderFunc: getTrashFolder}
+			fileRepo := &MockFileRepository{
+				GetFileFunc: func(string, string, string) (models.File, error) { return file, nil },
+				UpdateFileFunc: func(updated models.File) error {
+					if tt.updateErr != nil {
+						return tt.updateErr
+					}
+					file = updated
+					return nil
+				},
+			}
+			versionRepo := &MockVersionRepository{AddVersionFunc: func(version models.Version, _ models.VersionPolicy) (models.Version, []models.Version, error) {
+				return version, nil, tt.addErr
+			}}
+			blobRepo := newBlobs(data, refs)
+			if tt.releaseErr != nil {
+				blobRepo.ReleaseBlobFunc = func(string) error { return tt.releaseErr }
+			}
+			fileService := service.NewFileService(fileRepo, folderRepo, userRepo, blobRepo, &MockTrashRepository{}, versionRepo, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
+
+			err := fileService.WriteFile("alice", "/shared", "notes.txt", []byte("hello world"))
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedFile, file.ContentHash)
+			assert.Equal(t, tt.expectedRefs, refs)
+		})
+	}
+}
+
+// TestPruneVersions tests that PruneVersions enforces the version policy of the service on every file and releases
+// the blobs of the pruned versions
+func TestPruneVersions(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name           string
+		pruneErr       error
+		expectedPruned int
+		expectedRefs   map[string]int
+		expectedError  error
+	}{
+		{
+			name:           "Success",
+			expectedPruned: 2,
+			expectedRefs:   map[string]int{hashOf("hello"): 1},
+		},
+		{
+			name:          "RepositoryError",
+			pruneErr:      assert.AnError,
+			expectedRefs:  map[string]int{hashOf("hello"): 2, hashOf("old"): 1},
+			expectedError: assert.AnError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var policy models.VersionPolicy
+			var prunedAt time.Time
+			data := map[string][]byte{hashOf("hello"): []byte("hello"), hashOf("old"): []byte("old")}
+			refs := map[string]int{hashOf("hello"): 2, hashOf("old"): 1}
+			versionRepo := &MockVersionRepository{PruneVersionsFunc: func(p models.VersionPolicy, at time.Time) ([]models.Version, error) {
+				policy, prunedAt = p, at
+				if tt.pruneErr != nil {
+					return nil, tt.pruneErr
+				}
+				return []models.Version{{FileID: 9, Number: 1, Hash: hashOf("old")}, {FileID: 7, Number: 3, Hash: hashOf("hello")}}, nil
+			}}
+			keepForDay := models.VersionPolicy{KeepFor: 24 * time.Hour}
+			fileService := service.NewFileService(&MockFileRepository{}, &MockFolderRepository{}, &MockUserRepository{}, newBlobs(data, refs), &MockTrashRepository{}, versionRepo, models.DefaultNamePolicy(), keepForDay, 0)
+
+			pruned, err := fileService.PruneVersions(now)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedPruned, pruned)
+			assert.Equal(t, keepForDay, policy)
+			assert.True(t, now.Equal(prunedAt))
+			assert.Equal(t, tt.expectedRefs, refs)
+		})
+	}
+}
+
+// TestRevertFile tests the RevertFile method using table-driven tests
+func TestRevertFile(t *testing.T) {
+	tests := []struct {
+		name             string
+		userName         string
+		number           int
+		expectedContent  string
+		expectedVersions int
+		expectedError    error
+	}{
+		{
+			name:             "Success",
+			userName:         "alice",
+			number:           1,
+			expectedContent:  "first",
+			expectedVersions: 3,
+		},
+		{
+			name:             "VersionNotFound",
+			userName:         "alice",
+			number:           5,
+			expectedContent:  "second",
+			expectedVersions: 2,
+			expectedError:    customErrors.ErrVersionNotFound("/shared/notes.txt", 5),
+		},
+		{
+			name:             "PermissionDenied",
+			userName:         "bob",
+			number:           1,
+			expectedContent:  "second",
+			expectedVersions: 2,
+			expectedError:    customErrors.ErrPermissionDenied(customErrors.KindFile, "~alice/shared/notes.txt", "revert"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, refs := map[string][]byte{}, map[string]int{}
+			fileService := newVersionService(data, refs, "second", newVersionHistory(data, refs, "first", "second"))
+
+			err := fileService.RevertFile(tt.userName, "~alice/shared", "notes.txt", tt.number)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+			}
+			content, err := fileService.ReadFile("alice", "/shared", "notes.txt")
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedContent, string(content))
+			history, err := fileService.History("alice", "/shared", "notes.txt")
+			assert.NoError(t, err)
+			assert.Len(t, history, tt.expectedVersions)
+		})
+	}
+}
+
+// TestDiffVersions tests the DiffVersions method using table-driven tests
+func TestDiffVersions(t *testing.T) {
+	tests := []struct {
+		name          string
+		from, to      int
+		expectedDiff  []models.DiffLine
+		expectedError error
+	}{
+		{
+			name: "Versions",
+			from: 1,
+			to:   2,
+			expectedDiff: []models.DiffLine{
+				{Op: models.DiffEqual, Text: "a"},
+				{Op: models.DiffDelete, Text: "b"},
+				{Op: models.DiffInsert, Text: "c"},
+			},
+		},
+		{
+			name: "CurrentContent",
+			from: 2,
+			expectedDiff: []models.DiffLine{
+				{Op: models.DiffEqual, Text: "a"},
+				{Op: models.DiffEqual, Text: "c"},
+				{Op: models.DiffInsert, Text: "d"},
+			},
+		},
+		{
+			name:          "VersionNotFound",
+			from:          1,
+			to:            3,
+			expectedError: customErrors.ErrVersionNotFound("/shared/notes.txt", 3),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, refs := map[string][]byte{}, map[string]int{}
+			fileService := newVersionService(data, refs, "a\nc\nd\n", newVersionHistory(data, refs, "a\nb\n", "a\nc\n"))
+
+			diff, err := fileService.DiffVersions("alice", "/shared", "notes.txt", tt.from, tt.to)
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedDiff, diff)
+			}
+		})
+	}
+}
+
+// TestDiffVersionsTooLarge tests that DiffVersions refuses versions too large to diff, without reading the versions
+// too large in bytes
+func TestDiffVersionsTooLarge(t *testing.T) {
+	tests := []struct {
+		name          string
+		size          int64 // the size recorded for the first version, if set
+		content       string
+		expectedError error
+	}{
+		{
+			name:          "TooManyBytes",
+			size:          models.MaxDiffSize + 1,
+			content:       "a\n",
+			expectedError: customErrors.ErrDiffTooLarge(models.MaxDiffSize+1, models.MaxDiffSize, "bytes"),
+		},
+		{
+			name:          "TooManyLines",
+			content:       strings.Repeat("a\n", models.MaxDiffLines+1),
+			expectedError: customErrors.ErrDiffTooLarge(models.MaxDiffLines+1, models.MaxDiffLines, "lines"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, refs := map[string][]byte{}, map[string]int{}
+			versionRepo := newVersionHistory(data, refs, "a\n")
+			getVersion := versionRepo.GetVersionFunc
+			versionRepo.GetVersionFunc = func(fileID models.ID, number int) (models.Version, error) {
+				version, err := getVersion(fileID, number)
+				if tt.size != 0 {
+					version.Size = tt.size
+					delete(data, version.Hash) // reading it would fail
+				}
+				return version, err
+			}
+			fileService := newVersionService(data, refs, tt.content, versionRepo)
+
+			_, err := fileService.DiffVersions("alice", "/shared", "notes.txt", 1, 0)
+			assert.EqualError(t, err, tt.expectedError.Error())
+			assert.ErrorIs(t, err, customErrors.ErrInvalid)
+		})
+	}
+}
+
+// newVersionService returns a file service over the trashFile of alice holding the content, whose blobs are kept in
+// the maps and whose versions are kept in the version repository
+func newVersionService(data map[string][]byte, refs map[string]int, content string, versionRepo *MockVersionRepository) *service.FileService {
+	blobRepo := newBlobs(data, refs)
+	file := trashFile
+	file.ContentHash, _ = blobRepo.PutBlob([]byte(content))
+	userRepo := &MockUserRepository{GetUserFunc: getPermissionUser}
+	folderRepo := &MockFolderRepository{GetFolderFunc: getTrashFolder}
+	fileRepo := &MockFileRepository{
+		GetFileFunc:    func(string, string, string) (models.File, error) { return file, nil },
+		UpdateFileFunc: func(updated models.File) error { file = updated; return nil },
+	}
+	return service.NewFileService(fileRepo, folderRepo, userRepo, blobRepo, &MockTrashRepository{}, versionRepo, models.DefaultNamePolicy(), models.DefaultVersionPolicy(), 0)
+}
+
+// newVersionHistory returns a version repository holding a version of the trashFile for each of the contents, numbered
+// from 1, whose blobs are kept in the maps
+func newVersionHistory(data map[string][]byte, refs map[string]int, versionContents ...string) *MockVersionRepository {
+	var history []models.Version
+	for i, content := range versionContents {
+		version := models.Version{FileID: trashFile.ID, Number: i + 1, AuthorID: 1, Size: int64(len(content)), Hash: models.HashContent([]byte(content))}
+		data[version.Hash] = []byte(content)
+		refs[version.Hash]++
+		history = append(history, version)
+	}
+	return &MockVersionRepository{
+		AddVersionFunc: func(version models.Version, _ models.VersionPolicy) (models.Version, []models.Version, error) {
+			version.Number = len(history) + 1
+			history = append(history, version)
+			return version, nil, nil
+		},
+		GetVersionFunc: func(fileID models.ID, number int) (models.Version, error) {
+			for _, version := range history {
+				if version.FileID == fileID && version.Number == number {
+					return version, nil
+				}
+			}
+			return models.Version{}, customErrors.ErrVersionNotFound(fileID.String(), number)
+		},
+		ListVersionsFunc: func(models.ID) ([]models.Version, error) { return history, nil },
+	}
+}